@@ -0,0 +1,366 @@
+package commands
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/lucacoratu/disertatie/agent/config"
+	"github.com/lucacoratu/disertatie/agent/data"
+	rules "github.com/lucacoratu/disertatie/agent/detection/rules"
+	"github.com/lucacoratu/disertatie/agent/logging"
+)
+
+// Prints the usage of the rules command
+func printRulesUsage() {
+	fmt.Println("Usage: agent rules <command> [arguments]")
+	fmt.Println("")
+	fmt.Println("Commands:")
+	fmt.Println("  test [-config <file>] [-v] [path]                         Runs the tests embedded in the rules from the file or directory (default ./rules) and reports the failures")
+	fmt.Println("  lint [-config <file>] [-format text|json] [path]          Checks the rules from the file or directory (default ./rules) for schema errors, duplicate ids, empty matchers and bad regexes")
+	fmt.Println("  import-nuclei [-out <dir>] [-report <file>] [-overwrite] <templates dir>   Converts the nuclei HTTP templates into rules and reports the templates which could not be converted faithfully")
+}
+
+// Runs the rules subcommands
+// @param args - the command line arguments after the rules keyword
+// Returns the exit code of the command
+func RunRulesCommand(args []string) int {
+	if len(args) == 0 {
+		printRulesUsage()
+		return 2
+	}
+
+	switch args[0] {
+	case "test":
+		return runRulesTest(args[1:])
+	case "lint":
+		return runRulesLint(args[1:])
+	case "import-nuclei":
+		return runRulesImportNuclei(args[1:])
+	default:
+		printRulesUsage()
+		return 2
+	}
+}
+
+// Loads the configuration used by the rules commands
+// If the configuration file is specified the rules directory and the ignored directories are taken from it
+// @param configFile - the path to the agent configuration file (can be empty)
+// @param rulesDirectory - the rules directory specified on the command line (overrides the configuration file)
+// Returns the configuration or an error if the configuration file cannot be loaded
+func loadRulesConfiguration(configFile string, rulesDirectory string) (config.Configuration, error) {
+	configuration := config.Configuration{}
+	if configFile != "" {
+		if err := configuration.LoadConfigurationFromFile(configFile); err != nil {
+			return configuration, errors.New("could not load the configuration file, " + err.Error())
+		}
+	}
+	if rulesDirectory != "" {
+		configuration.RulesDirectory = rulesDirectory
+	}
+	if configuration.RulesDirectory == "" {
+		configuration.RulesDirectory = "./rules"
+	}
+	return configuration, nil
+}
+
+// Formats the matched strings of the findings for the test report
+func formatTestFindings(findings []*data.RuleFindingData) string {
+	matches := make([]string, 0, len(findings))
+	for _, finding := range findings {
+		matches = append(matches, strconv.Quote(finding.MatchedString))
+	}
+	return strings.Join(matches, ", ")
+}
+
+// Runs the tests embedded in the rules and prints a pass/fail report for every rule
+// The rules are loaded in strict mode so a rule file which cannot be loaded fails the command
+// Returns 0 if all the tests passed, 1 otherwise
+func runRulesTest(args []string) int {
+	flagSet := flag.NewFlagSet("test", flag.ContinueOnError)
+	configFile := flagSet.String("config", "", "The agent configuration file (the rules directory and the ignored directories are taken from it)")
+	verbose := flagSet.Bool("v", false, "Print the result of every test, not only the failures")
+	if err := flagSet.Parse(args); err != nil {
+		return 2
+	}
+	if flagSet.NArg() > 1 {
+		fmt.Println("At most one rule file or directory should be specified")
+		return 2
+	}
+
+	//Use a logger without debug messages so the report stays readable
+	logger := logging.NewDefaultLogger()
+
+	configuration, err := loadRulesConfiguration(*configFile, flagSet.Arg(0))
+	if err != nil {
+		fmt.Println(err.Error())
+		return 1
+	}
+	allRules, err := rules.LoadRulesFromDirectoryStrict(configuration, logger)
+	if err != nil {
+		fmt.Println("Could not load the rules,", err.Error())
+		return 1
+	}
+
+	var testedRules, untestedRules, passedTests, failedTests int = 0, 0, 0, 0
+	for _, rule := range allRules {
+		if len(rule.Tests) == 0 {
+			untestedRules++
+			continue
+		}
+		testedRules++
+
+		results := rules.RunRuleTests(rule, configuration, logger)
+		failures := make([]string, 0)
+		for _, result := range results {
+			switch {
+			case result.Err != nil:
+				failures = append(failures, fmt.Sprintf("%q: %s", result.Name, result.Err.Error()))
+			case !result.Passed():
+				got := rules.RuleTestExpectNoMatch
+				if result.Matched {
+					got = rules.RuleTestExpectMatch + " (" + formatTestFindings(result.Findings) + ")"
+				}
+				failures = append(failures, fmt.Sprintf("%q: expected %s, got %s", result.Name, result.Expect, got))
+			}
+		}
+		passedTests += len(results) - len(failures)
+		failedTests += len(failures)
+
+		if len(failures) > 0 {
+			fmt.Printf("FAIL  %s (%d/%d tests passed)\n", rule.Id, len(results)-len(failures), len(results))
+			for _, failure := range failures {
+				fmt.Println("      -", failure)
+			}
+			continue
+		}
+		fmt.Printf("PASS  %s (%d tests)\n", rule.Id, len(results))
+		if *verbose {
+			for _, result := range results {
+				if result.Matched {
+					fmt.Printf("      - %q: match (%s)\n", result.Name, formatTestFindings(result.Findings))
+				} else {
+					fmt.Printf("      - %q: no-match\n", result.Name)
+				}
+			}
+		}
+	}
+
+	fmt.Printf("\nRules: %d tested, %d without tests\n", testedRules, untestedRules)
+	fmt.Printf("Tests: %d passed, %d failed\n", passedTests, failedTests)
+	if failedTests > 0 {
+		return 1
+	}
+	return 0
+}
+
+// Holds the report of the rules linter printed in the JSON format
+type lintReport struct {
+	Files    int               `json:"files"`
+	Errors   int               `json:"errors"`
+	Warnings int               `json:"warnings"`
+	Issues   []rules.LintIssue `json:"issues"`
+}
+
+// Checks the rule files and prints the issues found in text or JSON format
+// Returns 0 if no errors were found (the warnings do not fail the command), 1 otherwise
+func runRulesLint(args []string) int {
+	flagSet := flag.NewFlagSet("lint", flag.ContinueOnError)
+	configFile := flagSet.String("config", "", "The agent configuration file (the rules directory and the ignored directories are taken from it)")
+	format := flagSet.String("format", "text", "The format of the report (text or json)")
+	if err := flagSet.Parse(args); err != nil {
+		return 2
+	}
+	if *format != "text" && *format != "json" {
+		fmt.Println("The format should be text or json")
+		return 2
+	}
+	if flagSet.NArg() > 1 {
+		fmt.Println("At most one rule file or directory should be specified")
+		return 2
+	}
+
+	logger := logging.NewDefaultLogger()
+
+	configuration, err := loadRulesConfiguration(*configFile, flagSet.Arg(0))
+	if err != nil {
+		fmt.Println(err.Error())
+		return 1
+	}
+	issues, filesCount, err := rules.LintRulesDirectory(configuration, logger)
+	if err != nil {
+		fmt.Println("Could not lint the rules,", err.Error())
+		return 1
+	}
+
+	report := lintReport{Files: filesCount, Issues: issues}
+	for _, issue := range issues {
+		if issue.Severity == rules.LintError {
+			report.Errors++
+		} else {
+			report.Warnings++
+		}
+	}
+
+	if *format == "json" {
+		encoder := json.NewEncoder(os.Stdout)
+		encoder.SetIndent("", "  ")
+		if err := encoder.Encode(report); err != nil {
+			fmt.Println("Could not encode the report,", err.Error())
+			return 1
+		}
+	} else {
+		for _, issue := range issues {
+			location := issue.File
+			if issue.RuleId != "" {
+				location += " (" + issue.RuleId + ")"
+			}
+			fmt.Printf("%s: %s: %s\n", location, issue.Severity, issue.Message)
+		}
+		fmt.Printf("\n%d files checked, %d errors, %d warnings\n", report.Files, report.Errors, report.Warnings)
+	}
+
+	if report.Errors > 0 {
+		return 1
+	}
+	return 0
+}
+
+// The status of the conversion of a nuclei template
+const (
+	importConverted = "converted" //The template was converted faithfully
+	importPartial   = "partial"   //The template was converted but some parts of it were skipped or approximated
+	importFailed    = "failed"    //The template could not be converted
+	importSkipped   = "skipped"   //The rule file already exists
+)
+
+// Holds the result of importing a nuclei template
+type importResult struct {
+	Template string   `json:"template"`
+	RuleId   string   `json:"ruleId,omitempty"`
+	Output   string   `json:"output,omitempty"`
+	Status   string   `json:"status"`
+	Warnings []string `json:"warnings,omitempty"`
+	Error    string   `json:"error,omitempty"`
+}
+
+// Converts a nuclei template file and writes the rule into the output directory
+// @param templatePath - the path of the nuclei template
+// @param outputDirectory - the directory the rule is written to (as <rule id>.yaml)
+// @param overwrite - if the existing rule files should be overwritten
+// @param logger - the logger
+// Returns the result of the import
+func importNucleiTemplate(templatePath string, outputDirectory string, overwrite bool, logger logging.ILogger) importResult {
+	result := importResult{Template: templatePath}
+	templateData, err := os.ReadFile(templatePath)
+	if err != nil {
+		result.Status, result.Error = importFailed, err.Error()
+		return result
+	}
+	conversion, err := rules.ConvertNucleiTemplate(templateData, logger)
+	if err != nil {
+		result.Status, result.Error = importFailed, err.Error()
+		return result
+	}
+	result.RuleId = conversion.Rule.Id
+	result.Warnings = conversion.Warnings
+
+	//The id of the rule is used as the name of the file so it should not contain path separators
+	fileName := strings.NewReplacer("/", "_", "\\", "_").Replace(conversion.Rule.Id) + ".yaml"
+	result.Output = filepath.Join(outputDirectory, fileName)
+	if _, err := os.Stat(result.Output); err == nil && !overwrite {
+		result.Status, result.Error = importSkipped, "the rule file already exists, use -overwrite to replace it"
+		return result
+	}
+
+	file, err := os.Create(result.Output)
+	if err != nil {
+		result.Status, result.Error = importFailed, err.Error()
+		return result
+	}
+	defer file.Close()
+	if err := conversion.Rule.ToYAML(file); err != nil {
+		result.Status, result.Error = importFailed, err.Error()
+		return result
+	}
+
+	result.Status = importConverted
+	if len(conversion.Warnings) > 0 {
+		result.Status = importPartial
+	}
+	return result
+}
+
+// Converts the nuclei HTTP templates from a directory into rules
+// The templates which were not converted faithfully are printed with the reason and can also be written to a JSON report
+// Returns 0 if the templates directory could be processed, 1 otherwise
+func runRulesImportNuclei(args []string) int {
+	flagSet := flag.NewFlagSet("import-nuclei", flag.ContinueOnError)
+	outputDirectory := flagSet.String("out", "./rules/CVEs", "The directory the converted rules are written to")
+	reportFile := flagSet.String("report", "", "The file the JSON report of the import is written to")
+	overwrite := flagSet.Bool("overwrite", false, "Overwrite the existing rule files")
+	if err := flagSet.Parse(args); err != nil {
+		return 2
+	}
+	if flagSet.NArg() != 1 {
+		fmt.Println("The directory with the nuclei templates should be specified")
+		return 2
+	}
+
+	logger := logging.NewDefaultLogger()
+
+	if err := os.MkdirAll(*outputDirectory, 0755); err != nil {
+		fmt.Println("Could not create the output directory,", err.Error())
+		return 1
+	}
+
+	results := make([]importResult, 0)
+	err := filepath.WalkDir(flagSet.Arg(0), func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() || (!strings.HasSuffix(d.Name(), ".yaml") && !strings.HasSuffix(d.Name(), ".yml")) {
+			return nil
+		}
+		results = append(results, importNucleiTemplate(path, *outputDirectory, *overwrite, logger))
+		return nil
+	})
+	if err != nil {
+		fmt.Println("Could not walk the templates directory,", err.Error())
+		return 1
+	}
+
+	//Print the templates which were not converted faithfully
+	counts := make(map[string]int)
+	for _, result := range results {
+		counts[result.Status]++
+		switch result.Status {
+		case importFailed, importSkipped:
+			fmt.Printf("%-9s %s: %s\n", result.Status, result.Template, result.Error)
+		case importPartial:
+			fmt.Printf("%-9s %s -> %s\n", result.Status, result.Template, result.Output)
+			for _, warning := range result.Warnings {
+				fmt.Println("          -", warning)
+			}
+		}
+	}
+	fmt.Printf("\n%d templates: %d converted, %d partial, %d failed, %d skipped\n", len(results), counts[importConverted], counts[importPartial], counts[importFailed], counts[importSkipped])
+
+	if *reportFile != "" {
+		reportData, err := json.MarshalIndent(results, "", "  ")
+		if err == nil {
+			err = os.WriteFile(*reportFile, reportData, 0644)
+		}
+		if err != nil {
+			fmt.Println("Could not write the report,", err.Error())
+			return 1
+		}
+	}
+	return 0
+}
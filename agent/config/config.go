@@ -16,31 +16,71 @@ type AdaptiveTemplate struct {
 	TemplatePath string //The path to the template file
 }
 
+// The weights of the severities and the thresholds used when the waf blocks based on the anomaly score
+// A finding adds the weight of its severity to the score of the request (or the response)
+type AnomalyScoring struct {
+	CriticalWeight    int `json:"critical" validate:"gte=0"`         //The score added by a critical finding
+	HighWeight        int `json:"high" validate:"gte=0"`             //The score added by a high finding
+	MediumWeight      int `json:"medium" validate:"gte=0"`           //The score added by a medium finding
+	LowWeight         int `json:"low" validate:"gte=0"`              //The score added by a low finding
+	RequestThreshold  int `json:"requestThreshold" validate:"gt=0"`  //The request is blocked if its score is greater or equal to the threshold
+	ResponseThreshold int `json:"responseThreshold" validate:"gt=0"` //The response is blocked if its score is greater or equal to the threshold
+}
+
+// Gets the default anomaly scoring values (the same as the CRS defaults)
+func DefaultAnomalyScoring() AnomalyScoring {
+	return AnomalyScoring{CriticalWeight: 5, HighWeight: 4, MediumWeight: 3, LowWeight: 2, RequestThreshold: 5, ResponseThreshold: 4}
+}
+
+// Enables a validator and holds its parameters
+type ValidatorConfiguration struct {
+	Name   string          `json:"name" validate:"required"` //The name the validator is registered with
+	Config json.RawMessage `json:"config"`                   //The parameters of the validator (the keys depend on the validator)
+}
+
+// Gets the validators enabled when the configuration file has no validators list
+func DefaultValidators() []ValidatorConfiguration {
+	return []ValidatorConfiguration{{Name: "UserAgentValidator"}}
+}
+
 // Structure that will hold the configuration parameters of the proxy
 type Configuration struct {
-	ListeningProtocol      string             `json:"protocol" validate:"required,oneof_insensitive=http https"`                //The protocol the agent uses to communicate to users
-	ListeningAddress       string             `json:"address" validate:"required,ipv4"`                                         //Address to listen on (127.0.0.1, 0.0.0.0, etc.)
-	ListeningPort          string             `json:"port" validate:"required,number,gt=0,lt=65536"`                            //Port to listen on
-	TLSCertificateFilepath string             `json:"tlsCertificateFilepath"`                                                   //The path to the certificate file
-	TLSKeyFilepath         string             `json:"tlsKeyFilepath"`                                                           //The path to the key associated with TLS Certificate
-	ForbiddenPagePath      string             `json:"forbiddenPagePath" validate:"required"`                                    //Forbidden page location
-	BlacklistUserAgentPath string             `json:"blacklistUserAgentPath" validate:"required"`                               //Path to the wordlist of banned User-Agents
-	ForwardServerProtocol  string             `json:"forwardServerProtocol" validate:"required"`                                //Protocol used when forwarding request to webserver
-	ForwardServerAddress   string             `json:"forwardServerAddress" validate:"required"`                                 //Address of the webserver to send the request to
-	ForwardServerPort      string             `json:"forwardServerPort" validate:"required,number,gt=0,lt=65536"`               //Port to forward the request to
-	APIProtocol            string             `json:"apiProtocol"`                                                              //API protocol
-	APIIpAddress           string             `json:"apiIpAddress"`                                                             //API ip address
-	APIPort                string             `json:"apiPort"`                                                                  //API port
-	UUID                   string             `json:"uuid"`                                                                     //The UUID of the agent, received after registration to the API
-	RulesDirectory         string             `json:"rulesDirectory"`                                                           //The directory where rules can be found
-	OperationMode          string             `json:"operationMode" validate:"required,oneof_insensitive=testing waf adaptive"` //The mode the agent will operate on (can be testing, waf, adaptive) - case insensitive
-	IgnoreRulesDirectories []string           `json:"ignoreRulesDirectories"`                                                   //The directories with rules that should be ignored when loading the rules
-	UseAIClassifier        bool               `json:"useAIClassifier"`                                                          //If the agent should use the AI classifier
-	Classifier             string             `json:"classifier" validate:"required,oneof_insensitive=svc knn random-forest"`   //The classifier model to be used
-	LLMAPIURL              string             `json:"llmAPIURL"`                                                                //The URL for the LLM API
-	CreateDataset          bool               `json:"createDataset"`                                                            //If the agent should save the requests features in a dataset
-	DatasetPath            string             `json:"datasetPath" validate:"required"`                                          //The path where the dataset will be saved
-	AdaptiveTemplates      []AdaptiveTemplate `json:"adaptiveTemplates"`                                                        //The list of templates used when getting response from the LLM
+	ListeningProtocol      string                   `json:"protocol" validate:"required,oneof_insensitive=http https"`                //The protocol the agent uses to communicate to users
+	ListeningAddress       string                   `json:"address" validate:"required,ipv4"`                                         //Address to listen on (127.0.0.1, 0.0.0.0, etc.)
+	ListeningPort          string                   `json:"port" validate:"required,number,gt=0,lt=65536"`                            //Port to listen on
+	TLSCertificateFilepath string                   `json:"tlsCertificateFilepath"`                                                   //The path to the certificate file
+	TLSKeyFilepath         string                   `json:"tlsKeyFilepath"`                                                           //The path to the key associated with TLS Certificate
+	ForbiddenPagePath      string                   `json:"forbiddenPagePath" validate:"required"`                                    //Forbidden page location
+	BlacklistUserAgentPath string                   `json:"blacklistUserAgentPath" validate:"required"`                               //Path to the wordlist of banned User-Agents
+	ForwardServerProtocol  string                   `json:"forwardServerProtocol" validate:"required"`                                //Protocol used when forwarding request to webserver
+	ForwardServerAddress   string                   `json:"forwardServerAddress" validate:"required"`                                 //Address of the webserver to send the request to
+	ForwardServerPort      string                   `json:"forwardServerPort" validate:"required,number,gt=0,lt=65536"`               //Port to forward the request to
+	APIProtocol            string                   `json:"apiProtocol"`                                                              //API protocol
+	APIIpAddress           string                   `json:"apiIpAddress"`                                                             //API ip address
+	APIPort                string                   `json:"apiPort"`                                                                  //API port
+	UUID                   string                   `json:"uuid"`                                                                     //The UUID of the agent, received after registration to the API
+	RulesDirectory         string                   `json:"rulesDirectory"`                                                           //The directory where rules can be found
+	OperationMode          string                   `json:"operationMode" validate:"required,oneof_insensitive=testing waf adaptive"` //The mode the agent will operate on (can be testing, waf, adaptive) - case insensitive
+	WAFBlockingMode        string                   `json:"wafBlockingMode" validate:"omitempty,oneof_insensitive=action anomaly"`    //How the waf decides to block (action - on the first finding of a drop rule, anomaly - when the anomaly score crosses the threshold)
+	AnomalyScoring         AnomalyScoring           `json:"anomalyScoring"`                                                           //The weights and thresholds of the anomaly scoring blocking mode
+	RedirectURL            string                   `json:"redirectURL" validate:"omitempty,url"`                                     //The URL where the clients are redirected by the rules with the redirect action
+	TarpitDelay            int                      `json:"tarpitDelay" validate:"gte=0"`                                             //The delay in milliseconds between the chunks of the tarpit response (0 means 1000)
+	TarpitChunkSize        int                      `json:"tarpitChunkSize" validate:"gte=0"`                                         //The size in bytes of the chunks of the tarpit response (0 means 16)
+	ExclusionsFile         string                   `json:"exclusionsFile"`                                                           //The file with the exclusions which disable the rules for some requests (the false positives)
+	IgnoreRulesDirectories []string                 `json:"ignoreRulesDirectories"`                                                   //The directories with rules that should be ignored when loading the rules
+	RuleWorkers            int                      `json:"ruleWorkers" validate:"gte=0"`                                             //The number of workers evaluating the rules concurrently (0 means the number of CPUs)
+	SlowSearchThreshold    int                      `json:"slowSearchThreshold" validate:"gte=0"`                                     //The time in milliseconds after which a regex search of a rule is counted as slow in the rule statistics, the search is not stopped (0 means 100)
+	AdminAddress           string                   `json:"adminAddress" validate:"omitempty,hostname_port"`                          //The address of the local admin endpoint exposing the rule statistics (127.0.0.1:8082), disabled if empty
+	RuleStatisticsInterval int                      `json:"ruleStatisticsInterval" validate:"gte=0"`                                  //The interval in seconds between the rule statistics reports sent to the API (0 means 60)
+	StrictRules            bool                     `json:"strictRules"`                                                              //If the rule files with unknown keys or lint errors (empty matchers, nested quantifiers) should be rejected when loading the rules
+	InspectDecodedURL      bool                     `json:"inspectDecodedURL"`                                                        //If the url matchers of the rules inspect the decoded path and query instead of the escaped path and the raw query
+	UseAIClassifier        bool                     `json:"useAIClassifier"`                                                          //If the agent should use the AI classifier
+	Classifier             string                   `json:"classifier" validate:"required,oneof_insensitive=svc knn random-forest"`   //The classifier model to be used
+	LLMAPIURL              string                   `json:"llmAPIURL"`                                                                //The URL for the LLM API
+	CreateDataset          bool                     `json:"createDataset"`                                                            //If the agent should save the requests features in a dataset
+	DatasetPath            string                   `json:"datasetPath" validate:"required"`                                          //The path where the dataset will be saved
+	AdaptiveTemplates      []AdaptiveTemplate       `json:"adaptiveTemplates"`                                                        //The list of templates used when getting response from the LLM
+	Validators             []ValidatorConfiguration `json:"validators" validate:"dive"`                                               //The validators run on the traffic, in order (the UserAgentValidator if missing, none if empty)
 }
 
 // Validate function for one of (case insensitive)
@@ -72,11 +112,17 @@ func (conf *Configuration) LoadConfigurationFromFile(filePath string) error {
 	if err != nil {
 		return err
 	}
+	//The anomaly scoring values missing from the file keep the default value
+	conf.AnomalyScoring = DefaultAnomalyScoring()
 	err = conf.FromJSON(file)
 	//Check if an error occured when loading the json from file
 	if err != nil {
 		return err
 	}
+	//The validators list missing from the file enables the default validators
+	if conf.Validators == nil {
+		conf.Validators = DefaultValidators()
+	}
 	//Initialize the validator of the json data
 	validate := validator.New(validator.WithRequiredStructEnabled())
 	validate.RegisterValidation("oneof_insensitive", validateOneOfInsensitive)
@@ -85,6 +131,11 @@ func (conf *Configuration) LoadConfigurationFromFile(filePath string) error {
 	return err
 }
 
+// Checks if the waf blocks based on the anomaly score instead of the first drop rule
+func (conf *Configuration) UsesAnomalyScoring() bool {
+	return strings.EqualFold(conf.WAFBlockingMode, "anomaly")
+}
+
 // Convert from json into the configuration structure
 func (conf *Configuration) FromJSON(r io.Reader) error {
 	d := json.NewDecoder(r)
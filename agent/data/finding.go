@@ -11,8 +11,13 @@ const (
 	UNKNOWN int64 = -1
 
 	//Request classifications
-	LFI_ATTACK        int64 = 0
-	SCRIPT_USER_AGENT int64 = 1
+	LFI_ATTACK          int64 = 0
+	SCRIPT_USER_AGENT   int64 = 1
+	BLOCKED_COUNTRY     int64 = 2
+	HOSTING_ASN         int64 = 3
+	KNOWN_BAD_IP        int64 = 4
+	RATE_LIMIT_EXCEEDED int64 = 5
+	BANNED_CLIENT       int64 = 6
 
 	//Response classifications
 	UNAUTHORIZED_ACCESS int64 = 100
@@ -22,8 +27,13 @@ const (
 
 // Classifications and their string equivalent
 var ClassificationsMap = map[int64]string{
-	LFI_ATTACK:        "LFI",
-	SCRIPT_USER_AGENT: "Script UA",
+	LFI_ATTACK:          "LFI",
+	SCRIPT_USER_AGENT:   "Script UA",
+	BLOCKED_COUNTRY:     "Blocked country",
+	HOSTING_ASN:         "Hosting ASN",
+	KNOWN_BAD_IP:        "Known bad IP",
+	RATE_LIMIT_EXCEEDED: "Rate limit exceeded",
+	BANNED_CLIENT:       "Banned client",
 }
 
 // Severity types
@@ -57,17 +67,31 @@ type Finding struct {
 // ==========================RULE FINDINGS===============================
 // Structure that will hold information about the rule finding
 type RuleFindingData struct {
-	RuleId             string `json:"ruleId"`             //The rule id specified on the agent rule
-	RuleName           string `json:"ruleName"`           //The name of the rule specified on the agent
-	RuleDescription    string `json:"ruleDescription"`    //The description of the rule
-	Line               int64  `json:"line"`               //The line from the request where the finding is located
-	LineIndex          int64  `json:"lineIndex"`          //The offset from the start of the line
-	Length             int64  `json:"length"`             //The length of the finding string
-	MatchedString      string `json:"matchedString"`      //The string on which the rule matched
-	MatchedBodyHash    string `json:"matchedBodyHash"`    //The hash of the body which matched
-	MatchedBodyHashAlg string `json:"matchedBodyHashAlg"` //The algorithm used for hashing the body
-	Classification     string `json:"classification"`     //The classification of the finding based on the string specified in the rule file
-	Severity           int64  `json:"severity"`           //The severity of the finding
+	RuleId             string   `json:"ruleId"`                    //The rule id specified on the agent rule
+	RuleName           string   `json:"ruleName"`                  //The name of the rule specified on the agent
+	RuleDescription    string   `json:"ruleDescription"`           //The description of the rule
+	Line               int64    `json:"line"`                      //The line from the request where the finding is located
+	LineIndex          int64    `json:"lineIndex"`                 //The offset from the start of the line
+	Length             int64    `json:"length"`                    //The length of the finding in the raw data
+	StartOffset        int64    `json:"startOffset"`               //The offset in the raw data where the finding starts (-1 if the finding is not in the raw data)
+	EndOffset          int64    `json:"endOffset"`                 //The offset in the raw data where the finding ends (-1 if the finding is not in the raw data)
+	Part               string   `json:"part,omitempty"`            //The part of the message which matched (method, url, header, parameter, cookie, body, file, status, message, latency, size)
+	PartName           string   `json:"partName,omitempty"`        //The name of the header, parameter or cookie which matched
+	PartSource         string   `json:"partSource,omitempty"`      //The source of the parameter which matched (query, body)
+	MatchedString      string   `json:"matchedString"`             //The string on which the rule matched
+	MatchedBodyHash    string   `json:"matchedBodyHash"`           //The hash of the body which matched
+	MatchedBodyHashAlg string   `json:"matchedBodyHashAlg"`        //The algorithm used for hashing the body
+	Classification     string   `json:"classification"`            //The classification of the finding based on the string specified in the rule file
+	Severity           int64    `json:"severity"`                  //The severity of the finding
+	DecodingChain      []string `json:"decodingChain"`             //The decodings applied on the inspected value before the match (empty if the match is in the raw data)
+	MatchedPath        string   `json:"matchedPath"`               //The path of the value which matched (cookie name, JSONPath or XPath), empty for the other matchers
+	ExclusionId        string   `json:"exclusionId,omitempty"`     //The ids of the exclusions which suppressed the finding (empty if the finding is not suppressed)
+	Tags               []string `json:"tags,omitempty"`            //The tags of the rule
+	References         []string `json:"references,omitempty"`      //The links to the advisories of the vulnerability detected by the rule
+	CWEIds             []string `json:"cweIds,omitempty"`          //The CWE ids of the rule (CWE-89)
+	CVSSScore          float64  `json:"cvssScore,omitempty"`       //The CVSS score of the vulnerability detected by the rule
+	CVSSMetrics        string   `json:"cvssMetrics,omitempty"`     //The CVSS vector of the vulnerability detected by the rule
+	MitreTechniques    []string `json:"mitreTechniques,omitempty"` //The MITRE ATT&CK techniques of the rule (T1190)
 }
 
 // Rule findings found by agent, one for request, one for response
@@ -8,14 +8,27 @@ import (
 // This structure holds the log data that is sent to the api
 type LogData struct {
 	//Id           string        `json:"id"`           //The UUID of the log from the database
-	AgentId      string        `json:"agentId"`      //The UUID of the agent that collected the log data
-	RemoteIP     string        `json:"remoteIp"`     //The IP address of the sender of the request
-	Timestamp    int64         `json:"timestamp"`    //Timestamp when the request was received
-	Websocket    bool          `json:"websocket"`    //If the log is from a websocket message
-	Request      string        `json:"request"`      //The request base64 encoded
-	Response     string        `json:"response"`     // The response base64 encoded
-	Findings     []Finding     `json:"findings"`     //A list of findings
-	RuleFindings []RuleFinding `json:"ruleFindings"` //The list of rule findings
+	AgentId                string           `json:"agentId"`                          //The UUID of the agent that collected the log data
+	RemoteIP               string           `json:"remoteIp"`                         //The IP address of the sender of the request
+	Timestamp              int64            `json:"timestamp"`                        //Timestamp when the request was received
+	Websocket              bool             `json:"websocket"`                        //If the log is from a websocket message
+	Direction              string           `json:"direction,omitempty"`              //The direction of the websocket message (client-to-server, server-to-client)
+	Request                string           `json:"request"`                          //The request base64 encoded
+	Response               string           `json:"response"`                         // The response base64 encoded
+	Findings               []Finding        `json:"findings"`                         //A list of findings
+	RuleFindings           []RuleFinding    `json:"ruleFindings"`                     //The list of rule findings
+	SuppressedRuleFindings []RuleFinding    `json:"suppressedRuleFindings,omitempty"` //The list of rule findings suppressed by the exclusions (they do not block the request)
+	Action                 string           `json:"action,omitempty"`                 //The action applied by the waf when the request (or the response) was blocked (drop, tarpit, redirect, respond)
+	AnomalyScore           int              `json:"anomalyScore"`                     //The anomaly score of the request and the response (when the waf blocks based on the anomaly score)
+	AnomalyContributors    []string         `json:"anomalyContributors,omitempty"`    //The ids of the rules and the names of the validators which added to the anomaly score
+	Upstream               *UpstreamMetrics `json:"upstream,omitempty"`               //The time the web server took to respond and the size of the response (empty if the request was not forwarded)
+}
+
+// Holds the measurements of the response received from the web server
+type UpstreamMetrics struct {
+	TimeToFirstByte int64 `json:"timeToFirstByte"` //The milliseconds from sending the request until the first byte of the response was received
+	Duration        int64 `json:"duration"`        //The milliseconds from sending the request until the whole response was received
+	Size            int64 `json:"size"`            //The size of the response body in bytes
 }
 
 // Convert json data to LogData structure
@@ -0,0 +1,32 @@
+package data
+
+import (
+	"encoding/json"
+	"io"
+)
+
+// Holds the counters of a rule since the agent started
+// The times are in microseconds
+type RuleStatistics struct {
+	RuleId       string `json:"ruleId"`       //The id of the rule
+	Evaluations  int64  `json:"evaluations"`  //The number of times the rule was evaluated
+	Matches      int64  `json:"matches"`      //The number of evaluations in which the rule matched
+	TotalTime    int64  `json:"totalTime"`    //The cumulative evaluation time
+	P99Time      int64  `json:"p99Time"`      //The 99th percentile of the evaluation time
+	MaxTime      int64  `json:"maxTime"`      //The slowest evaluation
+	SlowSearches int64  `json:"slowSearches"` //The number of regex searches which took longer than the slow search threshold
+	LastMatch    int64  `json:"lastMatch"`    //When the rule last matched (unix timestamp, 0 if it did not match)
+}
+
+// Holds the counters of all the loaded rules
+type RuleStatisticsReport struct {
+	AgentId   string           `json:"agentId"`   //The id of the agent
+	Since     int64            `json:"since"`     //When the agent started counting (unix timestamp)
+	Timestamp int64            `json:"timestamp"` //When the report was created (unix timestamp)
+	Rules     []RuleStatistics `json:"rules"`     //The counters of the rules
+}
+
+func (report *RuleStatisticsReport) ToJSON(w io.Writer) error {
+	e := json.NewEncoder(w)
+	return e.Encode(report)
+}
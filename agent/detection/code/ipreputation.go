@@ -0,0 +1,252 @@
+package detection
+
+import (
+	"errors"
+	"net"
+	"net/http"
+	"net/netip"
+	"strconv"
+	"strings"
+
+	"github.com/lucacoratu/disertatie/agent/config"
+	"github.com/lucacoratu/disertatie/agent/data"
+	"github.com/lucacoratu/disertatie/agent/logging"
+	"github.com/lucacoratu/disertatie/agent/utils"
+)
+
+// The config block of the IPReputationValidator
+// The lists have an address or a CIDR on every line, the empty lines and the lines starting with # are ignored
+type IPReputationValidatorOptions struct {
+	AllowListPath       string   `json:"allowListPath"`                                                 //The trusted addresses (monitoring) which bypass all the inspection
+	DenyListPath        string   `json:"denyListPath"`                                                  //The known bad addresses
+	TorExitListPath     string   `json:"torExitListPath"`                                               //The Tor exit nodes (the bulk exit list or the exit-addresses format)
+	CountryDatabasePath string   `json:"countryDatabasePath" validate:"required_with=BlockedCountries"` //The MaxMind DB with the countries of the addresses (GeoLite2-Country, GeoIP2-City)
+	ASNDatabasePath     string   `json:"asnDatabasePath" validate:"required_with=HostingASNs"`          //The MaxMind DB with the autonomous systems of the addresses (GeoLite2-ASN)
+	BlockedCountries    []string `json:"blockedCountries" validate:"dive,iso3166_1_alpha2"`             //The ISO codes of the countries whose clients are blocked (RU, KP)
+	HostingASNs         []uint32 `json:"hostingASNs"`                                                   //The autonomous systems of the hosting providers whose clients are reported (16509, 14061)
+}
+
+// Holds a set of addresses and networks
+type ipSet struct {
+	addresses map[netip.Addr]struct{}
+	prefixes  []netip.Prefix
+}
+
+// Checks if the address is in the set
+func (set *ipSet) contains(address netip.Addr) bool {
+	if _, found := set.addresses[address]; found {
+		return true
+	}
+	for _, prefix := range set.prefixes {
+		if prefix.Contains(address) {
+			return true
+		}
+	}
+	return false
+}
+
+// Loads a list of addresses and CIDRs from a file
+// The exit-addresses format of Tor is also accepted (only the ExitAddress lines are used)
+// @param path - the path to the list (an empty path creates an empty set)
+// Returns the set or an error if a line is not an address or a CIDR
+func loadIPSet(path string) (*ipSet, error) {
+	set := &ipSet{addresses: make(map[netip.Addr]struct{})}
+	if path == "" {
+		return set, nil
+	}
+	lines, err := utils.ReadLinesFromFile(path)
+	if err != nil {
+		return nil, errors.New("could not read the ip list " + path + ", " + err.Error())
+	}
+	for index, line := range lines {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		if fields := strings.Fields(line); len(fields) > 1 {
+			if fields[0] != "ExitAddress" {
+				continue
+			}
+			line = fields[1]
+		}
+		if strings.Contains(line, "/") {
+			prefix, err := netip.ParsePrefix(line)
+			if err != nil {
+				return nil, errors.New("invalid CIDR on line " + strconv.Itoa(index+1) + " of the ip list " + path)
+			}
+			set.prefixes = append(set.prefixes, prefix.Masked())
+			continue
+		}
+		address, err := netip.ParseAddr(line)
+		if err != nil {
+			return nil, errors.New("invalid address on line " + strconv.Itoa(index+1) + " of the ip list " + path)
+		}
+		set.addresses[address.Unmap()] = struct{}{}
+	}
+	return set, nil
+}
+
+// Checks the address of the client against the ip lists and the offline geo databases
+// The clients in the allow list are not inspected at all, the request is forwarded as it is
+type IPReputationValidator struct {
+	configuration    config.Configuration
+	logger           logging.ILogger
+	name             string
+	options          IPReputationValidatorOptions
+	allowList        *ipSet              //The trusted addresses
+	denyList         *ipSet              //The known bad addresses
+	torExitList      *ipSet              //The Tor exit nodes
+	countryDatabase  *maxMindDatabase    //The countries of the addresses (nil if not configured)
+	asnDatabase      *maxMindDatabase    //The autonomous systems of the addresses (nil if not configured)
+	blockedCountries map[string]struct{} //The ISO codes of the reported countries
+	hostingASNs      map[uint64]struct{} //The reported autonomous systems
+}
+
+// Creates an instance of the IPReputationValidator from the config block in the validators list
+func NewIPReputationValidatorFromOptions(logger logging.ILogger, configuration config.Configuration, options IPReputationValidatorOptions) (IValidator, error) {
+	ipVal := &IPReputationValidator{logger: logger, name: "IPReputationValidator", configuration: configuration, options: options}
+	ipVal.blockedCountries = make(map[string]struct{}, len(options.BlockedCountries))
+	for _, country := range options.BlockedCountries {
+		ipVal.blockedCountries[country] = struct{}{}
+	}
+	ipVal.hostingASNs = make(map[uint64]struct{}, len(options.HostingASNs))
+	for _, asn := range options.HostingASNs {
+		ipVal.hostingASNs[uint64(asn)] = struct{}{}
+	}
+	return ipVal, nil
+}
+
+// Gets the name of the validator
+func (ipVal *IPReputationValidator) GetName() string {
+	return ipVal.name
+}
+
+// Gets the phases inspected by the validator (the address of the client is known from the request)
+func (ipVal *IPReputationValidator) GetPhases() ValidatorPhase {
+	return PhaseRequest
+}
+
+// Loads the ip lists and opens the geo databases
+func (ipVal *IPReputationValidator) Init() error {
+	var err error
+	if ipVal.allowList, err = loadIPSet(ipVal.options.AllowListPath); err != nil {
+		return err
+	}
+	if ipVal.denyList, err = loadIPSet(ipVal.options.DenyListPath); err != nil {
+		return err
+	}
+	if ipVal.torExitList, err = loadIPSet(ipVal.options.TorExitListPath); err != nil {
+		return err
+	}
+	if ipVal.options.CountryDatabasePath != "" {
+		if ipVal.countryDatabase, err = openMaxMindDatabase(ipVal.options.CountryDatabasePath); err != nil {
+			return err
+		}
+		ipVal.logger.Debug(ipVal.name, "opened the country database", ipVal.countryDatabase.databaseType)
+	}
+	if ipVal.options.ASNDatabasePath != "" {
+		if ipVal.asnDatabase, err = openMaxMindDatabase(ipVal.options.ASNDatabasePath); err != nil {
+			return err
+		}
+		ipVal.logger.Debug(ipVal.name, "opened the ASN database", ipVal.asnDatabase.databaseType)
+	}
+	return nil
+}
+
+// Releases the resources of the validator (nothing to release, the lists and the databases are kept in memory)
+func (ipVal *IPReputationValidator) Close() error {
+	return nil
+}
+
+// Gets the address of the client which sent the request
+// Returns false if the remote address cannot be parsed
+func (ipVal *IPReputationValidator) getClientAddress(r *http.Request) (netip.Addr, bool) {
+	remoteAddress := r.RemoteAddr
+	if host, _, err := net.SplitHostPort(remoteAddress); err == nil {
+		remoteAddress = host
+	}
+	address, err := netip.ParseAddr(remoteAddress)
+	if err != nil {
+		return netip.Addr{}, false
+	}
+	return address.Unmap(), true
+}
+
+// Checks if the client is trusted so the request should be forwarded without being inspected
+func (ipVal *IPReputationValidator) Bypass(r *http.Request) bool {
+	address, ok := ipVal.getClientAddress(r)
+	return ok && ipVal.allowList.contains(address)
+}
+
+// The severities of the findings about the known bad addresses (the deny list is trusted more than the Tor exit nodes)
+const (
+	denyListSeverity = data.HIGH
+	torExitSeverity  = data.MEDIUM
+)
+
+// Checks if the finding was reported for a denied client (the address is in the deny list or the country is blocked)
+// In waf mode the denied clients are blocked even if no rule blocks the request, the Tor exit nodes and the hosting providers only add to the anomaly score
+func IsDeniedClientFinding(finding data.FindingData) bool {
+	return (finding.Classification == data.KNOWN_BAD_IP && finding.Severity == denyListSeverity) || finding.Classification == data.BLOCKED_COUNTRY
+}
+
+// Creates a finding about the address of the client (the address is not part of the raw request)
+func (ipVal *IPReputationValidator) newFinding(matchedString string, classification int64, severity int64) data.FindingData {
+	return data.FindingData{Line: -1, LineIndex: -1, Length: int64(len(matchedString)), MatchedString: matchedString, Classification: classification, Severity: severity, ValidatorName: ipVal.name}
+}
+
+// Validates the address of the client against the deny lists and the blocked countries and autonomous systems
+func (ipVal *IPReputationValidator) ValidateRequest(r *http.Request) ([]data.FindingData, error) {
+	address, ok := ipVal.getClientAddress(r)
+	if !ok {
+		return nil, nil
+	}
+
+	findings := make([]data.FindingData, 0)
+	if ipVal.denyList.contains(address) {
+		ipVal.logger.Info(ipVal.name, "found denied address:", address.String())
+		findings = append(findings, ipVal.newFinding(address.String(), data.KNOWN_BAD_IP, denyListSeverity))
+	} else if ipVal.torExitList.contains(address) {
+		ipVal.logger.Info(ipVal.name, "found Tor exit node:", address.String())
+		findings = append(findings, ipVal.newFinding(address.String(), data.KNOWN_BAD_IP, torExitSeverity))
+	}
+
+	if ipVal.countryDatabase != nil && len(ipVal.blockedCountries) != 0 {
+		record, err := ipVal.countryDatabase.lookup(address)
+		if err != nil {
+			return findings, err
+		}
+		country, _ := getMaxMindValue(record, "country", "iso_code").(string)
+		if country == "" {
+			country, _ = getMaxMindValue(record, "registered_country", "iso_code").(string)
+		}
+		if _, found := ipVal.blockedCountries[country]; found {
+			ipVal.logger.Info(ipVal.name, "found address from blocked country:", address.String(), country)
+			findings = append(findings, ipVal.newFinding(country, data.BLOCKED_COUNTRY, data.MEDIUM))
+		}
+	}
+
+	if ipVal.asnDatabase != nil && len(ipVal.hostingASNs) != 0 {
+		record, err := ipVal.asnDatabase.lookup(address)
+		if err != nil {
+			return findings, err
+		}
+		asn, _ := getMaxMindValue(record, "autonomous_system_number").(uint64)
+		if _, found := ipVal.hostingASNs[asn]; found {
+			organization, _ := getMaxMindValue(record, "autonomous_system_organization").(string)
+			matchedString := strings.TrimSpace("AS" + strconv.FormatUint(asn, 10) + " " + organization)
+			ipVal.logger.Info(ipVal.name, "found address from hosting provider:", address.String(), matchedString)
+			findings = append(findings, ipVal.newFinding(matchedString, data.HOSTING_ASN, data.LOW))
+		}
+	}
+
+	if len(findings) == 0 {
+		return nil, nil
+	}
+	return findings, nil
+}
+
+// Validates the response (do nothing function - the client address is checked on the request)
+func (ipVal *IPReputationValidator) ValidateResponse(r *http.Response) ([]data.FindingData, error) {
+	return nil, nil
+}
@@ -0,0 +1,86 @@
+package detection
+
+import (
+	"net/netip"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// Writes an ip list in a temporary file
+func writeIPList(t *testing.T, content string) string {
+	path := filepath.Join(t.TempDir(), "list.txt")
+	if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestLoadIPSet(t *testing.T) {
+	tests := []struct {
+		name      string
+		content   string
+		contained []string
+		missing   []string
+	}{
+		{
+			"addresses and networks",
+			"# bad addresses\n\n203.0.113.7\n198.51.100.0/24\n2001:db8::/32\n198.18.0.1/16\n",
+			[]string{"203.0.113.7", "198.51.100.200", "2001:db8::1", "198.18.200.1", "::ffff:203.0.113.7"},
+			[]string{"203.0.113.8", "198.51.101.1", "2001:db9::1"},
+		},
+		{
+			"tor bulk exit list",
+			"185.220.101.1\n185.220.101.2\n",
+			[]string{"185.220.101.1", "185.220.101.2"},
+			[]string{"185.220.101.3"},
+		},
+		{
+			"tor exit-addresses format",
+			"ExitNode 0011BD2485AD45D984EC4159C88FC066E5E3300E\n" +
+				"Published 2024-01-01 10:00:00\n" +
+				"LastStatus 2024-01-01 11:00:00\n" +
+				"ExitAddress 185.220.101.1 2024-01-01 11:05:00\n" +
+				"ExitNode 0111BA9B604669E636FFD5B503F382A4B7AD6E80\n" +
+				"Published 2024-01-01 10:30:00\n" +
+				"LastStatus 2024-01-01 11:30:00\n" +
+				"ExitAddress 176.10.99.200 2024-01-01 11:35:00\n" +
+				"ExitAddress 2a0b:f4c2::1 2024-01-01 11:40:00\n",
+			[]string{"185.220.101.1", "176.10.99.200", "2a0b:f4c2::1"},
+			[]string{"185.220.101.2", "10.0.0.1"},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			set, err := loadIPSet(writeIPList(t, test.content))
+			if err != nil {
+				t.Fatal(err)
+			}
+			for _, address := range test.contained {
+				if !set.contains(netip.MustParseAddr(address).Unmap()) {
+					t.Errorf("expected %s to be in the set", address)
+				}
+			}
+			for _, address := range test.missing {
+				if set.contains(netip.MustParseAddr(address).Unmap()) {
+					t.Errorf("expected %s not to be in the set", address)
+				}
+			}
+		})
+	}
+}
+
+func TestLoadIPSetInvalid(t *testing.T) {
+	for _, content := range []string{"203.0.113.300\n", "198.51.100.0/33\n", "example.com\n"} {
+		if _, err := loadIPSet(writeIPList(t, content)); err == nil {
+			t.Errorf("expected an error for the list %q", content)
+		}
+	}
+	if _, err := loadIPSet(filepath.Join(t.TempDir(), "missing.txt")); err == nil {
+		t.Error("expected an error for the missing list")
+	}
+	set, err := loadIPSet("")
+	if err != nil || set.contains(netip.MustParseAddr("203.0.113.7")) {
+		t.Errorf("expected an empty set for the empty path, got error %v", err)
+	}
+}
@@ -2,12 +2,46 @@ package detection
 
 import (
 	"net/http"
+	"time"
 
 	"github.com/lucacoratu/disertatie/agent/data"
 )
 
+// The phases of the traffic a validator inspects (the flags can be combined)
+type ValidatorPhase int
+
+const (
+	PhaseRequest  ValidatorPhase = 1 << iota //The validator inspects the requests
+	PhaseResponse                            //The validator inspects the responses
+)
+
+// Checks if the phase is one of the phases of the flags
+func (phases ValidatorPhase) Has(phase ValidatorPhase) bool {
+	return phases&phase != 0
+}
+
 // Interface that holds all the functions that the validators should implement
 type IValidator interface {
+	//Gets the name of the validator (the name used in the findings)
+	GetName() string
+	//Gets the phases of the traffic the validator inspects, the runner skips the validator in the other phases
+	GetPhases() ValidatorPhase
+	//Prepares the validator before it is used (loads the lists, opens the databases)
+	Init() error
+	//Releases the resources of the validator when the agent stops
+	Close() error
 	ValidateRequest(r *http.Request) ([]data.FindingData, error)
 	ValidateResponse(r *http.Response) ([]data.FindingData, error)
 }
+
+// Interface implemented by the validators which can exempt trusted clients from the inspection
+type IBypassValidator interface {
+	//Checks if the request should be forwarded without running the validators and the rules
+	Bypass(r *http.Request) bool
+}
+
+// Interface implemented by the validators which throttle the clients before the request is inspected
+type ILimitValidator interface {
+	//Checks if the client exceeded its limits, returns the time the client should wait before retrying, the findings and true if the request should be throttled
+	Limit(r *http.Request) (time.Duration, []data.FindingData, bool)
+}
@@ -0,0 +1,334 @@
+package detection
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"math"
+	"net/netip"
+	"os"
+	"strconv"
+)
+
+// The marker which precedes the metadata section at the end of a MaxMind DB file
+var maxMindMetadataMarker = []byte("\xAB\xCD\xEFMaxMind.com")
+
+// The data section starts after the search tree and 16 bytes of zeros
+const maxMindDataSectionSeparator = 16
+
+// The types of the fields of the data section
+const (
+	maxMindTypeExtended  = 0
+	maxMindTypePointer   = 1
+	maxMindTypeString    = 2
+	maxMindTypeDouble    = 3
+	maxMindTypeBytes     = 4
+	maxMindTypeUint16    = 5
+	maxMindTypeUint32    = 6
+	maxMindTypeMap       = 7
+	maxMindTypeInt32     = 8
+	maxMindTypeUint64    = 9
+	maxMindTypeUint128   = 10
+	maxMindTypeArray     = 11
+	maxMindTypeContainer = 12
+	maxMindTypeEndMarker = 13
+	maxMindTypeBoolean   = 14
+	maxMindTypeFloat     = 15
+)
+
+// Holds an offline MaxMind DB (GeoIP2, GeoLite2 or any database in the MaxMind DB format)
+// The whole file is kept in memory, the lookups do not allocate except for the decoded record
+type maxMindDatabase struct {
+	buffer       []byte //The search tree and the data section
+	nodeCount    uint64 //The number of nodes of the search tree
+	recordSize   uint64 //The size in bits of a record of a node (24, 28 or 32)
+	ipVersion    uint64 //The version of the addresses in the search tree (4 or 6)
+	databaseType string //The type of the database (GeoLite2-Country, GeoLite2-ASN)
+	dataSection  []byte //The data section holding the records of the networks
+	ipv4Start    uint64 //The node where the IPv4 addresses start in an IPv6 tree
+}
+
+// Opens a MaxMind DB file
+// @param path - the path to the .mmdb file
+// Returns the database or an error if the file is not a valid MaxMind DB
+func openMaxMindDatabase(path string) (*maxMindDatabase, error) {
+	buffer, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	markerIndex := bytes.LastIndex(buffer, maxMindMetadataMarker)
+	if markerIndex == -1 {
+		return nil, errors.New("invalid MaxMind DB file " + path + ", the metadata marker cannot be found")
+	}
+
+	//The metadata is a map encoded with the types of the data section
+	metadataValue, _, err := decodeMaxMindValue(buffer[markerIndex+len(maxMindMetadataMarker):], 0, 0)
+	if err != nil {
+		return nil, errors.New("invalid MaxMind DB metadata in " + path + ", " + err.Error())
+	}
+	metadata, ok := metadataValue.(map[string]interface{})
+	if !ok {
+		return nil, errors.New("invalid MaxMind DB metadata in " + path + ", the metadata is not a map")
+	}
+	database := &maxMindDatabase{}
+	database.nodeCount, _ = metadata["node_count"].(uint64)
+	database.recordSize, _ = metadata["record_size"].(uint64)
+	database.ipVersion, _ = metadata["ip_version"].(uint64)
+	database.databaseType, _ = metadata["database_type"].(string)
+	if database.recordSize != 24 && database.recordSize != 28 && database.recordSize != 32 {
+		return nil, errors.New("invalid MaxMind DB file " + path + ", unsupported record size " + strconv.FormatUint(database.recordSize, 10))
+	}
+	if database.ipVersion != 4 && database.ipVersion != 6 {
+		return nil, errors.New("invalid MaxMind DB file " + path + ", unsupported ip version " + strconv.FormatUint(database.ipVersion, 10))
+	}
+
+	treeSize := database.nodeCount * database.recordSize / 4
+	if treeSize+maxMindDataSectionSeparator > uint64(markerIndex) {
+		return nil, errors.New("invalid MaxMind DB file " + path + ", the search tree is larger than the file")
+	}
+	database.buffer = buffer[:markerIndex]
+	database.dataSection = buffer[treeSize+maxMindDataSectionSeparator : markerIndex]
+
+	//The IPv4 addresses are stored in the IPv6 tree under ::/96
+	if database.ipVersion == 6 {
+		node := uint64(0)
+		for i := 0; i < 96 && node < database.nodeCount; i++ {
+			node, err = database.readNode(node, 0)
+			if err != nil {
+				return nil, err
+			}
+		}
+		database.ipv4Start = node
+	}
+	return database, nil
+}
+
+// Reads a record of a node of the search tree
+// @param node - the number of the node
+// @param bit - the record which is read (0 for the left record, 1 for the right record)
+// Returns the value of the record
+func (database *maxMindDatabase) readNode(node uint64, bit uint) (uint64, error) {
+	nodeSize := database.recordSize / 4
+	offset := node * nodeSize
+	if offset+nodeSize > uint64(len(database.buffer)) {
+		return 0, errors.New("invalid MaxMind DB search tree, the node is outside the file")
+	}
+	b := database.buffer[offset : offset+nodeSize]
+	switch database.recordSize {
+	case 24:
+		if bit == 0 {
+			return uint64(b[0])<<16 | uint64(b[1])<<8 | uint64(b[2]), nil
+		}
+		return uint64(b[3])<<16 | uint64(b[4])<<8 | uint64(b[5]), nil
+	case 28:
+		if bit == 0 {
+			return uint64(b[3]&0xF0)<<20 | uint64(b[0])<<16 | uint64(b[1])<<8 | uint64(b[2]), nil
+		}
+		return uint64(b[3]&0x0F)<<24 | uint64(b[4])<<16 | uint64(b[5])<<8 | uint64(b[6]), nil
+	default:
+		if bit == 0 {
+			return uint64(binary.BigEndian.Uint32(b[0:4])), nil
+		}
+		return uint64(binary.BigEndian.Uint32(b[4:8])), nil
+	}
+}
+
+// Looks up the record of the network which contains the address
+// @param address - the address
+// Returns the decoded record (nil if the address is not in the database)
+func (database *maxMindDatabase) lookup(address netip.Addr) (map[string]interface{}, error) {
+	address = address.Unmap()
+	node := uint64(0)
+	if address.Is4() && database.ipVersion == 6 {
+		node = database.ipv4Start
+	} else if address.Is6() && database.ipVersion == 4 {
+		return nil, nil
+	}
+
+	ip := address.AsSlice()
+	for i := 0; i < len(ip)*8 && node < database.nodeCount; i++ {
+		bit := uint(ip[i/8]>>(7-uint(i%8))) & 1
+		next, err := database.readNode(node, bit)
+		if err != nil {
+			return nil, err
+		}
+		node = next
+	}
+	//The node count is the record of the networks which are not in the database
+	if node <= database.nodeCount {
+		return nil, nil
+	}
+
+	offset := node - database.nodeCount - maxMindDataSectionSeparator
+	if offset >= uint64(len(database.dataSection)) {
+		return nil, errors.New("invalid MaxMind DB search tree, the record is outside the data section")
+	}
+	value, _, err := decodeMaxMindValue(database.dataSection, int(offset), 0)
+	if err != nil {
+		return nil, err
+	}
+	record, _ := value.(map[string]interface{})
+	return record, nil
+}
+
+// Decodes a field of the data section
+// @param section - the section the pointers are relative to
+// @param offset - the offset of the field in the section
+// @param depth - the number of containers the field is in (the depth is limited so a corrupted file cannot loop)
+// Returns the value and the offset after the field
+func decodeMaxMindValue(section []byte, offset int, depth int) (interface{}, int, error) {
+	if depth > 32 {
+		return nil, 0, errors.New("the data section is nested too deep")
+	}
+	if offset >= len(section) {
+		return nil, 0, errors.New("unexpected end of the data section")
+	}
+	control := section[offset]
+	offset++
+	fieldType := int(control >> 5)
+	if fieldType == maxMindTypeExtended {
+		if offset >= len(section) {
+			return nil, 0, errors.New("unexpected end of the data section")
+		}
+		fieldType = 7 + int(section[offset])
+		offset++
+	}
+
+	//The pointers use the size bits for the length of the pointer
+	if fieldType == maxMindTypePointer {
+		pointerSize := int((control>>3)&0x3) + 1
+		if offset+pointerSize > len(section) {
+			return nil, 0, errors.New("unexpected end of the data section")
+		}
+		pointer := 0
+		if pointerSize != 4 {
+			pointer = int(control & 0x7)
+		}
+		for _, b := range section[offset : offset+pointerSize] {
+			pointer = pointer<<8 | int(b)
+		}
+		switch pointerSize {
+		case 2:
+			pointer += 2048
+		case 3:
+			pointer += 526336
+		}
+		value, _, err := decodeMaxMindValue(section, pointer, depth+1)
+		return value, offset + pointerSize, err
+	}
+
+	size := int(control & 0x1f)
+	if size >= 29 {
+		extraBytes := size - 28
+		if offset+extraBytes > len(section) {
+			return nil, 0, errors.New("unexpected end of the data section")
+		}
+		extra := 0
+		for _, b := range section[offset : offset+extraBytes] {
+			extra = extra<<8 | int(b)
+		}
+		offset += extraBytes
+		switch extraBytes {
+		case 1:
+			size = 29 + extra
+		case 2:
+			size = 285 + extra
+		default:
+			size = 65821 + extra
+		}
+	}
+
+	switch fieldType {
+	case maxMindTypeMap:
+		record := make(map[string]interface{}, size)
+		for i := 0; i < size; i++ {
+			key, next, err := decodeMaxMindValue(section, offset, depth+1)
+			if err != nil {
+				return nil, 0, err
+			}
+			keyString, ok := key.(string)
+			if !ok {
+				return nil, 0, errors.New("the key of a map is not a string")
+			}
+			value, next, err := decodeMaxMindValue(section, next, depth+1)
+			if err != nil {
+				return nil, 0, err
+			}
+			record[keyString] = value
+			offset = next
+		}
+		return record, offset, nil
+	case maxMindTypeArray:
+		values := make([]interface{}, 0, size)
+		for i := 0; i < size; i++ {
+			value, next, err := decodeMaxMindValue(section, offset, depth+1)
+			if err != nil {
+				return nil, 0, err
+			}
+			values = append(values, value)
+			offset = next
+		}
+		return values, offset, nil
+	case maxMindTypeBoolean:
+		return size != 0, offset, nil
+	case maxMindTypeContainer, maxMindTypeEndMarker:
+		return nil, offset, nil
+	}
+
+	if offset+size > len(section) {
+		return nil, 0, errors.New("unexpected end of the data section")
+	}
+	payload := section[offset : offset+size]
+	offset += size
+	switch fieldType {
+	case maxMindTypeString:
+		return string(payload), offset, nil
+	case maxMindTypeBytes, maxMindTypeUint128:
+		return append([]byte(nil), payload...), offset, nil
+	case maxMindTypeDouble:
+		if size != 8 {
+			return nil, 0, errors.New("invalid size of a double")
+		}
+		return math.Float64frombits(binary.BigEndian.Uint64(payload)), offset, nil
+	case maxMindTypeFloat:
+		if size != 4 {
+			return nil, 0, errors.New("invalid size of a float")
+		}
+		return float64(math.Float32frombits(binary.BigEndian.Uint32(payload))), offset, nil
+	case maxMindTypeUint16, maxMindTypeUint32, maxMindTypeUint64:
+		if size > 8 {
+			return nil, 0, errors.New("invalid size of an unsigned integer")
+		}
+		value := uint64(0)
+		for _, b := range payload {
+			value = value<<8 | uint64(b)
+		}
+		return value, offset, nil
+	case maxMindTypeInt32:
+		if size > 4 {
+			return nil, 0, errors.New("invalid size of an integer")
+		}
+		value := uint32(0)
+		for _, b := range payload {
+			value = value<<8 | uint32(b)
+		}
+		return int64(int32(value)), offset, nil
+	}
+	return nil, 0, errors.New("unknown type of field " + strconv.Itoa(fieldType))
+}
+
+// Gets a nested value of a record (record["country"]["iso_code"])
+// @param record - the decoded record
+// @param keys - the keys of the nested maps
+// Returns the value or nil if one of the keys is missing
+func getMaxMindValue(record map[string]interface{}, keys ...string) interface{} {
+	var value interface{} = record
+	for _, key := range keys {
+		current, ok := value.(map[string]interface{})
+		if !ok {
+			return nil
+		}
+		value = current[key]
+	}
+	return value
+}
@@ -0,0 +1,341 @@
+package detection
+
+import (
+	"encoding/binary"
+	"net/netip"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"testing"
+)
+
+// A pointer to a field of the data section written with the specified pointer size (1 to 4 bytes)
+type maxMindTestPointer struct {
+	offset int
+	size   int
+}
+
+// A field of the data section holding raw bytes (used to move the records to large offsets)
+type maxMindTestBytes int
+
+// Encodes the control byte of a field of the data section
+func encodeMaxMindControl(fieldType int, size int) []byte {
+	var sizeBits byte
+	var extra []byte
+	switch {
+	case size < 29:
+		sizeBits = byte(size)
+	case size < 285:
+		sizeBits, extra = 29, []byte{byte(size - 29)}
+	case size < 65821:
+		sizeBits, extra = 30, binary.BigEndian.AppendUint16(nil, uint16(size-285))
+	default:
+		sizeBits, extra = 31, binary.BigEndian.AppendUint32(nil, uint32(size-65821))[1:]
+	}
+	if fieldType <= maxMindTypeMap {
+		return append([]byte{byte(fieldType)<<5 | sizeBits}, extra...)
+	}
+	return append([]byte{sizeBits, byte(fieldType - 7)}, extra...)
+}
+
+// Encodes an unsigned integer on the minimum number of bytes
+func encodeMaxMindUint(fieldType int, value uint64) []byte {
+	payload := binary.BigEndian.AppendUint64(nil, value)
+	for len(payload) > 0 && payload[0] == 0 {
+		payload = payload[1:]
+	}
+	return append(encodeMaxMindControl(fieldType, len(payload)), payload...)
+}
+
+// Encodes a value as a field of the data section
+func encodeMaxMindValue(t *testing.T, value interface{}) []byte {
+	switch value := value.(type) {
+	case string:
+		return append(encodeMaxMindControl(maxMindTypeString, len(value)), value...)
+	case uint16:
+		return encodeMaxMindUint(maxMindTypeUint16, uint64(value))
+	case uint32:
+		return encodeMaxMindUint(maxMindTypeUint32, uint64(value))
+	case maxMindTestBytes:
+		return append(encodeMaxMindControl(maxMindTypeBytes, int(value)), make([]byte, value)...)
+	case maxMindTestPointer:
+		pointer := value.offset
+		switch value.size {
+		case 2:
+			pointer -= 2048
+		case 3:
+			pointer -= 526336
+		}
+		payload := binary.BigEndian.AppendUint32(nil, uint32(pointer))
+		control := byte(maxMindTypePointer<<5 | (value.size-1)<<3)
+		if value.size != 4 {
+			control |= payload[4-value.size-1] & 0x7
+		}
+		return append([]byte{control}, payload[4-value.size:]...)
+	case map[string]interface{}:
+		keys := make([]string, 0, len(value))
+		for key := range value {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		encoded := encodeMaxMindControl(maxMindTypeMap, len(value))
+		for _, key := range keys {
+			encoded = append(encoded, encodeMaxMindValue(t, key)...)
+			encoded = append(encoded, encodeMaxMindValue(t, value[key])...)
+		}
+		return encoded
+	}
+	t.Fatalf("cannot encode %T in the data section", value)
+	return nil
+}
+
+// A network of the test database and its record
+type maxMindTestNetwork struct {
+	prefix string
+	record interface{}
+}
+
+// Writes a MaxMind DB with the networks
+// @param ipVersion - the version of the addresses in the search tree (the IPv4 networks are stored under ::/96 in an IPv6 tree)
+// @param recordSize - the size in bits of the records of the nodes
+// @param padding - the size of the field written before the records in the data section (to test the large records)
+// Returns the path to the database
+func writeMaxMindDatabase(t *testing.T, ipVersion int, recordSize int, padding int, networks []maxMindTestNetwork) string {
+	dataSection := make([]byte, 0)
+	if padding > 0 {
+		dataSection = append(dataSection, encodeMaxMindValue(t, maxMindTestBytes(padding))...)
+	}
+
+	//The children of the nodes are 0 if empty, the index of the node if positive and -(offset + 1) of the record if negative
+	nodes := [][2]int{{0, 0}}
+	for _, network := range networks {
+		offset := len(dataSection)
+		dataSection = append(dataSection, encodeMaxMindValue(t, network.record)...)
+
+		prefix := netip.MustParsePrefix(network.prefix)
+		bits := prefix.Addr().AsSlice()
+		prefixLength := prefix.Bits()
+		if ipVersion == 6 && prefix.Addr().Is4() {
+			bits = append(make([]byte, 12), bits...)
+			prefixLength += 96
+		}
+		node := 0
+		for i := 0; i < prefixLength; i++ {
+			bit := int(bits[i/8]>>(7-i%8)) & 1
+			if i == prefixLength-1 {
+				nodes[node][bit] = -(offset + 1)
+				break
+			}
+			if nodes[node][bit] <= 0 {
+				nodes = append(nodes, [2]int{0, 0})
+				nodes[node][bit] = len(nodes) - 1
+			}
+			node = nodes[node][bit]
+		}
+	}
+
+	nodeCount := len(nodes)
+	tree := make([]byte, 0, nodeCount*recordSize/4)
+	for _, node := range nodes {
+		records := [2]uint32{}
+		for i, child := range node {
+			switch {
+			case child == 0:
+				records[i] = uint32(nodeCount)
+			case child > 0:
+				records[i] = uint32(child)
+			default:
+				records[i] = uint32(nodeCount + maxMindDataSectionSeparator - child - 1)
+			}
+		}
+		switch recordSize {
+		case 24:
+			tree = append(tree, byte(records[0]>>16), byte(records[0]>>8), byte(records[0]))
+			tree = append(tree, byte(records[1]>>16), byte(records[1]>>8), byte(records[1]))
+		case 28:
+			tree = append(tree, byte(records[0]>>16), byte(records[0]>>8), byte(records[0]))
+			tree = append(tree, byte(records[0]>>24)<<4|byte(records[1]>>24))
+			tree = append(tree, byte(records[1]>>16), byte(records[1]>>8), byte(records[1]))
+		default:
+			tree = binary.BigEndian.AppendUint32(tree, records[0])
+			tree = binary.BigEndian.AppendUint32(tree, records[1])
+		}
+	}
+
+	metadata := encodeMaxMindValue(t, map[string]interface{}{
+		"node_count":                  uint32(nodeCount),
+		"record_size":                 uint16(recordSize),
+		"ip_version":                  uint16(ipVersion),
+		"database_type":               "Test-Country",
+		"binary_format_major_version": uint16(2),
+	})
+	content := append(tree, make([]byte, maxMindDataSectionSeparator)...)
+	content = append(content, dataSection...)
+	content = append(content, maxMindMetadataMarker...)
+	content = append(content, metadata...)
+
+	path := filepath.Join(t.TempDir(), "test.mmdb")
+	if err := os.WriteFile(path, content, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+// Creates the record of a country
+func newCountryRecord(isoCode string) map[string]interface{} {
+	return map[string]interface{}{"country": map[string]interface{}{"iso_code": isoCode}}
+}
+
+func TestMaxMindLookup(t *testing.T) {
+	tests := []struct {
+		name       string
+		ipVersion  int
+		recordSize int
+		padding    int
+	}{
+		{"ipv4 tree with 24 bits records", 4, 24, 0},
+		{"ipv6 tree with 24 bits records", 6, 24, 0},
+		{"ipv6 tree with 28 bits records", 6, 28, 0},
+		{"ipv6 tree with 28 bits records above 2^24", 6, 28, 1 << 24},
+		{"ipv4 tree with 32 bits records", 4, 32, 0},
+		{"ipv6 tree with 32 bits records above 2^24", 6, 32, 1 << 24},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			networks := []maxMindTestNetwork{
+				{"10.1.0.0/16", newCountryRecord("RO")},
+				{"10.2.0.0/16", map[string]interface{}{"registered_country": map[string]interface{}{"iso_code": "KP"}}},
+			}
+			if test.ipVersion == 6 {
+				networks = append(networks, maxMindTestNetwork{"2001:db8::/32", newCountryRecord("RU")})
+			}
+			database, err := openMaxMindDatabase(writeMaxMindDatabase(t, test.ipVersion, test.recordSize, test.padding, networks))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			lookups := []struct {
+				address  string
+				keys     []string
+				expected interface{}
+			}{
+				{"10.1.2.3", []string{"country", "iso_code"}, "RO"},
+				{"::ffff:10.1.2.3", []string{"country", "iso_code"}, "RO"},
+				{"10.2.255.255", []string{"registered_country", "iso_code"}, "KP"},
+				{"10.3.0.1", nil, nil},
+				{"192.168.0.1", nil, nil},
+				{"2001:db9::1", nil, nil},
+			}
+			if test.ipVersion == 6 {
+				lookups = append(lookups, struct {
+					address  string
+					keys     []string
+					expected interface{}
+				}{"2001:db8:1::1", []string{"country", "iso_code"}, "RU"})
+			}
+			for _, lookup := range lookups {
+				record, err := database.lookup(netip.MustParseAddr(lookup.address))
+				if err != nil {
+					t.Fatalf("%s: %s", lookup.address, err.Error())
+				}
+				if lookup.expected == nil {
+					if record != nil {
+						t.Errorf("%s: expected no record, got %v", lookup.address, record)
+					}
+					continue
+				}
+				if value := getMaxMindValue(record, lookup.keys...); value != lookup.expected {
+					t.Errorf("%s: expected %v, got %v", lookup.address, lookup.expected, value)
+				}
+			}
+		})
+	}
+}
+
+func TestDecodeMaxMindPointers(t *testing.T) {
+	//The targets are placed in the ranges of every pointer size
+	targets := map[int]string{10: "size 1", 3000: "size 2", 600000: "size 3"}
+	offsets := []int{10, 3000, 600000}
+	section := make([]byte, 0)
+	for _, offset := range offsets {
+		section = append(section, make([]byte, offset-len(section))...)
+		section = append(section, encodeMaxMindValue(t, targets[offset])...)
+	}
+
+	tests := []struct {
+		pointer  maxMindTestPointer
+		expected string
+	}{
+		{maxMindTestPointer{10, 1}, "size 1"},
+		{maxMindTestPointer{3000, 2}, "size 2"},
+		{maxMindTestPointer{600000, 3}, "size 3"},
+		{maxMindTestPointer{10, 4}, "size 1"},
+		{maxMindTestPointer{600000, 4}, "size 3"},
+	}
+	for _, test := range tests {
+		pointerOffset := len(section)
+		pointerSection := append(append([]byte(nil), section...), encodeMaxMindValue(t, test.pointer)...)
+		value, next, err := decodeMaxMindValue(pointerSection, pointerOffset, 0)
+		if err != nil {
+			t.Fatalf("pointer of size %d to %d: %s", test.pointer.size, test.pointer.offset, err.Error())
+		}
+		if value != test.expected {
+			t.Errorf("pointer of size %d to %d: expected %q, got %v", test.pointer.size, test.pointer.offset, test.expected, value)
+		}
+		if next != pointerOffset+1+test.pointer.size {
+			t.Errorf("pointer of size %d to %d: expected the next field at %d, got %d", test.pointer.size, test.pointer.offset, pointerOffset+1+test.pointer.size, next)
+		}
+	}
+}
+
+func TestDecodeMaxMindValue(t *testing.T) {
+	record := map[string]interface{}{
+		"autonomous_system_number":       uint32(16509),
+		"autonomous_system_organization": "AMAZON-02",
+		"location":                       map[string]interface{}{"metro_code": uint16(807)},
+	}
+	value, next, err := decodeMaxMindValue(encodeMaxMindValue(t, record), 0, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := map[string]interface{}{
+		"autonomous_system_number":       uint64(16509),
+		"autonomous_system_organization": "AMAZON-02",
+		"location":                       map[string]interface{}{"metro_code": uint64(807)},
+	}
+	if !reflect.DeepEqual(value, expected) {
+		t.Errorf("expected %v, got %v", expected, value)
+	}
+	if encoded := encodeMaxMindValue(t, record); next != len(encoded) {
+		t.Errorf("expected the next field at %d, got %d", len(encoded), next)
+	}
+
+	//The truncated fields and the pointers looping on themselves are rejected
+	if _, _, err := decodeMaxMindValue([]byte{maxMindTypeString<<5 | 10, 'a'}, 0, 0); err == nil {
+		t.Error("expected an error for the truncated string")
+	}
+	if _, _, err := decodeMaxMindValue(encodeMaxMindValue(t, maxMindTestPointer{0, 1}), 0, 0); err == nil {
+		t.Error("expected an error for the pointer to itself")
+	}
+}
+
+func TestOpenMaxMindDatabaseInvalid(t *testing.T) {
+	directory := t.TempDir()
+	noMarker := filepath.Join(directory, "nomarker.mmdb")
+	if err := os.WriteFile(noMarker, make([]byte, 64), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := openMaxMindDatabase(noMarker); err == nil {
+		t.Error("expected an error for the file without the metadata marker")
+	}
+
+	invalidRecordSize := filepath.Join(directory, "recordsize.mmdb")
+	metadata := encodeMaxMindValue(t, map[string]interface{}{"node_count": uint32(1), "record_size": uint16(20), "ip_version": uint16(4)})
+	if err := os.WriteFile(invalidRecordSize, append(append(make([]byte, 32), maxMindMetadataMarker...), metadata...), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := openMaxMindDatabase(invalidRecordSize); err == nil {
+		t.Error("expected an error for the unsupported record size")
+	}
+}
@@ -0,0 +1,288 @@
+package detection
+
+import (
+	"errors"
+	"math"
+	"net"
+	"net/http"
+	"regexp"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/lucacoratu/disertatie/agent/config"
+	"github.com/lucacoratu/disertatie/agent/data"
+	rules "github.com/lucacoratu/disertatie/agent/detection/rules"
+	"github.com/lucacoratu/disertatie/agent/logging"
+	"github.com/lucacoratu/disertatie/agent/utils"
+)
+
+// The keys which identify the client of a rate limit
+const (
+	RateLimitKeyIP     = "ip"      //The address of the client (the default)
+	RateLimitKeyIPPath = "ip-path" //The address of the client and the path of the request (every path has its own bucket)
+	RateLimitKeyHeader = "header"  //The value of a header (an API key), the address of the client if the header is missing
+	RateLimitKeyCookie = "cookie"  //The value of a cookie (the session), the address of the client if the cookie is missing
+)
+
+// The default values of the rate limiting (used when they are not specified in the config block)
+const (
+	defaultRateLimitBanWindow   = 60    //The period in seconds in which the violations are counted
+	defaultRateLimitBanDuration = 300   //How long in seconds a client is banned
+	defaultRateLimitMaxClients  = 10000 //The number of clients whose state is kept
+)
+
+// A token bucket limit of the requests of a client
+// The bucket holds burst tokens and is refilled with requests tokens every period, every request takes a token
+type RateLimit struct {
+	Path     string `json:"path"`                                                             //The glob of the paths the limit applies to (/login, /api/**), ignored for the global limit
+	Requests int    `json:"requests" validate:"gt=0"`                                         //The number of requests allowed in the period
+	Period   int    `json:"period" validate:"gt=0"`                                           //The period in seconds
+	Burst    int    `json:"burst" validate:"gte=0"`                                           //The number of requests the client can send at once (the requests if 0)
+	Key      string `json:"key" validate:"omitempty,oneof=ip ip-path header cookie"`          //What identifies the client (ip if empty)
+	KeyName  string `json:"keyName" validate:"required_if=Key header,required_if=Key cookie"` //The name of the header or the cookie identifying the client
+}
+
+// The config block of the RateLimitValidator
+// The clients which exceed a limit receive 429 in waf mode, in the other modes the findings are only logged
+type RateLimitValidatorOptions struct {
+	Global      *RateLimit  `json:"global"`                       //The limit of all the requests (no global limit if missing)
+	Paths       []RateLimit `json:"paths" validate:"dive"`        //The limits of the requests on the paths matching the globs
+	BanAfter    int         `json:"banAfter" validate:"gte=0"`    //The number of violations in the ban window after which the client is banned (0 disables the ban)
+	BanWindow   int         `json:"banWindow" validate:"gte=0"`   //The period in seconds in which the violations are counted (60 if 0)
+	BanDuration int         `json:"banDuration" validate:"gte=0"` //How long in seconds a banned client receives 429 on every request (300 if 0)
+	MaxClients  int         `json:"maxClients" validate:"gte=0"`  //The number of buckets and bans kept in memory, the least recently seen clients are evicted (10000 if 0)
+}
+
+// Holds a limit with its compiled path glob
+type compiledRateLimit struct {
+	RateLimit
+	name      string         //The name of the limit used in the logs (global or the path glob)
+	pathRegex *regexp.Regexp //The regex of the path glob (nil for the global limit)
+	rate      float64        //The tokens added every second
+	capacity  float64        //The maximum number of tokens
+}
+
+// Holds the tokens of a client for a limit
+type tokenBucket struct {
+	tokens  float64
+	updated time.Time
+}
+
+// Holds the violations of a client and when its ban expires
+type clientBan struct {
+	violations  int
+	windowStart time.Time
+	bannedUntil time.Time
+}
+
+// Throttles the clients which send too many requests and bans the clients which keep exceeding the limits
+// The limits are checked by the validator runner before the request is inspected, not in the request phase
+type RateLimitValidator struct {
+	configuration config.Configuration
+	logger        logging.ILogger
+	name          string
+	options       RateLimitValidatorOptions
+	limits        []compiledRateLimit
+	mu            sync.Mutex
+	buckets       *utils.LRUCache[string, *tokenBucket] //The buckets by limit and client
+	bans          *utils.LRUCache[string, *clientBan]   //The violations and the bans by client
+	now           func() time.Time                      //The clock used to refill the buckets and expire the bans (time.Now, replaced in the tests)
+}
+
+// Creates an instance of the RateLimitValidator from the config block in the validators list
+func NewRateLimitValidatorFromOptions(logger logging.ILogger, configuration config.Configuration, options RateLimitValidatorOptions) (IValidator, error) {
+	limitVal := &RateLimitValidator{logger: logger, name: "RateLimitValidator", configuration: configuration, options: options, now: time.Now}
+	if options.Global != nil {
+		limitVal.limits = append(limitVal.limits, newCompiledRateLimit(*options.Global, "global", nil))
+	}
+	for _, limit := range options.Paths {
+		if limit.Path == "" {
+			return nil, errors.New("the rate limits of the paths need a path glob")
+		}
+		pathRegex, err := regexp.Compile(rules.GlobToRegex(limit.Path))
+		if err != nil {
+			return nil, errors.New("invalid path glob of rate limit " + limit.Path + ", " + err.Error())
+		}
+		limitVal.limits = append(limitVal.limits, newCompiledRateLimit(limit, limit.Path, pathRegex))
+	}
+	if len(limitVal.limits) == 0 {
+		return nil, errors.New("no rate limit is configured, add the global limit or the limits of the paths")
+	}
+	if limitVal.options.BanWindow == 0 {
+		limitVal.options.BanWindow = defaultRateLimitBanWindow
+	}
+	if limitVal.options.BanDuration == 0 {
+		limitVal.options.BanDuration = defaultRateLimitBanDuration
+	}
+	if limitVal.options.MaxClients == 0 {
+		limitVal.options.MaxClients = defaultRateLimitMaxClients
+	}
+	return limitVal, nil
+}
+
+// Computes the rate and the capacity of the bucket of the limit
+func newCompiledRateLimit(limit RateLimit, name string, pathRegex *regexp.Regexp) compiledRateLimit {
+	capacity := limit.Burst
+	if capacity == 0 {
+		capacity = limit.Requests
+	}
+	if limit.Key == "" {
+		limit.Key = RateLimitKeyIP
+	}
+	return compiledRateLimit{RateLimit: limit, name: name, pathRegex: pathRegex, rate: float64(limit.Requests) / float64(limit.Period), capacity: float64(capacity)}
+}
+
+// Gets the name of the validator
+func (limitVal *RateLimitValidator) GetName() string {
+	return limitVal.name
+}
+
+// Gets the phases inspected by the validator (none, the limits are checked before the inspection)
+func (limitVal *RateLimitValidator) GetPhases() ValidatorPhase {
+	return 0
+}
+
+// Creates the buckets and the ban list
+func (limitVal *RateLimitValidator) Init() error {
+	limitVal.buckets = utils.NewLRUCache[string, *tokenBucket](limitVal.options.MaxClients)
+	limitVal.bans = utils.NewLRUCache[string, *clientBan](limitVal.options.MaxClients)
+	return nil
+}
+
+// Releases the resources of the validator (nothing to release, the state is kept in memory)
+func (limitVal *RateLimitValidator) Close() error {
+	return nil
+}
+
+// Gets the value identifying the client of the limit
+// The header and cookie keys fall back to the address of the client when the request does not have them
+// @param r - the request
+// @param limit - the limit
+// Returns the client (used for the ban list) and the key of the bucket
+func (limitVal *RateLimitValidator) getClientKey(r *http.Request, limit compiledRateLimit) (string, string) {
+	remoteAddress := r.RemoteAddr
+	if host, _, err := net.SplitHostPort(remoteAddress); err == nil {
+		remoteAddress = host
+	}
+	client := "ip " + remoteAddress
+	switch limit.Key {
+	case RateLimitKeyHeader:
+		if value := r.Header.Get(limit.KeyName); value != "" {
+			client = "header " + limit.KeyName + " " + value
+		}
+	case RateLimitKeyCookie:
+		if cookie, err := r.Cookie(limit.KeyName); err == nil && cookie.Value != "" {
+			client = "cookie " + limit.KeyName + " " + cookie.Value
+		}
+	case RateLimitKeyIPPath:
+		return client, limit.name + "|" + client + "|" + r.URL.Path
+	}
+	return client, limit.name + "|" + client
+}
+
+// Counts a violation of the client and bans the client if it reached the number of violations
+// @param client - the client which exceeded a limit
+// @param now - the time of the request
+// Returns the ban of the client (nil if the ban is disabled)
+func (limitVal *RateLimitValidator) addViolation(client string, now time.Time) *clientBan {
+	if limitVal.options.BanAfter == 0 {
+		return nil
+	}
+	ban, found := limitVal.bans.Get(client)
+	if !found {
+		ban = &clientBan{windowStart: now}
+		limitVal.bans.Add(client, ban)
+	}
+	if now.Sub(ban.windowStart) > time.Duration(limitVal.options.BanWindow)*time.Second {
+		ban.violations = 0
+		ban.windowStart = now
+	}
+	ban.violations++
+	if ban.violations >= limitVal.options.BanAfter {
+		ban.bannedUntil = now.Add(time.Duration(limitVal.options.BanDuration) * time.Second)
+		ban.violations = 0
+		ban.windowStart = now
+	}
+	return ban
+}
+
+// Creates a finding about the client (the client is not located in the raw request)
+func (limitVal *RateLimitValidator) newFinding(client string, classification int64, severity int64) data.FindingData {
+	return data.FindingData{Line: -1, LineIndex: -1, Length: int64(len(client)), MatchedString: client, Classification: classification, Severity: severity, ValidatorName: limitVal.name}
+}
+
+// Takes a token from the buckets of the limits which apply to the request
+// The banned clients are throttled without taking tokens
+// @param r - the request
+// Returns the time the client should wait before retrying, the findings of the exceeded limits and true if the request should be throttled
+func (limitVal *RateLimitValidator) Limit(r *http.Request) (time.Duration, []data.FindingData, bool) {
+	now := limitVal.now()
+	var retryAfter time.Duration = 0
+	findings := make([]data.FindingData, 0)
+	//A client is reported once for every classification even if it exceeds more limits
+	reported := make(map[string]bool)
+	report := func(client string, classification int64, severity int64) {
+		key := client + "|" + strconv.FormatInt(classification, 10)
+		if !reported[key] {
+			reported[key] = true
+			findings = append(findings, limitVal.newFinding(client, classification, severity))
+		}
+	}
+
+	limitVal.mu.Lock()
+	defer limitVal.mu.Unlock()
+	for _, limit := range limitVal.limits {
+		if limit.pathRegex != nil && !limit.pathRegex.MatchString(r.URL.Path) {
+			continue
+		}
+		client, bucketKey := limitVal.getClientKey(r, limit)
+
+		//The banned clients are throttled until the ban expires
+		if ban, found := limitVal.bans.Get(client); found && ban.bannedUntil.After(now) {
+			retryAfter = max(retryAfter, ban.bannedUntil.Sub(now))
+			report(client, data.BANNED_CLIENT, data.HIGH)
+			continue
+		}
+
+		bucket, found := limitVal.buckets.Get(bucketKey)
+		if !found {
+			bucket = &tokenBucket{tokens: limit.capacity, updated: now}
+			limitVal.buckets.Add(bucketKey, bucket)
+		}
+		bucket.tokens = math.Min(limit.capacity, bucket.tokens+now.Sub(bucket.updated).Seconds()*limit.rate)
+		bucket.updated = now
+		if bucket.tokens >= 1 {
+			bucket.tokens--
+			continue
+		}
+
+		//The client has to wait for the next token
+		wait := time.Duration((1 - bucket.tokens) / limit.rate * float64(time.Second))
+		ban := limitVal.addViolation(client, now)
+		if ban != nil && ban.bannedUntil.After(now) {
+			limitVal.logger.Info(limitVal.name, "banned client", client, "for", limitVal.options.BanDuration, "seconds after exceeding the rate limit", limit.name)
+			wait = ban.bannedUntil.Sub(now)
+			report(client, data.BANNED_CLIENT, data.HIGH)
+		} else {
+			limitVal.logger.Info(limitVal.name, "client", client, "exceeded the rate limit", limit.name, strconv.Itoa(limit.Requests)+" requests per "+strconv.Itoa(limit.Period)+" seconds")
+			report(client, data.RATE_LIMIT_EXCEEDED, data.MEDIUM)
+		}
+		retryAfter = max(retryAfter, wait)
+	}
+
+	if len(findings) == 0 {
+		return 0, nil, false
+	}
+	return retryAfter, findings, true
+}
+
+// Validates the request (do nothing function - the limits are checked before the inspection)
+func (limitVal *RateLimitValidator) ValidateRequest(r *http.Request) ([]data.FindingData, error) {
+	return nil, nil
+}
+
+// Validates the response (do nothing function - the limits are checked on the request)
+func (limitVal *RateLimitValidator) ValidateResponse(r *http.Response) ([]data.FindingData, error) {
+	return nil, nil
+}
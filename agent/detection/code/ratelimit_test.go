@@ -0,0 +1,181 @@
+package detection
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/lucacoratu/disertatie/agent/config"
+	"github.com/lucacoratu/disertatie/agent/data"
+	"github.com/lucacoratu/disertatie/agent/logging"
+)
+
+// Holds the time returned by the clock of the validator in the tests
+type testClock struct {
+	current time.Time
+}
+
+func (clock *testClock) now() time.Time {
+	return clock.current
+}
+
+func (clock *testClock) advance(duration time.Duration) {
+	clock.current = clock.current.Add(duration)
+}
+
+// Creates a rate limit validator whose clock is moved by the test
+func newTestRateLimitValidator(t *testing.T, options RateLimitValidatorOptions) (*RateLimitValidator, *testClock) {
+	validator, err := NewRateLimitValidatorFromOptions(logging.NewDefaultLogger(), config.Configuration{}, options)
+	if err != nil {
+		t.Fatal(err)
+	}
+	limitVal := validator.(*RateLimitValidator)
+	if err := limitVal.Init(); err != nil {
+		t.Fatal(err)
+	}
+	clock := &testClock{current: time.Unix(1700000000, 0)}
+	limitVal.now = clock.now
+	return limitVal, clock
+}
+
+// Creates a request of the client on the path
+func newRateLimitRequest(remoteAddress string, path string) *http.Request {
+	r := httptest.NewRequest(http.MethodGet, path, nil)
+	r.RemoteAddr = remoteAddress + ":41000"
+	return r
+}
+
+// Checks the result of a request against the limits
+// @param classification - the classification of the expected finding (0 if the request should not be throttled)
+func checkLimit(t *testing.T, limitVal *RateLimitValidator, r *http.Request, classification int64, retryAfter time.Duration) {
+	t.Helper()
+	wait, findings, limited := limitVal.Limit(r)
+	if classification == 0 {
+		if limited {
+			t.Fatalf("expected the request to be allowed, got throttled for %s with %v", wait, findings)
+		}
+		return
+	}
+	if !limited {
+		t.Fatalf("expected the request to be throttled")
+	}
+	if len(findings) != 1 || findings[0].Classification != classification {
+		t.Fatalf("expected a finding with the classification %s, got %v", data.ClassificationsMap[classification], findings)
+	}
+	if wait != retryAfter {
+		t.Errorf("expected to retry after %s, got %s", retryAfter, wait)
+	}
+}
+
+func TestRateLimitBurstAndRefill(t *testing.T) {
+	limitVal, clock := newTestRateLimitValidator(t, RateLimitValidatorOptions{Global: &RateLimit{Requests: 1, Period: 10, Burst: 3}})
+	r := newRateLimitRequest("203.0.113.7", "/")
+
+	//The burst is allowed at once
+	for i := 0; i < 3; i++ {
+		checkLimit(t, limitVal, r, 0, 0)
+	}
+	checkLimit(t, limitVal, r, data.RATE_LIMIT_EXCEEDED, 10*time.Second)
+
+	//The other clients have their own buckets
+	checkLimit(t, limitVal, newRateLimitRequest("203.0.113.8", "/"), 0, 0)
+
+	//A token is added every 10 seconds
+	clock.advance(5 * time.Second)
+	checkLimit(t, limitVal, r, data.RATE_LIMIT_EXCEEDED, 5*time.Second)
+	clock.advance(5 * time.Second)
+	checkLimit(t, limitVal, r, 0, 0)
+	checkLimit(t, limitVal, r, data.RATE_LIMIT_EXCEEDED, 10*time.Second)
+
+	//The bucket is not refilled above the burst
+	clock.advance(time.Hour)
+	for i := 0; i < 3; i++ {
+		checkLimit(t, limitVal, r, 0, 0)
+	}
+	checkLimit(t, limitVal, r, data.RATE_LIMIT_EXCEEDED, 10*time.Second)
+}
+
+func TestRateLimitPaths(t *testing.T) {
+	limitVal, _ := newTestRateLimitValidator(t, RateLimitValidatorOptions{Paths: []RateLimit{
+		{Path: "/login", Requests: 1, Period: 60},
+		{Path: "/api/**", Requests: 1, Period: 60, Key: RateLimitKeyIPPath},
+		{Path: "/search", Requests: 1, Period: 60, Key: RateLimitKeyHeader, KeyName: "X-Api-Key"},
+	}})
+
+	checkLimit(t, limitVal, newRateLimitRequest("203.0.113.7", "/login"), 0, 0)
+	checkLimit(t, limitVal, newRateLimitRequest("203.0.113.7", "/login"), data.RATE_LIMIT_EXCEEDED, time.Minute)
+	//The paths without a limit are not throttled
+	checkLimit(t, limitVal, newRateLimitRequest("203.0.113.7", "/"), 0, 0)
+
+	//Every path has its own bucket with the ip-path key
+	checkLimit(t, limitVal, newRateLimitRequest("203.0.113.7", "/api/users"), 0, 0)
+	checkLimit(t, limitVal, newRateLimitRequest("203.0.113.7", "/api/orders"), 0, 0)
+	checkLimit(t, limitVal, newRateLimitRequest("203.0.113.7", "/api/users"), data.RATE_LIMIT_EXCEEDED, time.Minute)
+
+	//The clients are identified by the header, the address is used when the header is missing
+	withKey := func(key string) *http.Request {
+		r := newRateLimitRequest("203.0.113.7", "/search")
+		r.Header.Set("X-Api-Key", key)
+		return r
+	}
+	checkLimit(t, limitVal, withKey("first"), 0, 0)
+	checkLimit(t, limitVal, withKey("second"), 0, 0)
+	checkLimit(t, limitVal, withKey("first"), data.RATE_LIMIT_EXCEEDED, time.Minute)
+	checkLimit(t, limitVal, newRateLimitRequest("203.0.113.7", "/search"), 0, 0)
+	checkLimit(t, limitVal, newRateLimitRequest("203.0.113.7", "/search"), data.RATE_LIMIT_EXCEEDED, time.Minute)
+}
+
+func TestRateLimitBan(t *testing.T) {
+	limitVal, clock := newTestRateLimitValidator(t, RateLimitValidatorOptions{
+		Global:      &RateLimit{Requests: 1, Period: 60},
+		BanAfter:    2,
+		BanWindow:   10,
+		BanDuration: 120,
+	})
+	r := newRateLimitRequest("203.0.113.7", "/")
+
+	checkLimit(t, limitVal, r, 0, 0)
+	checkLimit(t, limitVal, r, data.RATE_LIMIT_EXCEEDED, time.Minute)
+
+	//The violations outside of the ban window are not counted together
+	clock.advance(30 * time.Second)
+	checkLimit(t, limitVal, r, data.RATE_LIMIT_EXCEEDED, 30*time.Second)
+
+	//The second violation in the window bans the client for the ban duration
+	clock.advance(5 * time.Second)
+	checkLimit(t, limitVal, r, data.BANNED_CLIENT, 2*time.Minute)
+	clock.advance(time.Minute)
+	checkLimit(t, limitVal, r, data.BANNED_CLIENT, time.Minute)
+
+	//The other clients are not banned
+	checkLimit(t, limitVal, newRateLimitRequest("203.0.113.8", "/"), 0, 0)
+
+	//The ban expires and the bucket was refilled in the meantime
+	clock.advance(time.Minute)
+	checkLimit(t, limitVal, r, 0, 0)
+}
+
+func TestRateLimitMaxClients(t *testing.T) {
+	limitVal, _ := newTestRateLimitValidator(t, RateLimitValidatorOptions{
+		Global:     &RateLimit{Requests: 1, Period: 60},
+		BanAfter:   1,
+		MaxClients: 3,
+	})
+	for i := 0; i < 10; i++ {
+		r := newRateLimitRequest("203.0.113."+strconv.Itoa(i), "/")
+		checkLimit(t, limitVal, r, 0, 0)
+		checkLimit(t, limitVal, r, data.BANNED_CLIENT, time.Duration(defaultRateLimitBanDuration)*time.Second)
+	}
+	if limitVal.buckets.Len() != 3 {
+		t.Errorf("expected 3 buckets, got %d", limitVal.buckets.Len())
+	}
+	if limitVal.bans.Len() != 3 {
+		t.Errorf("expected 3 bans, got %d", limitVal.bans.Len())
+	}
+
+	//The least recently seen clients were evicted, the last clients are still banned
+	checkLimit(t, limitVal, newRateLimitRequest("203.0.113.9", "/"), data.BANNED_CLIENT, time.Duration(defaultRateLimitBanDuration)*time.Second)
+	checkLimit(t, limitVal, newRateLimitRequest("203.0.113.0", "/"), 0, 0)
+}
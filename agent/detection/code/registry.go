@@ -0,0 +1,117 @@
+package detection
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"sort"
+	"strings"
+
+	"github.com/go-playground/validator/v10"
+	"github.com/lucacoratu/disertatie/agent/config"
+	"github.com/lucacoratu/disertatie/agent/logging"
+)
+
+// Creates a validator from its typed config block
+type ValidatorFactory[T any] func(logger logging.ILogger, configuration config.Configuration, options T) (IValidator, error)
+
+// Decodes the config block of a validator and creates the validator
+type validatorConstructor func(logger logging.ILogger, configuration config.Configuration, block json.RawMessage) (IValidator, error)
+
+// Holds the validators which can be enabled from the validators list of the configuration
+type ValidatorRegistry struct {
+	constructors map[string]validatorConstructor
+}
+
+// Creates a registry with the validators of the agent
+func NewValidatorRegistry() *ValidatorRegistry {
+	registry := &ValidatorRegistry{constructors: make(map[string]validatorConstructor)}
+	RegisterValidator(registry, "UserAgentValidator", NewUserAgentValidatorFromOptions)
+	RegisterValidator(registry, "IPReputationValidator", NewIPReputationValidatorFromOptions)
+	RegisterValidator(registry, "RateLimitValidator", NewRateLimitValidatorFromOptions)
+	return registry
+}
+
+// Registers a validator by name
+// The config block of the validator is decoded into the options type (the unknown keys are rejected) and validated before the factory is called
+// @param registry - the registry the validator is added to
+// @param name - the name used in the validators list of the configuration
+// @param factory - the function which creates the validator from its options
+func RegisterValidator[T any](registry *ValidatorRegistry, name string, factory ValidatorFactory[T]) error {
+	if _, found := registry.constructors[name]; found {
+		return errors.New("validator " + name + " is already registered")
+	}
+	registry.constructors[name] = func(logger logging.ILogger, configuration config.Configuration, block json.RawMessage) (IValidator, error) {
+		var options T
+		//The validators without a config block use the default options
+		if len(bytes.TrimSpace(block)) != 0 && !bytes.Equal(bytes.TrimSpace(block), []byte("null")) {
+			d := json.NewDecoder(bytes.NewReader(block))
+			d.DisallowUnknownFields()
+			if err := d.Decode(&options); err != nil {
+				return nil, err
+			}
+		}
+		validate := validator.New(validator.WithRequiredStructEnabled())
+		if err := validate.Struct(&options); err != nil {
+			return nil, err
+		}
+		return factory(logger, configuration, options)
+	}
+	return nil
+}
+
+// Gets the names of the registered validators in alphabetical order
+func (registry *ValidatorRegistry) GetNames() []string {
+	names := make([]string, 0, len(registry.constructors))
+	for name := range registry.constructors {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Creates and initializes the validators enabled in the configuration (in the order of the validators list)
+// If a validator cannot be created the validators initialized before it are closed
+// @param logger - the logger
+// @param configuration - the configuration of the agent
+// Returns the validators which will be run on the traffic
+func (registry *ValidatorRegistry) CreateValidators(logger logging.ILogger, configuration config.Configuration) ([]IValidator, error) {
+	validators := make([]IValidator, 0, len(configuration.Validators))
+	enabled := make(map[string]bool, len(configuration.Validators))
+	for _, validatorConfig := range configuration.Validators {
+		constructor, found := registry.constructors[validatorConfig.Name]
+		if !found {
+			CloseValidators(validators, logger)
+			return nil, errors.New("unknown validator " + validatorConfig.Name + ", the registered validators are " + strings.Join(registry.GetNames(), ", "))
+		}
+		if enabled[validatorConfig.Name] {
+			CloseValidators(validators, logger)
+			return nil, errors.New("validator " + validatorConfig.Name + " is enabled more than once")
+		}
+		enabled[validatorConfig.Name] = true
+
+		validator, err := constructor(logger, configuration, validatorConfig.Config)
+		if err != nil {
+			CloseValidators(validators, logger)
+			return nil, errors.New("invalid config of validator " + validatorConfig.Name + ", " + err.Error())
+		}
+		if err := validator.Init(); err != nil {
+			CloseValidators(validators, logger)
+			return nil, errors.New("could not initialize validator " + validatorConfig.Name + ", " + err.Error())
+		}
+		validators = append(validators, validator)
+		logger.Debug("Initialized validator", validatorConfig.Name)
+	}
+	return validators, nil
+}
+
+// Closes the validators in the reverse order of their initialization
+// @param validators - the validators to close
+// @param logger - the logger
+func CloseValidators(validators []IValidator, logger logging.ILogger) {
+	for i := len(validators) - 1; i >= 0; i-- {
+		if err := validators[i].Close(); err != nil {
+			logger.Error("Error occured when closing validator", validators[i].GetName(), err.Error())
+		}
+	}
+}
@@ -2,6 +2,7 @@ package detection
 
 import (
 	"net/http"
+	"time"
 
 	"github.com/lucacoratu/disertatie/agent/data"
 	"github.com/lucacoratu/disertatie/agent/logging"
@@ -16,12 +17,50 @@ func NewValidatorRunner(validators []IValidator, logger logging.ILogger) *Valida
 	return &ValidatorRunner{validators: validators, logger: logger}
 }
 
+// Checks if one of the validators exempts the request from the inspection (a trusted client)
+// Returns the name of the validator which exempts the request
+func (vr *ValidatorRunner) ShouldBypass(r *http.Request) (string, bool) {
+	for _, valid := range vr.validators {
+		bypassValidator, ok := valid.(IBypassValidator)
+		if ok && bypassValidator.Bypass(r) {
+			return valid.GetName(), true
+		}
+	}
+	return "", false
+}
+
+// Checks the limits of the validators which throttle the clients (the rate limits)
+// Returns the findings of the exceeded limits, the longest time the client should wait before retrying and true if the request should be throttled
+func (vr *ValidatorRunner) RunLimiters(r *http.Request) ([]data.FindingData, time.Duration, bool) {
+	limitFindings := make([]data.FindingData, 0)
+	var retryAfter time.Duration = 0
+	throttled := false
+	for _, valid := range vr.validators {
+		limitValidator, ok := valid.(ILimitValidator)
+		if !ok {
+			continue
+		}
+		wait, findings, limited := limitValidator.Limit(r)
+		if !limited {
+			continue
+		}
+		throttled = true
+		retryAfter = max(retryAfter, wait)
+		limitFindings = append(limitFindings, findings...)
+	}
+	return limitFindings, retryAfter, throttled
+}
+
 func (vr *ValidatorRunner) RunValidatorsOnRequest(r *http.Request) ([]data.FindingData, error) {
 	//Create the list of findings
 	requestFindings := make([]data.FindingData, 0)
 
 	//Run all the validators to check if the request seems valid
 	for _, valid := range vr.validators {
+		//Skip the validators which do not inspect the request
+		if !valid.GetPhases().Has(PhaseRequest) {
+			continue
+		}
 		//Call the validate method of the validator for the request
 		findingsRequest, err := valid.ValidateRequest(r)
 		//Check if there was an error when looking for malicious input in the request
@@ -48,6 +87,10 @@ func (vr *ValidatorRunner) RunValidatorsOnResponse(r *http.Response) ([]data.Fin
 
 	//Run all the validators to check if the response seems valid
 	for _, valid := range vr.validators {
+		//Skip the validators which do not inspect the response
+		if !valid.GetPhases().Has(PhaseResponse) {
+			continue
+		}
 		//Call the validate method of the validator for the response
 		findingsResponse, err := valid.ValidateResponse(r)
 		//Check if an error occured when looking for malicious input in the response
@@ -1,6 +1,7 @@
 package detection
 
 import (
+	"errors"
 	"net/http"
 	"strings"
 
@@ -10,15 +11,31 @@ import (
 	"github.com/lucacoratu/disertatie/agent/utils"
 )
 
+// The config block of the UserAgentValidator
+type UserAgentValidatorOptions struct {
+	BlacklistPath string `json:"blacklistPath"` //The path to the wordlist of banned User-Agents (blacklistUserAgentPath of the configuration if empty)
+}
+
 type UserAgentValidator struct {
 	configuration config.Configuration
 	logger        logging.ILogger
 	name          string
+	blacklistPath string   //The path to the wordlist of banned User-Agents
+	blacklist     []string //The banned User-Agents, loaded when the validator is initialized
 }
 
 // Creates an instance of the UserAgentValidator
 func NewUserAgentValidator(logger logging.ILogger, configuration config.Configuration) *UserAgentValidator {
-	return &UserAgentValidator{logger: logger, name: "UserAgentValidator", configuration: configuration}
+	return &UserAgentValidator{logger: logger, name: "UserAgentValidator", configuration: configuration, blacklistPath: configuration.BlacklistUserAgentPath}
+}
+
+// Creates an instance of the UserAgentValidator from the config block in the validators list
+func NewUserAgentValidatorFromOptions(logger logging.ILogger, configuration config.Configuration, options UserAgentValidatorOptions) (IValidator, error) {
+	userAgentVal := NewUserAgentValidator(logger, configuration)
+	if options.BlacklistPath != "" {
+		userAgentVal.blacklistPath = options.BlacklistPath
+	}
+	return userAgentVal, nil
 }
 
 // Gets the name of the validator
@@ -26,23 +43,43 @@ func (userAgentVal *UserAgentValidator) GetName() string {
 	return userAgentVal.name
 }
 
+// Gets the phases inspected by the validator (only the request has a User-Agent header)
+func (userAgentVal *UserAgentValidator) GetPhases() ValidatorPhase {
+	return PhaseRequest
+}
+
+// Reads the blacklisted User-Agents
+func (userAgentVal *UserAgentValidator) Init() error {
+	blacklist, err := utils.ReadLinesFromFile(userAgentVal.blacklistPath)
+	if err != nil {
+		return errors.New("could not read the user-agent blacklist " + userAgentVal.blacklistPath + ", " + err.Error())
+	}
+	//Empty lines would match every User-Agent
+	userAgentVal.blacklist = make([]string, 0, len(blacklist))
+	for _, line := range blacklist {
+		if line != "" {
+			userAgentVal.blacklist = append(userAgentVal.blacklist, line)
+		}
+	}
+	return nil
+}
+
+// Releases the resources of the validator (nothing to release)
+func (userAgentVal *UserAgentValidator) Close() error {
+	return nil
+}
+
 // Validates the User-Agent header from the request by using a black list approach
 func (userAgentVal *UserAgentValidator) ValidateRequest(r *http.Request) ([]data.FindingData, error) {
 	//Get the User-Agent value
 	userAgent := r.Header.Get("User-Agent")
 	//userAgentVal.logger.Debug(userAgent)
 
-	//Read the blacklisted User-Agents
-	blacklist, err := utils.ReadLinesFromFile(userAgentVal.configuration.BlacklistUserAgentPath)
-	if err != nil {
-		userAgentVal.logger.Error(userAgentVal.name, "error occured when readling user-agent blacklist:", err.Error())
-		return nil, nil
-	}
 	//Create the slice of findings which will be returned
 	findings := make([]data.FindingData, 0)
 
 	//Check if the User-Agent header contains any blacklist element
-	for _, line := range blacklist {
+	for _, line := range userAgentVal.blacklist {
 		if strings.Contains(userAgent, line) {
 			userAgentVal.logger.Info(userAgentVal.name, "found blacklisted User-Agent:", line, "received header:", userAgent)
 			//Find the line number, line index the string appears
@@ -0,0 +1,186 @@
+package detection
+
+import (
+	"errors"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+
+	"github.com/lucacoratu/disertatie/agent/data"
+	"github.com/lucacoratu/disertatie/agent/utils"
+)
+
+// The actions which can be taken when a rule matches (only for waf operation mode)
+const (
+	ActionAllow    = "allow"    //The request is allowed
+	ActionLog      = "log"      //The findings are only logged, the request is never blocked
+	ActionDrop     = "drop"     //The forbidden page is sent to the client (the default action)
+	ActionDeceive  = "deceive"  //The request is handled by the adaptive mode (the LLM generates the response)
+	ActionTarpit   = "tarpit"   //The forbidden page is sent to the client slowly
+	ActionRedirect = "redirect" //The client is redirected to a URL
+	ActionRespond  = "respond"  //The response specified in the rule is sent to the client
+)
+
+// The precedence of the actions when the rules which matched have the same severity (the highest one is applied)
+// The specific actions are preferred over drop since most of the rules do not have an action and default to drop
+var actionsPrecedence = map[string]int{
+	ActionAllow:    0,
+	ActionLog:      1,
+	ActionDrop:     2,
+	ActionDeceive:  3,
+	ActionTarpit:   4,
+	ActionRedirect: 5,
+	ActionRespond:  6,
+}
+
+// Gets the action of the rule in lowercase
+// If the rule does not have an action then drop is returned
+func NormalizeRuleAction(action string) string {
+	if action == "" {
+		return ActionDrop
+	}
+	return strings.ToLower(action)
+}
+
+// Checks if the action blocks the request (the request is not sent to the web server)
+func IsBlockingAction(action string) bool {
+	action = NormalizeRuleAction(action)
+	return action != ActionAllow && action != ActionLog
+}
+
+// Gets the precedence of the action of the rule
+func getRuleActionPrecedence(rule Rule) int {
+	if rule.Info == nil {
+		return actionsPrecedence[ActionDrop]
+	}
+	return actionsPrecedence[NormalizeRuleAction(rule.Info.Action)]
+}
+
+// Gets the severity of the rule (-1 if the rule does not have a valid severity)
+func getRuleSeverity(rule Rule) int64 {
+	if rule.Info == nil {
+		return -1
+	}
+	return ConvertSeverityStringToInteger(rule.Info.Severity)
+}
+
+// Checks if the action of the first rule is applied instead of the action of the second rule
+// The blocking actions win over the actions which let the request pass (allow, log), then the rule with the highest severity wins
+// The precedence of the actions decides only between the rules with the same severity, so a low severity respond rule cannot replace the drop of a critical rule
+func hasActionPrecedence(first Rule, second Rule) bool {
+	firstBlocking, secondBlocking := isRuleBlocking(first), isRuleBlocking(second)
+	if firstBlocking != secondBlocking {
+		return firstBlocking
+	}
+	if firstSeverity, secondSeverity := getRuleSeverity(first), getRuleSeverity(second); firstSeverity != secondSeverity {
+		return firstSeverity > secondSeverity
+	}
+	return getRuleActionPrecedence(first) > getRuleActionPrecedence(second)
+}
+
+// Checks if the action of the rule blocks the request (the rules without an action drop the request)
+func isRuleBlocking(rule Rule) bool {
+	if rule.Info == nil {
+		return true
+	}
+	return IsBlockingAction(rule.Info.Action)
+}
+
+// Sorts the rules by the precedence of their actions (the one which is applied first)
+// The rules with the same precedence keep the order they were loaded in
+// The first blocking rule which matches has the action applied so the evaluation can stop after it
+// @param rules - the list of rules
+// Returns the sorted copy of the rules
+func sortRulesByActionPrecedence(rules []Rule) []Rule {
+	sortedRules := make([]Rule, len(rules))
+	copy(sortedRules, rules)
+	sort.SliceStable(sortedRules, func(i, j int) bool {
+		return hasActionPrecedence(sortedRules[i], sortedRules[j])
+	})
+	return sortedRules
+}
+
+// Gets a rule by its id
+// @param rules - the list of rules loaded from disk
+// @param ruleId - the id of the rule
+// Returns the rule or nil if there is no rule with the id
+func GetRule(rules []Rule, ruleId string) *Rule {
+	for i := range rules {
+		if rules[i].Id == ruleId {
+			return &rules[i]
+		}
+	}
+	return nil
+}
+
+// Selects the action which should be applied when the rules disagree
+// The blocking actions are selected first, then the action of the rule with the highest severity and then the action with the highest precedence
+// If more rules have the same precedence the first one is selected
+// @param rules - the list of rules loaded from disk
+// @param ruleFindings - the findings of the rules
+// Returns the rule which has the selected action (nil if there are no findings of the loaded rules) and the action
+func SelectRuleAction(rules []Rule, ruleFindings []*data.RuleFindingData) (*Rule, string) {
+	var selectedRule *Rule = nil
+	for _, ruleFinding := range ruleFindings {
+		rule := GetRule(rules, ruleFinding.RuleId)
+		if rule == nil {
+			continue
+		}
+		if selectedRule == nil || hasActionPrecedence(*rule, *selectedRule) {
+			selectedRule = rule
+		}
+	}
+	if selectedRule == nil {
+		return nil, ActionAllow
+	}
+	return selectedRule, NormalizeRuleAction(selectedRule.Info.Action)
+}
+
+// Checks if the action and its parameters are valid
+// @param info - the rule information structure
+// Returns an error if the action or the parameters are not valid
+func CheckRuleAction(info *RuleInfo) error {
+	action := NormalizeRuleAction(info.Action)
+	if _, found := actionsPrecedence[action]; !found {
+		return errors.New("rule action cannot be something other than: allow, log, drop, deceive, tarpit, redirect, respond")
+	}
+
+	parameters := info.ActionParameters
+	if parameters == nil {
+		return nil
+	}
+	if parameters.RedirectURL != "" {
+		if action != ActionRedirect {
+			return errors.New("rule redirect-url can be used only with the redirect action")
+		}
+		if _, err := url.Parse(parameters.RedirectURL); err != nil {
+			return errors.New("rule redirect-url is not a valid url, " + err.Error())
+		}
+	}
+	if parameters.Status != 0 || len(parameters.Headers) > 0 || parameters.BodyFile != "" {
+		if action != ActionRespond {
+			return errors.New("rule status, headers and body-file can be used only with the respond action")
+		}
+		if parameters.Status != 0 && (parameters.Status < 200 || parameters.Status > 599) {
+			return errors.New("rule respond status should be between 200 and 599")
+		}
+		for name := range parameters.Headers {
+			if strings.TrimSpace(name) == "" || strings.ContainsAny(name, " :\r\n") {
+				return errors.New("rule respond header name is not valid, " + name)
+			}
+		}
+		if parameters.BodyFile != "" && !utils.CheckFileExists(parameters.BodyFile) {
+			return errors.New("rule respond body-file does not exist, " + parameters.BodyFile)
+		}
+	}
+	return nil
+}
+
+// Gets the status code of the respond action (403 if it is not specified)
+func (parameters *RuleActionParameters) GetStatus() int {
+	if parameters == nil || parameters.Status == 0 {
+		return http.StatusForbidden
+	}
+	return parameters.Status
+}
@@ -0,0 +1,61 @@
+package detection
+
+import (
+	"testing"
+
+	"github.com/lucacoratu/disertatie/agent/data"
+)
+
+func TestSelectRuleAction(t *testing.T) {
+	newRule := func(id string, severity string, action string) Rule {
+		return Rule{Id: id, Info: &RuleInfo{Severity: severity, Action: action}}
+	}
+	tests := []struct {
+		name     string
+		rules    []Rule
+		expected string
+	}{
+		{"drop wins over log", []Rule{newRule("log", "critical", ActionLog), newRule("drop", "low", "")}, "drop"},
+		{"drop wins over allow", []Rule{newRule("allow", "high", ActionAllow), newRule("drop", "medium", ActionDrop)}, "drop"},
+		{"higher severity wins", []Rule{newRule("respond", "low", ActionRespond), newRule("drop", "critical", ActionDrop)}, "drop"},
+		{"precedence breaks the severity ties", []Rule{newRule("drop", "high", ActionDrop), newRule("redirect", "high", ActionRedirect)}, "redirect"},
+		{"first rule wins the ties", []Rule{newRule("first", "high", ActionTarpit), newRule("second", "high", ActionTarpit)}, "first"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			findings := make([]*data.RuleFindingData, 0, len(test.rules))
+			for _, rule := range test.rules {
+				findings = append(findings, &data.RuleFindingData{RuleId: rule.Id})
+			}
+			selected, _ := SelectRuleAction(test.rules, findings)
+			if selected == nil || selected.Id != test.expected {
+				t.Fatalf("expected rule %s to be selected, got %v", test.expected, selected)
+			}
+			//The index evaluates first the rule whose action is selected
+			if sorted := sortRulesByActionPrecedence(test.rules); sorted[0].Id != test.expected {
+				t.Errorf("expected rule %s to be sorted first, got %s", test.expected, sorted[0].Id)
+			}
+		})
+	}
+}
+
+func TestCheckRuleActionStatus(t *testing.T) {
+	tests := []struct {
+		status int
+		valid  bool
+	}{
+		{0, true},
+		{101, false},
+		{199, false},
+		{200, true},
+		{403, true},
+		{599, true},
+		{600, false},
+	}
+	for _, test := range tests {
+		err := CheckRuleAction(&RuleInfo{Action: ActionRespond, ActionParameters: &RuleActionParameters{Status: test.status}})
+		if (err == nil) != test.valid {
+			t.Errorf("status %d: expected valid %v, got error %v", test.status, test.valid, err)
+		}
+	}
+}
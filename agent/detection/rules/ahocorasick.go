@@ -0,0 +1,131 @@
+package detection
+
+import "sort"
+
+// Holds a state of the Aho-Corasick automaton
+type ahoCorasickNode struct {
+	edgeBytes []byte  //The bytes of the transitions (sorted)
+	edgeNodes []int32 //The nodes the transitions lead to (same order as edgeBytes)
+	fail      int32   //The node to go to when there is no transition for a byte
+	dictLink  int32   //The closest node on the fail chain which is the end of a pattern (-1 if none)
+	patterns  []int32 //The patterns which end in this node
+}
+
+// Multi-pattern string matcher which finds all the patterns in a text in a single pass
+type ahoCorasick struct {
+	nodes         []ahoCorasickNode //The states of the automaton (0 is the root)
+	numberPattern int               //The number of patterns added in the automaton
+}
+
+// Gets the transition of the node for a byte
+// Returns -1 if the transition does not exist
+func (node *ahoCorasickNode) next(b byte) int32 {
+	index := sort.Search(len(node.edgeBytes), func(i int) bool { return node.edgeBytes[i] >= b })
+	if index < len(node.edgeBytes) && node.edgeBytes[index] == b {
+		return node.edgeNodes[index]
+	}
+	return -1
+}
+
+// Adds a transition to the node keeping the transitions sorted
+func (node *ahoCorasickNode) addEdge(b byte, child int32) {
+	index := sort.Search(len(node.edgeBytes), func(i int) bool { return node.edgeBytes[i] >= b })
+	node.edgeBytes = append(node.edgeBytes, 0)
+	node.edgeNodes = append(node.edgeNodes, 0)
+	copy(node.edgeBytes[index+1:], node.edgeBytes[index:])
+	copy(node.edgeNodes[index+1:], node.edgeNodes[index:])
+	node.edgeBytes[index] = b
+	node.edgeNodes[index] = child
+}
+
+// Builds the Aho-Corasick automaton from the list of patterns
+// The id of a pattern is the index in the list
+// @param patterns - the list of patterns to be found in texts
+// Returns the automaton
+func newAhoCorasick(patterns []string) *ahoCorasick {
+	ac := &ahoCorasick{nodes: []ahoCorasickNode{{fail: 0, dictLink: -1}}, numberPattern: len(patterns)}
+
+	//Build the trie of the patterns
+	for patternId, pattern := range patterns {
+		var current int32 = 0
+		for i := 0; i < len(pattern); i++ {
+			child := ac.nodes[current].next(pattern[i])
+			if child == -1 {
+				ac.nodes = append(ac.nodes, ahoCorasickNode{dictLink: -1})
+				child = int32(len(ac.nodes) - 1)
+				ac.nodes[current].addEdge(pattern[i], child)
+			}
+			current = child
+		}
+		ac.nodes[current].patterns = append(ac.nodes[current].patterns, int32(patternId))
+	}
+
+	//Compute the fail links and the dictionary links in BFS order
+	queue := make([]int32, 0, len(ac.nodes))
+	for _, child := range ac.nodes[0].edgeNodes {
+		ac.nodes[child].fail = 0
+		queue = append(queue, child)
+	}
+	for len(queue) > 0 {
+		current := queue[0]
+		queue = queue[1:]
+		for i, b := range ac.nodes[current].edgeBytes {
+			child := ac.nodes[current].edgeNodes[i]
+			//Follow the fail links of the parent until a transition on the same byte is found
+			fail := ac.nodes[current].fail
+			for {
+				next := ac.nodes[fail].next(b)
+				if next != -1 && next != child {
+					ac.nodes[child].fail = next
+					break
+				}
+				if fail == 0 {
+					ac.nodes[child].fail = 0
+					break
+				}
+				fail = ac.nodes[fail].fail
+			}
+			//The dictionary link points to the closest fail node which ends a pattern
+			failNode := ac.nodes[child].fail
+			if len(ac.nodes[failNode].patterns) > 0 {
+				ac.nodes[child].dictLink = failNode
+			} else {
+				ac.nodes[child].dictLink = ac.nodes[failNode].dictLink
+			}
+			queue = append(queue, child)
+		}
+	}
+
+	return ac
+}
+
+// Finds all the patterns which appear in the text
+// @param text - the text to be searched
+// @param found - the slice (indexed by the pattern id) where the found patterns are marked
+func (ac *ahoCorasick) match(text string, found []bool) {
+	var current int32 = 0
+	for i := 0; i < len(text); i++ {
+		//Follow the fail links until there is a transition for the byte
+		for {
+			next := ac.nodes[current].next(text[i])
+			if next != -1 {
+				current = next
+				break
+			}
+			if current == 0 {
+				break
+			}
+			current = ac.nodes[current].fail
+		}
+
+		//Mark all the patterns which end at this position
+		for output := current; output != -1; output = ac.nodes[output].dictLink {
+			if output == 0 {
+				break
+			}
+			for _, patternId := range ac.nodes[output].patterns {
+				found[patternId] = true
+			}
+		}
+	}
+}
@@ -0,0 +1,354 @@
+package detection
+
+import (
+	"errors"
+	"strconv"
+	"strings"
+)
+
+// The phases a rule condition can be applied on
+const (
+	RequestPhase   string = "request"
+	ResponsePhase  string = "response"
+	WebsocketPhase string = "websocket"
+)
+
+// The values accepted by the matchers-condition field
+const (
+	MatchersConditionOr  string = "or"
+	MatchersConditionAnd string = "and"
+)
+
+// Holds a boolean composition of the rule matchers (and, or, not groups can be nested)
+type RuleCondition struct {
+	And     []*RuleCondition `yaml:"and,omitempty"`     //All the subconditions should be true
+	Or      []*RuleCondition `yaml:"or,omitempty"`      //At least one of the subconditions should be true
+	Not     *RuleCondition   `yaml:"not,omitempty"`     //The subcondition should be false
+	Matcher string           `yaml:"matcher,omitempty"` //The reference to a matcher of the rule (request.method, request.params, request.params[0] etc.)
+}
+
+// Holds the conditions for each of the phases the rule can be applied on
+type RuleConditions struct {
+	Request   *RuleCondition `yaml:"request,omitempty"`   //The condition applied on the request matchers
+	Response  *RuleCondition `yaml:"response,omitempty"`  //The condition applied on the response matchers
+	Websocket *RuleCondition `yaml:"websocket,omitempty"` //The condition applied on the websocket matchers
+}
+
+// Holds the matches of a single matcher of the rule
+type matcherResult struct {
+	Reference   string          //The reference of the matcher (request.url[0], request.body[1] etc.)
+	Matches     []searchMatch   //The strings matched by the matcher
+	HashMatches []BodyHashMatch //The body hashes matched by the matcher
+}
+
+// Checks if the matcher found anything
+func (mr *matcherResult) matched() bool {
+	return len(mr.Matches) > 0 || len(mr.HashMatches) > 0
+}
+
+// Allows a condition leaf to be written as a plain string (- request.method) instead of (- matcher: request.method)
+func (rc *RuleCondition) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	var reference string
+	if err := unmarshal(&reference); err == nil {
+		rc.Matcher = reference
+		return nil
+	}
+
+	//The condition is a mapping so decode it into an alias type to avoid recursion
+	type plainRuleCondition RuleCondition
+	return unmarshal((*plainRuleCondition)(rc))
+}
+
+// Writes the condition leaves as plain strings, the same way they are usually written in the rules
+func (rc RuleCondition) MarshalYAML() (interface{}, error) {
+	if rc.Matcher != "" && rc.And == nil && rc.Or == nil && rc.Not == nil {
+		return rc.Matcher, nil
+	}
+	type plainRuleCondition RuleCondition
+	return plainRuleCondition(rc), nil
+}
+
+// Creates the reference of the i-th element of a matchers list
+func indexedReference(section string, index int) string {
+	return section + "[" + strconv.Itoa(index) + "]"
+}
+
+// Checks if a reference covers the matcher reference
+// A section reference (request.params) covers all the elements of the section (request.params[0], request.params[1] ...)
+func referenceCovers(reference string, matcherReference string) bool {
+	return reference == matcherReference || strings.HasPrefix(matcherReference, reference+"[")
+}
+
+// Gets the references of all the matchers defined in the rule for the specified phase
+// @param rule - the rule to extract the matcher references from
+// @param phase - the phase (request, response, websocket)
+// Returns the list of references in the order they are evaluated
+func getMatcherReferences(rule Rule, phase string) []string {
+	references := make([]string, 0)
+
+	switch phase {
+	case RequestPhase:
+		if rule.Request == nil {
+			return references
+		}
+		if rule.Request.Method != nil {
+			references = append(references, "request.method")
+		}
+		for i := range rule.Request.URL {
+			references = append(references, indexedReference("request.url", i))
+		}
+		for i := range rule.Request.Headers {
+			references = append(references, indexedReference("request.headers", i))
+		}
+		for i := range rule.Request.Parameters {
+			references = append(references, indexedReference("request.params", i))
+		}
+		for i := range rule.Request.Body {
+			references = append(references, indexedReference("request.body", i))
+		}
+		for i := range rule.Request.Cookies {
+			references = append(references, indexedReference("request.cookies", i))
+		}
+		for i := range rule.Request.Files {
+			references = append(references, indexedReference("request.files", i))
+		}
+		for i := range rule.Request.JSON {
+			references = append(references, indexedReference("request.json", i))
+		}
+		for i := range rule.Request.XML {
+			references = append(references, indexedReference("request.xml", i))
+		}
+	case ResponsePhase:
+		if rule.Response == nil {
+			return references
+		}
+		if rule.Response.Code != nil {
+			references = append(references, "response.code")
+		}
+		for i := range rule.Response.Headers {
+			references = append(references, indexedReference("response.headers", i))
+		}
+		for i := range rule.Response.Body {
+			references = append(references, indexedReference("response.body", i))
+		}
+		for i := range rule.Response.JSON {
+			references = append(references, indexedReference("response.json", i))
+		}
+		for i := range rule.Response.XML {
+			references = append(references, indexedReference("response.xml", i))
+		}
+		if rule.Response.Latency != nil {
+			references = append(references, "response.latency")
+		}
+		if rule.Response.Size != nil {
+			references = append(references, "response.size")
+		}
+	case WebsocketPhase:
+		for i := range rule.Websocket {
+			references = append(references, indexedReference("websocket", i))
+		}
+	}
+
+	return references
+}
+
+// Evaluates the condition tree based on the matcher results
+// @param results - the results of the matchers of the phase
+// Returns true if the condition holds
+func (rc *RuleCondition) evaluate(results []*matcherResult) bool {
+	switch {
+	case rc.And != nil:
+		for _, subCondition := range rc.And {
+			if !subCondition.evaluate(results) {
+				return false
+			}
+		}
+		return true
+	case rc.Or != nil:
+		for _, subCondition := range rc.Or {
+			if subCondition.evaluate(results) {
+				return true
+			}
+		}
+		return false
+	case rc.Not != nil:
+		return !rc.Not.evaluate(results)
+	default:
+		//Check if any of the matchers covered by the reference found something
+		for _, result := range results {
+			if referenceCovers(rc.Matcher, result.Reference) && result.matched() {
+				return true
+			}
+		}
+		return false
+	}
+}
+
+// Gets the references which are not negated in the condition tree
+// The matches of these matchers are the ones reported in the findings
+func (rc *RuleCondition) positiveReferences() []string {
+	references := make([]string, 0)
+	for _, subCondition := range rc.And {
+		references = append(references, subCondition.positiveReferences()...)
+	}
+	for _, subCondition := range rc.Or {
+		references = append(references, subCondition.positiveReferences()...)
+	}
+	if rc.Matcher != "" {
+		references = append(references, rc.Matcher)
+	}
+	return references
+}
+
+// Gets the condition of the rule for the specified phase
+func (rule *Rule) getPhaseCondition(phase string) *RuleCondition {
+	if rule.Condition == nil {
+		return nil
+	}
+
+	switch phase {
+	case RequestPhase:
+		return rule.Condition.Request
+	case ResponsePhase:
+		return rule.Condition.Response
+	case WebsocketPhase:
+		return rule.Condition.Websocket
+	}
+	return nil
+}
+
+// Decides if the rule matched based on the results of the matchers of a phase
+// If a condition is specified for the phase it is evaluated, otherwise the matchers-condition (or, and) is applied on all the matchers
+// @param rule - the rule which was run
+// @param phase - the phase the matchers were run on
+// @param results - the results of the matchers of the phase
+// Returns the list of matcher results which should be reported as findings (empty if the rule did not match)
+func ruleMatchedResults(rule Rule, phase string, results []*matcherResult) []*matcherResult {
+	matchedResults := make([]*matcherResult, 0)
+
+	//Check if there is a condition tree defined for the phase
+	condition := rule.getPhaseCondition(phase)
+	if condition != nil {
+		if !condition.evaluate(results) {
+			return matchedResults
+		}
+		//Report only the matchers which contributed to the condition
+		positiveReferences := condition.positiveReferences()
+		for _, result := range results {
+			if !result.matched() {
+				continue
+			}
+			for _, reference := range positiveReferences {
+				if referenceCovers(reference, result.Reference) {
+					matchedResults = append(matchedResults, result)
+					break
+				}
+			}
+		}
+		return matchedResults
+	}
+
+	//Apply the matchers condition on all the matchers of the phase
+	for _, result := range results {
+		if result.matched() {
+			matchedResults = append(matchedResults, result)
+		} else if strings.ToLower(rule.MatchersCondition) == MatchersConditionAnd {
+			//All the matchers should match when the matchers condition is and
+			return make([]*matcherResult, 0)
+		}
+	}
+
+	return matchedResults
+}
+
+// Checks if the condition tree is valid
+// @param condition - the condition to check
+// @param phase - the phase of the condition
+// @param references - the references of the matchers defined in the rule for the phase
+// Returns an error if the condition is not valid
+func checkCondition(condition *RuleCondition, phase string, references []string) error {
+	if condition == nil {
+		return errors.New("empty condition in " + phase + " condition")
+	}
+
+	//Check that exactly one of the operators (or the matcher reference) is specified
+	var specifiedFields int = 0
+	if condition.And != nil {
+		specifiedFields++
+	}
+	if condition.Or != nil {
+		specifiedFields++
+	}
+	if condition.Not != nil {
+		specifiedFields++
+	}
+	if condition.Matcher != "" {
+		specifiedFields++
+	}
+	if specifiedFields != 1 {
+		return errors.New("a condition node should have exactly one of: and, or, not, matcher")
+	}
+
+	switch {
+	case condition.And != nil:
+		if len(condition.And) == 0 {
+			return errors.New("and condition cannot be empty")
+		}
+		for _, subCondition := range condition.And {
+			if err := checkCondition(subCondition, phase, references); err != nil {
+				return err
+			}
+		}
+	case condition.Or != nil:
+		if len(condition.Or) == 0 {
+			return errors.New("or condition cannot be empty")
+		}
+		for _, subCondition := range condition.Or {
+			if err := checkCondition(subCondition, phase, references); err != nil {
+				return err
+			}
+		}
+	case condition.Not != nil:
+		return checkCondition(condition.Not, phase, references)
+	default:
+		//Check if the matcher is defined in the phase of the condition
+		if condition.Matcher != phase && !strings.HasPrefix(condition.Matcher, phase+".") && !strings.HasPrefix(condition.Matcher, phase+"[") {
+			return errors.New("matcher " + condition.Matcher + " cannot be used in the " + phase + " condition")
+		}
+		for _, reference := range references {
+			if referenceCovers(condition.Matcher, reference) {
+				return nil
+			}
+		}
+		return errors.New("matcher " + condition.Matcher + " is not defined in the rule")
+	}
+
+	return nil
+}
+
+// Checks if the matchers condition and the condition trees of the rule are valid
+// @param rule - the rule to check
+// Returns an error if any of the conditions is not valid
+func CheckRuleConditions(rule Rule) error {
+	//Check the matchers condition (or, and) - case insensitive
+	if rule.MatchersCondition != "" {
+		if strings.ToLower(rule.MatchersCondition) != MatchersConditionOr && strings.ToLower(rule.MatchersCondition) != MatchersConditionAnd {
+			return errors.New("matchers-condition cannot be something other than: and, or")
+		}
+	}
+
+	//Check the condition tree of each phase
+	if rule.Condition != nil {
+		for _, phase := range []string{RequestPhase, ResponsePhase, WebsocketPhase} {
+			condition := rule.getPhaseCondition(phase)
+			if condition == nil {
+				continue
+			}
+			if err := checkCondition(condition, phase, getMatcherReferences(rule, phase)); err != nil {
+				return errors.New("invalid " + phase + " condition, " + err.Error())
+			}
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,171 @@
+package detection
+
+import (
+	"reflect"
+	"testing"
+
+	"gopkg.in/yaml.v2"
+)
+
+// The rule whose matchers are referenced by the conditions in the tests
+var conditionTestRule = Rule{Id: "condition", Request: &RequestRule{
+	Method:     &RuleSearchMode{Match: "POST"},
+	URL:        []*RuleSearchMode{{Match: "/login"}},
+	Headers:    []*HeadersRule{{Name: "User-Agent", Match: "sqlmap"}},
+	Parameters: []*RequestParametersRule{{Name: "user", Match: "admin"}, {Name: "password", Match: "' or 1=1"}},
+}}
+
+// Parses a condition written the same way as in the rule files
+func parseTestCondition(t *testing.T, content string) *RuleCondition {
+	t.Helper()
+	condition := &RuleCondition{}
+	if err := yaml.UnmarshalStrict([]byte(content), condition); err != nil {
+		t.Fatalf("could not parse the condition %q, %v", content, err)
+	}
+	return condition
+}
+
+// Creates the results of the matchers of the request phase of the test rule, only the matched references have matches
+func newConditionTestResults(matched ...string) []*matcherResult {
+	results := make([]*matcherResult, 0)
+	for _, reference := range getMatcherReferences(conditionTestRule, RequestPhase) {
+		result := &matcherResult{Reference: reference}
+		if containsString(matched, reference) {
+			result.Matches = newSearchMatches([]string{reference})
+		}
+		results = append(results, result)
+	}
+	return results
+}
+
+// Gets the references of the matcher results
+func getResultsReferences(results []*matcherResult) []string {
+	references := make([]string, 0, len(results))
+	for _, result := range results {
+		references = append(references, result.Reference)
+	}
+	return references
+}
+
+func TestRuleConditionEvaluate(t *testing.T) {
+	tests := []struct {
+		condition string
+		matched   []string
+		expected  bool
+	}{
+		{"request.method", []string{"request.method"}, true},
+		{"request.method", []string{"request.url[0]"}, false},
+		{"and: [request.method, 'request.url[0]']", []string{"request.method"}, false},
+		{"and: [request.method, 'request.url[0]']", []string{"request.method", "request.url[0]"}, true},
+		{"or: [request.method, 'request.url[0]']", []string{"request.url[0]"}, true},
+		{"or: [request.method, 'request.url[0]']", nil, false},
+		{"not: 'request.headers[0]'", nil, true},
+		{"not: 'request.headers[0]'", []string{"request.headers[0]"}, false},
+		//The section references cover all the matchers of the section
+		{"request.params", []string{"request.params[1]"}, true},
+		{"request.params[0]", []string{"request.params[1]"}, false},
+		{"request.params[1]", []string{"request.params[1]"}, true},
+		{"request.param", []string{"request.params[0]"}, false},
+		{"not: request.params", []string{"request.params[0]"}, false},
+		//Nested operators
+		{"and: [request.method, {or: ['request.params[0]', {not: request.headers}]}]", []string{"request.method"}, true},
+		{"and: [request.method, {or: ['request.params[0]', {not: request.headers}]}]", []string{"request.method", "request.headers[0]"}, false},
+		{"and: [request.method, {or: ['request.params[0]', {not: request.headers}]}]", []string{"request.method", "request.headers[0]", "request.params[0]"}, true},
+		{"not: {and: [request.method, {not: 'request.url[0]'}]}", []string{"request.method"}, false},
+		{"not: {and: [request.method, {not: 'request.url[0]'}]}", []string{"request.method", "request.url[0]"}, true},
+	}
+	for _, test := range tests {
+		condition := parseTestCondition(t, test.condition)
+		if result := condition.evaluate(newConditionTestResults(test.matched...)); result != test.expected {
+			t.Errorf("%s with %v: expected %v, got %v", test.condition, test.matched, test.expected, result)
+		}
+	}
+}
+
+func TestRuleMatchedResults(t *testing.T) {
+	tests := []struct {
+		name              string
+		matchersCondition string
+		condition         string
+		matched           []string
+		expected          []string
+	}{
+		{"or reports the matched matchers", "", "", []string{"request.url[0]", "request.params[1]"}, []string{"request.url[0]", "request.params[1]"}},
+		{"or without matches", "or", "", nil, []string{}},
+		{"and without all the matchers", "and", "", []string{"request.method", "request.url[0]", "request.headers[0]", "request.params[0]"}, []string{}},
+		{"and with all the matchers", "AND", "", []string{"request.method", "request.url[0]", "request.headers[0]", "request.params[0]", "request.params[1]"}, []string{"request.method", "request.url[0]", "request.headers[0]", "request.params[0]", "request.params[1]"}},
+		{"condition overrides the matchers condition", "and", "request.method", []string{"request.method", "request.url[0]"}, []string{"request.method"}},
+		{"condition does not hold", "", "and: [request.method, 'request.url[0]']", []string{"request.method"}, []string{}},
+		{"section reference reports all the matched matchers", "", "request.params", []string{"request.params[0]", "request.params[1]"}, []string{"request.params[0]", "request.params[1]"}},
+		{"indexed reference reports only its matcher", "", "and: ['request.params[1]', request.method]", []string{"request.method", "request.params[0]", "request.params[1]"}, []string{"request.method", "request.params[1]"}},
+		{"negated matchers are not reported", "", "or: [request.method, {not: 'request.params[0]'}]", []string{"request.method", "request.params[0]"}, []string{"request.method"}},
+		{"negated section is not reported", "", "and: ['request.url[0]', {not: {and: [request.headers, request.method]}}]", []string{"request.url[0]", "request.method"}, []string{"request.url[0]"}},
+	}
+	for _, test := range tests {
+		rule := conditionTestRule
+		rule.MatchersCondition = test.matchersCondition
+		if test.condition != "" {
+			rule.Condition = &RuleConditions{Request: parseTestCondition(t, test.condition)}
+		}
+		results := ruleMatchedResults(rule, RequestPhase, newConditionTestResults(test.matched...))
+		if references := getResultsReferences(results); !reflect.DeepEqual(references, test.expected) {
+			t.Errorf("%s: expected the findings of %v, got %v", test.name, test.expected, references)
+		}
+	}
+}
+
+func TestCheckCondition(t *testing.T) {
+	references := getMatcherReferences(conditionTestRule, RequestPhase)
+	tests := []struct {
+		condition string
+		phase     string
+		valid     bool
+	}{
+		{"request.method", RequestPhase, true},
+		{"request.params", RequestPhase, true},
+		{"request.params[1]", RequestPhase, true},
+		{"and: [request.method, {or: ['request.params[0]', {not: request.headers}]}]", RequestPhase, true},
+		{"request.params[2]", RequestPhase, false},
+		{"request.param", RequestPhase, false},
+		{"request.cookies", RequestPhase, false},
+		{"response.code", RequestPhase, false},
+		{"request.method", ResponsePhase, false},
+		{"and: []", RequestPhase, false},
+		{"or: []", RequestPhase, false},
+		{"not: {}", RequestPhase, false},
+		{"{and: [request.method], matcher: request.url}", RequestPhase, false},
+		{"and: [request.method, {not: 'request.params[5]'}]", RequestPhase, false},
+	}
+	for _, test := range tests {
+		err := checkCondition(parseTestCondition(t, test.condition), test.phase, references)
+		if (err == nil) != test.valid {
+			t.Errorf("%s in the %s phase: expected valid %v, got error %v", test.condition, test.phase, test.valid, err)
+		}
+	}
+	if err := checkCondition(nil, RequestPhase, references); err == nil {
+		t.Error("expected an error for the empty condition")
+	}
+}
+
+func TestCheckRuleConditions(t *testing.T) {
+	tests := []struct {
+		matchersCondition string
+		condition         *RuleConditions
+		valid             bool
+	}{
+		{"", nil, true},
+		{"AND", nil, true},
+		{"xor", nil, false},
+		{"", &RuleConditions{Request: &RuleCondition{Matcher: "request.method"}}, true},
+		{"", &RuleConditions{Response: &RuleCondition{Matcher: "response.code"}}, false},
+		{"", &RuleConditions{Websocket: &RuleCondition{Matcher: "websocket"}}, false},
+	}
+	for _, test := range tests {
+		rule := conditionTestRule
+		rule.MatchersCondition = test.matchersCondition
+		rule.Condition = test.condition
+		if err := CheckRuleConditions(rule); (err == nil) != test.valid {
+			t.Errorf("matchers condition %q with the condition %+v: expected valid %v, got error %v", test.matchersCondition, test.condition, test.valid, err)
+		}
+	}
+}
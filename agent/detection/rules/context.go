@@ -0,0 +1,351 @@
+package detection
+
+import (
+	"bytes"
+	"crypto/md5"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/antchfx/xpath"
+	"github.com/gabriel-vasile/mimetype"
+	"github.com/lucacoratu/disertatie/agent/data"
+	"github.com/lucacoratu/disertatie/agent/logging"
+)
+
+// Holds the body of a request/response and the hashes of the body
+type InspectedBody struct {
+	Data        []byte //The raw bytes of the body
+	Text        string //The body as string (used by the search functions)
+	MD5         string //The MD5 hash of the body (hex)
+	SHA256      string //The SHA256 hash of the body (hex)
+	ContentType string //The media type of the body from the Content-Type header
+
+	//The JSON and XML documents are parsed only when a rule needs them (once for all the rules)
+	jsonOnce     sync.Once
+	jsonDocument interface{}
+	jsonValid    bool
+	xmlOnce      sync.Once
+	xmlDocument  *xmlNode
+}
+
+// Creates the inspected body, computing the hashes of the data
+// @param bodyData - the body
+// @param contentType - the value of the Content-Type header
+func newInspectedBody(bodyData []byte, contentType string) *InspectedBody {
+	md5sum := md5.Sum(bodyData)
+	sha256sum := sha256.Sum256(bodyData)
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		mediaType = ""
+	}
+	return &InspectedBody{Data: bodyData, Text: string(bodyData), MD5: hex.EncodeToString(md5sum[:]), SHA256: hex.EncodeToString(sha256sum[:]), ContentType: strings.ToLower(mediaType)}
+}
+
+// Checks if the body is a JSON document based on the Content-Type
+func (body *InspectedBody) IsJSON() bool {
+	return body.ContentType == "application/json" || strings.HasSuffix(body.ContentType, "+json")
+}
+
+// Checks if the body is a XML document based on the Content-Type
+func (body *InspectedBody) IsXML() bool {
+	return body.ContentType == "application/xml" || body.ContentType == "text/xml" || strings.HasSuffix(body.ContentType, "+xml")
+}
+
+// Gets the parsed JSON document of the body
+// Returns the document and false if the body is not JSON or cannot be parsed
+func (body *InspectedBody) getJSONDocument() (interface{}, bool) {
+	body.jsonOnce.Do(func() {
+		if !body.IsJSON() {
+			return
+		}
+		document, err := parseJSONDocument(body.Data)
+		if err == nil {
+			body.jsonDocument = document
+			body.jsonValid = true
+		}
+	})
+	return body.jsonDocument, body.jsonValid
+}
+
+// Gets the parsed XML document of the body
+// Returns the root of the document and false if the body is not XML or cannot be parsed
+func (body *InspectedBody) getXMLDocument() (*xmlNode, bool) {
+	body.xmlOnce.Do(func() {
+		if !body.IsXML() {
+			return
+		}
+		document, err := parseXMLDocument(body.Data)
+		if err == nil {
+			body.xmlDocument = document
+		}
+	})
+	return body.xmlDocument, body.xmlDocument != nil
+}
+
+// Gets all the values of the JSON or XML body (used to select the candidate rules)
+// The values can contain escapes (\u0027, &#39;) in the raw body so they are inspected after parsing
+func (body *InspectedBody) getDocumentValues() []string {
+	values := make([]string, 0)
+	if document, ok := body.getJSONDocument(); ok {
+		for _, value := range (jsonNode{path: "$", value: document}).scalars() {
+			values = append(values, value.Value)
+		}
+	}
+	if document, ok := body.getXMLDocument(); ok {
+		for _, node := range allXMLNodes(document) {
+			if node.nodeType == xpath.TextNode {
+				values = append(values, node.data)
+			}
+			for _, attribute := range node.attributes {
+				values = append(values, attribute.Value)
+			}
+		}
+	}
+	return values
+}
+
+// Holds a file uploaded in a multipart/form-data request
+type InspectedFile struct {
+	Field              string //The name of the form field
+	Filename           string //The filename as sent by the client (not sanitized, can contain paths)
+	ContentType        string //The media type declared in the part headers
+	SniffedContentType string //The media type detected from the content of the file
+	Size               int64  //The size of the file in bytes
+	MD5                string //The MD5 hash of the file (hex)
+	SHA256             string //The SHA256 hash of the file (hex)
+}
+
+// Parses the multipart/form-data body
+// @param bodyData - the body of the request
+// @param contentType - the value of the Content-Type header
+// Returns the uploaded files, the values of the other fields or an error if the body cannot be parsed
+func parseMultipartBody(bodyData []byte, contentType string) ([]*InspectedFile, url.Values, error) {
+	files := make([]*InspectedFile, 0)
+	values := make(url.Values)
+
+	//Only the multipart bodies are parsed, the other content types are not an error
+	mediaType, params, err := mime.ParseMediaType(contentType)
+	if err != nil || mediaType != "multipart/form-data" || params["boundary"] == "" {
+		return files, values, nil
+	}
+
+	reader := multipart.NewReader(bytes.NewReader(bodyData), params["boundary"])
+	for {
+		part, err := reader.NextPart()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return files, values, err
+		}
+		partData, err := io.ReadAll(part)
+		if err != nil {
+			return files, values, err
+		}
+
+		//Get the filename from the header because part.FileName() removes the directories (../../shell.php)
+		filename := ""
+		if _, dispositionParams, err := mime.ParseMediaType(part.Header.Get("Content-Disposition")); err == nil {
+			filename = dispositionParams["filename"]
+		}
+		if filename == "" {
+			values.Add(part.FormName(), string(partData))
+			continue
+		}
+
+		declaredType, _, err := mime.ParseMediaType(part.Header.Get("Content-Type"))
+		if err != nil {
+			declaredType = ""
+		}
+		sniffedType, _, _ := mime.ParseMediaType(mimetype.Detect(partData).String())
+		md5sum := md5.Sum(partData)
+		sha256sum := sha256.Sum256(partData)
+		files = append(files, &InspectedFile{Field: part.FormName(), Filename: filename, ContentType: strings.ToLower(declaredType), SniffedContentType: strings.ToLower(sniffedType), Size: int64(len(partData)), MD5: hex.EncodeToString(md5sum[:]), SHA256: hex.EncodeToString(sha256sum[:])})
+	}
+
+	return files, values, nil
+}
+
+// Holds all the data of the request inspected by the rules
+// The request is parsed only once and the context is not modified afterwards, so the rules can be evaluated concurrently on it
+type RequestInspectionContext struct {
+	Method   string           //The method of the request
+	URL      string           //The escaped path and the raw query of the request (decoded if the configuration enables it)
+	Headers  http.Header      //The headers of the request
+	Query    url.Values       //The query parameters of the request
+	PostForm url.Values       //The parameters from the body of the request
+	Cookies  []*http.Cookie   //The cookies sent with the request
+	Files    []*InspectedFile //The files uploaded in the multipart body of the request
+	Body     *InspectedBody   //The body of the request
+	Target   *requestTarget   //The host, path and client of the request (used by the scope of the rules and the exclusions)
+	Raw      *rawMessage      //The dumped request used to locate the matches (nil if the matches are not located)
+}
+
+// Holds all the data of the response inspected by the rules
+type ResponseInspectionContext struct {
+	StatusCode int                       //The status code of the response
+	Headers    http.Header               //The headers of the response
+	Body       *InspectedBody            //The body of the response
+	Target     *requestTarget            //The host, path and client of the request which led to the response (nil if the request is not known)
+	Upstream   *data.UpstreamMetrics     //The time the web server took to respond (nil if it was not measured)
+	Request    *RequestInspectionContext //The request which led to the response (only created when a rule confirms the request)
+	Raw        *rawMessage               //The dumped response used to locate the matches (nil if the matches are not located)
+}
+
+// Gets the URL inspected by the url matchers
+// The url matchers inspect the escaped path and the raw query, the decoded path and query are inspected only if enabled in the configuration
+// @param u - the URL of the request
+// @param decoded - if the decoded path and query should be inspected
+// Returns the inspected URL
+func getInspectedURL(u *url.URL, decoded bool) string {
+	if decoded {
+		return getDecodedURL(u)
+	}
+	//RawPath is only set when the path has an encoding different from the default one so the escaped path is used
+	rawURL := u.EscapedPath()
+	if u.RawQuery != "" {
+		rawURL += "?" + u.RawQuery
+	}
+	return rawURL
+}
+
+// Gets the decoded path and query of the URL
+// If the query cannot be decoded then the raw query is used
+func getDecodedURL(u *url.URL) string {
+	decodedURL := u.Path
+	if u.RawQuery != "" {
+		decodedQuery, err := url.QueryUnescape(u.RawQuery)
+		if err != nil {
+			decodedQuery = u.RawQuery
+		}
+		decodedURL += "?" + decodedQuery
+	}
+	return decodedURL
+}
+
+// Creates the inspection context of the request
+// The body of the request is restored so it can be read again after the context is created
+// @param r - the request
+// @param decodedURL - if the url matchers inspect the decoded path and query instead of the escaped ones
+// @param logger - the logger
+// Returns the inspection context of the request
+func NewRequestInspectionContext(r *http.Request, decodedURL bool, logger logging.ILogger) *RequestInspectionContext {
+	//Read the body of the request
+	bodyData := make([]byte, 0)
+	if r.Body != nil {
+		var err error
+		bodyData, err = io.ReadAll(r.Body)
+		if err != nil {
+			logger.Error("Error occured when reading the body contents from the request", err.Error())
+		}
+	}
+	//Reassign the body so other function can read the data
+	r.Body = io.NopCloser(bytes.NewReader(bodyData))
+	//Parse the form to get the POST parameters
+	err := r.ParseForm()
+	//Check if an error occured
+	if err != nil {
+		logger.Error("Error occured when parsing the request form when running rules on request", err.Error())
+	}
+	//Reasign the body after parsing the form
+	r.Body = io.NopCloser(bytes.NewReader(bodyData))
+
+	postForm := r.PostForm
+	if postForm == nil {
+		postForm = make(url.Values)
+	}
+
+	//Parse the multipart body to get the uploaded files and the other fields (which are added to the POST parameters)
+	files, multipartValues, err := parseMultipartBody(bodyData, r.Header.Get("Content-Type"))
+	if err != nil {
+		logger.Error("Error occured when parsing the multipart body when running rules on request", err.Error())
+	}
+	for name, values := range multipartValues {
+		postForm[name] = append(postForm[name], values...)
+	}
+
+	return &RequestInspectionContext{Method: r.Method, URL: getInspectedURL(r.URL, decodedURL), Headers: r.Header.Clone(), Query: r.URL.Query(), PostForm: postForm, Cookies: r.Cookies(), Files: files, Body: newInspectedBody(bodyData, r.Header.Get("Content-Type")), Target: newRequestTarget(r)}
+}
+
+// Creates the inspection context of the response
+// The body of the response is restored so it can be read again after the context is created
+// @param r - the response
+// @param upstream - the time the web server took to respond (nil if it was not measured)
+// @param logger - the logger
+// Returns the inspection context of the response
+func NewResponseInspectionContext(r *http.Response, upstream *data.UpstreamMetrics, logger logging.ILogger) *ResponseInspectionContext {
+	//Read the body of the response
+	bodyData := make([]byte, 0)
+	if r.Body != nil {
+		var err error
+		bodyData, err = io.ReadAll(r.Body)
+		if err != nil {
+			logger.Error("Error occured when reading the body contents from the response", err.Error())
+		}
+	}
+	//Reassign the body so other function can read the data
+	r.Body = io.NopCloser(bytes.NewReader(bodyData))
+
+	return &ResponseInspectionContext{StatusCode: r.StatusCode, Headers: r.Header.Clone(), Body: newInspectedBody(bodyData, r.Header.Get("Content-Type")), Target: newRequestTarget(r.Request), Upstream: upstream}
+}
+
+// Creates a copy of the context without some of the parameters and the headers (removed by the exclusions)
+// @param parameters - the names of the GET and POST parameters
+// @param headers - the names of the headers
+// Returns the new context
+func (ctx *RequestInspectionContext) without(parameters []string, headers []string) *RequestInspectionContext {
+	filtered := *ctx
+	filtered.Query = withoutParameters(ctx.Query, parameters)
+	filtered.PostForm = withoutParameters(ctx.PostForm, parameters)
+	filtered.Headers = withoutHeaders(ctx.Headers, headers)
+	return &filtered
+}
+
+// Creates a copy of the context without some of the headers (removed by the exclusions)
+// @param headers - the names of the headers
+// Returns the new context
+func (ctx *ResponseInspectionContext) without(headers []string) *ResponseInspectionContext {
+	filtered := *ctx
+	filtered.Headers = withoutHeaders(ctx.Headers, headers)
+	return &filtered
+}
+
+// Gets all the values of the request inspected by the rules (used to select the candidate rules)
+func (ctx *RequestInspectionContext) getInspectedValues() []string {
+	inspectedValues := []string{ctx.Method, ctx.URL, ctx.Body.Text}
+	for _, headerValues := range ctx.Headers {
+		inspectedValues = append(inspectedValues, headerValues...)
+	}
+	for _, parameterValues := range ctx.Query {
+		inspectedValues = append(inspectedValues, parameterValues...)
+	}
+	for _, parameterValues := range ctx.PostForm {
+		inspectedValues = append(inspectedValues, parameterValues...)
+	}
+	for _, cookie := range ctx.Cookies {
+		inspectedValues = append(inspectedValues, cookie.Value)
+	}
+	for _, file := range ctx.Files {
+		inspectedValues = append(inspectedValues, file.Filename, file.ContentType, file.SniffedContentType)
+	}
+	inspectedValues = append(inspectedValues, ctx.Body.getDocumentValues()...)
+	return inspectedValues
+}
+
+// Gets all the values of the response inspected by the rules (used to select the candidate rules)
+func (ctx *ResponseInspectionContext) getInspectedValues() []string {
+	inspectedValues := []string{strconv.Itoa(ctx.StatusCode), ctx.Body.Text}
+	for _, headerValues := range ctx.Headers {
+		inspectedValues = append(inspectedValues, headerValues...)
+	}
+	inspectedValues = append(inspectedValues, ctx.Body.getDocumentValues()...)
+	return inspectedValues
+}
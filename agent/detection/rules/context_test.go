@@ -0,0 +1,45 @@
+package detection
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/lucacoratu/disertatie/agent/config"
+	"github.com/lucacoratu/disertatie/agent/logging"
+)
+
+func TestGetInspectedURL(t *testing.T) {
+	tests := []struct {
+		target   string
+		decoded  bool
+		expected string
+	}{
+		{"/wp-admin/x.php", false, "/wp-admin/x.php"},
+		{"/wp-admin/x.php?a=1", false, "/wp-admin/x.php?a=1"},
+		{"/a%2Fb?q=%27or", false, "/a%2Fb?q=%27or"},
+		{"/a%20b?q=%27or", true, "/a b?q='or"},
+		{"/wp-admin/x.php", true, "/wp-admin/x.php"},
+	}
+	for _, test := range tests {
+		r := httptest.NewRequest(http.MethodGet, test.target, nil)
+		if inspected := getInspectedURL(r.URL, test.decoded); inspected != test.expected {
+			t.Errorf("%s (decoded %v): expected %q, got %q", test.target, test.decoded, test.expected, inspected)
+		}
+	}
+}
+
+func TestURLRuleMatchesPlainPath(t *testing.T) {
+	rule := Rule{Id: "wp-admin", Info: &RuleInfo{Name: "WordPress admin", Severity: "low", Classification: "recon"}, Request: &RequestRule{URL: []*RuleSearchMode{{Match: "/wp-admin/x.php"}}}}
+	for _, decoded := range []bool{false, true} {
+		logger := logging.NewDefaultLogger()
+		runner := NewRuleRunner(logger, NewRuleIndex([]Rule{rule}, logger), nil, config.Configuration{InspectDecodedURL: decoded})
+		findings, _, err := runner.RunRulesOnRequest(httptest.NewRequest(http.MethodGet, "/wp-admin/x.php?a=1", nil))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(findings) != 1 || findings[0].RuleId != "wp-admin" {
+			t.Errorf("decoded %v: expected the url rule to match, got %d findings", decoded, len(findings))
+		}
+	}
+}
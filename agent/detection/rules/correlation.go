@@ -0,0 +1,433 @@
+package detection
+
+import (
+	"errors"
+	"hash/fnv"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/lucacoratu/disertatie/agent/data"
+	"github.com/lucacoratu/disertatie/agent/utils"
+	"github.com/lucacoratu/disertatie/agent/websocket"
+)
+
+// The values which identify the client of a correlation rule
+const (
+	CorrelationKeyIP        = "ip"         //The IP address of the client
+	CorrelationKeySession   = "session"    //The value of the session cookie
+	CorrelationKeyUserAgent = "user-agent" //The User-Agent header
+)
+
+// The values whose distinct count can be used by a correlation rule (together with the keys)
+const (
+	CorrelationValuePath      = "path"      //The path of the request
+	CorrelationValueMethod    = "method"    //The method of the request
+	CorrelationValueStatus    = "status"    //The status code of the response
+	CorrelationValueParameter = "parameter" //The value of a GET or POST parameter
+)
+
+// The time between two removals of the expired correlation states
+const correlationSweepInterval = 1 * time.Minute
+
+// The limits of the state kept by the correlation rules
+const (
+	correlationShards    = 16     //The number of shards of the states, the requests of clients from different shards do not wait for each other
+	correlationMaxStates = 100000 //The maximum number of states (rule and client pairs), the least recently seen clients are evicted when it is reached
+)
+
+// Holds the requests of a client counted by a correlation rule in the current window
+type correlationState struct {
+	window   time.Duration        //The length of the window of the rule
+	times    []time.Time          //The time of each request (when the requests are counted)
+	values   map[string]time.Time //The last time each value was seen (when the distinct values are counted)
+	lastSeen time.Time            //The time of the last request
+}
+
+// Holds a part of the states of the correlation rules, the state of a client is always in the same shard
+type correlationShard struct {
+	mu        sync.Mutex
+	states    *utils.LRUCache[string, *correlationState] //The states mapped by the rule id and the key of the client
+	lastSweep time.Time                                  //The last time the expired states were removed
+}
+
+// Holds the state of the correlation rules for all the clients
+// The state is kept in memory and it is shared by all the requests, so it is not lost when the rules are reloaded
+// The number of states is bounded since the keys of the clients are chosen by the clients (the session cookie, the User-Agent)
+type CorrelationStore struct {
+	shards [correlationShards]correlationShard
+	now    func() time.Time //The clock (replaced in the tests)
+}
+
+// Creates an empty correlation store
+func NewCorrelationStore() *CorrelationStore {
+	return newCorrelationStore(correlationMaxStates)
+}
+
+// Creates an empty correlation store holding at most maxStates states
+func newCorrelationStore(maxStates int) *CorrelationStore {
+	store := &CorrelationStore{now: time.Now}
+	shardCapacity := max(maxStates/correlationShards, 1)
+	for i := range store.shards {
+		store.shards[i].states = utils.NewLRUCache[string, *correlationState](shardCapacity)
+	}
+	return store
+}
+
+// Gets the shard holding the state
+func (store *CorrelationStore) getShard(stateKey string) *correlationShard {
+	hash := fnv.New32a()
+	hash.Write([]byte(stateKey))
+	return &store.shards[hash.Sum32()%correlationShards]
+}
+
+// Removes the requests which are not in the window anymore
+func (state *correlationState) prune(now time.Time) {
+	start := now.Add(-state.window)
+	times := state.times[:0]
+	for _, requestTime := range state.times {
+		if requestTime.After(start) {
+			times = append(times, requestTime)
+		}
+	}
+	state.times = times
+	for value, valueTime := range state.values {
+		if !valueTime.After(start) {
+			delete(state.values, value)
+		}
+	}
+}
+
+// Removes the states of the clients which did not send a counted request in the window of the rule
+func (shard *correlationShard) sweep(now time.Time) {
+	shard.states.RemoveFunc(func(stateKey string, state *correlationState) bool {
+		return !state.lastSeen.After(now.Add(-state.window))
+	})
+	shard.lastSweep = now
+}
+
+// Counts a request of a client for a correlation rule
+// When the threshold is reached the state of the client is reset so the rule trips again only after the threshold is reached again
+// @param ruleId - the id of the correlation rule
+// @param key - the key of the client
+// @param value - the value whose distinct count is used (empty if the requests are counted)
+// @param window - the length of the window
+// @param threshold - the number of requests (or distinct values) which trips the rule
+// Returns the number of requests (or distinct values) in the window and if the threshold was reached
+func (store *CorrelationStore) observe(ruleId string, key string, value string, window time.Duration, threshold int) (int, bool) {
+	stateKey := ruleId + "\x00" + key
+	shard := store.getShard(stateKey)
+	shard.mu.Lock()
+	defer shard.mu.Unlock()
+
+	now := store.now()
+	if now.Sub(shard.lastSweep) > correlationSweepInterval {
+		shard.sweep(now)
+	}
+
+	state, found := shard.states.Get(stateKey)
+	if !found {
+		state = &correlationState{times: make([]time.Time, 0), values: make(map[string]time.Time)}
+		shard.states.Add(stateKey, state)
+	}
+	//The window is taken from the rule every time since the rule can be modified by a reload
+	state.window = window
+	state.prune(now)
+	state.lastSeen = now
+
+	count := 0
+	if value == "" {
+		state.times = append(state.times, now)
+		count = len(state.times)
+	} else {
+		state.values[value] = now
+		count = len(state.values)
+	}
+
+	if count < threshold {
+		return count, false
+	}
+	shard.states.Remove(stateKey)
+	return count, true
+}
+
+// Checks if the correlation rule is valid
+// @param rule - the rule to be checked
+// Returns an error if the correlation rule is not valid
+func CheckCorrelationRule(rule Rule) error {
+	correlation := rule.Correlation
+	if correlation == nil {
+		return nil
+	}
+	if rule.Request != nil || rule.Response != nil || len(rule.Websocket) > 0 {
+		return errors.New("rule correlation cannot be used together with the request, response and websocket matchers, use the correlation filter on the findings of the other rules")
+	}
+	if rule.Condition != nil || rule.MatchersCondition != "" {
+		return errors.New("rule correlation cannot have a condition")
+	}
+	if len(rule.Tests) > 0 {
+		return errors.New("rule correlation cannot have tests, they need more than one request")
+	}
+
+	if len(correlation.Keys) == 0 {
+		return errors.New("rule correlation should have at least one key")
+	}
+	usesSession := false
+	for i, key := range correlation.Keys {
+		if key != CorrelationKeyIP && key != CorrelationKeySession && key != CorrelationKeyUserAgent {
+			return errors.New("rule correlation key cannot be something other than: ip, session, user-agent")
+		}
+		if containsString(correlation.Keys[:i], key) {
+			return errors.New("rule correlation key is used more than once, " + key)
+		}
+		usesSession = usesSession || key == CorrelationKeySession
+	}
+
+	switch correlation.Distinct {
+	case "", CorrelationKeyIP, CorrelationKeyUserAgent, CorrelationValuePath, CorrelationValueMethod, CorrelationValueStatus:
+	case CorrelationKeySession:
+		usesSession = true
+	case CorrelationValueParameter:
+		if strings.TrimSpace(correlation.Parameter) == "" {
+			return errors.New("rule correlation distinct parameter needs the name of the parameter")
+		}
+	default:
+		return errors.New("rule correlation distinct cannot be something other than: path, method, status, ip, session, user-agent, parameter")
+	}
+	if correlation.Parameter != "" && correlation.Distinct != CorrelationValueParameter {
+		return errors.New("rule correlation parameter can be used only when the distinct parameter values are counted")
+	}
+	if containsString(correlation.Keys, correlation.Distinct) {
+		return errors.New("rule correlation distinct cannot be one of the keys")
+	}
+	if usesSession && strings.TrimSpace(correlation.SessionCookie) == "" {
+		return errors.New("rule correlation session needs the name of the session cookie")
+	}
+
+	window, err := time.ParseDuration(correlation.Window)
+	if err != nil {
+		return errors.New("rule correlation window is not a valid duration (30s, 5m, 1h), " + correlation.Window)
+	}
+	if window <= 0 {
+		return errors.New("rule correlation window should be greater than 0")
+	}
+	if correlation.Threshold < 1 {
+		return errors.New("rule correlation threshold should be greater than 0")
+	}
+
+	if correlation.Filter != nil {
+		for _, status := range correlation.Filter.Status {
+			if status < 100 || status > 599 {
+				return errors.New("rule correlation filter status is not a valid status code, " + strconv.Itoa(status))
+			}
+		}
+	}
+	return nil
+}
+
+// Gets a value of the request identifying the client or counted by the correlation rule
+// @param name - the name of the value (ip, session, user-agent, path, method, status, parameter)
+// @param correlation - the correlation rule
+// @param r - the request
+// @param target - the information about the request
+// @param statusCode - the status code of the response
+// Returns the value and false if the request does not have it (no session cookie)
+func getCorrelationValue(name string, correlation *CorrelationRule, r *http.Request, target *requestTarget, statusCode int) (string, bool) {
+	switch name {
+	case CorrelationKeyIP:
+		if target != nil && target.RemoteIP != nil {
+			return target.RemoteIP.String(), true
+		}
+		return r.RemoteAddr, r.RemoteAddr != ""
+	case CorrelationKeySession:
+		cookie, err := r.Cookie(correlation.SessionCookie)
+		if err != nil || cookie.Value == "" {
+			return "", false
+		}
+		return cookie.Value, true
+	case CorrelationKeyUserAgent:
+		return r.UserAgent(), true
+	case CorrelationValuePath:
+		return r.URL.Path, true
+	case CorrelationValueMethod:
+		return r.Method, true
+	case CorrelationValueStatus:
+		return strconv.Itoa(statusCode), true
+	case CorrelationValueParameter:
+		//The form is already parsed when the rules were run on the request (it is not parsed here since that would read the body)
+		parameters := r.Form
+		if parameters == nil {
+			parameters = r.URL.Query()
+		}
+		value := parameters.Get(correlation.Parameter)
+		return value, value != ""
+	}
+	return "", false
+}
+
+// Checks if the request and its response pass the filter of the correlation rule
+// @param filter - the filter of the correlation rule
+// @param statusCode - the status code of the response
+// @param findings - the findings of the other rules on the request and the response
+// Returns true if the request should be counted
+func (filter *CorrelationFilter) matches(statusCode int, findings []*data.RuleFindingData) bool {
+	if filter == nil {
+		return true
+	}
+	if len(filter.Status) > 0 {
+		statusFound := false
+		for _, status := range filter.Status {
+			if status == statusCode {
+				statusFound = true
+				break
+			}
+		}
+		if !statusFound {
+			return false
+		}
+	}
+	if len(filter.Rules) > 0 || len(filter.Classifications) > 0 {
+		for _, finding := range findings {
+			if containsString(filter.Rules, finding.RuleId) {
+				return true
+			}
+			for _, classification := range filter.Classifications {
+				if strings.EqualFold(classification, finding.Classification) {
+					return true
+				}
+			}
+		}
+		return false
+	}
+	return true
+}
+
+// Creates the synthetic finding of a correlation rule which reached its threshold
+// @param rule - the correlation rule
+// @param count - the number of requests (or distinct values) in the window
+// @param clientKey - the description of the client (ip=10.0.0.1,user-agent=curl/8.0)
+// Returns the rule finding
+func newCorrelationFinding(rule Rule, count int, clientKey string) *data.RuleFindingData {
+	finding := newRuleFindingData(rule)
+	counted := "requests"
+	if rule.Correlation.Distinct == CorrelationValueParameter {
+		counted = "distinct " + rule.Correlation.Parameter + " values"
+	} else if rule.Correlation.Distinct != "" {
+		counted = "distinct " + rule.Correlation.Distinct + " values"
+	}
+	finding.MatchedString = strconv.Itoa(count) + " " + counted + " in " + rule.Correlation.Window
+	finding.Length = int64(len(finding.MatchedString))
+	finding.MatchedPath = clientKey
+	//The finding is not in the raw request or response
+	finding.Line = -1
+	finding.LineIndex = -1
+	return finding
+}
+
+// Sends an alert to the API via WebSocket when a correlation rule reaches its threshold (regardless of the severity of the rule)
+// @param rule - the correlation rule
+func (rl *RuleRunner) sendCorrelationAlert(rule Rule) {
+	if rl.apiWsConn == nil {
+		return
+	}
+	err := rl.apiWsConn.SendRuleDetectionAlert(websocket.RuleDetectionAlert{AgentId: rl.configuration.UUID, RuleId: rule.Id, RuleName: rule.Info.Name, RuleDescription: rule.Info.Description, Classification: rule.Info.Classification, Severity: rule.Info.Severity, Timestamp: time.Now().Unix()})
+	//Check if an error occured when sending the alert
+	if err != nil {
+		rl.logger.Error("Error occured when sending alert to API when a correlation rule reached its threshold")
+	}
+}
+
+// Counts the request in the state of the correlation rules and reports the rules which reached their threshold
+// It should be called after the response is received (or the request is blocked) since the status code and the findings of the other rules are needed
+// @param correlations - the state of the correlation rules
+// @param r - the request
+// @param statusCode - the status code of the response sent to the client
+// @param requestFindings - the findings of the rules on the request
+// @param responseFindings - the findings of the rules on the response
+// Returns a list of findings and a list of findings suppressed by the exclusions
+func (rl *RuleRunner) RunCorrelationRules(correlations *CorrelationStore, r *http.Request, statusCode int, requestFindings []*data.RuleFindingData, responseFindings []*data.RuleFindingData) ([]*data.RuleFindingData, []*data.RuleFindingData) {
+	findings := make([]*data.RuleFindingData, 0)
+	suppressedFindings := make([]*data.RuleFindingData, 0)
+	if rl.rules == nil || correlations == nil || r == nil {
+		return findings, suppressedFindings
+	}
+
+	priorFindings := make([]*data.RuleFindingData, 0, len(requestFindings)+len(responseFindings))
+	priorFindings = append(append(priorFindings, requestFindings...), responseFindings...)
+	target := newRequestTarget(r)
+
+	for _, rule := range rl.rules {
+		if rule.Correlation == nil {
+			continue
+		}
+		start := time.Now()
+		kept, suppressed := rl.runCorrelationRule(correlations, rule, r, target, statusCode, priorFindings)
+		rl.statistics.record(rule.Id, time.Since(start), len(kept) > 0, nil)
+		suppressedFindings = append(suppressedFindings, suppressed...)
+		if len(kept) == 0 {
+			continue
+		}
+		rl.logger.Info("Correlation rule", rule.Id, "reached its threshold for", kept[0].MatchedPath+",", kept[0].MatchedString)
+		findings = append(findings, kept...)
+		rl.sendCorrelationAlert(rule)
+	}
+
+	return findings, suppressedFindings
+}
+
+// Counts the request in the state of the correlation rule
+// @param correlations - the state of the correlation rules
+// @param rule - the correlation rule
+// @param r - the request
+// @param target - the target of the request
+// @param statusCode - the status code of the response sent to the client
+// @param priorFindings - the findings of the other rules on the request and the response
+// Returns the finding of the rule if it reached its threshold and the findings suppressed by the exclusions
+func (rl *RuleRunner) runCorrelationRule(correlations *CorrelationStore, rule Rule, r *http.Request, target *requestTarget, statusCode int, priorFindings []*data.RuleFindingData) ([]*data.RuleFindingData, []*data.RuleFindingData) {
+	correlation := rule.Correlation
+	if correlation == nil || !rl.index.isRuleInScope(rule, target) || !correlation.Filter.matches(statusCode, priorFindings) {
+		return nil, nil
+	}
+	window, err := time.ParseDuration(correlation.Window)
+	if err != nil {
+		return nil, nil
+	}
+
+	//Build the key of the client, the request is not counted if a key is missing
+	keyParts := make([]string, 0, len(correlation.Keys))
+	keyAvailable := true
+	for _, key := range correlation.Keys {
+		value, found := getCorrelationValue(key, correlation, r, target, statusCode)
+		if !found {
+			keyAvailable = false
+			break
+		}
+		keyParts = append(keyParts, key+"="+value)
+	}
+	if !keyAvailable {
+		return nil, nil
+	}
+	distinctValue := ""
+	if correlation.Distinct != "" {
+		value, found := getCorrelationValue(correlation.Distinct, correlation, r, target, statusCode)
+		if !found {
+			return nil, nil
+		}
+		//The value is prefixed so an empty value is still counted
+		distinctValue = "=" + value
+	}
+
+	clientKey := strings.Join(keyParts, ",")
+	count, tripped := correlations.observe(rule.Id, clientKey, distinctValue, window, correlation.Threshold)
+	if !tripped {
+		return nil, nil
+	}
+
+	ruleFindings := []*data.RuleFindingData{newCorrelationFinding(rule, count, clientKey)}
+	return rl.index.applyExclusions(rule, target, ruleFindings, func(parameters []string, headers []string) []*data.RuleFindingData {
+		//The parameters and the headers are not inspected by the correlation rules
+		return ruleFindings
+	})
+}
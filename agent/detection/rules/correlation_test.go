@@ -0,0 +1,120 @@
+package detection
+
+import (
+	"strconv"
+	"testing"
+	"time"
+)
+
+// Creates a correlation store whose clock is moved by the test
+func newTestCorrelationStore(maxStates int) (*CorrelationStore, *time.Time) {
+	store := newCorrelationStore(maxStates)
+	current := time.Unix(1700000000, 0)
+	store.now = func() time.Time {
+		return current
+	}
+	return store, &current
+}
+
+// Gets the number of states held by the store
+func countCorrelationStates(store *CorrelationStore) int {
+	count := 0
+	for i := range store.shards {
+		count += store.shards[i].states.Len()
+	}
+	return count
+}
+
+func TestCorrelationStoreObserve(t *testing.T) {
+	type observation struct {
+		advance time.Duration //The time passed since the previous request
+		key     string
+		value   string
+		count   int
+		tripped bool
+	}
+	tests := []struct {
+		name         string
+		threshold    int
+		observations []observation
+	}{
+		{"requests in the window", 3, []observation{
+			{0, "ip=10.0.0.1", "", 1, false},
+			{4 * time.Second, "ip=10.0.0.1", "", 2, false},
+			{time.Second, "ip=10.0.0.1", "", 3, true},
+		}},
+		{"requests outside the window are not counted", 3, []observation{
+			{0, "ip=10.0.0.1", "", 1, false},
+			{6 * time.Second, "ip=10.0.0.1", "", 2, false},
+			{5 * time.Second, "ip=10.0.0.1", "", 2, false},
+			{time.Second, "ip=10.0.0.1", "", 3, true},
+		}},
+		{"clients are counted separately", 2, []observation{
+			{0, "ip=10.0.0.1", "", 1, false},
+			{0, "ip=10.0.0.2", "", 1, false},
+			{0, "ip=10.0.0.1", "", 2, true},
+		}},
+		{"distinct values", 3, []observation{
+			{0, "ip=10.0.0.1", "=/a", 1, false},
+			{time.Second, "ip=10.0.0.1", "=/a", 1, false},
+			{time.Second, "ip=10.0.0.1", "=/b", 2, false},
+			{time.Second, "ip=10.0.0.1", "=", 3, true},
+		}},
+		{"distinct values expire", 3, []observation{
+			{0, "ip=10.0.0.1", "=/a", 1, false},
+			{5 * time.Second, "ip=10.0.0.1", "=/b", 2, false},
+			{6 * time.Second, "ip=10.0.0.1", "=/c", 2, false},
+			{time.Second, "ip=10.0.0.1", "=/b", 2, false},
+		}},
+		{"threshold resets the state", 2, []observation{
+			{0, "ip=10.0.0.1", "", 1, false},
+			{0, "ip=10.0.0.1", "", 2, true},
+			{0, "ip=10.0.0.1", "", 1, false},
+			{0, "ip=10.0.0.1", "", 2, true},
+		}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			store, current := newTestCorrelationStore(correlationMaxStates)
+			for i, observation := range test.observations {
+				*current = current.Add(observation.advance)
+				count, tripped := store.observe("rule", observation.key, observation.value, 10*time.Second, test.threshold)
+				if count != observation.count || tripped != observation.tripped {
+					t.Errorf("request %d: expected the count %d (tripped %v), got %d (tripped %v)", i, observation.count, observation.tripped, count, tripped)
+				}
+			}
+		})
+	}
+}
+
+func TestCorrelationStoreRulesAreSeparate(t *testing.T) {
+	store, _ := newTestCorrelationStore(correlationMaxStates)
+	store.observe("first", "ip=10.0.0.1", "", time.Minute, 2)
+	if count, tripped := store.observe("second", "ip=10.0.0.1", "", time.Minute, 2); count != 1 || tripped {
+		t.Errorf("expected the second rule to count 1 request, got %d (tripped %v)", count, tripped)
+	}
+}
+
+func TestCorrelationStoreBounds(t *testing.T) {
+	store, current := newTestCorrelationStore(correlationShards * 2)
+	for i := 0; i < 1000; i++ {
+		store.observe("rule", "session="+strconv.Itoa(i), "", time.Hour, 10)
+	}
+	if count := countCorrelationStates(store); count > correlationShards*2 {
+		t.Errorf("expected at most %d states, got %d", correlationShards*2, count)
+	}
+
+	//The most recently seen client is kept
+	if count, _ := store.observe("rule", "session=999", "", time.Hour, 10); count != 2 {
+		t.Errorf("expected the last client to have 2 requests, got %d", count)
+	}
+
+	//The expired states are removed by the sweep
+	*current = current.Add(2 * time.Hour)
+	for i := range store.shards {
+		store.shards[i].sweep(*current)
+	}
+	if count := countCorrelationStates(store); count != 0 {
+		t.Errorf("expected the expired states to be removed, got %d", count)
+	}
+}
@@ -0,0 +1,356 @@
+package detection
+
+import (
+	"bytes"
+	"compress/flate"
+	"compress/gzip"
+	"compress/zlib"
+	"encoding/base64"
+	"encoding/hex"
+	"html"
+	"io"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// The maximum number of decodings applied one after the other on a value (url -> base64 -> url ...)
+const maxDecodeDepth = 3
+
+// The maximum number of distinct decoded values kept for a value (the original value included)
+const maxDecodedValues = 32
+
+// The maximum size of a decompressed value (protects against decompression bombs)
+const maxDecompressedSize = 1 << 20
+
+// Holds a value obtained by decoding the inspected data
+type decodedValue struct {
+	Value string   //The decoded value
+	Chain []string //The decodings applied, in order, to obtain the value (empty for the original value)
+}
+
+// Decodes a value, returns the decoded value and true if the decoding succeeded
+type decoder func(value string) (string, bool)
+
+// The decoders for every supported encoding
+// Double URL encoding is handled by applying the url decoder recursively
+// The keys should be the same as SupportedEncodings
+var decoders = map[string]decoder{
+	"base64":        decodeBase64,
+	"base64url":     decodeBase64URL,
+	"url":           decodeURL,
+	"hex":           decodeHex,
+	"html":          decodeHTMLEntities,
+	"js":            decodeJSEscapes,
+	"utf8-overlong": decodeUTF8Overlong,
+	"sql-char":      foldSQLStrings,
+	"gzip":          decompressGzip,
+	"deflate":       decompressDeflate,
+}
+
+// Checks if the encoding is supported (case insensitive)
+func isSupportedEncoding(encoding string) bool {
+	_, found := decoders[strings.ToLower(encoding)]
+	return found
+}
+
+// Decodes the value recursively using the encodings
+// Every decoded value is decoded again with all the encodings until the maximum depth is reached
+// The intermediate results are deduplicated so the same value is not decoded twice
+// @param value - the value to be decoded
+// @param encodings - the list of encodings
+// Returns the list of decoded values (the first one is the unmodified value)
+func decodeValue(value string, encodings []string) []decodedValue {
+	decodedValues, _ := decodeValueWithLimit(value, encodings)
+	return decodedValues
+}
+
+// Decodes the value recursively using the encodings and reports if some decodings were dropped
+// The decoding stops when the maximum number of decoded values is reached, the values which were not decoded yet are lost
+// @param value - the value to be decoded
+// @param encodings - the list of encodings
+// Returns the list of decoded values (the first one is the unmodified value) and true if the decoding was truncated
+func decodeValueWithLimit(value string, encodings []string) ([]decodedValue, bool) {
+	decodedValues := []decodedValue{{Value: value, Chain: nil}}
+	if len(encodings) == 0 {
+		return decodedValues, false
+	}
+
+	seen := map[string]bool{value: true}
+	//Breadth first so the shortest chain leading to a value is the one recorded
+	for start := 0; start < len(decodedValues); start++ {
+		current := decodedValues[start]
+		if len(current.Chain) >= maxDecodeDepth {
+			continue
+		}
+		for _, encoding := range encodings {
+			decode, found := decoders[strings.ToLower(encoding)]
+			if !found {
+				continue
+			}
+			decoded, ok := decode(current.Value)
+			if !ok || seen[decoded] {
+				continue
+			}
+			if len(decodedValues) >= maxDecodedValues {
+				return decodedValues, true
+			}
+			seen[decoded] = true
+			chain := make([]string, 0, len(current.Chain)+1)
+			chain = append(chain, current.Chain...)
+			chain = append(chain, strings.ToLower(encoding))
+			decodedValues = append(decodedValues, decodedValue{Value: decoded, Chain: chain})
+		}
+	}
+
+	return decodedValues, false
+}
+
+// Decodes a base64 string (with or without padding)
+func decodeBase64(value string) (string, bool) {
+	return decodeBase64WithEncodings(value, base64.StdEncoding, base64.RawStdEncoding)
+}
+
+// Decodes a base64url string (with or without padding)
+func decodeBase64URL(value string) (string, bool) {
+	return decodeBase64WithEncodings(value, base64.URLEncoding, base64.RawURLEncoding)
+}
+
+// Decodes the value with the padded or the raw base64 encoding
+// The decoded value should be valid UTF-8 text, else the value was not base64
+func decodeBase64WithEncodings(value string, padded *base64.Encoding, raw *base64.Encoding) (string, bool) {
+	value = strings.TrimSpace(value)
+	if len(value) < 4 {
+		return "", false
+	}
+	decoded, err := padded.DecodeString(value)
+	if err != nil {
+		decoded, err = raw.DecodeString(value)
+		if err != nil {
+			return "", false
+		}
+	}
+	if !utf8.Valid(decoded) {
+		return "", false
+	}
+	return string(decoded), true
+}
+
+// Decodes an URL encoded string
+func decodeURL(value string) (string, bool) {
+	if !strings.ContainsAny(value, "%+") {
+		return "", false
+	}
+	decoded, err := url.QueryUnescape(value)
+	if err != nil {
+		//Fallback to the path unescaping which does not fail on the + sign
+		decoded, err = url.PathUnescape(value)
+		if err != nil {
+			return "", false
+		}
+	}
+	return decoded, decoded != value
+}
+
+// Decodes a hex string (can be prefixed with 0x)
+func decodeHex(value string) (string, bool) {
+	value = strings.TrimSpace(value)
+	value = strings.TrimPrefix(strings.TrimPrefix(value, "0x"), "0X")
+	if len(value) < 2 {
+		return "", false
+	}
+	decoded, err := hex.DecodeString(value)
+	if err != nil || !utf8.Valid(decoded) {
+		return "", false
+	}
+	return string(decoded), true
+}
+
+// Decodes the HTML entities (named, decimal and hex)
+func decodeHTMLEntities(value string) (string, bool) {
+	if !strings.Contains(value, "&") {
+		return "", false
+	}
+	decoded := html.UnescapeString(value)
+	return decoded, decoded != value
+}
+
+// Decodes the JavaScript and unicode escapes (\xHH, \uHHHH, \u{HHHHH}, %uHHHH)
+func decodeJSEscapes(value string) (string, bool) {
+	if !strings.Contains(value, "\\x") && !strings.Contains(value, "\\u") && !strings.Contains(value, "%u") && !strings.Contains(value, "%U") {
+		return "", false
+	}
+
+	var builder strings.Builder
+	for i := 0; i < len(value); i++ {
+		//Get the hex digits of the escape sequence starting at position i
+		var digits string
+		var length int
+		switch {
+		case strings.HasPrefix(value[i:], "\\x") && i+4 <= len(value):
+			digits, length = value[i+2:i+4], 4
+		case strings.HasPrefix(value[i:], "\\u{"):
+			end := strings.IndexByte(value[i:], '}')
+			if end != -1 {
+				digits, length = value[i+3:i+end], end+1
+			}
+		case (strings.HasPrefix(value[i:], "\\u") || strings.HasPrefix(value[i:], "%u") || strings.HasPrefix(value[i:], "%U")) && i+6 <= len(value):
+			digits, length = value[i+2:i+6], 6
+		}
+		if digits != "" {
+			codepoint, err := strconv.ParseUint(digits, 16, 32)
+			if err == nil && codepoint <= utf8.MaxRune {
+				builder.WriteRune(rune(codepoint))
+				i += length - 1
+				continue
+			}
+		}
+		builder.WriteByte(value[i])
+	}
+
+	decoded := builder.String()
+	return decoded, decoded != value
+}
+
+// Decodes the UTF-8 overlong sequences (%c0%af -> /) which are used to bypass filters
+// Only the 2 and 3 bytes overlong sequences are decoded
+func decodeUTF8Overlong(value string) (string, bool) {
+	var buffer bytes.Buffer
+	changed := false
+	for i := 0; i < len(value); i++ {
+		b := value[i]
+		//2 bytes overlong sequence (C0 or C1 followed by a continuation byte)
+		if (b == 0xc0 || b == 0xc1) && i+1 < len(value) && value[i+1]&0xc0 == 0x80 {
+			buffer.WriteByte((b&0x1f)<<6 | value[i+1]&0x3f)
+			i++
+			changed = true
+			continue
+		}
+		//3 bytes overlong sequence (E0 followed by a continuation byte smaller than A0)
+		if b == 0xe0 && i+2 < len(value) && value[i+1]&0xc0 == 0x80 && value[i+1] < 0xa0 && value[i+2]&0xc0 == 0x80 {
+			buffer.WriteRune(rune(value[i+1]&0x3f)<<6 | rune(value[i+2]&0x3f))
+			i += 2
+			changed = true
+			continue
+		}
+		buffer.WriteByte(b)
+	}
+	return buffer.String(), changed
+}
+
+// Regexes used to fold the SQL string building functions
+var (
+	sqlCharRegex       = regexp.MustCompile(`(?i)\b(?:n?char|chr)\s*\(\s*((?:0x[0-9a-f]+|\d+)(?:\s*,\s*(?:0x[0-9a-f]+|\d+))*)\s*\)`)
+	sqlConcatRegex     = regexp.MustCompile(`(?i)\bconcat\s*\(\s*('[^']*'(?:\s*,\s*'[^']*')*)\s*\)`)
+	sqlConcatArgRegex  = regexp.MustCompile(`'([^']*)'`)
+	sqlConcatOperRegex = regexp.MustCompile(`'([^']*)'\s*(?:\|\||\+)\s*'([^']*)'`)
+)
+
+// Folds the SQL string building expressions into string literals
+// CHAR(97,100)/CHR(97) become 'ad'/'a', CONCAT('a','b') and 'a'||'b' or 'a'+'b' become 'ab'
+func foldSQLStrings(value string) (string, bool) {
+	lowerValue := strings.ToLower(value)
+	if !strings.Contains(lowerValue, "char") && !strings.Contains(lowerValue, "chr") && !strings.Contains(lowerValue, "concat") && !strings.Contains(value, "'") {
+		return "", false
+	}
+
+	//Replace the CHAR calls with the string literal
+	folded := sqlCharRegex.ReplaceAllStringFunc(value, func(call string) string {
+		arguments := sqlCharRegex.FindStringSubmatch(call)[1]
+		var builder strings.Builder
+		builder.WriteByte('\'')
+		for _, argument := range strings.Split(arguments, ",") {
+			codepoint, err := strconv.ParseUint(strings.TrimSpace(argument), 0, 32)
+			if err != nil || codepoint > utf8.MaxRune {
+				return call
+			}
+			builder.WriteRune(rune(codepoint))
+		}
+		builder.WriteByte('\'')
+		return builder.String()
+	})
+
+	//Replace the CONCAT calls with the concatenated literal
+	folded = sqlConcatRegex.ReplaceAllStringFunc(folded, func(call string) string {
+		arguments := sqlConcatRegex.FindStringSubmatch(call)[1]
+		var builder strings.Builder
+		builder.WriteByte('\'')
+		for _, argument := range sqlConcatArgRegex.FindAllStringSubmatch(arguments, -1) {
+			builder.WriteString(argument[1])
+		}
+		builder.WriteByte('\'')
+		return builder.String()
+	})
+
+	//Join the literals concatenated with the operators until nothing changes
+	for {
+		joined := sqlConcatOperRegex.ReplaceAllString(folded, "'$1$2'")
+		if joined == folded {
+			break
+		}
+		folded = joined
+	}
+
+	return folded, folded != value
+}
+
+// Reads the decompressed data up to the maximum size
+func readDecompressed(reader io.Reader) (string, bool) {
+	decompressed, err := io.ReadAll(io.LimitReader(reader, maxDecompressedSize))
+	if err != nil || len(decompressed) == 0 {
+		return "", false
+	}
+	return string(decompressed), true
+}
+
+// Decompresses a gzip value
+func decompressGzip(value string) (string, bool) {
+	//Check the gzip magic bytes
+	if !strings.HasPrefix(value, "\x1f\x8b") {
+		return "", false
+	}
+	reader, err := gzip.NewReader(strings.NewReader(value))
+	if err != nil {
+		return "", false
+	}
+	defer reader.Close()
+	return readDecompressed(reader)
+}
+
+// Checks if the value is valid UTF-8 text without control characters (except the whitespaces)
+func isPrintableText(value string) bool {
+	if !utf8.ValidString(value) {
+		return false
+	}
+	for _, r := range value {
+		if !unicode.IsPrint(r) && !unicode.IsSpace(r) {
+			return false
+		}
+	}
+	return true
+}
+
+// Decompresses a deflate value (zlib wrapped as in the HTTP deflate encoding or raw)
+// Raw deflate does not have a header so many short strings are valid raw deflate streams, the result is kept only if it is printable text
+func decompressDeflate(value string) (string, bool) {
+	if len(value) < 2 {
+		return "", false
+	}
+	reader, err := zlib.NewReader(strings.NewReader(value))
+	if err == nil {
+		defer reader.Close()
+		if decompressed, ok := readDecompressed(reader); ok {
+			return decompressed, true
+		}
+	}
+	rawReader := flate.NewReader(strings.NewReader(value))
+	defer rawReader.Close()
+	decompressed, ok := readDecompressed(rawReader)
+	if !ok || !isPrintableText(decompressed) {
+		return "", false
+	}
+	return decompressed, true
+}
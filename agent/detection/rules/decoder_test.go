@@ -0,0 +1,195 @@
+package detection
+
+import (
+	"bytes"
+	"compress/flate"
+	"compress/gzip"
+	"compress/zlib"
+	"io"
+	"reflect"
+	"testing"
+)
+
+// A case of a decoder test
+type decoderTest struct {
+	name     string
+	value    string
+	expected string
+	ok       bool
+}
+
+// Runs the cases of a decoder test
+func runDecoderTests(t *testing.T, decode decoder, tests []decoderTest) {
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			decoded, ok := decode(test.value)
+			if ok != test.ok {
+				t.Fatalf("decoding %q: expected ok %v, got %v (%q)", test.value, test.ok, ok, decoded)
+			}
+			if ok && decoded != test.expected {
+				t.Errorf("decoding %q: expected %q, got %q", test.value, test.expected, decoded)
+			}
+		})
+	}
+}
+
+// Compresses the value with one of the compress writers
+func compress(t *testing.T, newWriter func(w io.Writer) (io.WriteCloser, error), value string) string {
+	var buffer bytes.Buffer
+	writer, err := newWriter(&buffer)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := writer.Write([]byte(value)); err != nil {
+		t.Fatal(err)
+	}
+	if err := writer.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buffer.String()
+}
+
+func TestDecodeBase64(t *testing.T) {
+	runDecoderTests(t, decodeBase64, []decoderTest{
+		{"padded", "PHNjcmlwdD4=", "<script>", true},
+		{"unpadded", "PHNjcmlwdD4", "<script>", true},
+		{"too short", "YQ", "", false},
+		{"not base64", "<script>", "", false},
+		{"binary", "//79", "", false},
+	})
+	runDecoderTests(t, decodeBase64URL, []decoderTest{
+		{"url alphabet", "Pz8_Pz8-", "?????>", true},
+	})
+}
+
+func TestDecodeURL(t *testing.T) {
+	runDecoderTests(t, decodeURL, []decoderTest{
+		{"percent", "%3Cscript%3E", "<script>", true},
+		{"plus", "union+select", "union select", true},
+		{"double encoded", "%253C", "%3C", true},
+		{"invalid escape", "%zz+a", "", false},
+		{"nothing encoded", "script", "", false},
+	})
+}
+
+func TestDecodeHex(t *testing.T) {
+	runDecoderTests(t, decodeHex, []decoderTest{
+		{"plain", "3c7363726970743e", "<script>", true},
+		{"prefixed", "0x756E696F6E", "union", true},
+		{"odd length", "3c7", "", false},
+		{"not hex", "zz", "", false},
+		{"too short", "a", "", false},
+		{"invalid utf-8", "c0af", "", false},
+	})
+}
+
+func TestDecodeHTMLEntities(t *testing.T) {
+	runDecoderTests(t, decodeHTMLEntities, []decoderTest{
+		{"named", "&lt;script&gt;", "<script>", true},
+		{"decimal", "&#60;script&#62;", "<script>", true},
+		{"hex", "&#x3c;script&#x3E;", "<script>", true},
+		{"unknown entity", "&foo;", "", false},
+		{"no entities", "<script>", "", false},
+	})
+}
+
+func TestDecodeJSEscapes(t *testing.T) {
+	runDecoderTests(t, decodeJSEscapes, []decoderTest{
+		{"hex escape", `\x3cscript\x3e`, "<script>", true},
+		{"unicode escape", `\u003cscript\u003E`, "<script>", true},
+		{"code point escape", `\u{1F600}`, "\U0001F600", true},
+		{"percent u", "%u003cscript%U003E", "<script>", true},
+		{"invalid digits", `\xzz`, "", false},
+		{"truncated escape", `a\u00`, "", false},
+		{"no escapes", "<script>", "", false},
+	})
+}
+
+func TestDecodeUTF8Overlong(t *testing.T) {
+	runDecoderTests(t, decodeUTF8Overlong, []decoderTest{
+		{"2 bytes", "..\xc0\xaf..\xc0\xafetc", "../../etc", true},
+		{"3 bytes", "..\xe0\x80\xafetc", "../etc", true},
+		{"c1 lead byte", "\xc1\x9c", "\\", true},
+		{"no continuation byte", "\xc0/", "", false},
+		{"valid 3 bytes sequence", "\xe0\xa0\x80", "", false},
+		{"ascii", "../etc", "", false},
+	})
+}
+
+func TestFoldSQLStrings(t *testing.T) {
+	runDecoderTests(t, foldSQLStrings, []decoderTest{
+		{"char", "CHAR(97,100,109,105,110)", "'admin'", true},
+		{"chr hex", "chr(0x61)", "'a'", true},
+		{"nchar with spaces", "NCHAR ( 97 , 98 )", "'ab'", true},
+		{"concat", "concat('ad','min')", "'admin'", true},
+		{"pipes", "'ad'||'m'||'in'", "'admin'", true},
+		{"plus", "'ad' + 'min'", "'admin'", true},
+		{"char and pipes", "char(97)||char(98)", "'ab'", true},
+		{"invalid code point", "char(99999999999)", "", false},
+		{"nothing to fold", "select 1", "", false},
+	})
+}
+
+func TestDecompressGzip(t *testing.T) {
+	compressed := compress(t, func(w io.Writer) (io.WriteCloser, error) {
+		return gzip.NewWriter(w), nil
+	}, "<script>alert(1)</script>")
+
+	runDecoderTests(t, decompressGzip, []decoderTest{
+		{"gzip", compressed, "<script>alert(1)</script>", true},
+		{"truncated", compressed[:len(compressed)/2], "", false},
+		{"magic bytes only", "\x1f\x8b", "", false},
+		{"not gzip", "<script>", "", false},
+	})
+}
+
+func TestDecompressDeflate(t *testing.T) {
+	zlibCompressed := compress(t, func(w io.Writer) (io.WriteCloser, error) {
+		return zlib.NewWriter(w), nil
+	}, "union select password from users")
+	rawCompressed := compress(t, func(w io.Writer) (io.WriteCloser, error) {
+		return flate.NewWriter(w, flate.BestCompression)
+	}, "../../etc/passwd")
+
+	runDecoderTests(t, decompressDeflate, []decoderTest{
+		{"zlib", zlibCompressed, "union select password from users", true},
+		{"raw deflate", rawCompressed, "../../etc/passwd", true},
+		{"raw stored block", "\x01\x03\x00\xfc\xffabc", "abc", true},
+		{"raw binary output", "\x01\x03\x00\xfc\xff\x00\x01\x02", "", false},
+		{"raw invalid utf-8 output", "\x01\x02\x00\xfd\xff\xc0\xaf", "", false},
+		{"truncated", rawCompressed[:len(rawCompressed)/2], "", false},
+		{"too short", "a", "", false},
+		{"text", "hello world", "", false},
+	})
+}
+
+func TestDecodeValue(t *testing.T) {
+	tests := []struct {
+		name      string
+		value     string
+		encodings []string
+		expected  []decodedValue
+	}{
+		{"no encodings", "%3C", nil, []decodedValue{{Value: "%3C"}}},
+		{"url then base64", "PHNjcmlwdD4%3D", []string{"base64", "URL"}, []decodedValue{
+			{Value: "PHNjcmlwdD4%3D"},
+			{Value: "PHNjcmlwdD4=", Chain: []string{"url"}},
+			{Value: "<script>", Chain: []string{"url", "base64"}},
+		}},
+		{"unknown encoding", "%3C", []string{"rot13"}, []decodedValue{{Value: "%3C"}}},
+		{"maximum depth", "%2525253C", []string{"url"}, []decodedValue{
+			{Value: "%2525253C"},
+			{Value: "%25253C", Chain: []string{"url"}},
+			{Value: "%253C", Chain: []string{"url", "url"}},
+			{Value: "%3C", Chain: []string{"url", "url", "url"}},
+		}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			decoded := decodeValue(test.value, test.encodings)
+			if !reflect.DeepEqual(decoded, test.expected) {
+				t.Errorf("expected %v, got %v", test.expected, decoded)
+			}
+		})
+	}
+}
@@ -0,0 +1,263 @@
+package detection
+
+import (
+	"errors"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+
+	"github.com/lucacoratu/disertatie/agent/config"
+	"github.com/lucacoratu/disertatie/agent/data"
+	"gopkg.in/yaml.v2"
+)
+
+// Holds an exclusion which disables rules to remove the false positives
+// The exclusion applies to the rules selected by id or classification (all the rules if none is specified)
+// on the requests matching the paths and the source networks (all the requests if none is specified)
+// If parameters or headers are specified only them are not inspected by the rules, otherwise the rules are disabled
+type RuleExclusion struct {
+	Id              string   `yaml:"id"`                        //The id of the exclusion (added to the suppressed findings)
+	Description     string   `yaml:"description,omitempty"`     //Why the exclusion is needed
+	Rules           []string `yaml:"rules,omitempty"`           //The ids of the rules which are excluded
+	Classifications []string `yaml:"classifications,omitempty"` //The classifications of the rules which are excluded (case insensitive)
+	Paths           []string `yaml:"paths,omitempty"`           //The globs of the paths where the exclusion applies
+	PathRegexes     []string `yaml:"path-regex,omitempty"`      //The regexes of the paths where the exclusion applies
+	CIDRs           []string `yaml:"cidrs,omitempty"`           //The networks of the clients the exclusion applies to (10.0.0.0/8)
+	Parameters      []string `yaml:"parameters,omitempty"`      //The GET and POST parameters which are not inspected by the parameters matchers
+	Headers         []string `yaml:"headers,omitempty"`         //The headers which are not inspected by the headers matchers (case insensitive)
+}
+
+// Holds the content of the exclusions file
+type RuleExclusionsFile struct {
+	Exclusions []*RuleExclusion `yaml:"exclusions"` //The list of exclusions
+}
+
+// Checks if the exclusion removes only some values from the inspection instead of disabling the rules
+func (exclusion *RuleExclusion) hasTargets() bool {
+	return len(exclusion.Parameters) > 0 || len(exclusion.Headers) > 0
+}
+
+// Checks if the exclusion applies to the rule
+func (exclusion *RuleExclusion) selectsRule(rule Rule) bool {
+	if len(exclusion.Rules) == 0 && len(exclusion.Classifications) == 0 {
+		return true
+	}
+	for _, ruleId := range exclusion.Rules {
+		if ruleId == rule.Id {
+			return true
+		}
+	}
+	for _, classification := range exclusion.Classifications {
+		if rule.Info != nil && strings.EqualFold(classification, rule.Info.Classification) {
+			return true
+		}
+	}
+	return false
+}
+
+// Checks if the IP address is in one of the networks of the exclusion
+func (exclusion *RuleExclusion) containsIP(ip net.IP) bool {
+	if ip == nil {
+		return false
+	}
+	for _, cidr := range exclusion.CIDRs {
+		_, network, err := net.ParseCIDR(cidr)
+		if err != nil {
+			continue
+		}
+		if network.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// Checks if the exclusion is valid
+// @param exclusion - the exclusion
+// Returns an error if the exclusion is not valid
+func CheckRuleExclusion(exclusion *RuleExclusion) error {
+	if exclusion == nil {
+		return errors.New("exclusion cannot be empty")
+	}
+	if strings.TrimSpace(exclusion.Id) == "" {
+		return errors.New("exclusion should have an id")
+	}
+	for _, cidr := range exclusion.CIDRs {
+		if _, _, err := net.ParseCIDR(cidr); err != nil {
+			return errors.New("exclusion " + exclusion.Id + " cidr is not valid, " + cidr)
+		}
+	}
+	if err := checkPathPatterns(exclusion.Paths, exclusion.PathRegexes); err != nil {
+		return errors.New("exclusion " + exclusion.Id + " " + err.Error())
+	}
+	//An exclusion without rules, conditions and targets would disable all the rules for all the requests
+	if len(exclusion.Rules) == 0 && len(exclusion.Classifications) == 0 && len(exclusion.Paths) == 0 && len(exclusion.PathRegexes) == 0 && len(exclusion.CIDRs) == 0 && !exclusion.hasTargets() {
+		return errors.New("exclusion " + exclusion.Id + " would disable all the rules, it should have rules, classifications, paths, cidrs, parameters or headers")
+	}
+	return nil
+}
+
+// Loads the exclusions from the exclusions file
+// The unknown keys are rejected so a typo does not turn an exclusion into a broader one
+// @param path - the path of the exclusions file
+// Returns the list of exclusions or an error if the file cannot be read or an exclusion is not valid
+func LoadRuleExclusions(path string) ([]*RuleExclusion, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, errors.New("could not open the exclusions file, " + err.Error())
+	}
+	defer file.Close()
+
+	exclusionsFile := RuleExclusionsFile{}
+	d := yaml.NewDecoder(file)
+	d.SetStrict(true)
+	if err := d.Decode(&exclusionsFile); err != nil {
+		return nil, errors.New("could not parse the exclusions file, " + err.Error())
+	}
+
+	ids := make(map[string]bool)
+	for _, exclusion := range exclusionsFile.Exclusions {
+		if err := CheckRuleExclusion(exclusion); err != nil {
+			return nil, err
+		}
+		if ids[exclusion.Id] {
+			return nil, errors.New("exclusion id is used more than once, " + exclusion.Id)
+		}
+		ids[exclusion.Id] = true
+	}
+	return exclusionsFile.Exclusions, nil
+}
+
+// Loads the exclusions from the exclusions file specified in the configuration
+// @param configuration - the configuration of the agent
+// Returns the list of exclusions (empty if no exclusions file is specified) or an error if the file is not valid
+func LoadConfiguredExclusions(configuration config.Configuration) ([]*RuleExclusion, error) {
+	if configuration.ExclusionsFile == "" {
+		return make([]*RuleExclusion, 0), nil
+	}
+	return LoadRuleExclusions(configuration.ExclusionsFile)
+}
+
+// Gets the exclusions which apply to the rule on the request
+// @param rule - the rule
+// @param target - the information about the request (nil if it is not known, then only the exclusions without conditions apply)
+// Returns the list of exclusions
+func (index *RuleIndex) getRuleExclusions(rule Rule, target *requestTarget) []*RuleExclusion {
+	exclusions := make([]*RuleExclusion, 0)
+	for _, exclusion := range index.exclusions {
+		if !exclusion.selectsRule(rule) {
+			continue
+		}
+		if len(exclusion.Paths) > 0 || len(exclusion.PathRegexes) > 0 {
+			if target == nil || !index.matchesPath(target.Path, exclusion.Paths, exclusion.PathRegexes) {
+				continue
+			}
+		}
+		if len(exclusion.CIDRs) > 0 {
+			if target == nil || !exclusion.containsIP(target.RemoteIP) {
+				continue
+			}
+		}
+		exclusions = append(exclusions, exclusion)
+	}
+	return exclusions
+}
+
+// Removes the values from the parameters
+func withoutParameters(parameters url.Values, names []string) url.Values {
+	filtered := make(url.Values, len(parameters))
+	for name, values := range parameters {
+		if !containsString(names, name) {
+			filtered[name] = values
+		}
+	}
+	return filtered
+}
+
+// Removes the headers (case insensitive)
+func withoutHeaders(headers http.Header, names []string) http.Header {
+	filtered := make(http.Header, len(headers))
+	for name, values := range headers {
+		excluded := false
+		for _, excludedName := range names {
+			if strings.EqualFold(name, excludedName) {
+				excluded = true
+				break
+			}
+		}
+		if !excluded {
+			filtered[name] = values
+		}
+	}
+	return filtered
+}
+
+// Marks the findings as suppressed by the exclusions
+// @param findings - the findings of the rule
+// @param exclusions - the exclusions which suppressed the findings
+// Returns the findings
+func suppressFindings(findings []*data.RuleFindingData, exclusions []*RuleExclusion) []*data.RuleFindingData {
+	ids := make([]string, 0, len(exclusions))
+	for _, exclusion := range exclusions {
+		ids = append(ids, exclusion.Id)
+	}
+	for _, finding := range findings {
+		finding.ExclusionId = strings.Join(ids, ",")
+	}
+	return findings
+}
+
+// Applies the exclusions on the findings of a rule
+// If an exclusion disables the rule all the findings are suppressed
+// Otherwise the rule is evaluated again without the excluded parameters and headers and the findings are suppressed if it does not match anymore
+// @param rule - the rule which matched
+// @param target - the information about the request
+// @param findings - the findings of the rule
+// @param evaluateWithout - runs the rule again without the parameters and the headers
+// Returns the findings which are kept and the suppressed findings
+func (index *RuleIndex) applyExclusions(rule Rule, target *requestTarget, findings []*data.RuleFindingData, evaluateWithout func(parameters []string, headers []string) []*data.RuleFindingData) ([]*data.RuleFindingData, []*data.RuleFindingData) {
+	if len(findings) == 0 || len(index.exclusions) == 0 {
+		return findings, nil
+	}
+	exclusions := index.getRuleExclusions(rule, target)
+	if len(exclusions) == 0 {
+		return findings, nil
+	}
+
+	parameters := make([]string, 0)
+	headers := make([]string, 0)
+	targetExclusions := make([]*RuleExclusion, 0)
+	for _, exclusion := range exclusions {
+		if !exclusion.hasTargets() {
+			return nil, suppressFindings(findings, []*RuleExclusion{exclusion})
+		}
+		parameters = append(parameters, exclusion.Parameters...)
+		headers = append(headers, exclusion.Headers...)
+		targetExclusions = append(targetExclusions, exclusion)
+	}
+
+	remainingFindings := evaluateWithout(parameters, headers)
+	if len(remainingFindings) == 0 {
+		return nil, suppressFindings(findings, targetExclusions)
+	}
+	return remainingFindings, nil
+}
+
+// Sets the exclusions applied by the rule runners using the index
+// It should be called before the index is used
+func (index *RuleIndex) SetExclusions(exclusions []*RuleExclusion) {
+	index.exclusions = exclusions
+	//Precompile the path regexes of the exclusions
+	for _, exclusion := range exclusions {
+		for _, pattern := range getPathPatterns(exclusion.Paths, exclusion.PathRegexes) {
+			index.precompileRegex(pattern)
+		}
+	}
+}
+
+// Gets the exclusions applied by the rule runners using the index
+func (index *RuleIndex) GetExclusions() []*RuleExclusion {
+	return index.exclusions
+}
@@ -0,0 +1,528 @@
+package detection
+
+import (
+	"regexp"
+	"regexp/syntax"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/lucacoratu/disertatie/agent/logging"
+)
+
+// Holds the prefilter information for the rules of a phase
+type phaseIndex struct {
+	automaton      *ahoCorasick //The automaton containing the literals of the rules
+	patternRules   [][]int      //The rules (indexes in the list of rules) which contain each pattern of the automaton
+	alwaysEvaluate []int        //The rules which cannot be prefiltered and should always be evaluated
+	encodingSets   [][]string   //The distinct encoding lists of the matchers of the phase (the texts are decoded with each list, like the matchers do, before prefiltering)
+	encodedRules   []int        //The rules which decode the inspected data (selected when the decoding of the prefilter texts was truncated)
+}
+
+// The compiled form of the rules which is built once when the rules are loaded
+// Holds the rules, the precompiled regexes and an Aho-Corasick automaton for each phase which selects the candidate rules
+type RuleIndex struct {
+	rules      []Rule                    //The list of rules the index was built from
+	regexes    map[string]*regexp.Regexp //The precompiled regexes of all the rules
+	jsonPaths  map[string]*jsonPath      //The precompiled JSONPath selectors of all the rules
+	phases     map[string]*phaseIndex    //The prefilter for each phase (request, response, websocket)
+	prefilter  bool                      //If the candidate rules are selected using the automaton (false means all the rules are evaluated)
+	exclusions []*RuleExclusion          //The exclusions applied on the findings of the rules
+}
+
+// Creates the rule index from the list of rules
+// All the literals that must appear for a matcher to find something (match strings and literals extracted from regexes) are added in an Aho-Corasick automaton.
+// A rule is selected as candidate only if one of its literals appears in the inspected data, rules which have matchers without literals are always evaluated.
+// The rules are ordered by the precedence of their actions so the first blocking rule which matches has the action that is applied.
+// @param rules - the list of rules loaded
+// @param logger - the logger used to display the problems when compiling the rules
+// Returns the rule index
+func NewRuleIndex(rules []Rule, logger logging.ILogger) *RuleIndex {
+	//The rules with the highest precedence actions are evaluated first
+	rules = sortRulesByActionPrecedence(rules)
+	index := &RuleIndex{rules: rules, regexes: make(map[string]*regexp.Regexp), jsonPaths: make(map[string]*jsonPath), phases: make(map[string]*phaseIndex), prefilter: true}
+
+	//Precompile all the regexes of the rules
+	for _, rule := range rules {
+		for _, mode := range getRuleSearchModes(rule) {
+			if mode.Regex == "" {
+				continue
+			}
+			if err := index.precompileRegex(mode.Regex); err != nil {
+				logger.Error("Could not compile regex", mode.Regex, "from rule", rule.Id, err.Error())
+			}
+		}
+		//Precompile the path regexes of the scope
+		if rule.Scope != nil {
+			for _, pattern := range getPathPatterns(rule.Scope.Paths, rule.Scope.PathRegexes) {
+				if err := index.precompileRegex(pattern); err != nil {
+					logger.Error("Could not compile scope regex", pattern, "from rule", rule.Id, err.Error())
+				}
+			}
+		}
+	}
+
+	//Precompile all the JSONPath selectors of the rules
+	for _, rule := range rules {
+		for _, expression := range getRuleJSONPaths(rule) {
+			if _, found := index.jsonPaths[expression]; found {
+				continue
+			}
+			compiledPath, err := compileJSONPath(expression)
+			if err != nil {
+				logger.Error("Could not compile JSONPath", expression, "from rule", rule.Id, err.Error())
+				continue
+			}
+			index.jsonPaths[expression] = compiledPath
+		}
+	}
+
+	//Build the prefilter of each phase
+	for _, phase := range []string{RequestPhase, ResponsePhase, WebsocketPhase} {
+		index.phases[phase] = newPhaseIndex(rules, phase)
+	}
+
+	return index
+}
+
+// Creates a rule index which does not prefilter the rules and does not precompile the regexes
+// Every rule is evaluated on every request (used to compare the performance with the compiled index)
+// @param rules - the list of rules loaded
+// Returns the rule index
+func NewLinearRuleIndex(rules []Rule) *RuleIndex {
+	return &RuleIndex{rules: sortRulesByActionPrecedence(rules), regexes: make(map[string]*regexp.Regexp), jsonPaths: make(map[string]*jsonPath), phases: make(map[string]*phaseIndex), prefilter: false}
+}
+
+// Gets the list of rules the index was built from
+func (index *RuleIndex) GetRules() []Rule {
+	return index.rules
+}
+
+// Compiles the regex and adds it to the precompiled regexes
+// Returns an error if the regex cannot be compiled
+func (index *RuleIndex) precompileRegex(pattern string) error {
+	if _, found := index.regexes[pattern]; found {
+		return nil
+	}
+	compiledRegex, err := regexp.Compile(pattern)
+	if err != nil {
+		return err
+	}
+	index.regexes[pattern] = compiledRegex
+	return nil
+}
+
+// Gets the precompiled regex
+// If the regex was not precompiled it is compiled now
+// @param pattern - the regex pattern
+// Returns the compiled regex or an error if the regex cannot be compiled
+func (index *RuleIndex) getRegex(pattern string) (*regexp.Regexp, error) {
+	if compiledRegex, found := index.regexes[pattern]; found {
+		return compiledRegex, nil
+	}
+	return regexp.Compile(pattern)
+}
+
+// Gets the precompiled JSONPath selector
+// If the selector was not precompiled it is compiled now
+// @param expression - the JSONPath expression
+// Returns the compiled selector or an error if the expression is not valid
+func (index *RuleIndex) getJSONPath(expression string) (*jsonPath, error) {
+	if compiledPath, found := index.jsonPaths[expression]; found {
+		return compiledPath, nil
+	}
+	return compileJSONPath(expression)
+}
+
+// Gets the encoding lists which should be used to decode the inspected data before prefiltering a phase
+func (index *RuleIndex) getPhaseEncodingSets(phase string) [][]string {
+	phaseIdx, found := index.phases[phase]
+	if !found {
+		return nil
+	}
+	return phaseIdx.encodingSets
+}
+
+// Selects the rules which should be evaluated on the inspected data
+// @param phase - the phase (request, response, websocket)
+// @param texts - the inspected values (already decoded and lowercased)
+// @param truncated - if the decoding of the inspected values was truncated (the rules with encodings are selected because their literals may be in the values which were not decoded)
+// Returns the indexes of the candidate rules in ascending order
+func (index *RuleIndex) getCandidateRules(phase string, texts []string, truncated bool) []int {
+	phaseIdx, found := index.phases[phase]
+	//If the prefilter is disabled then all the rules are candidates
+	if !index.prefilter || !found {
+		candidates := make([]int, len(index.rules))
+		for i := range index.rules {
+			candidates[i] = i
+		}
+		return candidates
+	}
+
+	selected := make([]bool, len(index.rules))
+	for _, ruleIndex := range phaseIdx.alwaysEvaluate {
+		selected[ruleIndex] = true
+	}
+	if truncated {
+		for _, ruleIndex := range phaseIdx.encodedRules {
+			selected[ruleIndex] = true
+		}
+	}
+
+	//Find all the literals in the inspected data
+	if phaseIdx.automaton != nil {
+		foundPatterns := make([]bool, phaseIdx.automaton.numberPattern)
+		for _, text := range texts {
+			phaseIdx.automaton.match(text, foundPatterns)
+		}
+		for patternId, patternFound := range foundPatterns {
+			if !patternFound {
+				continue
+			}
+			for _, ruleIndex := range phaseIdx.patternRules[patternId] {
+				selected[ruleIndex] = true
+			}
+		}
+	}
+
+	candidates := make([]int, 0)
+	for ruleIndex, isSelected := range selected {
+		if isSelected {
+			candidates = append(candidates, ruleIndex)
+		}
+	}
+	return candidates
+}
+
+// Builds the prefilter for the rules of a phase
+// @param rules - the list of rules
+// @param phase - the phase (request, response, websocket)
+// Returns the prefilter of the phase
+func newPhaseIndex(rules []Rule, phase string) *phaseIndex {
+	phaseIdx := &phaseIndex{alwaysEvaluate: make([]int, 0), encodingSets: make([][]string, 0), encodedRules: make([]int, 0)}
+	encodingSetKeys := make(map[string]bool)
+	patterns := make([]string, 0)
+	patternIds := make(map[string]int)
+	patternRules := make([][]int, 0)
+
+	for ruleIndex, rule := range rules {
+		literals, hasLiterals := getPhaseLiterals(rule, phase)
+		//The rule does not have matchers for this phase
+		if !hasLiterals && literals == nil {
+			continue
+		}
+
+		//Gather the encoding lists used by the matchers of the rule
+		//The order of the encodings is kept because it decides which decoded values are dropped when the decoding is truncated
+		encodingSets := getPhaseEncodingSets(rule, phase)
+		for _, encodings := range encodingSets {
+			key := strings.ToLower(strings.Join(encodings, ","))
+			if !encodingSetKeys[key] {
+				encodingSetKeys[key] = true
+				phaseIdx.encodingSets = append(phaseIdx.encodingSets, encodings)
+			}
+		}
+		if len(encodingSets) > 0 {
+			phaseIdx.encodedRules = append(phaseIdx.encodedRules, ruleIndex)
+		}
+
+		//The rule should always be evaluated if the condition contains negations or a matcher has no literal
+		condition := rule.getPhaseCondition(phase)
+		if !hasLiterals || (condition != nil && condition.hasNegation()) {
+			phaseIdx.alwaysEvaluate = append(phaseIdx.alwaysEvaluate, ruleIndex)
+			continue
+		}
+
+		//Add the literals in the automaton
+		for _, literal := range literals {
+			patternId, found := patternIds[literal]
+			if !found {
+				patternId = len(patterns)
+				patternIds[literal] = patternId
+				patterns = append(patterns, literal)
+				patternRules = append(patternRules, make([]int, 0))
+			}
+			patternRules[patternId] = append(patternRules[patternId], ruleIndex)
+		}
+	}
+
+	if len(patterns) > 0 {
+		phaseIdx.automaton = newAhoCorasick(patterns)
+	}
+	phaseIdx.patternRules = patternRules
+	return phaseIdx
+}
+
+// Gets the literals of the rule matchers for a phase
+// @param rule - the rule
+// @param phase - the phase (request, response, websocket)
+// Returns the list of literals (nil if the rule has no matchers for the phase) and false if any of the matchers can match without a literal
+func getPhaseLiterals(rule Rule, phase string) ([]string, bool) {
+	var modes []*RuleSearchMode
+	var hasHashMatcher bool = false
+
+	switch phase {
+	case RequestPhase:
+		if rule.Request == nil {
+			return nil, false
+		}
+		modes = getRequestSearchModes(rule.Request)
+		for _, bodyRule := range rule.Request.Body {
+			if bodyRule.MD5Sum != "" || bodyRule.SHA256Sum != "" {
+				hasHashMatcher = true
+			}
+		}
+		//The files matchers can match on the size or the hashes of the files
+		if len(rule.Request.Files) > 0 {
+			hasHashMatcher = true
+		}
+	case ResponsePhase:
+		if rule.Response == nil {
+			return nil, false
+		}
+		modes = getResponseSearchModes(rule.Response)
+		for _, bodyRule := range rule.Response.Body {
+			if bodyRule.MD5Sum != "" || bodyRule.SHA256Sum != "" {
+				hasHashMatcher = true
+			}
+		}
+		//The latency and the size matchers do not have literals in the response
+		if rule.Response.Latency != nil || rule.Response.Size != nil {
+			hasHashMatcher = true
+		}
+	case WebsocketPhase:
+		if rule.Websocket == nil {
+			return nil, false
+		}
+		modes = getWebsocketSearchModes(rule.Websocket)
+	}
+
+	//The hashes cannot be prefiltered
+	literals := make([]string, 0)
+	if hasHashMatcher {
+		return literals, false
+	}
+
+	for _, mode := range modes {
+		if mode.Match != "" {
+			literals = append(literals, strings.ToLower(mode.Match))
+		}
+		if mode.Regex != "" {
+			regexLiterals := getRegexRequiredLiterals(mode.Regex)
+			//The regex can match without a literal so the rule cannot be prefiltered
+			if len(regexLiterals) == 0 {
+				return literals, false
+			}
+			literals = append(literals, regexLiterals...)
+		}
+	}
+
+	return literals, true
+}
+
+// Gets all the search modes of the request matchers
+func getRequestSearchModes(request *RequestRule) []*RuleSearchMode {
+	modes := make([]*RuleSearchMode, 0)
+	if request.Method != nil {
+		modes = append(modes, request.Method)
+	}
+	modes = append(modes, request.URL...)
+	for _, headerRule := range request.Headers {
+		modes = append(modes, &RuleSearchMode{Match: headerRule.Match, Regex: headerRule.Regex, Encodings: headerRule.Encodings})
+	}
+	for _, parameterRule := range request.Parameters {
+		modes = append(modes, &RuleSearchMode{Match: parameterRule.Match, Regex: parameterRule.Regex, Encodings: parameterRule.Encodings})
+	}
+	for _, bodyRule := range request.Body {
+		modes = append(modes, &RuleSearchMode{Match: bodyRule.Match, Regex: bodyRule.Regex, Encodings: bodyRule.Encodings})
+	}
+	for _, cookieRule := range request.Cookies {
+		modes = append(modes, &RuleSearchMode{Match: cookieRule.Match, Regex: cookieRule.Regex, Encodings: cookieRule.Encodings})
+	}
+	modes = append(modes, getFilesSearchModes(request.Files)...)
+	modes = append(modes, getDocumentSearchModes(request.JSON, request.XML)...)
+	return modes
+}
+
+// Gets all the search modes of the response matchers
+func getResponseSearchModes(response *ResponseRule) []*RuleSearchMode {
+	modes := make([]*RuleSearchMode, 0)
+	if response.Code != nil {
+		modes = append(modes, response.Code)
+	}
+	for _, headerRule := range response.Headers {
+		modes = append(modes, &RuleSearchMode{Match: headerRule.Match, Regex: headerRule.Regex, Encodings: headerRule.Encodings})
+	}
+	for _, bodyRule := range response.Body {
+		modes = append(modes, &RuleSearchMode{Match: bodyRule.Match, Regex: bodyRule.Regex, Encodings: bodyRule.Encodings})
+	}
+	modes = append(modes, getDocumentSearchModes(response.JSON, response.XML)...)
+	return modes
+}
+
+// Gets the search modes of the JSON and XML matchers
+func getDocumentSearchModes(jsonRules []*JSONPathRule, xmlRules []*XMLPathRule) []*RuleSearchMode {
+	modes := make([]*RuleSearchMode, 0)
+	for _, jsonRule := range jsonRules {
+		modes = append(modes, &RuleSearchMode{Match: jsonRule.Match, Regex: jsonRule.Regex, Encodings: jsonRule.Encodings})
+	}
+	for _, xmlRule := range xmlRules {
+		modes = append(modes, &RuleSearchMode{Match: xmlRule.Match, Regex: xmlRule.Regex, Encodings: xmlRule.Encodings})
+	}
+	return modes
+}
+
+// Gets all the JSONPath selectors of the rule (for the request, the response and the websocket messages)
+func getRuleJSONPaths(rule Rule) []string {
+	expressions := make([]string, 0)
+	if rule.Request != nil {
+		for _, jsonRule := range rule.Request.JSON {
+			expressions = append(expressions, jsonRule.Path)
+		}
+	}
+	if rule.Response != nil {
+		for _, jsonRule := range rule.Response.JSON {
+			expressions = append(expressions, jsonRule.Path)
+		}
+	}
+	for _, wsRule := range rule.Websocket {
+		if wsRule.JSONPath != "" {
+			expressions = append(expressions, wsRule.JSONPath)
+		}
+	}
+	return expressions
+}
+
+// Gets all the search modes of the rule (for all the phases)
+func getRuleSearchModes(rule Rule) []*RuleSearchMode {
+	modes := make([]*RuleSearchMode, 0)
+	if rule.Request != nil {
+		modes = append(modes, getRequestSearchModes(rule.Request)...)
+	}
+	if rule.Response != nil {
+		modes = append(modes, getResponseSearchModes(rule.Response)...)
+	}
+	modes = append(modes, getWebsocketSearchModes(rule.Websocket)...)
+	return modes
+}
+
+// Gets the encoding lists of the matchers of a phase (the matchers without encodings are skipped)
+func getPhaseEncodingSets(rule Rule, phase string) [][]string {
+	var modes []*RuleSearchMode
+	switch phase {
+	case RequestPhase:
+		if rule.Request != nil {
+			modes = getRequestSearchModes(rule.Request)
+		}
+	case ResponsePhase:
+		if rule.Response != nil {
+			modes = getResponseSearchModes(rule.Response)
+		}
+	case WebsocketPhase:
+		modes = getWebsocketSearchModes(rule.Websocket)
+	}
+	encodingSets := make([][]string, 0)
+	for _, mode := range modes {
+		if len(mode.Encodings) > 0 {
+			encodingSets = append(encodingSets, mode.Encodings)
+		}
+	}
+	return encodingSets
+}
+
+// Checks if the condition tree contains a negation
+func (rc *RuleCondition) hasNegation() bool {
+	if rc.Not != nil {
+		return true
+	}
+	for _, subCondition := range rc.And {
+		if subCondition.hasNegation() {
+			return true
+		}
+	}
+	for _, subCondition := range rc.Or {
+		if subCondition.hasNegation() {
+			return true
+		}
+	}
+	return false
+}
+
+// Gets the literals one of which must appear in any string matched by the regex (lowercased)
+// The alternations (the pattern lists) have a literal for every alternative
+// @param pattern - the regex
+// Returns the literals or nil if the regex can match without a literal
+func getRegexRequiredLiterals(pattern string) []string {
+	parsedRegex, err := syntax.Parse(pattern, syntax.Perl)
+	if err != nil {
+		return nil
+	}
+	literals := requiredLiterals(parsedRegex.Simplify())
+	for i := range literals {
+		literals[i] = strings.ToLower(literals[i])
+	}
+	return literals
+}
+
+// Gets the length of the shortest literal (the literals with the shortest one are the most selective)
+func shortestLiteral(literals []string) int {
+	shortest := -1
+	for _, literal := range literals {
+		if shortest == -1 || len(literal) < shortest {
+			shortest = len(literal)
+		}
+	}
+	return shortest
+}
+
+// Walks the regex syntax tree to find the literals one of which must appear in every match
+func requiredLiterals(re *syntax.Regexp) []string {
+	switch re.Op {
+	case syntax.OpLiteral:
+		//The literals are searched in lowercased text so the case folding is not a problem
+		literal := string(re.Rune)
+		if !utf8.ValidString(literal) {
+			return nil
+		}
+		return []string{literal}
+	case syntax.OpCapture, syntax.OpPlus:
+		return requiredLiterals(re.Sub[0])
+	case syntax.OpRepeat:
+		if re.Min >= 1 {
+			return requiredLiterals(re.Sub[0])
+		}
+		return nil
+	case syntax.OpConcat:
+		//Keep the subexpression whose shortest literal is the longest
+		var best []string = nil
+		for _, sub := range re.Sub {
+			literals := requiredLiterals(sub)
+			if len(literals) > 0 && (best == nil || shortestLiteral(literals) > shortestLiteral(best)) {
+				best = literals
+			}
+		}
+		return best
+	case syntax.OpAlternate:
+		//Every alternative should have a literal
+		all := make([]string, 0)
+		for _, sub := range re.Sub {
+			literals := requiredLiterals(sub)
+			if len(literals) == 0 {
+				return nil
+			}
+			all = append(all, literals...)
+		}
+		return all
+	default:
+		return nil
+	}
+}
+
+// Checks if the string is in the list
+func containsString(list []string, value string) bool {
+	for _, elem := range list {
+		if elem == value {
+			return true
+		}
+	}
+	return false
+}
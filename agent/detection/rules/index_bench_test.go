@@ -0,0 +1,100 @@
+package detection
+
+import (
+	"encoding/csv"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/lucacoratu/disertatie/agent/config"
+	"github.com/lucacoratu/disertatie/agent/logging"
+)
+
+// Reads the payloads from the CSV files in testdata/datasets (the first line of every file is the header)
+func readBenchmarkPayloads(b *testing.B) []string {
+	filePaths, err := filepath.Glob(filepath.Join("testdata", "datasets", "*.csv"))
+	if err != nil || len(filePaths) == 0 {
+		b.Fatal("no payload datasets found in testdata/datasets")
+	}
+
+	payloads := make([]string, 0)
+	for _, filePath := range filePaths {
+		file, err := os.Open(filePath)
+		if err != nil {
+			b.Fatal("could not open the dataset", filePath, err)
+		}
+		reader := csv.NewReader(file)
+		reader.FieldsPerRecord = -1
+		var header bool = true
+		for {
+			record, err := reader.Read()
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				file.Close()
+				b.Fatal("could not read the dataset", filePath, err)
+			}
+			if header {
+				header = false
+				continue
+			}
+			if len(record) > 0 && record[0] != "" {
+				payloads = append(payloads, record[0])
+			}
+		}
+		file.Close()
+	}
+	return payloads
+}
+
+// Creates the requests replayed in the benchmarks, each payload is sent as a query parameter and as a form parameter
+func createBenchmarkRequests(payloads []string) []*http.Request {
+	requests := make([]*http.Request, 0, 2*len(payloads))
+	for _, payload := range payloads {
+		getRequest, err := http.NewRequest(http.MethodGet, "http://localhost/search?q="+url.QueryEscape(payload), http.NoBody)
+		if err == nil {
+			getRequest.Header.Set("User-Agent", "Mozilla/5.0")
+			requests = append(requests, getRequest)
+		}
+		postRequest, err := http.NewRequest(http.MethodPost, "http://localhost/login", strings.NewReader("user="+url.QueryEscape(payload)))
+		if err == nil {
+			postRequest.Header.Set("User-Agent", "Mozilla/5.0")
+			postRequest.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+			requests = append(requests, postRequest)
+		}
+	}
+	return requests
+}
+
+// Replays the payloads through the rule runner using the index created from the rules of the agent
+func benchmarkRuleIndex(b *testing.B, createIndex func(rules []Rule, logger logging.ILogger) *RuleIndex) {
+	logger := logging.NewDefaultLogger()
+	allRules, err := LoadRulesFromDirectory(config.Configuration{RulesDirectory: filepath.Join("..", "..", "rules")}, logger)
+	if err != nil {
+		b.Fatal("could not load the rules", err)
+	}
+	requests := createBenchmarkRequests(readBenchmarkPayloads(b))
+	ruleRunner := NewRuleRunner(logger, createIndex(allRules, logger), nil, config.Configuration{})
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		ruleRunner.RunRulesOnRequest(requests[i%len(requests)])
+	}
+}
+
+// Measures the latency per request of the compiled rule index (Aho-Corasick prefilter and precompiled regexes)
+func BenchmarkRuleIndex(b *testing.B) {
+	benchmarkRuleIndex(b, NewRuleIndex)
+}
+
+// Measures the latency per request of the linear evaluation of all the rules
+func BenchmarkLinearScan(b *testing.B) {
+	benchmarkRuleIndex(b, func(rules []Rule, logger logging.ILogger) *RuleIndex {
+		return NewLinearRuleIndex(rules)
+	})
+}
@@ -0,0 +1,80 @@
+package detection
+
+import (
+	"encoding/base64"
+	"testing"
+
+	"github.com/lucacoratu/disertatie/agent/config"
+	"github.com/lucacoratu/disertatie/agent/logging"
+)
+
+// Creates a rule which searches the match in all the parameters decoded with the encodings
+func newParameterRule(id string, match string, encodings []string) Rule {
+	return Rule{Id: id, Request: &RequestRule{Parameters: []*RequestParametersRule{{Name: "any", Match: match, Encodings: encodings}}}}
+}
+
+// Checks if the rule is in the list of candidates
+func isCandidate(index *RuleIndex, candidates []int, id string) bool {
+	for _, candidate := range candidates {
+		if index.rules[candidate].Id == id {
+			return true
+		}
+	}
+	return false
+}
+
+func TestPrefilterDecodesWithEveryEncodingList(t *testing.T) {
+	index := NewRuleIndex([]Rule{
+		newParameterRule("plain", "select", nil),
+		newParameterRule("base64", "union", []string{"base64"}),
+		newParameterRule("url-hex", "sleep", []string{"url", "hex"}),
+	}, logging.NewDefaultLogger())
+	runner := NewRuleRunner(logging.NewDefaultLogger(), index, nil, config.Configuration{})
+
+	tests := []struct {
+		name     string
+		value    string
+		expected []string
+	}{
+		{"raw value", "1 SELECT 2", []string{"plain"}},
+		{"base64 value", base64.StdEncoding.EncodeToString([]byte("1 union 2")), []string{"base64"}},
+		{"url encoded hex value", "%37%33%36%63%36%35%36%35%37%30", []string{"url-hex"}},
+		{"no literal", "hello", nil},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			texts, truncated := runner.getPrefilterTexts(RequestPhase, []string{test.value})
+			if truncated {
+				t.Fatalf("the decoding of %q should not be truncated", test.value)
+			}
+			candidates := index.getCandidateRules(RequestPhase, texts, truncated)
+			if len(candidates) != len(test.expected) {
+				t.Fatalf("expected %d candidates, got %d", len(test.expected), len(candidates))
+			}
+			for _, id := range test.expected {
+				if !isCandidate(index, candidates, id) {
+					t.Errorf("rule %s should be a candidate for %q", id, test.value)
+				}
+			}
+		})
+	}
+}
+
+func TestPrefilterTruncationSelectsEncodedRules(t *testing.T) {
+	index := NewRuleIndex([]Rule{
+		newParameterRule("plain", "select", nil),
+		newParameterRule("base64", "union", []string{"base64"}),
+	}, logging.NewDefaultLogger())
+
+	candidates := index.getCandidateRules(RequestPhase, []string{"hello"}, false)
+	if len(candidates) != 0 {
+		t.Errorf("expected no candidates without truncation, got %d", len(candidates))
+	}
+	candidates = index.getCandidateRules(RequestPhase, []string{"hello"}, true)
+	if !isCandidate(index, candidates, "base64") {
+		t.Error("the rule with encodings should be a candidate when the decoding was truncated")
+	}
+	if isCandidate(index, candidates, "plain") {
+		t.Error("the rule without encodings should not be a candidate when its literal is missing")
+	}
+}
@@ -0,0 +1,328 @@
+package detection
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// The kinds of steps of a JSONPath selector
+const (
+	jsonPathChild    = iota //Selects a member of an object (.name or ['name'])
+	jsonPathIndex           //Selects an element of an array ([0], [-1])
+	jsonPathWildcard        //Selects all the members of an object or the elements of an array (.* or [*])
+)
+
+// Holds a step of the JSONPath selector
+type jsonPathStep struct {
+	kind      int    //The kind of the step
+	name      string //The name of the member (for child steps)
+	index     int    //The index of the element (for index steps)
+	recursive bool   //If the step is applied on all the descendants (..)
+}
+
+// Holds a compiled JSONPath selector
+type jsonPath struct {
+	expression string         //The JSONPath expression as specified in the rule
+	steps      []jsonPathStep //The steps of the selector
+}
+
+// Holds a value selected from the JSON document and the path of the value
+type selectedValue struct {
+	Path  string //The exact path of the value in the document ($.filter.name, /users/user[2]/@id)
+	Value string //The value as string
+}
+
+// Compiles a JSONPath expression
+// The supported syntax is $, .name, ['name'], [index], .*, [*] and the recursive descent (..)
+// @param expression - the JSONPath expression
+// Returns the compiled selector or an error if the expression is not valid
+func compileJSONPath(expression string) (*jsonPath, error) {
+	expression = strings.TrimSpace(expression)
+	if !strings.HasPrefix(expression, "$") {
+		return nil, errors.New("the JSONPath should start with $, " + expression)
+	}
+
+	path := &jsonPath{expression: expression, steps: make([]jsonPathStep, 0)}
+	i := 1
+	for i < len(expression) {
+		recursive := false
+		switch {
+		case strings.HasPrefix(expression[i:], ".."):
+			recursive = true
+			i += 2
+		case expression[i] == '.':
+			i++
+		case expression[i] == '[':
+		default:
+			return nil, errors.New("unexpected character in JSONPath at position " + strconv.Itoa(i) + ", " + expression)
+		}
+
+		if i >= len(expression) {
+			return nil, errors.New("the JSONPath cannot end with a dot, " + expression)
+		}
+
+		//Parse the bracket notation
+		if expression[i] == '[' {
+			end := strings.IndexByte(expression[i:], ']')
+			if end == -1 {
+				return nil, errors.New("unclosed bracket in JSONPath, " + expression)
+			}
+			content := strings.TrimSpace(expression[i+1 : i+end])
+			i += end + 1
+			switch {
+			case content == "*":
+				path.steps = append(path.steps, jsonPathStep{kind: jsonPathWildcard, recursive: recursive})
+			case len(content) >= 2 && (content[0] == '\'' || content[0] == '"') && content[len(content)-1] == content[0]:
+				path.steps = append(path.steps, jsonPathStep{kind: jsonPathChild, name: content[1 : len(content)-1], recursive: recursive})
+			default:
+				index, err := strconv.Atoi(content)
+				if err != nil {
+					return nil, errors.New("invalid index in JSONPath, " + content)
+				}
+				path.steps = append(path.steps, jsonPathStep{kind: jsonPathIndex, index: index, recursive: recursive})
+			}
+			continue
+		}
+
+		//Parse the dot notation
+		end := strings.IndexAny(expression[i:], ".[")
+		if end == -1 {
+			end = len(expression) - i
+		}
+		name := expression[i : i+end]
+		i += end
+		if name == "" {
+			return nil, errors.New("empty member name in JSONPath, " + expression)
+		}
+		if name == "*" {
+			path.steps = append(path.steps, jsonPathStep{kind: jsonPathWildcard, recursive: recursive})
+		} else {
+			path.steps = append(path.steps, jsonPathStep{kind: jsonPathChild, name: name, recursive: recursive})
+		}
+	}
+
+	return path, nil
+}
+
+// Parses the JSON document (the numbers are kept as they are written)
+// Returns the document or an error if the data is not valid JSON
+func parseJSONDocument(data []byte) (interface{}, error) {
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+	var document interface{}
+	err := decoder.Decode(&document)
+	return document, err
+}
+
+// Holds a node of the JSON document during the selection
+type jsonNode struct {
+	path  string
+	value interface{}
+}
+
+// Formats the path of an object member
+func jsonMemberPath(parentPath string, name string) string {
+	isIdentifier := name != ""
+	for _, c := range name {
+		if !(c == '_' || c == '-' || (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z') || (c >= '0' && c <= '9')) {
+			isIdentifier = false
+			break
+		}
+	}
+	if isIdentifier {
+		return parentPath + "." + name
+	}
+	return parentPath + "['" + strings.ReplaceAll(name, "'", "\\'") + "']"
+}
+
+// Gets the children of the node (object members are sorted by name so the result is deterministic)
+func (node jsonNode) children() []jsonNode {
+	children := make([]jsonNode, 0)
+	switch value := node.value.(type) {
+	case map[string]interface{}:
+		names := make([]string, 0, len(value))
+		for name := range value {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			children = append(children, jsonNode{path: jsonMemberPath(node.path, name), value: value[name]})
+		}
+	case []interface{}:
+		for index, element := range value {
+			children = append(children, jsonNode{path: node.path + "[" + strconv.Itoa(index) + "]", value: element})
+		}
+	}
+	return children
+}
+
+// Gets the node and all its descendants
+func (node jsonNode) descendants() []jsonNode {
+	nodes := []jsonNode{node}
+	for _, child := range node.children() {
+		nodes = append(nodes, child.descendants()...)
+	}
+	return nodes
+}
+
+// Applies the step on the node
+func (step jsonPathStep) apply(node jsonNode) []jsonNode {
+	switch step.kind {
+	case jsonPathChild:
+		if object, ok := node.value.(map[string]interface{}); ok {
+			if value, found := object[step.name]; found {
+				return []jsonNode{{path: jsonMemberPath(node.path, step.name), value: value}}
+			}
+		}
+	case jsonPathIndex:
+		if array, ok := node.value.([]interface{}); ok {
+			index := step.index
+			if index < 0 {
+				index += len(array)
+			}
+			if index >= 0 && index < len(array) {
+				return []jsonNode{{path: node.path + "[" + strconv.Itoa(index) + "]", value: array[index]}}
+			}
+		}
+	case jsonPathWildcard:
+		return node.children()
+	}
+	return nil
+}
+
+// Converts the scalar value to string
+func jsonScalarToString(value interface{}) string {
+	switch v := value.(type) {
+	case string:
+		return v
+	case json.Number:
+		return v.String()
+	case bool:
+		return strconv.FormatBool(v)
+	case nil:
+		return "null"
+	}
+	return ""
+}
+
+// Collects all the scalar values from the node (the node itself if it is a scalar)
+func (node jsonNode) scalars() []selectedValue {
+	switch node.value.(type) {
+	case map[string]interface{}, []interface{}:
+		values := make([]selectedValue, 0)
+		for _, child := range node.children() {
+			values = append(values, child.scalars()...)
+		}
+		return values
+	}
+	return []selectedValue{{Path: node.path, Value: jsonScalarToString(node.value)}}
+}
+
+// Selects the values from the JSON document
+// If the selector matches an object or an array all the scalar values inside it are returned
+// @param document - the parsed JSON document
+// Returns the selected scalar values with their exact paths
+func (path *jsonPath) selectValues(document interface{}) []selectedValue {
+	nodes := []jsonNode{{path: "$", value: document}}
+	for _, step := range path.steps {
+		selected := make([]jsonNode, 0)
+		for _, node := range nodes {
+			candidates := []jsonNode{node}
+			if step.recursive {
+				candidates = node.descendants()
+			}
+			for _, candidate := range candidates {
+				selected = append(selected, step.apply(candidate)...)
+			}
+		}
+		nodes = selected
+	}
+
+	//The recursive descent can select the same value more than once
+	values := make([]selectedValue, 0)
+	seenPaths := make(map[string]bool)
+	for _, node := range nodes {
+		for _, value := range node.scalars() {
+			if !seenPaths[value.Path] {
+				seenPaths[value.Path] = true
+				values = append(values, value)
+			}
+		}
+	}
+	return values
+}
+
+// Holds an object or an array of the JSON document while the spans of the values are computed
+type jsonSpanFrame struct {
+	path      string //The path of the object or the array
+	array     bool   //If the container is an array
+	index     int    //The index of the next element of the array
+	key       string //The name of the current member of the object
+	expectKey bool   //If the next token of the object is the name of a member
+}
+
+// Gets the spans of the scalar values of the JSON document in the raw data (used to locate the matches)
+// @param data - the raw JSON document
+// Returns the offsets of the values by their exact path (the quotes of the strings are not included)
+func getJSONValueSpans(data []byte) map[string][2]int {
+	spans := make(map[string][2]int)
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	stack := make([]*jsonSpanFrame, 0)
+
+	//Gets the path of the next value of the current container
+	nextPath := func() string {
+		if len(stack) == 0 {
+			return "$"
+		}
+		top := stack[len(stack)-1]
+		if top.array {
+			top.index++
+			return top.path + "[" + strconv.Itoa(top.index-1) + "]"
+		}
+		return jsonMemberPath(top.path, top.key)
+	}
+	//The object expects the name of the next member after a value
+	valueDone := func() {
+		if len(stack) > 0 && !stack[len(stack)-1].array {
+			stack[len(stack)-1].expectKey = true
+		}
+	}
+
+	for {
+		start := int(decoder.InputOffset())
+		token, err := decoder.Token()
+		if err != nil {
+			return spans
+		}
+		end := int(decoder.InputOffset())
+		//Skip the separators read before the token
+		for start < end && strings.IndexByte(" \t\r\n:,", data[start]) != -1 {
+			start++
+		}
+
+		switch value := token.(type) {
+		case json.Delim:
+			if value == '{' || value == '[' {
+				stack = append(stack, &jsonSpanFrame{path: nextPath(), array: value == '[', expectKey: value == '{'})
+				continue
+			}
+			stack = stack[:len(stack)-1]
+			valueDone()
+		default:
+			if len(stack) > 0 && stack[len(stack)-1].expectKey {
+				stack[len(stack)-1].key, _ = value.(string)
+				stack[len(stack)-1].expectKey = false
+				continue
+			}
+			if _, isString := value.(string); isString && end-start >= 2 {
+				start, end = start+1, end-1
+			}
+			spans[nextPath()] = [2]int{start, end}
+			valueDone()
+		}
+	}
+}
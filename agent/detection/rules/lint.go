@@ -0,0 +1,310 @@
+package detection
+
+import (
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"regexp/syntax"
+	"sort"
+	"strings"
+
+	"github.com/lucacoratu/disertatie/agent/config"
+	"github.com/lucacoratu/disertatie/agent/logging"
+)
+
+// The severities of the lint issues
+const (
+	LintError   = "error"   //The rule is rejected in strict mode
+	LintWarning = "warning" //The rule is loaded but it should be fixed
+)
+
+// Holds a problem found by the linter in a rule file
+type LintIssue struct {
+	File     string `json:"file"`             //The path of the rule file
+	RuleId   string `json:"ruleId,omitempty"` //The id of the rule (empty if the file could not be parsed)
+	Severity string `json:"severity"`         //The severity of the issue (error or warning)
+	Message  string `json:"message"`          //The description of the issue
+}
+
+// Holds a matcher of the rule as seen by the linter
+type lintMatcher struct {
+	reference string   //The reference of the matcher (request.params[0], websocket[1])
+	regexes   []string //The regexes of the matcher
+	empty     bool     //If the matcher does not have anything to match
+}
+
+// Checks if the search mode does not have anything to match
+func isEmptySearchMode(mode *RuleSearchMode) bool {
+	return mode == nil || (mode.Match == "" && mode.MatchList == "" && mode.Regex == "")
+}
+
+// Gets all the matchers of the rule with their references
+func getLintMatchers(rule Rule) []lintMatcher {
+	matchers := make([]lintMatcher, 0)
+	if rule.Request != nil {
+		if rule.Request.Method != nil {
+			matchers = append(matchers, lintMatcher{reference: "request.method", regexes: []string{rule.Request.Method.Regex}, empty: isEmptySearchMode(rule.Request.Method)})
+		}
+		for i, urlRule := range rule.Request.URL {
+			matchers = append(matchers, lintMatcher{reference: indexedReference("request.url", i), regexes: []string{urlRule.Regex}, empty: isEmptySearchMode(urlRule)})
+		}
+		for i, headerRule := range rule.Request.Headers {
+			matchers = append(matchers, lintMatcher{reference: indexedReference("request.headers", i), regexes: []string{headerRule.Regex}, empty: headerRule.Match == "" && headerRule.MatchList == "" && headerRule.Regex == ""})
+		}
+		for i, parameterRule := range rule.Request.Parameters {
+			matchers = append(matchers, lintMatcher{reference: indexedReference("request.params", i), regexes: []string{parameterRule.Regex}, empty: parameterRule.Match == "" && parameterRule.MatchList == "" && parameterRule.Regex == ""})
+		}
+		for i, bodyRule := range rule.Request.Body {
+			matchers = append(matchers, lintMatcher{reference: indexedReference("request.body", i), regexes: []string{bodyRule.Regex}, empty: bodyRule.Match == "" && bodyRule.MatchList == "" && bodyRule.Regex == "" && bodyRule.MD5Sum == "" && bodyRule.SHA256Sum == ""})
+		}
+		for i, cookieRule := range rule.Request.Cookies {
+			matchers = append(matchers, lintMatcher{reference: indexedReference("request.cookies", i), regexes: []string{cookieRule.Regex}, empty: cookieRule.Match == "" && cookieRule.MatchList == "" && cookieRule.Regex == ""})
+		}
+		for i, fileRule := range rule.Request.Files {
+			matcher := lintMatcher{reference: indexedReference("request.files", i)}
+			for _, mode := range getFilesSearchModes([]*FilesRule{fileRule}) {
+				matcher.regexes = append(matcher.regexes, mode.Regex)
+			}
+			matchers = append(matchers, matcher)
+		}
+		for i, jsonRule := range rule.Request.JSON {
+			matchers = append(matchers, lintMatcher{reference: indexedReference("request.json", i), regexes: []string{jsonRule.Regex}, empty: jsonRule.Match == "" && jsonRule.MatchList == "" && jsonRule.Regex == ""})
+		}
+		for i, xmlRule := range rule.Request.XML {
+			matchers = append(matchers, lintMatcher{reference: indexedReference("request.xml", i), regexes: []string{xmlRule.Regex}, empty: xmlRule.Match == "" && xmlRule.MatchList == "" && xmlRule.Regex == ""})
+		}
+	}
+	if rule.Response != nil {
+		if rule.Response.Code != nil {
+			matchers = append(matchers, lintMatcher{reference: "response.code", regexes: []string{rule.Response.Code.Regex}, empty: isEmptySearchMode(rule.Response.Code)})
+		}
+		for i, headerRule := range rule.Response.Headers {
+			matchers = append(matchers, lintMatcher{reference: indexedReference("response.headers", i), regexes: []string{headerRule.Regex}, empty: headerRule.Match == "" && headerRule.MatchList == "" && headerRule.Regex == ""})
+		}
+		for i, bodyRule := range rule.Response.Body {
+			matchers = append(matchers, lintMatcher{reference: indexedReference("response.body", i), regexes: []string{bodyRule.Regex}, empty: bodyRule.Match == "" && bodyRule.MatchList == "" && bodyRule.Regex == "" && bodyRule.MD5Sum == "" && bodyRule.SHA256Sum == ""})
+		}
+		for i, jsonRule := range rule.Response.JSON {
+			matchers = append(matchers, lintMatcher{reference: indexedReference("response.json", i), regexes: []string{jsonRule.Regex}, empty: jsonRule.Match == "" && jsonRule.MatchList == "" && jsonRule.Regex == ""})
+		}
+		for i, xmlRule := range rule.Response.XML {
+			matchers = append(matchers, lintMatcher{reference: indexedReference("response.xml", i), regexes: []string{xmlRule.Regex}, empty: xmlRule.Match == "" && xmlRule.MatchList == "" && xmlRule.Regex == ""})
+		}
+		if rule.Response.Latency != nil {
+			matchers = append(matchers, lintMatcher{reference: "response.latency", empty: rule.Response.Latency.Gt == nil && rule.Response.Latency.Lt == nil})
+		}
+		if rule.Response.Size != nil {
+			matchers = append(matchers, lintMatcher{reference: "response.size", empty: rule.Response.Size.Gt == nil && rule.Response.Size.Lt == nil})
+		}
+	}
+	//The correlation rules match on the state of the client instead of the matchers (checked by CheckRule)
+	if rule.Correlation != nil {
+		matchers = append(matchers, lintMatcher{reference: "correlation", empty: false})
+	}
+	for i, wsRule := range rule.Websocket {
+		matchers = append(matchers, lintMatcher{reference: indexedReference("websocket", i), regexes: []string{wsRule.Regex, wsRule.HexRegex}, empty: wsRule.Match == "" && wsRule.MatchList == "" && wsRule.Regex == "" && wsRule.HexMatch == "" && wsRule.HexRegex == ""})
+	}
+	return matchers
+}
+
+// Checks if the quantifier can repeat its subexpression more than once
+func isRepeatingQuantifier(re *syntax.Regexp) bool {
+	switch re.Op {
+	case syntax.OpStar, syntax.OpPlus:
+		return true
+	case syntax.OpRepeat:
+		return re.Max == -1 || re.Max > 1
+	}
+	return false
+}
+
+// Checks if the regex contains a quantifier applied on a subexpression which is quantified as well ((a+)+, (\w*)*)
+// The regex engine runs in linear time but the nested quantifiers make the rules slow and are catastrophic in the backtracking engines the rules are imported from
+// @param pattern - the regex
+// Returns true if the regex has nested quantifiers
+func hasNestedQuantifiers(pattern string) bool {
+	re, err := syntax.Parse(pattern, syntax.Perl)
+	if err != nil {
+		return false
+	}
+	var check func(re *syntax.Regexp, quantified bool) bool
+	check = func(re *syntax.Regexp, quantified bool) bool {
+		repeating := isRepeatingQuantifier(re)
+		if repeating && quantified {
+			return true
+		}
+		for _, sub := range re.Sub {
+			if check(sub, quantified || repeating) {
+				return true
+			}
+		}
+		return false
+	}
+	return check(re, false)
+}
+
+// Lints the rule, the problems are not checked by CheckRule since they do not prevent the rule from running
+// @param rule - the rule to be checked
+// Returns the list of issues found (without the file)
+func LintRule(rule Rule) []LintIssue {
+	issues := make([]LintIssue, 0)
+	addIssue := func(severity string, message string) {
+		issues = append(issues, LintIssue{RuleId: rule.Id, Severity: severity, Message: message})
+	}
+
+	if strings.TrimSpace(rule.Id) == "" {
+		addIssue(LintError, "rule should have an id")
+	}
+	if rule.Info != nil {
+		if rule.Info.Name == "" {
+			addIssue(LintWarning, "rule should have a name")
+		}
+		if rule.Info.Description == "" {
+			addIssue(LintWarning, "rule should have a description")
+		}
+		if rule.Info.Classification == "" {
+			addIssue(LintWarning, "rule should have a classification")
+		}
+	}
+
+	matchers := getLintMatchers(rule)
+	if len(matchers) == 0 {
+		addIssue(LintError, "rule does not have any matcher")
+	}
+	for _, matcher := range matchers {
+		if matcher.empty {
+			addIssue(LintError, matcher.reference+" is empty, it should have a match, a match_list or a regex")
+		}
+		for _, pattern := range matcher.regexes {
+			if pattern == "" {
+				continue
+			}
+			//The regexes which do not compile are reported by CheckRule
+			if hasNestedQuantifiers(pattern) {
+				addIssue(LintError, matcher.reference+" regex has nested quantifiers, "+pattern)
+			}
+		}
+	}
+	return issues
+}
+
+// Lints a rule file, the file is decoded in strict mode so the unknown keys are reported
+// @param path - the path of the rule file
+// @param library - the pattern lists and the macros referenced by the rule (only the built-in macros if nil)
+// @param logger - the logger
+// Returns the rule (nil if the file could not be parsed) and the issues found
+func LintRuleFile(path string, library *PatternLibrary, logger logging.ILogger) (*Rule, []LintIssue) {
+	fileIssue := func(message string) []LintIssue {
+		return []LintIssue{{File: path, Severity: LintError, Message: message}}
+	}
+
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, fileIssue("could not open the file for reading, " + err.Error())
+	}
+	defer file.Close()
+
+	rule := Rule{}
+	if err := rule.FromYAMLStrict(file); err != nil {
+		return nil, fileIssue("error when parsing, " + err.Error())
+	}
+
+	if library == nil {
+		library = NewPatternLibrary()
+	}
+	library.ResolveRule(&rule)
+
+	issues := make([]LintIssue, 0)
+	if err := CheckRule(rule, logger); err != nil {
+		issues = append(issues, LintIssue{RuleId: rule.Id, Severity: LintError, Message: err.Error()})
+	}
+	issues = append(issues, LintRule(rule)...)
+	for i := range issues {
+		issues[i].File = path
+	}
+	return &rule, issues
+}
+
+// Checks if the directory is in the list of ignored directories from the configuration
+func isIgnoredRulesDirectory(configuration config.Configuration, name string) bool {
+	for _, ignoreDir := range configuration.IgnoreRulesDirectories {
+		if ignoreDir == name {
+			return true
+		}
+	}
+	return false
+}
+
+// Lints all the rule files from the rules directory (or the rule file if the path is a file)
+// The rule ids are checked to be unique across all the files
+// @param configuration - the configuration of the agent (the rules directory and the ignored directories)
+// @param logger - the logger
+// Returns the issues found sorted by file, the number of files checked or an error if the directory cannot be read
+func LintRulesDirectory(configuration config.Configuration, logger logging.ILogger) ([]LintIssue, int, error) {
+	if _, err := os.Stat(configuration.RulesDirectory); err != nil {
+		return nil, 0, errors.New("rules directory does not exist")
+	}
+
+	issues := make([]LintIssue, 0)
+	library, err := LoadPatternLibrary(configuration.RulesDirectory)
+	if err != nil {
+		issues = append(issues, LintIssue{File: filepath.Join(configuration.RulesDirectory, PatternListsDirectory), Severity: LintError, Message: err.Error()})
+	}
+	filesCount := 0
+	ruleFiles := make(map[string]string)
+	err = filepath.WalkDir(configuration.RulesDirectory, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			issues = append(issues, LintIssue{File: path, Severity: LintError, Message: "could not read the directory entry, " + err.Error()})
+			return nil
+		}
+		if d.IsDir() {
+			if isPatternListsDirectory(configuration.RulesDirectory, path) || isRuleSetStagingDirectory(configuration.RulesDirectory, path) {
+				return filepath.SkipDir
+			}
+			if path != configuration.RulesDirectory && isIgnoredRulesDirectory(configuration, d.Name()) {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if isRuleSetVersionFile(configuration.RulesDirectory, path) {
+			return nil
+		}
+		if !strings.HasSuffix(d.Name(), ".yaml") {
+			issues = append(issues, LintIssue{File: path, Severity: LintWarning, Message: "file is not a yaml file and it is not loaded, check the file extension"})
+			return nil
+		}
+
+		filesCount++
+		rule, fileIssues := LintRuleFile(path, library, logger)
+		issues = append(issues, fileIssues...)
+		if rule == nil || rule.Id == "" {
+			return nil
+		}
+		if firstFile, found := ruleFiles[rule.Id]; found {
+			issues = append(issues, LintIssue{File: path, RuleId: rule.Id, Severity: LintError, Message: "a rule with this id already exists in " + firstFile})
+			return nil
+		}
+		ruleFiles[rule.Id] = path
+		return nil
+	})
+	if err != nil {
+		return nil, filesCount, errors.New("could not walk rules directory, " + err.Error())
+	}
+
+	sort.SliceStable(issues, func(i, j int) bool {
+		return issues[i].File < issues[j].File
+	})
+	return issues, filesCount, nil
+}
+
+// Gets the first error from the lint issues
+// Returns nil if there are only warnings
+func firstLintError(issues []LintIssue) error {
+	for _, issue := range issues {
+		if issue.Severity == LintError {
+			return errors.New(issue.Message)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,278 @@
+package detection
+
+import (
+	"net/url"
+	"strings"
+	"sync"
+
+	"github.com/lucacoratu/disertatie/agent/data"
+)
+
+// The parts of the request, the response or the websocket message where a rule can match
+const (
+	MatchPartMethod    = "method"
+	MatchPartURL       = "url"
+	MatchPartHeader    = "header"
+	MatchPartParameter = "parameter"
+	MatchPartCookie    = "cookie"
+	MatchPartBody      = "body"
+	MatchPartFile      = "file"
+	MatchPartStatus    = "status"
+	MatchPartMessage   = "message"
+	MatchPartLatency   = "latency"
+	MatchPartSize      = "size"
+)
+
+// The sources of the parameters of the request
+const (
+	ParameterSourceQuery = "query" //The parameters from the query string of the URL
+	ParameterSourceBody  = "body"  //The parameters from the body of the request (urlencoded or multipart)
+)
+
+// Sets the part of the message and the name of the header, parameter or cookie on all the matches
+func setMatchesPart(matches []searchMatch, part string, name string) []searchMatch {
+	for i := range matches {
+		matches[i].Part = part
+		matches[i].Name = name
+	}
+	return matches
+}
+
+// Sets the source of the parameter on all the matches
+func setMatchesSource(matches []searchMatch, source string) []searchMatch {
+	for i := range matches {
+		matches[i].Source = source
+	}
+	return matches
+}
+
+// Holds the position of a header value in the raw message
+type rawHeader struct {
+	name  string //The name of the header
+	start int    //The offset where the value starts
+	end   int    //The offset where the value ends
+}
+
+// Holds the raw request/response (as it is dumped and sent to the api) split into the parts where the matches are located
+type rawMessage struct {
+	data         string       //The raw message
+	startLineEnd int          //The offset where the start line (request line or status line) ends
+	headers      []*rawHeader //The positions of the header values
+	bodyStart    int          //The offset where the body starts
+	hasStartLine bool         //If the message has a start line and headers (the websocket messages only have the body)
+
+	//The spans of the JSON values are computed only when a JSONPath matcher matched (once for all the rules)
+	jsonOnce  sync.Once
+	jsonSpans map[string][2]int
+}
+
+// Splits the dumped request/response into the start line, the headers and the body
+// @param raw - the dumped request/response
+// Returns the raw message
+func newRawMessage(raw string) *rawMessage {
+	message := &rawMessage{data: raw, startLineEnd: len(raw), headers: make([]*rawHeader, 0), bodyStart: len(raw), hasStartLine: true}
+	lineStart := 0
+	for lineStart < len(raw) {
+		lineEnd := strings.IndexByte(raw[lineStart:], '\n')
+		if lineEnd == -1 {
+			lineEnd = len(raw)
+		} else {
+			lineEnd += lineStart
+		}
+		line := raw[lineStart:lineEnd]
+		switch {
+		case lineStart == 0:
+			message.startLineEnd = lineEnd
+		case line == "":
+			//The empty line separates the headers from the body
+			message.bodyStart = min(lineEnd+1, len(raw))
+			return message
+		default:
+			if name, _, found := strings.Cut(line, ": "); found {
+				message.headers = append(message.headers, &rawHeader{name: name, start: lineStart + len(name) + 2, end: lineEnd})
+			}
+		}
+		lineStart = lineEnd + 1
+	}
+	return message
+}
+
+// Creates the raw message of a websocket message (the whole message is the body)
+func newRawWebsocketMessage(message []byte) *rawMessage {
+	return &rawMessage{data: string(message), headers: make([]*rawHeader, 0)}
+}
+
+// Searches case insensitive for the value in a span of the raw message
+// Returns the offsets of the value and true if it was found
+func (raw *rawMessage) indexIn(start int, end int, value string) (int, int, bool) {
+	if value == "" || start < 0 || end > len(raw.data) || start > end {
+		return 0, 0, false
+	}
+	index := strings.Index(strings.ToLower(raw.data[start:end]), strings.ToLower(value))
+	if index == -1 || len(strings.ToLower(raw.data[start:end])) != end-start {
+		return 0, 0, false
+	}
+	return start + index, start + index + len(value), true
+}
+
+// Gets the span of the value of a name=value pair in a span of the raw message
+// The names and the values are compared after they are URL decoded
+// @param start - the offset where the pairs start
+// @param end - the offset where the pairs end
+// @param separator - the separator of the pairs (& for parameters, ; for cookies)
+// @param name - the name of the pair
+// @param value - the decoded value of the pair
+// Returns the offsets of the raw value and true if the pair was found
+func (raw *rawMessage) findPair(start int, end int, separator string, name string, value string) (int, int, bool) {
+	offset := start
+	for _, pair := range strings.Split(raw.data[start:end], separator) {
+		pairStart := offset
+		offset += len(pair) + len(separator)
+		trimmed := strings.TrimLeft(pair, " ")
+		pairStart += len(pair) - len(trimmed)
+		pairName, pairValue, found := strings.Cut(trimmed, "=")
+		if !found {
+			continue
+		}
+		decodedName, err := url.QueryUnescape(pairName)
+		if err != nil {
+			decodedName = pairName
+		}
+		decodedValue, err := url.QueryUnescape(pairValue)
+		if err != nil {
+			decodedValue = pairValue
+		}
+		if decodedName == name && (decodedValue == value || pairValue == value) {
+			valueStart := pairStart + len(pairName) + 1
+			return valueStart, valueStart + len(pairValue), true
+		}
+	}
+	return 0, 0, false
+}
+
+// Gets the span of the header value in the raw message
+// The headers with multiple values are dumped on the same line so the value is searched in the line
+func (raw *rawMessage) findHeader(name string, value string) (int, int, bool) {
+	for _, header := range raw.headers {
+		if !strings.EqualFold(header.name, name) {
+			continue
+		}
+		if start, end, found := raw.indexIn(header.start, header.end, value); found {
+			return start, end, true
+		}
+	}
+	return 0, 0, false
+}
+
+// Gets the span of the JSON value in the body of the raw message
+// @param path - the exact path of the value ($.user.name)
+// Returns the offsets of the value and true if the body is a JSON document with the value
+func (raw *rawMessage) findJSONValue(path string) (int, int, bool) {
+	raw.jsonOnce.Do(func() {
+		raw.jsonSpans = getJSONValueSpans([]byte(raw.data[raw.bodyStart:]))
+	})
+	span, found := raw.jsonSpans[path]
+	if !found {
+		return 0, 0, false
+	}
+	return raw.bodyStart + span[0], raw.bodyStart + span[1], true
+}
+
+// Gets the span of the value inspected by the rule in the raw message
+// @param match - the match of the rule with the part of the message it was found in
+// Returns the offsets of the inspected value and true if the value was found
+func (raw *rawMessage) getValueSpan(match searchMatch) (int, int, bool) {
+	switch match.Part {
+	case MatchPartMethod, MatchPartURL, MatchPartStatus:
+		if !raw.hasStartLine {
+			return 0, 0, false
+		}
+		//The request line is: method path?query protocol
+		firstSpace := strings.IndexByte(raw.data[:raw.startLineEnd], ' ')
+		lastSpace := strings.LastIndexByte(raw.data[:raw.startLineEnd], ' ')
+		if firstSpace == -1 {
+			return 0, 0, false
+		}
+		if match.Part == MatchPartMethod {
+			return 0, firstSpace, true
+		}
+		if match.Part == MatchPartStatus {
+			return raw.indexIn(firstSpace, raw.startLineEnd, match.Inspected)
+		}
+		return firstSpace + 1, max(lastSpace, firstSpace+1), true
+	case MatchPartHeader:
+		return raw.findHeader(match.Name, match.Inspected)
+	case MatchPartCookie:
+		for _, header := range raw.headers {
+			if strings.EqualFold(header.name, "Cookie") {
+				if start, end, found := raw.findPair(header.start, header.end, ";", match.Name, match.Inspected); found {
+					return start, end, true
+				}
+			}
+		}
+	case MatchPartParameter:
+		if match.Source == ParameterSourceQuery && raw.hasStartLine {
+			urlStart, urlEnd, _ := raw.getValueSpan(searchMatch{Part: MatchPartURL})
+			if queryStart := strings.IndexByte(raw.data[urlStart:urlEnd], '?'); queryStart != -1 {
+				return raw.findPair(urlStart+queryStart+1, urlEnd, "&", match.Name, match.Inspected)
+			}
+			return 0, 0, false
+		}
+		//The multipart fields are not name=value pairs so their value is searched in the body
+		if start, end, found := raw.findPair(raw.bodyStart, len(raw.data), "&", match.Name, match.Inspected); found {
+			return start, end, true
+		}
+		return raw.indexIn(raw.bodyStart, len(raw.data), match.Inspected)
+	case MatchPartBody, MatchPartMessage:
+		//The values selected by the JSONPath are located by their path and the values selected by the XPath are searched in the body
+		if strings.HasPrefix(match.Path, "$") {
+			if start, end, found := raw.findJSONValue(match.Path); found {
+				return start, end, true
+			}
+		}
+		if match.Path != "" {
+			if start, end, found := raw.indexIn(raw.bodyStart, len(raw.data), match.Inspected); found {
+				return start, end, true
+			}
+		}
+		if raw.bodyStart < len(raw.data) {
+			return raw.bodyStart, len(raw.data), true
+		}
+	case MatchPartFile:
+		return raw.indexIn(raw.bodyStart, len(raw.data), match.Inspected)
+	}
+	return 0, 0, false
+}
+
+// Gets the line number and the offset from the start of the line of an offset in the raw message
+func (raw *rawMessage) getLinePosition(offset int) (int64, int64) {
+	line := strings.Count(raw.data[:offset], "\n")
+	lineStart := strings.LastIndexByte(raw.data[:offset], '\n') + 1
+	return int64(line), int64(offset - lineStart)
+}
+
+// Sets the location of the match in the raw message on the finding
+// The match is located exactly when it was found in the raw value, otherwise (decoded or escaped values) the whole raw value is reported
+// @param finding - the finding of the match
+// @param match - the match with the part of the message and the offset in the inspected value
+func (raw *rawMessage) locate(finding *data.RuleFindingData, match searchMatch) {
+	if raw == nil {
+		return
+	}
+	start, end, found := raw.getValueSpan(match)
+	if !found {
+		return
+	}
+	if len(match.DecodingChain) == 0 {
+		if match.Start >= 0 && raw.data[start:end] == match.Inspected && match.Start+len(match.Value) <= len(match.Inspected) {
+			//The inspected value is the same in the raw message so the offset of the match is known
+			start, end = start+match.Start, start+match.Start+len(match.Value)
+		} else if matchStart, matchEnd, found := raw.indexIn(start, end, match.Value); found {
+			start, end = matchStart, matchEnd
+		}
+	}
+	finding.StartOffset = int64(start)
+	finding.EndOffset = int64(end)
+	finding.Length = int64(end - start)
+	finding.Line, finding.LineIndex = raw.getLinePosition(start)
+}
@@ -0,0 +1,637 @@
+package detection
+
+import (
+	"errors"
+	"net/http"
+	"net/textproto"
+	"net/url"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/lucacoratu/disertatie/agent/logging"
+	"gopkg.in/yaml.v2"
+)
+
+// The minimum number of literal characters a value should have to be used as a matcher (shorter values match almost everything)
+const minNucleiLiteralLength = 3
+
+// Matches the variables and the helper functions of the nuclei templates ({{BaseURL}}, {{randstr}}, {{base64('a')}})
+var nucleiVariableRegex = regexp.MustCompile(`\{\{[^{}]*\}\}`)
+
+// Matches the variables the paths of the nuclei templates start with
+var nucleiBaseURLRegex = regexp.MustCompile(`^\{\{(?:BaseURL|RootURL|Hostname|Host)\}\}`)
+
+// Matches the parts of the response the nuclei matchers can be applied on (body_2 is the body of the second request)
+var nucleiPartRegex = regexp.MustCompile(`^(body|header|all_headers|all|response|raw)(?:_\d+)?$`)
+
+// The request headers which are sent by every client so they are not converted to matchers
+var genericNucleiHeaders = map[string]bool{"Host": true, "User-Agent": true, "Accept": true, "Accept-Encoding": true, "Accept-Language": true, "Connection": true, "Content-Length": true, "Content-Type": true, "Cache-Control": true, "Pragma": true, "Origin": true, "Referer": true, "Cookie": true, "Authorization": true, "Upgrade-Insecure-Requests": true}
+
+// The nuclei tags which are used as the classification of the rule
+var nucleiClassificationTags = map[string]string{"lfi": "lfi", "traversal": "lfi", "sqli": "sqli", "xss": "xss", "rce": "rce", "ssti": "ssti", "xxe": "xxe", "ssrf": "ssrf", "redirect": "redirect", "fileupload": "file-upload", "file-upload": "file-upload", "deserialization": "deserialization", "injection": "injection"}
+
+// Holds the classification of the vulnerability from the nuclei template
+type nucleiClassification struct {
+	CVSSMetrics string      `yaml:"cvss-metrics"`
+	CVSSScore   interface{} `yaml:"cvss-score"`
+	CVEId       interface{} `yaml:"cve-id"`
+	CWEId       interface{} `yaml:"cwe-id"`
+}
+
+// Holds the info field of the nuclei template
+type nucleiInfo struct {
+	Name           string                `yaml:"name"`
+	Severity       string                `yaml:"severity"`
+	Description    string                `yaml:"description"`
+	Reference      interface{}           `yaml:"reference"` //A string or a list of strings
+	Tags           interface{}           `yaml:"tags"`      //A comma separated string or a list of strings
+	Classification *nucleiClassification `yaml:"classification"`
+}
+
+// Holds a matcher of a nuclei HTTP request
+type nucleiMatcher struct {
+	Type            string   `yaml:"type"`
+	Part            string   `yaml:"part"`
+	Condition       string   `yaml:"condition"`
+	Negative        bool     `yaml:"negative"`
+	CaseInsensitive bool     `yaml:"case-insensitive"`
+	Words           []string `yaml:"words"`
+	Regex           []string `yaml:"regex"`
+	Status          []int    `yaml:"status"`
+}
+
+// Holds a HTTP request of the nuclei template
+type nucleiRequest struct {
+	Method            string                 `yaml:"method"`
+	Path              []string               `yaml:"path"`
+	Raw               []string               `yaml:"raw"`
+	Headers           map[string]string      `yaml:"headers"`
+	Body              string                 `yaml:"body"`
+	Payloads          map[string]interface{} `yaml:"payloads"`
+	MatchersCondition string                 `yaml:"matchers-condition"`
+	Matchers          []*nucleiMatcher       `yaml:"matchers"`
+}
+
+// Holds the fields of the nuclei template which are converted (the other fields are ignored)
+type nucleiTemplate struct {
+	Id       string           `yaml:"id"`
+	Info     nucleiInfo       `yaml:"info"`
+	HTTP     []*nucleiRequest `yaml:"http"`
+	Requests []*nucleiRequest `yaml:"requests"` //The HTTP requests in the templates written before nuclei v3
+}
+
+// Holds a request sent by the nuclei template
+type nucleiSample struct {
+	method  string
+	target  string
+	headers map[string]string
+	body    string
+}
+
+// Holds the result of the conversion of a nuclei template
+type NucleiConversion struct {
+	Rule     *Rule    //The converted rule
+	Warnings []string //The parts of the template which could not be converted faithfully
+}
+
+// Holds the state of the conversion of a nuclei template
+type nucleiConverter struct {
+	rule     *Rule
+	warnings []string
+	statuses []int //The status codes used by the status matchers (the rule can have a single code matcher)
+}
+
+// Adds a warning about a part of the template which could not be converted faithfully
+func (nc *nucleiConverter) warn(warning string) {
+	for _, existing := range nc.warnings {
+		if existing == warning {
+			return
+		}
+	}
+	nc.warnings = append(nc.warnings, warning)
+}
+
+// Converts a list of strings from the template which can also be written as a single string
+// @param value - the value from the template
+// @param separator - the separator of the values in the single string form (empty if the string is a single value)
+func nucleiStringList(value interface{}, separator string) []string {
+	values := make([]string, 0)
+	switch v := value.(type) {
+	case string:
+		parts := []string{v}
+		if separator != "" {
+			parts = strings.Split(v, separator)
+		}
+		for _, part := range parts {
+			if part = strings.TrimSpace(part); part != "" {
+				values = append(values, part)
+			}
+		}
+	case []interface{}:
+		for _, element := range v {
+			if s, ok := element.(string); ok && strings.TrimSpace(s) != "" {
+				values = append(values, strings.TrimSpace(s))
+			}
+		}
+	}
+	return values
+}
+
+// Creates a leaf of the condition tree
+func newMatcherCondition(reference string) *RuleCondition {
+	return &RuleCondition{Matcher: reference}
+}
+
+// Combines the conditions with the and/or operator (a single condition is returned as it is)
+func combineConditions(operator string, conditions []*RuleCondition) *RuleCondition {
+	if len(conditions) == 0 {
+		return nil
+	}
+	if len(conditions) == 1 {
+		return conditions[0]
+	}
+	if operator == MatchersConditionAnd {
+		return &RuleCondition{And: conditions}
+	}
+	return &RuleCondition{Or: conditions}
+}
+
+// Converts a value from the template which can contain variables into a search mode
+// The literal parts between the variables are joined by a wildcard regex
+// @param value - the value from the template
+// @param caseInsensitive - if the regex should be case insensitive (the exact matches are always case insensitive)
+// Returns the search mode or nil if the value does not have enough literal characters
+func nucleiSearchMode(value string, caseInsensitive bool) *RuleSearchMode {
+	segments := make([]string, 0)
+	literalLength := 0
+	for _, segment := range nucleiVariableRegex.Split(value, -1) {
+		if segment != "" {
+			segments = append(segments, segment)
+			literalLength += len(segment)
+		}
+	}
+	if literalLength < minNucleiLiteralLength {
+		return nil
+	}
+	if len(segments) == 1 {
+		return &RuleSearchMode{Match: segments[0]}
+	}
+
+	quotedSegments := make([]string, 0, len(segments))
+	for _, segment := range segments {
+		quotedSegments = append(quotedSegments, regexp.QuoteMeta(segment))
+	}
+	flags := "(?s)"
+	if caseInsensitive {
+		flags = "(?is)"
+	}
+	return &RuleSearchMode{Regex: flags + strings.Join(quotedSegments, ".*")}
+}
+
+// Decodes the path of the nuclei request the same way the agent decodes the URL of the inspected requests
+func decodeNucleiPath(path string) string {
+	path = nucleiBaseURLRegex.ReplaceAllString(path, "")
+	pathPart, queryPart, hasQuery := strings.Cut(path, "?")
+	if decoded, err := url.PathUnescape(pathPart); err == nil {
+		pathPart = decoded
+	}
+	if !hasQuery {
+		return pathPart
+	}
+	if decoded, err := url.QueryUnescape(queryPart); err == nil {
+		queryPart = decoded
+	}
+	return pathPart + "?" + queryPart
+}
+
+// Parses a raw request of the nuclei template (the request can contain variables so it is not parsed with net/http)
+// @param raw - the raw request
+// Returns the request sample or an error if the request line is not valid
+func parseNucleiRawRequest(raw string) (*nucleiSample, error) {
+	head, body := splitRawHTTPMessage(raw)
+	lines := make([]string, 0)
+	for _, line := range strings.Split(strings.TrimSpace(head), "\r\n") {
+		//Skip the annotations of the request (@timeout: 10s)
+		if !strings.HasPrefix(line, "@") {
+			lines = append(lines, line)
+		}
+	}
+	if len(lines) == 0 {
+		return nil, errors.New("empty raw request")
+	}
+
+	fields := strings.Fields(lines[0])
+	if len(fields) < 2 {
+		return nil, errors.New("invalid request line in raw request, " + lines[0])
+	}
+	sample := &nucleiSample{method: fields[0], target: fields[1], headers: make(map[string]string), body: body}
+	for _, line := range lines[1:] {
+		name, value, found := strings.Cut(line, ":")
+		if found {
+			sample.headers[name] = strings.TrimSpace(value)
+		}
+	}
+	return sample, nil
+}
+
+// Gets all the requests sent by the nuclei request (every path and every raw request)
+func (nc *nucleiConverter) getSamples(request *nucleiRequest) []*nucleiSample {
+	samples := make([]*nucleiSample, 0)
+	for _, path := range request.Path {
+		samples = append(samples, &nucleiSample{method: request.Method, target: path, headers: request.Headers, body: request.Body})
+	}
+	for _, raw := range request.Raw {
+		sample, err := parseNucleiRawRequest(raw)
+		if err != nil {
+			nc.warn("raw request could not be parsed, " + err.Error())
+			continue
+		}
+		samples = append(samples, sample)
+	}
+	if len(request.Payloads) > 0 {
+		nc.warn("the payloads are not expanded, the payload variables match any value")
+	}
+	return samples
+}
+
+// Gets the index of the search mode in the list, the search modes are compared by value
+// Returns -1 if the list does not contain the search mode
+func indexOfSearchMode(modes []*RuleSearchMode, mode *RuleSearchMode) int {
+	for i, existing := range modes {
+		if existing.Match == mode.Match && existing.Regex == mode.Regex {
+			return i
+		}
+	}
+	return -1
+}
+
+// Adds the matchers of the request sample to the rule
+// The path, the non generic headers and the body should all match for the sample to match
+// Returns the condition of the sample or nil if the sample does not have anything specific to match
+func (nc *nucleiConverter) addRequestSample(sample *nucleiSample) *RuleCondition {
+	request := nc.rule.Request
+	conditions := make([]*RuleCondition, 0)
+
+	//The same path, header or body can be sent by many requests so the existing matchers are reused
+	if mode := nucleiSearchMode(decodeNucleiPath(sample.target), false); mode != nil {
+		index := indexOfSearchMode(request.URL, mode)
+		if index == -1 {
+			request.URL = append(request.URL, mode)
+			index = len(request.URL) - 1
+		}
+		conditions = append(conditions, newMatcherCondition(indexedReference("request.url", index)))
+	}
+
+	headerNames := make([]string, 0, len(sample.headers))
+	for name := range sample.headers {
+		headerNames = append(headerNames, name)
+	}
+	sort.Strings(headerNames)
+	for _, name := range headerNames {
+		canonicalName := textproto.CanonicalMIMEHeaderKey(name)
+		if genericNucleiHeaders[canonicalName] {
+			continue
+		}
+		if mode := nucleiSearchMode(sample.headers[name], false); mode != nil {
+			index := -1
+			for i, headerRule := range request.Headers {
+				if headerRule.Name == canonicalName && headerRule.Match == mode.Match && headerRule.Regex == mode.Regex {
+					index = i
+					break
+				}
+			}
+			if index == -1 {
+				request.Headers = append(request.Headers, &HeadersRule{Name: canonicalName, Match: mode.Match, Regex: mode.Regex})
+				index = len(request.Headers) - 1
+			}
+			conditions = append(conditions, newMatcherCondition(indexedReference("request.headers", index)))
+		}
+	}
+
+	if mode := nucleiSearchMode(sample.body, false); mode != nil {
+		index := -1
+		for i, bodyRule := range request.Body {
+			if bodyRule.Match == mode.Match && bodyRule.Regex == mode.Regex {
+				index = i
+				break
+			}
+		}
+		if index == -1 {
+			request.Body = append(request.Body, &BodyRule{Match: mode.Match, Regex: mode.Regex})
+			index = len(request.Body) - 1
+		}
+		conditions = append(conditions, newMatcherCondition(indexedReference("request.body", index)))
+	}
+
+	return combineConditions(MatchersConditionAnd, conditions)
+}
+
+// Converts the requests of the template into the request matchers of the rule
+// Returns the condition of the request phase or nil if no request could be converted
+func (nc *nucleiConverter) convertRequests(requests []*nucleiRequest) *RuleCondition {
+	sampleConditions := make([]*RuleCondition, 0)
+	methods := make(map[string]bool)
+	seenSamples := make(map[string]bool)
+
+	for _, request := range requests {
+		for _, sample := range nc.getSamples(request) {
+			method := strings.ToUpper(sample.method)
+			if method == "" {
+				method = http.MethodGet
+			}
+			key := method + " " + sample.target + "\n" + sample.body
+			if seenSamples[key] {
+				continue
+			}
+			seenSamples[key] = true
+
+			condition := nc.addRequestSample(sample)
+			if condition == nil {
+				nc.warn("request " + method + " " + sample.target + " has nothing specific to match, only the response matchers are used for it")
+				continue
+			}
+			methods[method] = true
+			sampleConditions = append(sampleConditions, condition)
+		}
+	}
+
+	condition := combineConditions(MatchersConditionOr, sampleConditions)
+	if condition == nil {
+		return nil
+	}
+	//The rule can have a single method matcher so it is used only if all the requests have the same method
+	if len(methods) == 1 {
+		for method := range methods {
+			nc.rule.Request.Method = &RuleSearchMode{Match: method}
+		}
+		condition = combineConditions(MatchersConditionAnd, []*RuleCondition{newMatcherCondition("request.method"), condition})
+	} else {
+		nc.warn("the requests use different methods, the method is not matched")
+	}
+	return condition
+}
+
+// Adds a body or a header matcher for a word or a regex of a nuclei matcher
+// Returns the condition of the added matcher
+func (nc *nucleiConverter) addResponseMatcher(part string, mode *RuleSearchMode) *RuleCondition {
+	response := nc.rule.Response
+	addBody := func() *RuleCondition {
+		response.Body = append(response.Body, &BodyRule{Match: mode.Match, Regex: mode.Regex})
+		return newMatcherCondition(indexedReference("response.body", len(response.Body)-1))
+	}
+	addHeader := func() *RuleCondition {
+		//A word written as a full header line (Server: nginx) is matched on the value of that header
+		name, value := "any", mode.Match
+		if headerName, headerValue, found := strings.Cut(mode.Match, ": "); found && !strings.ContainsAny(headerName, " \t") {
+			name, value = textproto.CanonicalMIMEHeaderKey(headerName), headerValue
+		}
+		response.Headers = append(response.Headers, &HeadersRule{Name: name, Match: value, Regex: mode.Regex})
+		return newMatcherCondition(indexedReference("response.headers", len(response.Headers)-1))
+	}
+
+	switch part {
+	case "header", "all_headers":
+		return addHeader()
+	case "all", "response", "raw":
+		return combineConditions(MatchersConditionOr, []*RuleCondition{addBody(), addHeader()})
+	}
+	return addBody()
+}
+
+// Converts a nuclei matcher into response matchers
+// Returns the condition of the matcher (nil if it could not be converted) and if the matcher checks the content of the response (not only the status code)
+func (nc *nucleiConverter) convertMatcher(matcher *nucleiMatcher) (*RuleCondition, bool) {
+	part := "body"
+	if matcher.Part != "" {
+		submatches := nucleiPartRegex.FindStringSubmatch(matcher.Part)
+		if submatches == nil {
+			nc.warn("matcher on part " + matcher.Part + " is not supported and was skipped")
+			return nil, false
+		}
+		part = submatches[1]
+	}
+	operator := strings.ToLower(matcher.Condition)
+	if operator != MatchersConditionAnd {
+		operator = MatchersConditionOr
+	}
+
+	conditions := make([]*RuleCondition, 0)
+	checksContent := false
+	switch matcher.Type {
+	case "word":
+		for _, word := range matcher.Words {
+			mode := nucleiSearchMode(word, matcher.CaseInsensitive)
+			if mode == nil {
+				nc.warn("word " + strconv.Quote(word) + " is too short to be matched and was skipped")
+				continue
+			}
+			conditions = append(conditions, nc.addResponseMatcher(part, mode))
+		}
+		checksContent = len(conditions) > 0
+	case "regex":
+		for _, pattern := range matcher.Regex {
+			if nucleiVariableRegex.MatchString(pattern) {
+				nc.warn("regex " + strconv.Quote(pattern) + " uses template variables and was skipped")
+				continue
+			}
+			if matcher.CaseInsensitive {
+				pattern = "(?i)" + pattern
+			}
+			if _, err := regexp.Compile(pattern); err != nil {
+				nc.warn("regex " + strconv.Quote(pattern) + " does not compile and was skipped")
+				continue
+			}
+			conditions = append(conditions, nc.addResponseMatcher(part, &RuleSearchMode{Regex: pattern}))
+		}
+		checksContent = len(conditions) > 0
+	case "status":
+		if len(matcher.Status) > 0 {
+			conditions = append(conditions, newMatcherCondition("response.code"))
+		}
+	default:
+		nc.warn("matcher of type " + matcher.Type + " is not supported and was skipped")
+		return nil, false
+	}
+
+	condition := combineConditions(operator, conditions)
+	if condition == nil {
+		return nil, false
+	}
+	if matcher.Negative {
+		return &RuleCondition{Not: condition}, false
+	}
+	return condition, checksContent
+}
+
+// Converts the matchers of the template requests into the response matchers of the rule
+// The conditions which do not check the content of the response (status only, negative only) are dropped because they would match most of the responses
+// Returns the condition of the response phase or nil if no matcher could be converted
+func (nc *nucleiConverter) convertMatchers(requests []*nucleiRequest) *RuleCondition {
+	requestConditions := make([]*RuleCondition, 0)
+	for _, request := range requests {
+		matchersCondition := strings.ToLower(request.MatchersCondition)
+		if matchersCondition != MatchersConditionAnd {
+			matchersCondition = MatchersConditionOr
+		}
+
+		conditions := make([]*RuleCondition, 0)
+		statuses := make([]int, 0)
+		checksContent := false
+		for _, matcher := range request.Matchers {
+			condition, matcherChecksContent := nc.convertMatcher(matcher)
+			if condition == nil {
+				continue
+			}
+			if matchersCondition == MatchersConditionOr && !matcherChecksContent {
+				nc.warn("matcher of type " + matcher.Type + " does not check the content of the response and was skipped")
+				continue
+			}
+			if matcher.Type == "status" {
+				statuses = append(statuses, matcher.Status...)
+			}
+			conditions = append(conditions, condition)
+			checksContent = checksContent || matcherChecksContent
+		}
+		if len(conditions) == 0 {
+			continue
+		}
+		if !checksContent {
+			nc.warn("the matchers do not check the content of the response and were skipped")
+			continue
+		}
+		nc.statuses = append(nc.statuses, statuses...)
+		requestConditions = append(requestConditions, combineConditions(matchersCondition, conditions))
+	}
+
+	condition := combineConditions(MatchersConditionOr, requestConditions)
+	if condition == nil {
+		return nil
+	}
+
+	//The rule has a single code matcher so all the status codes are matched by it
+	if len(nc.statuses) > 0 {
+		if len(requestConditions) > 1 {
+			nc.warn("the status codes of all the requests are matched together")
+		}
+		codes := make([]string, 0)
+		seenCodes := make(map[int]bool)
+		for _, status := range nc.statuses {
+			if !seenCodes[status] {
+				seenCodes[status] = true
+				codes = append(codes, strconv.Itoa(status))
+			}
+		}
+		nc.rule.Response.Code = &RuleSearchMode{Regex: "^(?:" + strings.Join(codes, "|") + ")$"}
+	}
+	return condition
+}
+
+// Converts the info of the nuclei template into the info of the rule
+func (nc *nucleiConverter) convertInfo(template *nucleiTemplate) *RuleInfo {
+	info := &RuleInfo{Name: template.Info.Name, Description: strings.TrimSpace(template.Info.Description), Severity: strings.ToLower(template.Info.Severity)}
+	switch info.Severity {
+	case "low", "medium", "high", "critical":
+	default:
+		nc.warn("severity " + template.Info.Severity + " is converted to low")
+		info.Severity = "low"
+	}
+	if info.Name == "" {
+		info.Name = template.Id
+	}
+
+	for _, tag := range nucleiStringList(template.Info.Tags, ",") {
+		info.Tags = append(info.Tags, strings.ReplaceAll(tag, " ", "-"))
+	}
+	for _, reference := range nucleiStringList(template.Info.Reference, "") {
+		if parsedReference, err := url.Parse(reference); err != nil || parsedReference.Scheme == "" || parsedReference.Host == "" {
+			nc.warn("reference " + reference + " is not an absolute URL and it is not imported")
+			continue
+		}
+		info.References = append(info.References, reference)
+	}
+
+	cveIds := make([]string, 0)
+	if classification := template.Info.Classification; classification != nil {
+		info.CVSSMetrics = classification.CVSSMetrics
+		switch score := classification.CVSSScore.(type) {
+		case float64:
+			info.CVSSScore = score
+		case int:
+			info.CVSSScore = float64(score)
+		case string:
+			info.CVSSScore, _ = strconv.ParseFloat(score, 64)
+		}
+		cveIds = nucleiStringList(classification.CVEId, ",")
+		for _, cweId := range nucleiStringList(classification.CWEId, ",") {
+			cweId = strings.ToUpper(cweId)
+			if cweIdRegex.MatchString(cweId) {
+				info.CWEIds = append(info.CWEIds, cweId)
+			} else {
+				nc.warn("cwe id " + cweId + " is not valid and it is not imported")
+			}
+		}
+	}
+
+	//The classification is the attack from the tags, otherwise the CVE (as in the rules imported before)
+	for _, tag := range info.Tags {
+		if classification, found := nucleiClassificationTags[strings.ToLower(tag)]; found {
+			info.Classification = classification
+			break
+		}
+	}
+	if info.Classification == "" && len(cveIds) > 0 {
+		info.Classification = strings.ToUpper(cveIds[0])
+	}
+	if info.Classification == "" {
+		info.Classification = template.Id
+	}
+	return info
+}
+
+// Converts a nuclei HTTP template into a rule
+// The requests sent by the template are converted into request matchers and the matchers of the template into response matchers
+// The parts of the template which cannot be converted (dsl matchers, payloads, other protocols) are reported as warnings
+// @param templateData - the content of the nuclei template
+// @param logger - the logger
+// Returns the conversion or an error if the template cannot be converted into a valid rule
+func ConvertNucleiTemplate(templateData []byte, logger logging.ILogger) (*NucleiConversion, error) {
+	template := nucleiTemplate{}
+	if err := yaml.Unmarshal(templateData, &template); err != nil {
+		return nil, errors.New("invalid nuclei template, " + err.Error())
+	}
+	if template.Id == "" {
+		return nil, errors.New("the nuclei template does not have an id")
+	}
+	requests := append(append([]*nucleiRequest{}, template.HTTP...), template.Requests...)
+	if len(requests) == 0 {
+		return nil, errors.New("the nuclei template does not have HTTP requests")
+	}
+
+	converter := &nucleiConverter{rule: &Rule{Id: template.Id, Request: &RequestRule{}, Response: &ResponseRule{}, Condition: &RuleConditions{}}}
+	converter.rule.Info = converter.convertInfo(&template)
+	converter.rule.Condition.Request = converter.convertRequests(requests)
+	converter.rule.Condition.Response = converter.convertMatchers(requests)
+
+	//Remove the phases which were not converted
+	if converter.rule.Condition.Request == nil {
+		converter.rule.Request = nil
+	}
+	if converter.rule.Condition.Response == nil {
+		converter.rule.Response = nil
+	}
+	if converter.rule.Request == nil && converter.rule.Response == nil {
+		return nil, errors.New("no request or matcher of the template could be converted, " + strings.Join(converter.warnings, "; "))
+	}
+
+	//The converted rule should be valid and should pass the lint checks
+	if err := CheckRule(*converter.rule, logger); err != nil {
+		return nil, errors.New("the converted rule is not valid, " + err.Error())
+	}
+	if err := firstLintError(LintRule(*converter.rule)); err != nil {
+		return nil, errors.New("the converted rule is not valid, " + err.Error())
+	}
+
+	return &NucleiConversion{Rule: converter.rule, Warnings: converter.warnings}, nil
+}
@@ -0,0 +1,325 @@
+package detection
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+
+	"gopkg.in/yaml.v2"
+)
+
+// The directory (inside the rules directory) which holds the pattern lists and the macros
+const PatternListsDirectory = "lists"
+
+// The extension of the pattern list files (the name of the file is the name of the list)
+const patternListExtension = ".list"
+
+// The file (inside the lists directory) which holds the macros defined by the user
+const macrosFileName = "macros.yaml"
+
+// The format of the macro references in the regexes ({{IPV4}})
+var macroReferenceRegex = regexp.MustCompile(`\{\{([A-Z][A-Z0-9_]*)\}\}`)
+
+// The format of the names of the macros and of the pattern lists
+var (
+	macroNameRegex       = regexp.MustCompile(`^[A-Z][A-Z0-9_]*$`)
+	patternListNameRegex = regexp.MustCompile(`^[A-Za-z0-9_-]+$`)
+)
+
+// The octet of an IPv4 address (0-255)
+const ipv4Octet = `(?:25[0-5]|2[0-4][0-9]|1?[0-9]?[0-9])`
+
+// The macros which can be used in the regexes of all the rules
+var BuiltinMacros = map[string]string{
+	"IPV4":            `\b` + ipv4Octet + `\.` + ipv4Octet + `\.` + ipv4Octet + `\.` + ipv4Octet + `\b`,
+	"PATH_TRAVERSAL":  `(?i:(?:\.|%2e|%252e|%c0%ae)(?:\.|%2e|%252e|%c0%ae)(?:/|\\|%2f|%5c|%252f|%255c|%c0%af))`,
+	"NULL_BYTE":       `(?:%00|\x00)`,
+	"SQL_COMMENT":     `(?:--|#|/\*)`,
+	"SHELL_METACHARS": "(?:;|\\||&&|`|\\$\\()",
+	"EMAIL":           `[A-Za-z0-9._%+-]+@[A-Za-z0-9.-]+\.[A-Za-z]{2,}`,
+	"URL":             `(?i:(?:https?|ftp)://[^\s/?#]+)`,
+}
+
+// Holds the pattern lists and the macros which can be referenced from the rules
+// The references are resolved when the rules are loaded so the runner only sees regexes
+type PatternLibrary struct {
+	Lists  map[string][]string //The pattern lists by name (the patterns are matched like the match field, case insensitive)
+	Macros map[string]string   //The regex macros by name (the built-in ones and the ones from the macros file)
+}
+
+// Holds a matcher field which can reference pattern lists and macros
+type patternField struct {
+	reference string  //The reference of the matcher (request.params[0], websocket[1])
+	matchList *string //The name of the pattern list referenced by the matcher
+	regex     *string //The regex of the matcher
+}
+
+// Creates a pattern library which contains only the built-in macros
+func NewPatternLibrary() *PatternLibrary {
+	macros := make(map[string]string, len(BuiltinMacros))
+	for name, pattern := range BuiltinMacros {
+		macros[name] = pattern
+	}
+	return &PatternLibrary{Lists: make(map[string][]string), Macros: macros}
+}
+
+// Loads the patterns of a list file (one pattern per line, the empty lines and the lines starting with # are ignored)
+// @param path - the path of the list file
+// Returns the patterns or an error if the file cannot be read or it is empty
+func loadPatternList(path string) ([]string, error) {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return nil, errors.New("could not read the pattern list " + path + ", " + err.Error())
+	}
+	patterns := make([]string, 0)
+	for _, line := range strings.Split(string(content), "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		patterns = append(patterns, line)
+	}
+	if len(patterns) == 0 {
+		return nil, errors.New("pattern list " + path + " does not have any pattern")
+	}
+	return patterns, nil
+}
+
+// Loads the macros defined by the user, the macros can reference only the built-in macros
+// @param path - the path of the macros file
+// Returns an error if the file cannot be parsed or a macro is not valid
+func (library *PatternLibrary) loadMacros(path string) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return errors.New("could not open the macros file, " + err.Error())
+	}
+	defer file.Close()
+
+	macros := make(map[string]string)
+	d := yaml.NewDecoder(file)
+	d.SetStrict(true)
+	if err := d.Decode(&macros); err != nil {
+		return errors.New("could not parse the macros file, " + err.Error())
+	}
+	for name, pattern := range macros {
+		if !macroNameRegex.MatchString(name) {
+			return errors.New("macro name should contain only uppercase letters, digits and _, " + name)
+		}
+		if _, found := BuiltinMacros[name]; found {
+			return errors.New("macro " + name + " is already a built-in macro")
+		}
+		pattern = expandMacros(pattern, BuiltinMacros)
+		if unknown := getMacroReferences(pattern); len(unknown) > 0 {
+			return errors.New("macro " + name + " references an unknown macro, " + unknown[0])
+		}
+		if _, err := regexp.Compile(pattern); err != nil {
+			return errors.New("cannot compile the regex of macro " + name + ", " + err.Error())
+		}
+		library.Macros[name] = pattern
+	}
+	return nil
+}
+
+// Loads the pattern lists and the macros from the lists directory of the rules directory
+// The list files are named <name>.list and the macros are defined in macros.yaml (NAME: regex)
+// @param rulesDirectory - the rules directory (or a rule file, then only the built-in macros are available)
+// Returns the library (only the built-in macros if the lists directory does not exist) or an error if a file is not valid
+func LoadPatternLibrary(rulesDirectory string) (*PatternLibrary, error) {
+	library := NewPatternLibrary()
+	if info, err := os.Stat(rulesDirectory); err != nil || !info.IsDir() {
+		return library, nil
+	}
+	listsDirectory := filepath.Join(rulesDirectory, PatternListsDirectory)
+	entries, err := os.ReadDir(listsDirectory)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return library, nil
+		}
+		return library, errors.New("could not read the lists directory, " + err.Error())
+	}
+
+	for _, entry := range entries {
+		if entry.IsDir() {
+			continue
+		}
+		path := filepath.Join(listsDirectory, entry.Name())
+		if entry.Name() == macrosFileName {
+			if err := library.loadMacros(path); err != nil {
+				return library, err
+			}
+			continue
+		}
+		if !strings.HasSuffix(entry.Name(), patternListExtension) {
+			continue
+		}
+		name := strings.TrimSuffix(entry.Name(), patternListExtension)
+		if !patternListNameRegex.MatchString(name) {
+			return library, errors.New("pattern list name should contain only letters, digits, _ and -, " + name)
+		}
+		patterns, err := loadPatternList(path)
+		if err != nil {
+			return library, err
+		}
+		library.Lists[name] = patterns
+	}
+	return library, nil
+}
+
+// Checks if the path is the lists directory of the rules directory (it does not contain rules)
+func isPatternListsDirectory(rulesDirectory string, path string) bool {
+	return filepath.Clean(path) == filepath.Join(rulesDirectory, PatternListsDirectory)
+}
+
+// Gets the names of the macros referenced in the regex which are not escaped
+func getMacroReferences(pattern string) []string {
+	names := make([]string, 0)
+	for _, location := range macroReferenceRegex.FindAllStringSubmatchIndex(pattern, -1) {
+		if location[0] > 0 && pattern[location[0]-1] == '\\' {
+			continue
+		}
+		names = append(names, pattern[location[2]:location[3]])
+	}
+	return names
+}
+
+// Replaces the macro references in the regex with the regexes of the macros
+// The escaped references (\{{NAME}}) and the unknown macros are left as they are
+// @param pattern - the regex
+// @param macros - the macros by name
+// Returns the expanded regex
+func expandMacros(pattern string, macros map[string]string) string {
+	locations := macroReferenceRegex.FindAllStringSubmatchIndex(pattern, -1)
+	if len(locations) == 0 {
+		return pattern
+	}
+	var builder strings.Builder
+	last := 0
+	for _, location := range locations {
+		if location[0] > 0 && pattern[location[0]-1] == '\\' {
+			continue
+		}
+		macro, found := macros[pattern[location[2]:location[3]]]
+		if !found {
+			continue
+		}
+		builder.WriteString(pattern[last:location[0]])
+		builder.WriteString("(?:" + macro + ")")
+		last = location[1]
+	}
+	builder.WriteString(pattern[last:])
+	return builder.String()
+}
+
+// Converts the patterns of a list into a case insensitive regex which matches any of them
+func patternListToRegex(patterns []string) string {
+	quoted := make([]string, 0, len(patterns))
+	for _, pattern := range patterns {
+		quoted = append(quoted, regexp.QuoteMeta(pattern))
+	}
+	//The longest patterns are tried first so the reported match is the most specific one
+	sort.SliceStable(quoted, func(i, j int) bool {
+		return len(quoted[i]) > len(quoted[j])
+	})
+	return "(?i:" + strings.Join(quoted, "|") + ")"
+}
+
+// Gets all the matcher fields of the rule which can reference pattern lists and macros
+func getPatternFields(rule *Rule) []patternField {
+	fields := make([]patternField, 0)
+	addSearchMode := func(reference string, mode *RuleSearchMode) {
+		if mode != nil {
+			fields = append(fields, patternField{reference: reference, matchList: &mode.MatchList, regex: &mode.Regex})
+		}
+	}
+
+	if rule.Request != nil {
+		addSearchMode("request.method", rule.Request.Method)
+		for i, urlRule := range rule.Request.URL {
+			addSearchMode(indexedReference("request.url", i), urlRule)
+		}
+		for i, headerRule := range rule.Request.Headers {
+			fields = append(fields, patternField{reference: indexedReference("request.headers", i), matchList: &headerRule.MatchList, regex: &headerRule.Regex})
+		}
+		for i, parameterRule := range rule.Request.Parameters {
+			fields = append(fields, patternField{reference: indexedReference("request.params", i), matchList: &parameterRule.MatchList, regex: &parameterRule.Regex})
+		}
+		for i, bodyRule := range rule.Request.Body {
+			fields = append(fields, patternField{reference: indexedReference("request.body", i), matchList: &bodyRule.MatchList, regex: &bodyRule.Regex})
+		}
+		for i, cookieRule := range rule.Request.Cookies {
+			fields = append(fields, patternField{reference: indexedReference("request.cookies", i), matchList: &cookieRule.MatchList, regex: &cookieRule.Regex})
+		}
+		for i, fileRule := range rule.Request.Files {
+			addSearchMode(indexedReference("request.files", i)+".filename", fileRule.Filename)
+			addSearchMode(indexedReference("request.files", i)+".content-type", fileRule.ContentType)
+			addSearchMode(indexedReference("request.files", i)+".sniffed-content-type", fileRule.SniffedContentType)
+		}
+		for i, jsonRule := range rule.Request.JSON {
+			fields = append(fields, patternField{reference: indexedReference("request.json", i), matchList: &jsonRule.MatchList, regex: &jsonRule.Regex})
+		}
+		for i, xmlRule := range rule.Request.XML {
+			fields = append(fields, patternField{reference: indexedReference("request.xml", i), matchList: &xmlRule.MatchList, regex: &xmlRule.Regex})
+		}
+	}
+	if rule.Response != nil {
+		addSearchMode("response.code", rule.Response.Code)
+		for i, headerRule := range rule.Response.Headers {
+			fields = append(fields, patternField{reference: indexedReference("response.headers", i), matchList: &headerRule.MatchList, regex: &headerRule.Regex})
+		}
+		for i, bodyRule := range rule.Response.Body {
+			fields = append(fields, patternField{reference: indexedReference("response.body", i), matchList: &bodyRule.MatchList, regex: &bodyRule.Regex})
+		}
+		for i, jsonRule := range rule.Response.JSON {
+			fields = append(fields, patternField{reference: indexedReference("response.json", i), matchList: &jsonRule.MatchList, regex: &jsonRule.Regex})
+		}
+		for i, xmlRule := range rule.Response.XML {
+			fields = append(fields, patternField{reference: indexedReference("response.xml", i), matchList: &xmlRule.MatchList, regex: &xmlRule.Regex})
+		}
+	}
+	for i, wsRule := range rule.Websocket {
+		fields = append(fields, patternField{reference: indexedReference("websocket", i), matchList: &wsRule.MatchList, regex: &wsRule.Regex})
+	}
+	return fields
+}
+
+// Resolves the pattern lists and the macros referenced by the rule into regexes
+// The pattern list of a matcher is added to its regex as an alternative (the matcher matches the regex or any of the patterns)
+// The unknown lists and macros are left in the rule so they are reported by CheckRule
+// @param rule - the rule to be resolved
+func (library *PatternLibrary) ResolveRule(rule *Rule) {
+	for _, field := range getPatternFields(rule) {
+		*field.regex = expandMacros(*field.regex, library.Macros)
+		if *field.matchList == "" {
+			continue
+		}
+		patterns, found := library.Lists[*field.matchList]
+		if !found {
+			continue
+		}
+		listRegex := patternListToRegex(patterns)
+		if *field.regex == "" {
+			*field.regex = listRegex
+		} else {
+			*field.regex = "(?:" + *field.regex + ")|" + listRegex
+		}
+		*field.matchList = ""
+	}
+}
+
+// Checks if the rule references pattern lists or macros which could not be resolved
+// @param rule - the rule (after the pattern lists and the macros were resolved)
+// Returns an error describing the first unknown reference
+func CheckRulePatternReferences(rule Rule) error {
+	for _, field := range getPatternFields(&rule) {
+		if *field.matchList != "" {
+			return errors.New(field.reference + " references an unknown pattern list, " + *field.matchList + " (the lists are loaded from the " + PatternListsDirectory + " directory)")
+		}
+		if unknown := getMacroReferences(*field.regex); len(unknown) > 0 {
+			return errors.New(field.reference + " regex references an unknown macro, {{" + unknown[0] + "}}")
+		}
+	}
+	return nil
+}
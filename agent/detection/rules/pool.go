@@ -0,0 +1,128 @@
+package detection
+
+import (
+	"runtime"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/lucacoratu/disertatie/agent/data"
+)
+
+// Holds the result of running a rule
+type ruleEvaluation struct {
+	rule       Rule                    //The rule which was evaluated
+	findings   []*data.RuleFindingData //The findings of the rule (empty if the rule did not match)
+	suppressed []*data.RuleFindingData //The findings of the rule suppressed by the exclusions
+}
+
+// Gets the number of workers used to evaluate the rules
+// If the number is not specified in the configuration the number of CPUs is used
+func (rl *RuleRunner) getWorkersCount() int {
+	if rl.configuration.RuleWorkers > 0 {
+		return rl.configuration.RuleWorkers
+	}
+	return runtime.NumCPU()
+}
+
+// Checks if the evaluation of the rules can stop after the rule matched
+// In waf mode the request is blocked by the first matching rule with a blocking action so the other rules are not needed
+// The rules are sorted by the precedence of their actions, so the rules after it cannot have an action which is applied instead
+// In anomaly scoring mode all the rules are evaluated since every finding adds to the score
+func (rl *RuleRunner) stopsEvaluation(rule Rule) bool {
+	if !strings.EqualFold(rl.configuration.OperationMode, "waf") || rl.configuration.UsesAnomalyScoring() {
+		return false
+	}
+	return IsBlockingAction(rule.Info.Action)
+}
+
+// Evaluates a rule and records the evaluation in the counters of the rules
+// The rule is run on a copy of the runner holding the profile of the evaluation so the slow searches are counted for the rule
+// @param rule - the rule to evaluate
+// @param evaluate - the function which runs the rule
+// Returns the findings of the rule and the findings suppressed by the exclusions
+func (rl *RuleRunner) evaluateRule(rule Rule, evaluate func(runner *RuleRunner, rule Rule) ([]*data.RuleFindingData, []*data.RuleFindingData)) ([]*data.RuleFindingData, []*data.RuleFindingData) {
+	if rl.statistics == nil {
+		return evaluate(rl, rule)
+	}
+	runner := *rl
+	runner.profile = rl.newRuleProfile()
+	start := time.Now()
+	findings, suppressed := evaluate(&runner, rule)
+	rl.statistics.record(rule.Id, time.Since(start), len(findings) > 0, runner.profile)
+	return findings, suppressed
+}
+
+// Evaluates the candidate rules using a bounded pool of workers
+// The evaluations are returned in the order of the candidate rules, regardless of the order the workers finished them
+// In waf mode the rules after the first matching blocking rule are not evaluated
+// @param candidateRules - the indexes of the rules to be evaluated
+// @param evaluate - the function which runs a rule with the runner of the evaluation and returns its findings and the findings suppressed by the exclusions
+// Returns the evaluations of the rules
+func (rl *RuleRunner) evaluateRules(candidateRules []int, evaluate func(runner *RuleRunner, rule Rule) ([]*data.RuleFindingData, []*data.RuleFindingData)) []ruleEvaluation {
+	evaluations := make([]ruleEvaluation, len(candidateRules))
+	//The position of the first rule which stops the evaluation (the rules after it are skipped)
+	var stopPosition atomic.Int64
+	stopPosition.Store(int64(len(candidateRules)))
+
+	//Evaluate the rule at the position in the candidate list
+	evaluatePosition := func(position int) {
+		//Skip the rule if a blocking rule before it already matched
+		if int64(position) > stopPosition.Load() {
+			return
+		}
+		rule := rl.rules[candidateRules[position]]
+		findings, suppressed := rl.evaluateRule(rule, evaluate)
+		evaluations[position] = ruleEvaluation{rule: rule, findings: findings, suppressed: suppressed}
+		if len(evaluations[position].findings) > 0 && rl.stopsEvaluation(rule) {
+			//Keep the smallest position so the result does not depend on the order the workers finish
+			for {
+				current := stopPosition.Load()
+				if int64(position) >= current || stopPosition.CompareAndSwap(current, int64(position)) {
+					break
+				}
+			}
+		}
+	}
+
+	numberWorkers := rl.getWorkersCount()
+	if numberWorkers > len(candidateRules) {
+		numberWorkers = len(candidateRules)
+	}
+
+	if numberWorkers <= 1 {
+		//Not worth starting goroutines
+		for position := range candidateRules {
+			evaluatePosition(position)
+		}
+	} else {
+		positions := make(chan int)
+		var wg sync.WaitGroup
+		for i := 0; i < numberWorkers; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				for position := range positions {
+					evaluatePosition(position)
+				}
+			}()
+		}
+		for position := range candidateRules {
+			//Stop sending rules to the workers if a blocking rule already matched
+			if int64(position) > stopPosition.Load() {
+				break
+			}
+			positions <- position
+		}
+		close(positions)
+		wg.Wait()
+	}
+
+	//Discard the evaluations after the first matching blocking rule
+	lastPosition := int(stopPosition.Load())
+	if lastPosition < len(evaluations) {
+		evaluations = evaluations[:lastPosition+1]
+	}
+	return evaluations
+}
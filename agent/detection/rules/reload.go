@@ -0,0 +1,272 @@
+package detection
+
+import (
+	"errors"
+	"io/fs"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+	"github.com/lucacoratu/disertatie/agent/config"
+	"github.com/lucacoratu/disertatie/agent/logging"
+)
+
+// The time to wait after the last change in the rules directory before reloading (editors write files in multiple steps)
+const rulesReloadDebounce = 1 * time.Second
+
+// Holds the rule index currently used by the agent
+// The index is swapped atomically when the rules are reloaded so the requests in progress keep using the index they started with
+type RuleStore struct {
+	index        atomic.Pointer[RuleIndex]
+	correlations *CorrelationStore //The state of the correlation rules (kept when the index is swapped)
+	statistics   *RuleStatistics   //The performance and hit counters of the rules (kept when the index is swapped)
+}
+
+// Creates a new rule store holding the rule index
+func NewRuleStore(index *RuleIndex) *RuleStore {
+	store := &RuleStore{correlations: NewCorrelationStore(), statistics: NewRuleStatistics()}
+	store.index.Store(index)
+	return store
+}
+
+// Gets the state of the correlation rules
+func (store *RuleStore) GetCorrelations() *CorrelationStore {
+	return store.correlations
+}
+
+// Gets the performance and hit counters of the rules
+func (store *RuleStore) GetStatistics() *RuleStatistics {
+	return store.statistics
+}
+
+// Gets the rule index currently in use
+func (store *RuleStore) GetIndex() *RuleIndex {
+	return store.index.Load()
+}
+
+// Replaces the rule index in use
+// Returns the previous rule index
+func (store *RuleStore) SwapIndex(index *RuleIndex) *RuleIndex {
+	return store.index.Swap(index)
+}
+
+// Holds the differences between two rule sets
+type RulesDiff struct {
+	Added   []string //The ids of the rules which were added
+	Removed []string //The ids of the rules which were removed
+	Changed []string //The ids of the rules which were modified
+}
+
+// Checks if there is any difference between the rule sets
+func (diff RulesDiff) IsEmpty() bool {
+	return len(diff.Added) == 0 && len(diff.Removed) == 0 && len(diff.Changed) == 0
+}
+
+// Computes the differences between the old and the new rule sets based on the rule ids
+// @param oldRules - the rules currently in use
+// @param newRules - the rules which will replace them
+// Returns the ids of the added, removed and changed rules (sorted)
+func DiffRules(oldRules []Rule, newRules []Rule) RulesDiff {
+	diff := RulesDiff{Added: make([]string, 0), Removed: make([]string, 0), Changed: make([]string, 0)}
+
+	oldRulesMap := make(map[string]Rule, len(oldRules))
+	for _, rule := range oldRules {
+		oldRulesMap[rule.Id] = rule
+	}
+	newRulesMap := make(map[string]Rule, len(newRules))
+	for _, rule := range newRules {
+		newRulesMap[rule.Id] = rule
+		oldRule, found := oldRulesMap[rule.Id]
+		if !found {
+			diff.Added = append(diff.Added, rule.Id)
+		} else if !reflect.DeepEqual(oldRule, rule) {
+			diff.Changed = append(diff.Changed, rule.Id)
+		}
+	}
+	for _, rule := range oldRules {
+		if _, found := newRulesMap[rule.Id]; !found {
+			diff.Removed = append(diff.Removed, rule.Id)
+		}
+	}
+
+	sort.Strings(diff.Added)
+	sort.Strings(diff.Removed)
+	sort.Strings(diff.Changed)
+	return diff
+}
+
+// Reloads the rules from the rules directory when the files change or when requested (SIGHUP)
+type RuleReloader struct {
+	logger        logging.ILogger      //The logger
+	configuration config.Configuration //The configuration of the agent (the rules directory and the ignored directories)
+	store         *RuleStore           //The store holding the rule index in use
+	watcher       *fsnotify.Watcher    //The watcher of the rules directory
+	ruleFiles     map[string]Rule      //The rules in use mapped by the path of their files (an invalid file keeps its previous rule)
+	mu            sync.Mutex           //Mutex so that only one reload runs at a time
+}
+
+// Creates a new rule reloader
+func NewRuleReloader(logger logging.ILogger, configuration config.Configuration, store *RuleStore) *RuleReloader {
+	return &RuleReloader{logger: logger, configuration: configuration, store: store}
+}
+
+// Loads the rules from the rules directory, the rule files which are not valid are skipped
+// The files the rules were loaded from are remembered so the reloads can keep the previous version of a file which becomes invalid
+// Returns the list of rules or an error if the rules directory cannot be read
+func (reloader *RuleReloader) LoadRules() ([]Rule, error) {
+	reloader.mu.Lock()
+	defer reloader.mu.Unlock()
+
+	rules, ruleFiles, err := loadRuleFilesFromDirectory(reloader.configuration, reloader.logger, false, nil)
+	if err != nil {
+		return nil, err
+	}
+	reloader.ruleFiles = ruleFiles
+	return rules, nil
+}
+
+// Loads all the rules from the rules directory and the exclusions and swaps the rule index
+// The new rule files which are not valid are skipped, the modified rule files which are not valid keep the rule in use until they are fixed
+// If the exclusions file is not valid the rules in use are kept
+// Returns the differences between the rule sets or an error if the rules directory or the exclusions cannot be loaded
+func (reloader *RuleReloader) Reload() (RulesDiff, error) {
+	reloader.mu.Lock()
+	defer reloader.mu.Unlock()
+
+	newRules, newRuleFiles, err := loadRuleFilesFromDirectory(reloader.configuration, reloader.logger, false, reloader.ruleFiles)
+	if err != nil {
+		reloader.logger.Error("Rules reload failed, keeping the rules in use,", err.Error())
+		return RulesDiff{}, err
+	}
+
+	newExclusions, err := LoadConfiguredExclusions(reloader.configuration)
+	if err != nil {
+		reloader.logger.Error("Rules reload failed, keeping the rules in use,", err.Error())
+		return RulesDiff{}, err
+	}
+
+	oldIndex := reloader.store.GetIndex()
+	var oldRules []Rule = nil
+	var oldExclusions []*RuleExclusion = make([]*RuleExclusion, 0)
+	if oldIndex != nil {
+		oldRules = oldIndex.GetRules()
+		oldExclusions = oldIndex.GetExclusions()
+	}
+	reloader.ruleFiles = newRuleFiles
+	diff := DiffRules(oldRules, newRules)
+	exclusionsChanged := !reflect.DeepEqual(oldExclusions, newExclusions)
+	if diff.IsEmpty() && !exclusionsChanged {
+		reloader.logger.Info("Rules reloaded, no rule was modified")
+		return diff, nil
+	}
+
+	//Compile the new rules and swap the index
+	newIndex := NewRuleIndex(newRules, reloader.logger)
+	newIndex.SetExclusions(newExclusions)
+	reloader.store.SwapIndex(newIndex)
+	if exclusionsChanged {
+		reloader.logger.Info("Exclusions reloaded,", len(newExclusions), "exclusions in use")
+	}
+	reloader.logger.Info("Rules reloaded,", len(newRules), "rules in use, added:", strings.Join(diff.Added, ", "), "removed:", strings.Join(diff.Removed, ", "), "changed:", strings.Join(diff.Changed, ", "))
+	return diff, nil
+}
+
+// Adds the rules directory and all its subdirectories to the watcher
+func (reloader *RuleReloader) watchDirectories(root string) error {
+	return filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() {
+			return reloader.watcher.Add(path)
+		}
+		return nil
+	})
+}
+
+// Starts watching the rules directory for changes
+// The events are debounced and trigger a reload of the rules
+// Returns an error if the watcher cannot be created
+func (reloader *RuleReloader) Start() error {
+	if reloader.configuration.RulesDirectory == "" {
+		return errors.New("the rules directory is not specified")
+	}
+
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return errors.New("could not create the rules directory watcher, " + err.Error())
+	}
+	reloader.watcher = watcher
+
+	err = reloader.watchDirectories(reloader.configuration.RulesDirectory)
+	if err != nil {
+		watcher.Close()
+		return errors.New("could not watch the rules directory, " + err.Error())
+	}
+	//Watch the directory of the exclusions file as well (the file can be replaced by the editors)
+	if reloader.configuration.ExclusionsFile != "" {
+		err = watcher.Add(filepath.Dir(reloader.configuration.ExclusionsFile))
+		if err != nil {
+			reloader.logger.Warning("The exclusions will not be reloaded when the file changes,", err.Error())
+		}
+	}
+
+	go reloader.run()
+	return nil
+}
+
+// Handles the events from the watcher
+func (reloader *RuleReloader) run() {
+	var timer *time.Timer = nil
+	reloadChannel := make(chan struct{}, 1)
+
+	for {
+		select {
+		case event, ok := <-reloader.watcher.Events:
+			if !ok {
+				return
+			}
+			//Watch the new directories as well
+			if event.Has(fsnotify.Create) {
+				if err := reloader.watchDirectories(event.Name); err != nil {
+					reloader.logger.Debug("Could not watch", event.Name, err.Error())
+				}
+			}
+			//Only the rule files and the directories are relevant
+			if !event.Has(fsnotify.Remove) && !event.Has(fsnotify.Rename) && filepath.Ext(event.Name) != ".yaml" && !event.Has(fsnotify.Create) && filepath.Clean(event.Name) != filepath.Clean(reloader.configuration.ExclusionsFile) {
+				continue
+			}
+			//Wait for the changes to settle before reloading
+			if timer != nil {
+				timer.Stop()
+			}
+			timer = time.AfterFunc(rulesReloadDebounce, func() {
+				select {
+				case reloadChannel <- struct{}{}:
+				default:
+				}
+			})
+		case <-reloadChannel:
+			reloader.logger.Info("Change detected in the rules directory, reloading the rules")
+			reloader.Reload()
+		case err, ok := <-reloader.watcher.Errors:
+			if !ok {
+				return
+			}
+			reloader.logger.Error("Error occured when watching the rules directory", err.Error())
+		}
+	}
+}
+
+// Stops watching the rules directory
+func (reloader *RuleReloader) Close() error {
+	if reloader.watcher == nil {
+		return nil
+	}
+	return reloader.watcher.Close()
+}
@@ -0,0 +1,122 @@
+package detection
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/lucacoratu/disertatie/agent/config"
+	"github.com/lucacoratu/disertatie/agent/logging"
+)
+
+// Creates the content of a rule file matching the payload in the parameters
+func newReloadTestRule(id string, payload string) string {
+	return "id: " + id + `
+info:
+  name: Reload test rule
+  description: Matches the test payload
+  severity: high
+  classification: sqli
+request:
+  params:
+    - name: any
+      match: ` + payload + "\n"
+}
+
+// A rule file which is parsed but rejected by CheckRule
+const invalidReloadTestRule = `id: ReloadA
+info:
+  name: Reload test rule
+  severity: unknown
+request:
+  params:
+    - name: any
+      match: payload
+`
+
+func TestReload(t *testing.T) {
+	logger := logging.NewDefaultLogger()
+	configuration := config.Configuration{RulesDirectory: t.TempDir()}
+	store := NewRuleStore(NewRuleIndex(nil, logger))
+	reloader := NewRuleReloader(logger, configuration, store)
+	empty := []string{}
+
+	tests := []struct {
+		name     string
+		files    map[string]string //The content of the files written before the reload (empty to remove the file)
+		expected RulesDiff
+		payloads map[string]string //The payload of the rules in use after the reload
+	}{
+		{
+			"initial rules",
+			map[string]string{"a.yaml": newReloadTestRule("ReloadA", "alpha"), "b.yaml": newReloadTestRule("ReloadB", "beta")},
+			RulesDiff{Added: []string{"ReloadA", "ReloadB"}, Removed: empty, Changed: empty},
+			map[string]string{"ReloadA": "alpha", "ReloadB": "beta"},
+		},
+		{
+			"changed rule",
+			map[string]string{"a.yaml": newReloadTestRule("ReloadA", "alpha-2")},
+			RulesDiff{Added: empty, Removed: empty, Changed: []string{"ReloadA"}},
+			map[string]string{"ReloadA": "alpha-2", "ReloadB": "beta"},
+		},
+		{
+			"invalid changed rule keeps the previous version",
+			map[string]string{"a.yaml": invalidReloadTestRule},
+			RulesDiff{Added: empty, Removed: empty, Changed: empty},
+			map[string]string{"ReloadA": "alpha-2", "ReloadB": "beta"},
+		},
+		{
+			"invalid new rule is skipped",
+			map[string]string{"c.yaml": "id: ReloadC\nrequest: [\n", "d.yaml": newReloadTestRule("ReloadD", "delta")},
+			RulesDiff{Added: []string{"ReloadD"}, Removed: empty, Changed: empty},
+			map[string]string{"ReloadA": "alpha-2", "ReloadB": "beta", "ReloadD": "delta"},
+		},
+		{
+			"removed rule",
+			map[string]string{"b.yaml": ""},
+			RulesDiff{Added: empty, Removed: []string{"ReloadB"}, Changed: empty},
+			map[string]string{"ReloadA": "alpha-2", "ReloadD": "delta"},
+		},
+		{
+			"fixed rule",
+			map[string]string{"a.yaml": newReloadTestRule("ReloadA", "alpha-3"), "c.yaml": newReloadTestRule("ReloadC", "gamma")},
+			RulesDiff{Added: []string{"ReloadC"}, Removed: empty, Changed: []string{"ReloadA"}},
+			map[string]string{"ReloadA": "alpha-3", "ReloadC": "gamma", "ReloadD": "delta"},
+		},
+	}
+	for _, test := range tests {
+		for name, content := range test.files {
+			path := filepath.Join(configuration.RulesDirectory, name)
+			var err error
+			if content == "" {
+				err = os.Remove(path)
+			} else {
+				err = os.WriteFile(path, []byte(content), 0644)
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		diff, err := reloader.Reload()
+		if err != nil {
+			t.Fatalf("%s: %v", test.name, err)
+		}
+		if !reflect.DeepEqual(diff, test.expected) {
+			t.Errorf("%s: expected the diff %+v, got %+v", test.name, test.expected, diff)
+		}
+		rules := store.GetIndex().GetRules()
+		if len(rules) != len(test.payloads) {
+			t.Errorf("%s: expected %d rules in use, got %d", test.name, len(test.payloads), len(rules))
+		}
+		for id, payload := range test.payloads {
+			rule := GetRule(rules, id)
+			if rule == nil {
+				t.Errorf("%s: expected the rule %s to be in use", test.name, id)
+			} else if rule.Request.Parameters[0].Match != payload {
+				t.Errorf("%s: expected the rule %s to match %s, got %s", test.name, id, payload, rule.Request.Parameters[0].Match)
+			}
+		}
+	}
+}
@@ -8,84 +8,223 @@ import (
 
 // Holds all the information in the info field of the rule.yaml files
 type RuleInfo struct {
-	Name           string   `yaml:"name"`           //The name of the rule
-	Description    string   `yaml:"description"`    //The description of the rule
-	Severity       string   `yaml:"severity"`       //The severity of the rule, in the string representation
-	Classification string   `yaml:"classification"` //The classification if it matches, in the string representation
-	Action         string   `yaml:"action"`         //The action that should be taken if anything matches the rule (only for waf operation mode) (drop or allow)
-	Encodings      []string `yaml:"encodings"`      //The encodings supported when searching (this will apply to all the fields)
+	Name             string                `yaml:"name,omitempty"`              //The name of the rule
+	Description      string                `yaml:"description,omitempty"`       //The description of the rule
+	Severity         string                `yaml:"severity,omitempty"`          //The severity of the rule, in the string representation
+	Classification   string                `yaml:"classification,omitempty"`    //The classification if it matches, in the string representation
+	Action           string                `yaml:"action,omitempty"`            //The action that should be taken if anything matches the rule (only for waf operation mode) (allow, log, drop, deceive, tarpit, redirect, respond)
+	ActionParameters *RuleActionParameters `yaml:"action-parameters,omitempty"` //The parameters of the action (the redirect URL, the custom response)
+	Encodings        []string              `yaml:"encodings,omitempty"`         //The encodings supported when searching (this will apply to all the fields)
+	Tags             []string              `yaml:"tags,omitempty"`              //The tags of the rule (cve, wordpress, rce)
+	References       []string              `yaml:"references,omitempty"`        //The links to the advisories and the write-ups of the vulnerability
+	CVSSScore        float64               `yaml:"cvss-score,omitempty"`        //The CVSS score of the vulnerability detected by the rule
+	CVSSMetrics      string                `yaml:"cvss-metrics,omitempty"`      //The CVSS vector of the vulnerability (CVSS:3.1/AV:N/AC:L/...)
+	CWEIds           []string              `yaml:"cwe-ids,omitempty"`           //The CWE ids of the weakness exploited by the attack (CWE-89)
+	MitreTechniques  []string              `yaml:"mitre-techniques,omitempty"`  //The MITRE ATT&CK techniques of the attack (T1190, T1059.004)
+}
+
+// Holds the parameters of the rule action
+type RuleActionParameters struct {
+	RedirectURL string            `yaml:"redirect-url,omitempty"` //The URL where the client is redirected (redirect action), if missing the URL from the configuration is used
+	Status      int               `yaml:"status,omitempty"`       //The status code of the response (respond action), 403 if missing
+	Headers     map[string]string `yaml:"headers,omitempty"`      //The headers of the response (respond action)
+	BodyFile    string            `yaml:"body-file,omitempty"`    //The path to the file with the body of the response (respond action)
+}
+
+// Holds the requests a rule applies to
+// A rule is evaluated only if the request matches all the fields which are specified
+type RuleScope struct {
+	Hosts       []string `yaml:"hosts,omitempty"`      //The hosts (case insensitive, *.example.com matches all the subdomains)
+	Paths       []string `yaml:"paths,omitempty"`      //The globs of the path (* matches inside a path segment, ** matches anything)
+	PathRegexes []string `yaml:"path-regex,omitempty"` //The regexes of the path (the path matches if it matches a glob or a regex)
+	Methods     []string `yaml:"methods,omitempty"`    //The methods (case insensitive)
 }
 
 // Holds all the modes the hex search can be made
 type RuleHexSearchMod struct {
-	Match string `yaml:"match"` //The hex string to find
-	Regex string `yaml:"regex"` //The regex (as hex - except regex special chars) to match
+	Match string `yaml:"match,omitempty"` //The hex string to find
+	Regex string `yaml:"regex,omitempty"` //The regex (as hex - except regex special chars) to match
 }
 
 // Holds all the modes the search can be made
 type RuleSearchMode struct {
-	Match     string   `yaml:"match"`     //The string to match exactly
-	Regex     string   `yaml:"regex"`     //The regex used for searching
-	Encodings []string `yaml:"encodings"` //The encodings supported when searching
+	Match     string   `yaml:"match,omitempty"`      //The string to match exactly
+	Regex     string   `yaml:"regex,omitempty"`      //The regex used for searching
+	MatchList string   `yaml:"match_list,omitempty"` //The name of the pattern list (from the lists directory) whose patterns are searched
+	Encodings []string `yaml:"encodings,omitempty"`  //The encodings supported when searching
 }
 
 // Holds all the information about headers
 type HeadersRule struct {
-	Name      string   `yaml:"name"`      //The name of the search to search for matches
-	Match     string   `yaml:"match"`     //The string to match exactly
-	Regex     string   `yaml:"regex"`     //The regex used for searching
-	Encodings []string `yaml:"encodings"` //The encodings supported when searching
+	Name      string   `yaml:"name,omitempty"`       //The name of the header to search for matches (can be any which means look through all the headers for a match)
+	Match     string   `yaml:"match,omitempty"`      //The string to match exactly
+	Regex     string   `yaml:"regex,omitempty"`      //The regex used for searching
+	MatchList string   `yaml:"match_list,omitempty"` //The name of the pattern list (from the lists directory) whose patterns are searched
+	Encodings []string `yaml:"encodings,omitempty"`  //The encodings supported when searching
 }
 
 // Holds all the information about request parameters
 type RequestParametersRule struct {
-	Name      string   `yaml:"name"`      //The name of the query variable (can be any which means look through all the query variable names for a match)
-	Match     string   `yaml:"match"`     //The string to match exactly
-	Regex     string   `yaml:"regex"`     //The regex used for searching
-	Encodings []string `yaml:"encodings"` //The encodings supported when searching
+	Name      string   `yaml:"name,omitempty"`       //The name of the query variable (can be any which means look through all the query variable names for a match)
+	Match     string   `yaml:"match,omitempty"`      //The string to match exactly
+	Regex     string   `yaml:"regex,omitempty"`      //The regex used for searching
+	MatchList string   `yaml:"match_list,omitempty"` //The name of the pattern list (from the lists directory) whose patterns are searched
+	Encodings []string `yaml:"encodings,omitempty"`  //The encodings supported when searching
+}
+
+// Holds all the information about request cookies
+type CookiesRule struct {
+	Name      string   `yaml:"name,omitempty"`       //The name of the cookie (can be any which means look through all the cookies for a match)
+	Match     string   `yaml:"match,omitempty"`      //The string to match exactly
+	Regex     string   `yaml:"regex,omitempty"`      //The regex used for searching
+	MatchList string   `yaml:"match_list,omitempty"` //The name of the pattern list (from the lists directory) whose patterns are searched
+	Encodings []string `yaml:"encodings,omitempty"`  //The encodings supported when searching
+}
+
+// Holds all the information about the values selected from a JSON body
+type JSONPathRule struct {
+	Path      string   `yaml:"path,omitempty"`       //The JSONPath selector of the values ($.filter.name, $..name, $.items[*].id)
+	Match     string   `yaml:"match,omitempty"`      //The string to match exactly
+	Regex     string   `yaml:"regex,omitempty"`      //The regex used for searching
+	MatchList string   `yaml:"match_list,omitempty"` //The name of the pattern list (from the lists directory) whose patterns are searched
+	Encodings []string `yaml:"encodings,omitempty"`  //The encodings supported when searching
+}
+
+// Holds all the information about the values selected from a XML body
+type XMLPathRule struct {
+	Path      string   `yaml:"path,omitempty"`       //The XPath selector of the nodes (//user/name, /order/@id)
+	Match     string   `yaml:"match,omitempty"`      //The string to match exactly
+	Regex     string   `yaml:"regex,omitempty"`      //The regex used for searching
+	MatchList string   `yaml:"match_list,omitempty"` //The name of the pattern list (from the lists directory) whose patterns are searched
+	Encodings []string `yaml:"encodings,omitempty"`  //The encodings supported when searching
+}
+
+// Holds all the information about the files uploaded in multipart/form-data requests
+// All the criteria specified should be satisfied by the same file for the matcher to match
+type FilesRule struct {
+	Field               string          `yaml:"field,omitempty"`                 //The name of the form field of the file (can be any or empty which means all the files)
+	Filename            *RuleSearchMode `yaml:"filename,omitempty"`              //The modes to search on the filename
+	ContentType         *RuleSearchMode `yaml:"content-type,omitempty"`          //The modes to search on the content type declared in the part headers
+	SniffedContentType  *RuleSearchMode `yaml:"sniffed-content-type,omitempty"`  //The modes to search on the content type detected from the file content
+	ContentTypeMismatch bool            `yaml:"content-type-mismatch,omitempty"` //Matches if the declared content type is different from the detected one
+	MinSize             int64           `yaml:"min-size,omitempty"`              //The minimum size of the file in bytes (0 means no limit)
+	MaxSize             int64           `yaml:"max-size,omitempty"`              //The maximum size of the file in bytes (0 means no limit)
+	SHA256Sum           string          `yaml:"sha256sum,omitempty"`             //The SHA256 hash of the file
+	MD5Sum              string          `yaml:"md5sum,omitempty"`                //The MD5 hash of the file
 }
 
 // Holds all the information about the body
 type BodyRule struct {
-	SHA256Sum string   `yaml:"sha256sum"` //The SHA256 hash of the body to match
-	MD5Sum    string   `yaml:"md5sum"`    //The MD5 hash of the body
-	Match     string   `yaml:"match"`     //The string to match exactly
-	Regex     string   `yaml:"regex"`     //The regex used for searching
-	Encodings []string `yaml:"encodings"` //The encodings supported when searching
+	SHA256Sum string   `yaml:"sha256sum,omitempty"`  //The SHA256 hash of the body to match
+	MD5Sum    string   `yaml:"md5sum,omitempty"`     //The MD5 hash of the body
+	Match     string   `yaml:"match,omitempty"`      //The string to match exactly
+	Regex     string   `yaml:"regex,omitempty"`      //The regex used for searching
+	MatchList string   `yaml:"match_list,omitempty"` //The name of the pattern list (from the lists directory) whose patterns are searched
+	Encodings []string `yaml:"encodings,omitempty"`  //The encodings supported when searching
 }
 
 // Holds all the information about the websocket message
 type WebsocketRule struct {
-	MessageType int    `yaml:"message_type"` //The type of the websocket message (can be 1 - TextMessage, 2 - BinaryMessage, 8 - CloseMessage, 9 - PingMessage, 10 - PongMessage) RFC 6455, section 11.8.
-	Match       string `yaml:"match"`        //The string to find in message
-	Regex       string `yaml:"regex"`        //The regex used for matching
-	HexMatch    string `yaml:"hexmatch"`     //The hexstring to find in message
-	HexRegex    string `yaml:"hexregex"`     //The regex which contains hex bytes used for matching
+	MessageType int      `yaml:"message_type,omitempty"` //The type of the websocket message (can be 1 - TextMessage, 2 - BinaryMessage, 8 - CloseMessage, 9 - PingMessage, 10 - PongMessage) RFC 6455, section 11.8.
+	Direction   string   `yaml:"direction,omitempty"`    //The direction of the messages (client-to-server, server-to-client), both directions if missing
+	JSONPath    string   `yaml:"json-path,omitempty"`    //The JSONPath selector of the values searched in the JSON text messages (the whole message is searched if missing)
+	Header      string   `yaml:"header,omitempty"`       //The name of the header of the upgrade request which is searched instead of the message (can be any)
+	Match       string   `yaml:"match,omitempty"`        //The string to find in message
+	Regex       string   `yaml:"regex,omitempty"`        //The regex used for matching
+	MatchList   string   `yaml:"match_list,omitempty"`   //The name of the pattern list (from the lists directory) whose patterns are searched
+	HexMatch    string   `yaml:"hexmatch,omitempty"`     //The hexstring to find in message
+	HexRegex    string   `yaml:"hexregex,omitempty"`     //The regex which contains hex bytes used for matching
+	Encodings   []string `yaml:"encodings,omitempty"`    //The encodings supported when searching the text messages and the headers
 }
 
 // Holds all the information in the request field of the rule YAML file
 type RequestRule struct {
-	Method     *RuleSearchMode          `yaml:"method"`  //The modes to search on the method
-	URL        []*RuleSearchMode        `yaml:"url"`     //The modes to search on the URL
-	Headers    []*HeadersRule           `yaml:"headers"` //The headers to be checked
-	Parameters []*RequestParametersRule `yaml:"params"`  //The request parameters (both from URL and body)
-	Body       []*BodyRule              `yaml:"body"`    //The string to search for in the body
+	Method     *RuleSearchMode          `yaml:"method,omitempty"`  //The modes to search on the method
+	URL        []*RuleSearchMode        `yaml:"url,omitempty"`     //The modes to search on the URL
+	Headers    []*HeadersRule           `yaml:"headers,omitempty"` //The headers to be checked
+	Parameters []*RequestParametersRule `yaml:"params,omitempty"`  //The request parameters (both from URL and body)
+	Body       []*BodyRule              `yaml:"body,omitempty"`    //The string to search for in the body
+	Cookies    []*CookiesRule           `yaml:"cookies,omitempty"` //The cookies to be checked
+	JSON       []*JSONPathRule          `yaml:"json,omitempty"`    //The values selected from the JSON body to be checked
+	XML        []*XMLPathRule           `yaml:"xml,omitempty"`     //The values selected from the XML body to be checked
+	Files      []*FilesRule             `yaml:"files,omitempty"`   //The files uploaded in the multipart body to be checked
+}
+
+// Holds the bounds of a numeric matcher (the latency and the size of the response)
+// The value matches if it is greater than gt and less than lt, the bounds which are not specified are not checked
+type RuleRangeMatch struct {
+	Gt *int64 `yaml:"gt,omitempty"` //The value should be greater than this
+	Lt *int64 `yaml:"lt,omitempty"` //The value should be less than this
+}
+
+// Holds the bounds of the time the web server took to respond (in milliseconds)
+type RuleLatencyMatch struct {
+	RuleRangeMatch `yaml:",inline"`
+	Measure        string `yaml:"measure,omitempty"` //What is measured: ttfb - until the first byte of the response (default), total - until the whole response is received
 }
 
 // Holds all the information in the response field of the rule YAML file
 type ResponseRule struct {
-	Code    *RuleSearchMode `yaml:"code"`    //The modes to search on the status code
-	Headers []*HeadersRule  `yaml:"headers"` //The headers to be checked
-	Body    []*BodyRule     `yaml:"body"`    //The string to search for in the body
+	Code           *RuleSearchMode   `yaml:"code,omitempty"`            //The modes to search on the status code
+	Headers        []*HeadersRule    `yaml:"headers,omitempty"`         //The headers to be checked
+	Body           []*BodyRule       `yaml:"body,omitempty"`            //The string to search for in the body
+	JSON           []*JSONPathRule   `yaml:"json,omitempty"`            //The values selected from the JSON body to be checked
+	XML            []*XMLPathRule    `yaml:"xml,omitempty"`             //The values selected from the XML body to be checked
+	Latency        *RuleLatencyMatch `yaml:"latency,omitempty"`         //The time the web server took to respond in milliseconds (time based blind attacks)
+	Size           *RuleRangeMatch   `yaml:"size,omitempty"`            //The size of the response body in bytes (data dumps)
+	ConfirmRequest bool              `yaml:"confirm-request,omitempty"` //The response matchers apply only if the request matchers of the rule matched the request of the response (the request matchers do not report findings on their own)
+}
+
+// Holds the filters of the requests counted by a correlation rule
+// The request is counted only if it matches all the fields which are specified (the hosts, the paths and the methods are filtered by the scope of the rule)
+type CorrelationFilter struct {
+	Status          []int    `yaml:"status,omitempty"`          //The status codes of the responses
+	Rules           []string `yaml:"rules,omitempty"`           //The ids of the rules which should have matched the request or its response (prior findings)
+	Classifications []string `yaml:"classifications,omitempty"` //The classifications of the rules which should have matched the request or its response (case insensitive)
+}
+
+// Holds a correlation rule which counts the requests of the same client over a sliding window
+// The rule matches when the number of requests (or the number of distinct values) from the same key reaches the threshold in the window
+type CorrelationRule struct {
+	Keys          []string           `yaml:"keys,omitempty"`           //The values which identify the client (ip, session, user-agent)
+	SessionCookie string             `yaml:"session-cookie,omitempty"` //The name of the session cookie (used by the session key and distinct value)
+	Window        string             `yaml:"window,omitempty"`         //The length of the sliding window (30s, 1m, 1h)
+	Threshold     int                `yaml:"threshold,omitempty"`      //The number of requests (or distinct values) which trips the rule
+	Distinct      string             `yaml:"distinct,omitempty"`       //Count the distinct values instead of the requests (path, method, status, ip, session, user-agent, parameter)
+	Parameter     string             `yaml:"parameter,omitempty"`      //The name of the GET or POST parameter whose distinct values are counted (the usernames for credential stuffing)
+	Filter        *CorrelationFilter `yaml:"filter,omitempty"`         //The requests which are counted (all the requests in the scope if missing)
+}
+
+// Holds the websocket frame of a rule test
+type RuleTestWebsocket struct {
+	MessageType int    `yaml:"message_type,omitempty"` //The type of the websocket message (1 - TextMessage, 2 - BinaryMessage), default 1
+	Direction   string `yaml:"direction,omitempty"`    //The direction of the message (client-to-server, server-to-client), default client-to-server
+	Message     string `yaml:"message,omitempty"`      //The content of the message (hex encoded for binary messages)
+}
+
+// Holds a sample of traffic the rule is tested on and the expected result
+// Only one of the request, response and websocket samples should be specified in a test (the request can be specified together with the response or the websocket message, as the upgrade request)
+type RuleTest struct {
+	Name      string             `yaml:"name,omitempty"`      //The name of the test (used in the report)
+	Request   string             `yaml:"request,omitempty"`   //The raw HTTP request (together with the response for the rules confirming the request, together with the websocket message as the upgrade request)
+	Response  string             `yaml:"response,omitempty"`  //The raw HTTP response
+	Latency   int64              `yaml:"latency,omitempty"`   //The time the web server took to send the response in milliseconds (used by the latency matchers)
+	Websocket *RuleTestWebsocket `yaml:"websocket,omitempty"` //The websocket message
+	Expect    string             `yaml:"expect,omitempty"`    //The expected result (match or no-match)
 }
 
 // Structure which holds all the information about the rule parsed from the rule.yaml file
 type Rule struct {
-	Id        string           `yaml:"id"`        //The ID of the rule (should be unique)
-	Info      *RuleInfo        `yaml:"info"`      //The info structure
-	Request   *RequestRule     `yaml:"request"`   //The request matchers
-	Response  *ResponseRule    `yaml:"response"`  //The response matchers
-	Websocket []*WebsocketRule `yaml:"websocket"` //The websocket matchers
+	Id                string           `yaml:"id,omitempty"`                 //The ID of the rule (should be unique)
+	Info              *RuleInfo        `yaml:"info,omitempty"`               //The info structure
+	Scope             *RuleScope       `yaml:"scope,omitempty"`              //The requests the rule applies to (all the requests if missing)
+	Request           *RequestRule     `yaml:"request,omitempty"`            //The request matchers
+	Response          *ResponseRule    `yaml:"response,omitempty"`           //The response matchers
+	Websocket         []*WebsocketRule `yaml:"websocket,omitempty"`          //The websocket matchers
+	Correlation       *CorrelationRule `yaml:"correlation,omitempty"`        //The correlation of the requests of the same client (cannot be used together with the matchers)
+	MatchersCondition string           `yaml:"matchers-condition,omitempty"` //How the matchers of a phase are combined when no condition is specified (or, and) - default or
+	Condition         *RuleConditions  `yaml:"condition,omitempty"`          //The boolean composition (and, or, not) of the matchers for each phase
+	Tests             []*RuleTest      `yaml:"tests,omitempty"`              //The samples of traffic the rule should and should not match
 }
 
 // Function to read the yaml rule from a reader into the struct
@@ -94,8 +233,23 @@ func (yr *Rule) FromYAML(r io.Reader) error {
 	return d.Decode(yr)
 }
 
+// Function to write the rule as yaml into a writer (the empty fields are omitted)
+func (yr *Rule) ToYAML(w io.Writer) error {
+	e := yaml.NewEncoder(w)
+	defer e.Close()
+	return e.Encode(yr)
+}
+
+// Function to read the yaml rule from a reader into the struct, rejecting the unknown and the duplicated keys (regx instead of regex)
+func (yr *Rule) FromYAMLStrict(r io.Reader) error {
+	d := yaml.NewDecoder(r)
+	d.SetStrict(true)
+	return d.Decode(yr)
+}
+
 // Holds all the information about the matched hash of the body
 type BodyHashMatch struct {
 	BodyHash          string
 	BodyHashAlgorithm string
+	Path              string //The uploaded file which matched (empty for the whole body)
 }
@@ -0,0 +1,231 @@
+package detection
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// The rule sets received from the API are stored in this subdirectory of the rules directory
+// The files are owned by the API, the local changes are overwritten by the next rule set update
+const (
+	ManagedRulesDirectory    = "managed"         //The directory holding the rules of the rule set (one file for each rule)
+	ruleSetVersionFile       = "ruleset.version" //The file inside the managed directory holding the version of the rule set
+	ruleSetStagingDirsPrefix = ".managed-"       //The prefix of the directories where the rule set is written before replacing the managed directory
+)
+
+// The rule ids are used as file names so they cannot contain path separators
+var ruleSetIdRegex = regexp.MustCompile(`^[A-Za-z0-9_.-]+$`)
+
+// Checks if the path is the file holding the version of the rule set
+func isRuleSetVersionFile(rulesDirectory string, path string) bool {
+	return filepath.Clean(path) == filepath.Join(rulesDirectory, ManagedRulesDirectory, ruleSetVersionFile)
+}
+
+// Checks if the path is a directory where a rule set is written before being applied
+func isRuleSetStagingDirectory(rulesDirectory string, path string) bool {
+	return filepath.Dir(filepath.Clean(path)) == filepath.Clean(rulesDirectory) && strings.HasPrefix(filepath.Base(path), ruleSetStagingDirsPrefix)
+}
+
+// Gets the version of the rule set received from the API which is stored in the rules directory
+// @param rulesDirectory - the rules directory
+// Returns the version or 0 if no rule set was received
+func GetRuleSetVersion(rulesDirectory string) int64 {
+	content, err := os.ReadFile(filepath.Join(rulesDirectory, ManagedRulesDirectory, ruleSetVersionFile))
+	if err != nil {
+		return 0
+	}
+	version, err := strconv.ParseInt(strings.TrimSpace(string(content)), 10, 64)
+	if err != nil {
+		return 0
+	}
+	return version
+}
+
+// Parses and validates the rules of a rule set the same way the rule files are validated when loaded from disk
+// @param ruleFiles - the YAML content of the rules mapped by the rule id
+// @param library - the pattern lists and the macros referenced by the rules
+// @param strict - if the unknown keys and the lint errors should reject the rules
+// Returns the rules sorted by id or an error describing the first rule which is not valid
+func (reloader *RuleReloader) parseRuleSet(ruleFiles map[string]string, library *PatternLibrary, strict bool) ([]Rule, error) {
+	ids := make([]string, 0, len(ruleFiles))
+	for id := range ruleFiles {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+
+	rules := make([]Rule, 0, len(ids))
+	for _, id := range ids {
+		if !ruleSetIdRegex.MatchString(id) {
+			return nil, errors.New("rule id can contain only letters, digits, dots, dashes and underscores, " + id)
+		}
+		rule := Rule{}
+		var err error
+		if strict {
+			err = rule.FromYAMLStrict(strings.NewReader(ruleFiles[id]))
+		} else {
+			err = rule.FromYAML(strings.NewReader(ruleFiles[id]))
+		}
+		if err != nil {
+			return nil, errors.New("rule " + id + " error when parsing, " + err.Error())
+		}
+		if rule.Id != id {
+			return nil, errors.New("rule " + id + " has a different id in its content, " + rule.Id)
+		}
+		library.ResolveRule(&rule)
+		if err := CheckRule(rule, reloader.logger); err != nil {
+			return nil, errors.New("rule " + id + " error when checking rule, " + err.Error())
+		}
+		if strict {
+			if err := firstLintError(LintRule(rule)); err != nil {
+				return nil, errors.New("rule " + id + " error when linting rule, " + err.Error())
+			}
+		}
+		if err := HandleEncodingsField(&rule); err != nil {
+			return nil, errors.New("rule " + id + " error occured when handling encodings lists " + err.Error())
+		}
+		rules = append(rules, rule)
+	}
+	return rules, nil
+}
+
+// Writes the rule set into the managed directory
+// The rules are written in a staging directory first which then replaces the managed directory
+// @param version - the version of the rule set
+// @param ruleFiles - the YAML content of the rules mapped by the rule id
+// Returns an error if the rule set could not be written
+func (reloader *RuleReloader) writeRuleSet(version int64, ruleFiles map[string]string) error {
+	rulesDirectory := reloader.configuration.RulesDirectory
+	stagingDirectory, err := os.MkdirTemp(rulesDirectory, ruleSetStagingDirsPrefix)
+	if err != nil {
+		return errors.New("could not create the rule set directory, " + err.Error())
+	}
+	defer os.RemoveAll(stagingDirectory)
+
+	for id, content := range ruleFiles {
+		if err := os.WriteFile(filepath.Join(stagingDirectory, id+".yaml"), []byte(content), 0644); err != nil {
+			return errors.New("could not write rule " + id + ", " + err.Error())
+		}
+	}
+	if err := os.WriteFile(filepath.Join(stagingDirectory, ruleSetVersionFile), []byte(strconv.FormatInt(version, 10)), 0644); err != nil {
+		return errors.New("could not write the rule set version, " + err.Error())
+	}
+	//The staging directory is created with restricted permissions
+	if err := os.Chmod(stagingDirectory, 0755); err != nil {
+		return errors.New("could not change the permissions of the rule set directory, " + err.Error())
+	}
+
+	//Move the current rule set aside so the new one can take its place
+	managedDirectory := filepath.Join(rulesDirectory, ManagedRulesDirectory)
+	previousDirectory := ""
+	if _, err := os.Stat(managedDirectory); err == nil {
+		previousDirectory = stagingDirectory + ".old"
+		if err := os.Rename(managedDirectory, previousDirectory); err != nil {
+			return errors.New("could not replace the rule set directory, " + err.Error())
+		}
+	}
+	if err := os.Rename(stagingDirectory, managedDirectory); err != nil {
+		//Restore the previous rule set
+		if previousDirectory != "" {
+			os.Rename(previousDirectory, managedDirectory)
+		}
+		return errors.New("could not replace the rule set directory, " + err.Error())
+	}
+	if previousDirectory != "" {
+		os.RemoveAll(previousDirectory)
+	}
+	return nil
+}
+
+// Applies a rule set received from the API
+// The rules are validated, together with the local rules, before anything is changed, then they are written into the managed directory and the rule index is swapped
+// If the rule set is not valid or it is not newer than the rule set in use the rules in use are kept
+// @param version - the version of the rule set
+// @param ruleFiles - the YAML content of the rules mapped by the rule id
+// Returns the version of the rule set in use, the number of rules in use and an error if the rule set was rejected
+func (reloader *RuleReloader) ApplyRuleSet(version int64, ruleFiles map[string]string) (int64, int, error) {
+	reloader.mu.Lock()
+	defer reloader.mu.Unlock()
+
+	rulesDirectory := reloader.configuration.RulesDirectory
+	currentVersion := GetRuleSetVersion(rulesDirectory)
+	currentRulesCount := len(reloader.store.GetIndex().GetRules())
+	if rulesDirectory == "" {
+		return currentVersion, currentRulesCount, errors.New("the rules directory is not specified, the rule set cannot be stored")
+	}
+
+	//The rule sets are applied only in the order of their versions (a delayed update cannot roll back the rules)
+	if version == currentVersion {
+		reloader.logger.Info("Rule set version", version, "is already in use")
+		return currentVersion, currentRulesCount, nil
+	}
+	if version < currentVersion {
+		return currentVersion, currentRulesCount, errors.New("rule set version " + strconv.FormatInt(version, 10) + " is older than the version in use " + strconv.FormatInt(currentVersion, 10))
+	}
+
+	rulesCount, err := reloader.applyRuleSet(version, ruleFiles)
+	if err != nil {
+		return currentVersion, currentRulesCount, err
+	}
+	return version, rulesCount, nil
+}
+
+// Validates the rule set, stores it in the managed directory and swaps the rule index
+// @param version - the version of the rule set
+// @param ruleFiles - the YAML content of the rules mapped by the rule id
+// Returns the number of rules in use or an error if the rule set was rejected
+func (reloader *RuleReloader) applyRuleSet(version int64, ruleFiles map[string]string) (int, error) {
+	rulesDirectory := reloader.configuration.RulesDirectory
+	library, err := LoadPatternLibrary(rulesDirectory)
+	if err != nil {
+		return 0, errors.New("invalid pattern library, " + err.Error())
+	}
+	managedRules, err := reloader.parseRuleSet(ruleFiles, library, reloader.configuration.StrictRules)
+	if err != nil {
+		return 0, err
+	}
+
+	//Load the local rules without the previous rule set, the invalid local files are handled as in a reload
+	localConfiguration := reloader.configuration
+	localConfiguration.IgnoreRulesDirectories = append(append(make([]string, 0), reloader.configuration.IgnoreRulesDirectories...), ManagedRulesDirectory)
+	localRules, localRuleFiles, err := loadRuleFilesFromDirectory(localConfiguration, reloader.logger, false, reloader.ruleFiles)
+	if err != nil {
+		return 0, err
+	}
+	for _, rule := range managedRules {
+		if GetRule(localRules, rule.Id) != nil {
+			return 0, errors.New("rule " + rule.Id + " already exists in the local rules")
+		}
+	}
+
+	exclusions, err := LoadConfiguredExclusions(reloader.configuration)
+	if err != nil {
+		return 0, err
+	}
+
+	//Store the rule set so it is loaded when the agent restarts
+	if err := reloader.writeRuleSet(version, ruleFiles); err != nil {
+		return 0, err
+	}
+
+	newRules := append(localRules, managedRules...)
+	for _, rule := range managedRules {
+		localRuleFiles[filepath.Join(rulesDirectory, ManagedRulesDirectory, rule.Id+".yaml")] = rule
+	}
+	reloader.ruleFiles = localRuleFiles
+	var oldRules []Rule = nil
+	if oldIndex := reloader.store.GetIndex(); oldIndex != nil {
+		oldRules = oldIndex.GetRules()
+	}
+	diff := DiffRules(oldRules, newRules)
+
+	newIndex := NewRuleIndex(newRules, reloader.logger)
+	newIndex.SetExclusions(exclusions)
+	reloader.store.SwapIndex(newIndex)
+	reloader.logger.Info("Rule set version", version, "applied,", len(newRules), "rules in use, added:", strings.Join(diff.Added, ", "), "removed:", strings.Join(diff.Removed, ", "), "changed:", strings.Join(diff.Changed, ", "))
+	return len(newRules), nil
+}
@@ -0,0 +1,53 @@
+package detection
+
+import (
+	"testing"
+
+	"github.com/lucacoratu/disertatie/agent/config"
+	"github.com/lucacoratu/disertatie/agent/logging"
+)
+
+// A rule of the rule sets pushed in the tests
+const testRuleSetRule = `id: TestRuleSetRule
+info:
+  name: Test rule set rule
+  description: Matches the test payload
+  severity: high
+  classification: sqli
+request:
+  params:
+    - name: any
+      match: ruleset-test-payload
+`
+
+func TestApplyRuleSetVersionOrder(t *testing.T) {
+	logger := logging.NewDefaultLogger()
+	configuration := config.Configuration{RulesDirectory: t.TempDir()}
+	reloader := NewRuleReloader(logger, configuration, NewRuleStore(NewRuleIndex(nil, logger)))
+	ruleFiles := map[string]string{"TestRuleSetRule": testRuleSetRule}
+
+	tests := []struct {
+		name            string
+		version         int64
+		expectedVersion int64
+		expectedRules   int
+		rejected        bool
+	}{
+		{"first version", 2, 2, 1, false},
+		{"same version", 2, 2, 1, false},
+		{"older version", 1, 2, 1, true},
+		{"newer version", 3, 3, 1, false},
+	}
+	for _, test := range tests {
+		version, rulesCount, err := reloader.ApplyRuleSet(test.version, ruleFiles)
+		if (err != nil) != test.rejected {
+			t.Fatalf("%s: expected rejected %v, got error %v", test.name, test.rejected, err)
+		}
+		if version != test.expectedVersion || rulesCount != test.expectedRules {
+			t.Errorf("%s: expected version %d with %d rules, got version %d with %d rules", test.name, test.expectedVersion, test.expectedRules, version, rulesCount)
+		}
+		if stored := GetRuleSetVersion(configuration.RulesDirectory); stored != test.expectedVersion {
+			t.Errorf("%s: expected the stored version %d, got %d", test.name, test.expectedVersion, stored)
+		}
+	}
+}
@@ -0,0 +1,273 @@
+package detection
+
+import (
+	"bufio"
+	"encoding/hex"
+	"errors"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/lucacoratu/disertatie/agent/config"
+	"github.com/lucacoratu/disertatie/agent/data"
+	"github.com/lucacoratu/disertatie/agent/logging"
+)
+
+// The expected results of a rule test
+const (
+	RuleTestExpectMatch   = "match"
+	RuleTestExpectNoMatch = "no-match"
+)
+
+// Holds the result of running a test of the rule
+type RuleTestResult struct {
+	RuleId   string                  //The id of the tested rule
+	Name     string                  //The name of the test (or its position in the tests list if the test has no name)
+	Expect   string                  //The expected result (match or no-match)
+	Matched  bool                    //If the rule matched the sample
+	Findings []*data.RuleFindingData //The findings of the rule on the sample
+	Err      error                   //The error which prevented running the test
+}
+
+// Checks if the test has the expected result
+func (result RuleTestResult) Passed() bool {
+	return result.Err == nil && result.Matched == (result.Expect == RuleTestExpectMatch)
+}
+
+// Splits the raw HTTP message into the head (start line and headers) and the body
+// The line endings are normalized and the newline added at the end of the YAML literal blocks is removed from the body
+func splitRawHTTPMessage(raw string) (string, string) {
+	raw = strings.ReplaceAll(raw, "\r\n", "\n")
+	raw = strings.TrimLeft(raw, "\n")
+	head, body, found := strings.Cut(raw, "\n\n")
+	if !found {
+		return strings.TrimRight(head, "\n") + "\r\n\r\n", ""
+	}
+	return strings.ReplaceAll(head, "\n", "\r\n") + "\r\n\r\n", strings.TrimSuffix(body, "\n")
+}
+
+// Parses the raw HTTP request of a rule test
+// The Content-Length is computed from the body so it does not have to be written in the test
+// @param raw - the raw HTTP request
+// Returns the request or an error if the request cannot be parsed
+func ParseRawHTTPRequest(raw string) (*http.Request, error) {
+	head, body := splitRawHTTPMessage(raw)
+	request, err := http.ReadRequest(bufio.NewReader(strings.NewReader(head)))
+	if err != nil {
+		return nil, errors.New("invalid raw request, " + err.Error())
+	}
+	request.Body = io.NopCloser(strings.NewReader(body))
+	request.ContentLength = int64(len(body))
+	if body != "" {
+		request.Header.Set("Content-Length", strconv.Itoa(len(body)))
+	}
+	return request, nil
+}
+
+// Parses the raw HTTP response of a rule test
+// The Content-Length is computed from the body so it does not have to be written in the test
+// @param raw - the raw HTTP response
+// Returns the response or an error if the response cannot be parsed
+func ParseRawHTTPResponse(raw string) (*http.Response, error) {
+	head, body := splitRawHTTPMessage(raw)
+	response, err := http.ReadResponse(bufio.NewReader(strings.NewReader(head)), nil)
+	if err != nil {
+		return nil, errors.New("invalid raw response, " + err.Error())
+	}
+	response.Body = io.NopCloser(strings.NewReader(body))
+	response.ContentLength = int64(len(body))
+	if body != "" {
+		response.Header.Set("Content-Length", strconv.Itoa(len(body)))
+	}
+	return response, nil
+}
+
+// Gets the type and the content of the websocket message of a rule test
+// Returns the message type, the message or an error if the message is not valid
+func getRuleTestWebsocketMessage(websocket *RuleTestWebsocket) (int, []byte, error) {
+	switch websocket.MessageType {
+	case 0, 1:
+		return 1, []byte(websocket.Message), nil
+	case 2:
+		message, err := hex.DecodeString(websocket.Message)
+		if err != nil {
+			return 0, nil, errors.New("the binary websocket message should be hex encoded, " + err.Error())
+		}
+		return 2, message, nil
+	}
+	return 0, nil, errors.New("invalid websocket message type " + strconv.Itoa(websocket.MessageType) + ", should be 1 (text) or 2 (binary)")
+}
+
+// Gets the measurements of the response of a rule test (nil if the test does not specify the latency)
+func getRuleTestUpstream(test *RuleTest) *data.UpstreamMetrics {
+	if test.Latency == 0 {
+		return nil
+	}
+	return &data.UpstreamMetrics{TimeToFirstByte: test.Latency, Duration: test.Latency}
+}
+
+// Runs the rules on the request of a rule test and then on its response (which has the request attached)
+// @param runner - the rule runner
+// @param test - the rule test
+// @param findings - the list where the findings of both the request and the response are added
+// Returns an error if the samples cannot be parsed or the rules cannot be run
+func runRuleTestExchange(runner *RuleRunner, test *RuleTest, findings *[]*data.RuleFindingData) error {
+	request, err := ParseRawHTTPRequest(test.Request)
+	if err != nil {
+		return err
+	}
+	requestFindings, _, err := runner.RunRulesOnRequest(request)
+	if err != nil {
+		return err
+	}
+	response, err := ParseRawHTTPResponse(test.Response)
+	if err != nil {
+		return err
+	}
+	response.Request = request
+	responseFindings, _, err := runner.RunRulesOnResponse(response, getRuleTestUpstream(test))
+	if err != nil {
+		return err
+	}
+	*findings = append(requestFindings, responseFindings...)
+	return nil
+}
+
+// Runs the rules on the websocket message of a rule test
+// The request of the test (if specified) is used as the upgrade request of the connection
+// @param runner - the rule runner
+// @param test - the rule test
+// @param findings - the list where the findings are added
+// Returns an error if the samples cannot be parsed or the rules cannot be run
+func runRuleTestWebsocket(runner *RuleRunner, test *RuleTest, findings *[]*data.RuleFindingData) error {
+	var connection *WebsocketConnectionContext = nil
+	if test.Request != "" {
+		request, err := ParseRawHTTPRequest(test.Request)
+		if err != nil {
+			return err
+		}
+		connection = NewWebsocketConnectionContext(request)
+	}
+	messageType, message, err := getRuleTestWebsocketMessage(test.Websocket)
+	if err != nil {
+		return err
+	}
+	direction := WebsocketDirectionClient
+	if test.Websocket.Direction != "" {
+		direction = strings.ToLower(test.Websocket.Direction)
+	}
+	*findings, _, err = runner.RunRulesOnWebsocketMessage(connection, direction, messageType, message)
+	return err
+}
+
+// Checks if the tests of the rule are valid
+// @param tests - the tests of the rule
+// Returns an error if any of the tests is not valid
+func CheckRuleTests(tests []*RuleTest) error {
+	for i, test := range tests {
+		if test == nil {
+			return errors.New("rule test " + strconv.Itoa(i) + " is empty")
+		}
+		if test.Expect != RuleTestExpectMatch && test.Expect != RuleTestExpectNoMatch {
+			return errors.New("rule test " + strconv.Itoa(i) + " has invalid expect value " + test.Expect + ", should be match or no-match")
+		}
+
+		//Exactly one sample should be specified (the request together with its response counts as one sample)
+		samples := 0
+		if test.Request != "" {
+			samples++
+			if _, err := ParseRawHTTPRequest(test.Request); err != nil {
+				return errors.New("rule test " + strconv.Itoa(i) + " has " + err.Error())
+			}
+		}
+		if test.Response != "" {
+			if test.Request == "" {
+				samples++
+			}
+			if _, err := ParseRawHTTPResponse(test.Response); err != nil {
+				return errors.New("rule test " + strconv.Itoa(i) + " has " + err.Error())
+			}
+		}
+		if test.Websocket != nil {
+			//The request is the upgrade request of the websocket connection
+			if test.Request == "" {
+				samples++
+			}
+			if test.Response != "" {
+				return errors.New("rule test " + strconv.Itoa(i) + " cannot have both response and websocket")
+			}
+			if _, _, err := getRuleTestWebsocketMessage(test.Websocket); err != nil {
+				return errors.New("rule test " + strconv.Itoa(i) + " has " + err.Error())
+			}
+			direction := strings.ToLower(test.Websocket.Direction)
+			if direction != "" && direction != WebsocketDirectionClient && direction != WebsocketDirectionServer {
+				return errors.New("rule test " + strconv.Itoa(i) + " has invalid websocket direction " + test.Websocket.Direction + ", should be client-to-server or server-to-client")
+			}
+		}
+		if samples != 1 {
+			return errors.New("rule test " + strconv.Itoa(i) + " should have exactly one of request, response or websocket")
+		}
+		if test.Latency < 0 {
+			return errors.New("rule test " + strconv.Itoa(i) + " latency cannot be negative")
+		}
+		if test.Latency > 0 && test.Response == "" {
+			return errors.New("rule test " + strconv.Itoa(i) + " latency needs a response")
+		}
+	}
+	return nil
+}
+
+// Runs the tests of the rule through the rule runner
+// The rule is evaluated alone so the result does not depend on the other rules (in waf mode a drop rule stops the evaluation)
+// @param rule - the rule to be tested
+// @param configuration - the configuration of the agent
+// @param logger - the logger
+// Returns the results of the tests in the order they are written in the rule
+func RunRuleTests(rule Rule, configuration config.Configuration, logger logging.ILogger) []RuleTestResult {
+	results := make([]RuleTestResult, 0, len(rule.Tests))
+	if len(rule.Tests) == 0 {
+		return results
+	}
+
+	//The rule goes through the compiled index so the prefilter is tested as well
+	runner := NewRuleRunner(logger, NewRuleIndex([]Rule{rule}, logger), nil, configuration)
+	for i, test := range rule.Tests {
+		result := RuleTestResult{RuleId: rule.Id, Name: "#" + strconv.Itoa(i+1), Expect: test.Expect}
+		if test.Name != "" {
+			result.Name = test.Name
+		}
+
+		var findings []*data.RuleFindingData
+		switch {
+		case test.Websocket != nil:
+			result.Err = runRuleTestWebsocket(runner, test, &findings)
+		case test.Request != "" && test.Response != "":
+			result.Err = runRuleTestExchange(runner, test, &findings)
+		case test.Request != "":
+			request, err := ParseRawHTTPRequest(test.Request)
+			if err == nil {
+				findings, _, err = runner.RunRulesOnRequest(request)
+			}
+			result.Err = err
+		case test.Response != "":
+			response, err := ParseRawHTTPResponse(test.Response)
+			if err == nil {
+				findings, _, err = runner.RunRulesOnResponse(response, getRuleTestUpstream(test))
+			}
+			result.Err = err
+		default:
+			result.Err = errors.New("the test does not have a request, response or websocket sample")
+		}
+
+		for _, finding := range findings {
+			if finding.RuleId == rule.Id {
+				result.Findings = append(result.Findings, finding)
+			}
+		}
+		result.Matched = len(result.Findings) > 0
+		results = append(results, result)
+	}
+
+	return results
+}
@@ -0,0 +1,39 @@
+package detection
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/lucacoratu/disertatie/agent/config"
+	"github.com/lucacoratu/disertatie/agent/logging"
+)
+
+// Runs the tests embedded in the rules of the agent (the same tests as the rules test command)
+func TestRules(t *testing.T) {
+	logger := logging.NewDefaultLogger()
+	configuration := config.Configuration{RulesDirectory: filepath.Join("..", "..", "rules")}
+	allRules, err := LoadRulesFromDirectoryStrict(configuration, logger)
+	if err != nil {
+		t.Fatal("could not load the rules,", err)
+	}
+
+	for _, rule := range allRules {
+		if len(rule.Tests) == 0 {
+			continue
+		}
+		t.Run(rule.Id, func(t *testing.T) {
+			for _, result := range RunRuleTests(rule, configuration, logger) {
+				switch {
+				case result.Err != nil:
+					t.Errorf("%q: %s", result.Name, result.Err.Error())
+				case !result.Passed():
+					got := RuleTestExpectNoMatch
+					if result.Matched {
+						got = RuleTestExpectMatch
+					}
+					t.Errorf("%q: expected %s, got %s", result.Name, result.Expect, got)
+				}
+			}
+		})
+	}
+}
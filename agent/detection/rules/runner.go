@@ -1,138 +1,145 @@
 package detection
 
 import (
-	"bytes"
-	"crypto/md5"
-	"crypto/sha256"
-	"encoding/base64"
 	"encoding/hex"
-	"io"
 	"net/http"
-	"net/url"
-	"reflect"
-	"regexp"
+	"strconv"
 	"strings"
 	"time"
 
+	"github.com/antchfx/xpath"
 	"github.com/lucacoratu/disertatie/agent/config"
 	"github.com/lucacoratu/disertatie/agent/data"
 	"github.com/lucacoratu/disertatie/agent/logging"
 	"github.com/lucacoratu/disertatie/agent/utils"
 	"github.com/lucacoratu/disertatie/agent/websocket"
-	"github.com/mowshon/iterium"
 )
 
 // Structure which will hold all the necessary data to match the rules on the request and the response
 type RuleRunner struct {
 	logger        logging.ILogger
 	rules         []Rule
+	index         *RuleIndex
 	apiWsConn     *websocket.APIWebSocketConnection
 	configuration config.Configuration
+	statistics    *RuleStatistics //The counters of the rules (nil if the rules are not profiled)
+	profile       *ruleProfile    //The measurements of the rule being evaluated (set on the copy of the runner used by the evaluation)
 }
 
 // Creates a new rule runner struct
-func NewRuleRunner(logger logging.ILogger, rules []Rule, apiWsConn *websocket.APIWebSocketConnection, configuration config.Configuration) *RuleRunner {
-	return &RuleRunner{logger: logger, rules: rules, apiWsConn: apiWsConn, configuration: configuration}
+func NewRuleRunner(logger logging.ILogger, index *RuleIndex, apiWsConn *websocket.APIWebSocketConnection, configuration config.Configuration) *RuleRunner {
+	return &RuleRunner{logger: logger, rules: index.GetRules(), index: index, apiWsConn: apiWsConn, configuration: configuration}
 }
 
-// Decodes the value string using the encodings of the rule
-// Creates all the possible permutation of the list of encodings specified (length 1, 2 ...)
-// @param value - the value to be decoded
-// @param encodings - the list of encodings
-// Returns the list of possible decodings of the value
-func (rl *RuleRunner) decodeString(value string, encodings []string) ([]string, error) {
-	//Create the return list of decoded strings
-	decodedStrings := make([]string, 0)
-	//The first element in the list should be the unmodified string (len 0 of the encodings permutations)
-	decodedStrings = append(decodedStrings, value)
-
-	//Check if the list of encodings is not empty
-	if encodings != nil {
-		//Create the list of possible permutations
-		for i := 0; i < len(encodings); i++ {
-			sizeIPermutations := iterium.Permutations(encodings, i)
-			encPermutations, err := sizeIPermutations.Slice()
-			//Check if an error occured
-			if err != nil {
-				//Return the original value and the error
-				return decodedStrings, err
-			}
-			//For each permutation of the possible encodings
-			for _, permutation := range encPermutations {
-				//Create the result so that chained decoding can be made
-				var decString string = value
-				//Apply the decodings specified in the permutation
-				for _, decoding := range permutation {
-					//Do the correct decoding
-					switch decoding {
-					case "base64":
-						//Decode the string from base64
-						decodedString, err := base64.StdEncoding.DecodeString(decString)
-						if err != nil {
-							//The decoding failed from base64 so continue with the other decodings
-							continue
-						}
-						//Update the final decoded string
-						decString = string(decodedString)
-					case "url":
-						//Decode the string from url
-						decodedString, err := url.QueryUnescape(decString)
-						if err != nil {
-							//The decoding failed from url so continue with other decodings
-							continue
-						}
-						//Update the final decoded string
-						decString = decodedString
-					}
-				}
-				//Apend the decoded string to the list of decoded strings
-				decodedStrings = append(decodedStrings, decString)
+// Sets the counters where the evaluations of the rules are recorded
+// @param statistics - the counters of the rules (nil disables the profiling)
+func (rl *RuleRunner) SetStatistics(statistics *RuleStatistics) {
+	rl.statistics = statistics
+}
+
+// Gets the values inspected in a phase lowercased and decoded with every encoding list used by the matchers of the phase
+// The values are decoded with the same encoding lists as the matchers so the prefilter sees all the decoded values the matchers inspect
+// These values are searched by the rule index to select the candidate rules
+// @param phase - the phase (request, response, websocket)
+// @param values - the values which will be inspected by the rules
+// Returns the list of texts for the prefilter and true if the decoding of a value was truncated
+func (rl *RuleRunner) getPrefilterTexts(phase string, values []string) ([]string, bool) {
+	texts := make([]string, 0, len(values))
+	seen := make(map[string]bool, len(values))
+	addText := func(text string) {
+		text = strings.ToLower(text)
+		if !seen[text] {
+			seen[text] = true
+			texts = append(texts, text)
+		}
+	}
+
+	var truncated bool = false
+	encodingSets := rl.index.getPhaseEncodingSets(phase)
+	for _, value := range values {
+		addText(value)
+		for _, encodings := range encodingSets {
+			decodedValues, valueTruncated := decodeValueWithLimit(value, encodings)
+			truncated = truncated || valueTruncated
+			for _, decoded := range decodedValues[1:] {
+				addText(decoded.Value)
 			}
 		}
 	}
+	return texts, truncated
+}
 
-	//Return the list of decoded strings using the specified encodings
-	return decodedStrings, nil
+// Holds a string matched by a rule, the decodings applied on the value before the match and where the value is in the message
+type searchMatch struct {
+	Value         string   //The matched string
+	DecodingChain []string //The decodings applied on the inspected value to find the match (empty if the match is in the raw value)
+	Path          string   //The path of the value which matched (cookie name, JSONPath or XPath of the value), empty for the other matchers
+	Part          string   //The part of the message which contains the inspected value (method, url, header, parameter, ...)
+	Name          string   //The name of the header, parameter or cookie which contains the inspected value
+	Source        string   //The source of the parameter (query, body)
+	Inspected     string   //The value searched by the rule (before the decodings)
+	Start         int      //The offset of the match in the inspected value (-1 if the match is in a decoded value)
+}
+
+// Sets the path of the value on all the matches
+func setMatchesPath(matches []searchMatch, path string) []searchMatch {
+	for i := range matches {
+		matches[i].Path = path
+	}
+	return matches
+}
+
+// Converts the matched strings to search matches without decodings
+func newSearchMatches(matches []string) []searchMatch {
+	searchMatches := make([]searchMatch, 0, len(matches))
+	for _, match := range matches {
+		searchMatches = append(searchMatches, searchMatch{Value: match, Start: -1})
+	}
+	return searchMatches
 }
 
 // Searches for case insensitive match on the value or if the regex can find any matches on the given value
+// The value is decoded recursively with the encodings of the rule and every decoded value is searched
 // @param value - the value to be searched uppon
 // @param mode - the rule search specification
-// Returns the list of matches it found
-func (rl *RuleRunner) search(value string, mode *RuleSearchMode) []string {
-	allMatches := make([]string, 0)
-
-	//Get all the possible decodings of the value
-	decodedStrings, _ := rl.decodeString(value, mode.Encodings)
+// Returns the list of matches it found with the decodings which led to each match
+func (rl *RuleRunner) search(value string, mode *RuleSearchMode) []searchMatch {
+	allMatches := make([]searchMatch, 0)
+
+	//Check if any of the decoded values matches the rule conditions
+	for _, decoded := range decodeValue(value, mode.Encodings) {
+		//The offset of the match is kept only for the raw value (the offsets in the decoded values cannot be mapped to the message)
+		getStart := func(start int) int {
+			if len(decoded.Chain) > 0 {
+				return -1
+			}
+			return start
+		}
 
-	//Check if any of the decoded string matches the rule conditions
-	for _, decString := range decodedStrings {
 		//Check if the exact match is specified
 		if mode.Match != "" {
 			//Check if the value contains the match string (case insensitive)
-			if strings.Contains(strings.ToLower(decString), strings.ToLower(mode.Match)) {
+			if index := strings.Index(strings.ToLower(decoded.Value), strings.ToLower(mode.Match)); index != -1 {
 				//Add the match to the list of matches
-				allMatches = append(allMatches, mode.Match)
+				allMatches = append(allMatches, searchMatch{Value: mode.Match, DecodingChain: decoded.Chain, Inspected: value, Start: getStart(index)})
 			}
 		}
 
 		//Check if the regex match is specified
 		if mode.Regex != "" {
-			//Compile the regex
-			r, err := regexp.Compile(mode.Regex)
+			//Get the precompiled regex
+			r, err := rl.index.getRegex(mode.Regex)
 			//Check if an error occured during the regex compilation
 			if err != nil {
 				rl.logger.Error("Could not the compile regex matcher from the rule, invalid regex:", mode.Regex)
 				return nil
 			}
-			//Find all the matches for the regex
-			matches := r.FindAllString(decString, -1)
-			//rl.logger.Debug("Value:", value, "Regex:", mode.Regex, "Matches:", matches)
-			//Check if there were any matches
-			if len(matches) > 0 {
-				//rl.logger.Debug("Regex,", mode.Regex, "matched on", value)
-				//Add the matches to the list of matches
-				allMatches = append(allMatches, matches...)
+			//Find all the matches for the regex and add them to the list of matches
+			regexStart := time.Now()
+			locations := r.FindAllStringIndex(decoded.Value, -1)
+			rl.checkSearchTime(regexStart)
+			for _, location := range locations {
+				allMatches = append(allMatches, searchMatch{Value: decoded.Value[location[0]:location[1]], DecodingChain: decoded.Chain, Inspected: value, Start: getStart(location[0])})
 			}
 		}
 	}
@@ -145,65 +152,65 @@ func (rl *RuleRunner) search(value string, mode *RuleSearchMode) []string {
 // @param method - the method to be searched uppon
 // @param ruleMethod - the rule search specification
 // Returns the list of matches or an error if something occured
-func (rl *RuleRunner) checkMethod(method string, ruleMethod *RuleSearchMode) ([]string, error) {
+func (rl *RuleRunner) checkMethod(method string, ruleMethod *RuleSearchMode) ([]searchMatch, error) {
 	//Check if the rule has a method specification
 	if ruleMethod == nil {
 		//Return an empty list of matches
-		return make([]string, 0), nil
+		return make([]searchMatch, 0), nil
 	}
 	//Search in the method for any matches
 	matches := rl.search(method, ruleMethod)
-	return matches, nil
+	return setMatchesPart(matches, MatchPartMethod, ""), nil
 }
 
 // Checks if the URL of the request matches any of the rule matching specification
 // @param url - the URL to be searched uppon
 // @param ruleURL - the rule search specification
 // Returns the list of matches or an error if something occured
-func (rl *RuleRunner) checkURL(url string, ruleURL []*RuleSearchMode) ([]string, error) {
+func (rl *RuleRunner) checkURL(url string, ruleURL []*RuleSearchMode) ([]searchMatch, error) {
 	//Check if the rule has a URL specification
 	if ruleURL == nil {
 		//Return an empty match list
-		return make([]string, 0), nil
+		return make([]searchMatch, 0), nil
 	}
 
 	//Initialize the return list of matches
-	ret_matches := make([]string, 0)
+	ret_matches := make([]searchMatch, 0)
 
 	for _, rule := range ruleURL {
 		//Search in the URL path for any matches
 		matches := rl.search(url, rule)
-		ret_matches = append(ret_matches, matches...)
+		ret_matches = append(ret_matches, setMatchesPart(matches, MatchPartURL, "")...)
 	}
 
 	return ret_matches, nil
 }
 
-// Check if any of the header value matches a rule specification for that header name
+// Check if any of the header value matches a rule specification for that header name (any means all the headers)
 // @param headers - the headers of the request as given by http.request package
 // @param ruleURL - the rule search specification
 // Returns the list of matches or an error if something occured
-func (rl *RuleRunner) checkHeaders(headers map[string][]string, ruleHeaders []*HeadersRule) ([]string, error) {
+func (rl *RuleRunner) checkHeaders(headers map[string][]string, ruleHeaders []*HeadersRule) ([]searchMatch, error) {
 	//Check if the rule has the request headers specified
 	if ruleHeaders == nil {
 		//Return an empty list of matches
-		return make([]string, 0), nil
+		return make([]searchMatch, 0), nil
 	}
 
 	//Create the structure which will hold the findings list
-	allMatches := make([]string, 0)
+	allMatches := make([]searchMatch, 0)
 
 	//Loop through each header
 	for headerName, headerValue := range headers {
 		//Check if the header name can be found in the list of header specifications of the rule
 		for _, headerSpec := range ruleHeaders {
-			if headerSpec.Name == headerName {
+			if headerSpec.Name == headerName || headerSpec.Name == "any" {
 				//Run the rule search for every value of this header
 				for _, headerVal := range headerValue {
 					//Call the search functions to get all the matches of the header with the rule header specifications
 					matches := rl.search(headerVal, &RuleSearchMode{Match: headerSpec.Match, Regex: headerSpec.Regex, Encodings: headerSpec.Encodings})
 					//Add the matches to the list of all matches
-					allMatches = append(allMatches, matches...)
+					allMatches = append(allMatches, setMatchesPart(matches, MatchPartHeader, headerName)...)
 				}
 				//Go to the next header
 				break
@@ -215,16 +222,17 @@ func (rl *RuleRunner) checkHeaders(headers map[string][]string, ruleHeaders []*H
 
 // Checks if any of the parameters matches a rule specification
 // @param url - the URL to be searched uppon
+// @param source - the source of the parameters (query, body)
 // @param ruleURL - the rule search specification
 // Returns the list of matches or an error if something occured
-func (rl *RuleRunner) checkParameters(parameters map[string][]string, ruleParameters []*RequestParametersRule) ([]string, error) {
+func (rl *RuleRunner) checkParameters(parameters map[string][]string, source string, ruleParameters []*RequestParametersRule) ([]searchMatch, error) {
 	//Check if the parameters field is specified in the rule
 	if ruleParameters == nil {
-		return make([]string, 0), nil
+		return make([]searchMatch, 0), nil
 	}
 
 	//Create the structure which will hold all the matches
-	allMatches := make([]string, 0)
+	allMatches := make([]searchMatch, 0)
 
 	//Loop through all the parameter names
 	for parameterName, parameterValues := range parameters {
@@ -236,7 +244,7 @@ func (rl *RuleRunner) checkParameters(parameters map[string][]string, ruleParame
 				for _, parameterValue := range parameterValues {
 					matches := rl.search(parameterValue, &RuleSearchMode{Match: ruleParameter.Match, Regex: ruleParameter.Regex, Encodings: ruleParameter.Encodings})
 					//Add the found matches to the list of all matches
-					allMatches = append(allMatches, matches...)
+					allMatches = append(allMatches, setMatchesSource(setMatchesPart(matches, MatchPartParameter, parameterName), source)...)
 				}
 			} else {
 				//Check if the parameter name matches the param name from the rule
@@ -245,7 +253,7 @@ func (rl *RuleRunner) checkParameters(parameters map[string][]string, ruleParame
 					for _, parameterValue := range parameterValues {
 						matches := rl.search(parameterValue, &RuleSearchMode{Match: ruleParameter.Match, Regex: ruleParameter.Regex, Encodings: ruleParameter.Encodings})
 						//Add the found matches to the list of all matches
-						allMatches = append(allMatches, matches...)
+						allMatches = append(allMatches, setMatchesSource(setMatchesPart(matches, MatchPartParameter, parameterName), source)...)
 					}
 				}
 			}
@@ -257,263 +265,535 @@ func (rl *RuleRunner) checkParameters(parameters map[string][]string, ruleParame
 
 // Checks if the body of the request/response matches any rule specification for the body
 // It can also check if the hash of the body (MD5 or SHA256) matches a specified hash
-// @param body - the body of the request
+// @param body - the body of the request/response with the precomputed hashes
 // @param bodyRule - the list of rule specifications for the body
 // Returns the list of matches, the list of hash matches or an error if something occured
-func (rl *RuleRunner) checkBody(body string, bodyRule []*BodyRule) ([]string, []BodyHashMatch, error) {
+func (rl *RuleRunner) checkBody(body *InspectedBody, bodyRule []*BodyRule) ([]searchMatch, []BodyHashMatch, error) {
 	//Check if the bodyRules is not nil
 	if bodyRule == nil {
-		return make([]string, 0), make([]BodyHashMatch, 0), nil
+		return make([]searchMatch, 0), make([]BodyHashMatch, 0), nil
 	}
 
 	//Initialize the all matches structure
-	allMatches := make([]string, 0)
+	allMatches := make([]searchMatch, 0)
 	//Initialize the slice which will hold all the hash matches
 	allHashMatches := make([]BodyHashMatch, 0)
 
 	//Loop through every body rule
 	for _, bRule := range bodyRule {
 		//Get the matches for the exact string search and regex
-		matches := rl.search(body, &RuleSearchMode{Match: bRule.Match, Regex: bRule.Regex, Encodings: bRule.Encodings})
-		allMatches = append(allMatches, matches...)
+		matches := rl.search(body.Text, &RuleSearchMode{Match: bRule.Match, Regex: bRule.Regex, Encodings: bRule.Encodings})
+		allMatches = append(allMatches, setMatchesPart(matches, MatchPartBody, "")...)
 
 		//Check if the any of the hash types matches
-		if bRule.MD5Sum != "" {
-			//Compute the MD5 hash
-			md5hasher := md5.New()
-			bodyMd5 := md5hasher.Sum([]byte(body))
-			//Decode the hex string of the hash from the rule
-			decRuleMd5sum, err := hex.DecodeString(bRule.MD5Sum)
-			//Check if an error occured
-			if err != nil {
-				rl.logger.Error("Could not decode md5sum from the rule", err.Error())
-			} else {
-				if reflect.DeepEqual(bodyMd5, decRuleMd5sum) {
-					allHashMatches = append(allHashMatches, BodyHashMatch{BodyHash: bRule.MD5Sum, BodyHashAlgorithm: "MD5"})
-				}
-			}
+		if bRule.MD5Sum != "" && strings.EqualFold(body.MD5, bRule.MD5Sum) {
+			allHashMatches = append(allHashMatches, BodyHashMatch{BodyHash: bRule.MD5Sum, BodyHashAlgorithm: "MD5"})
 		}
-		if bRule.SHA256Sum != "" {
-			//Compute the SHA256 sum
-			bodySha256 := sha256.Sum256([]byte(body))
-			hexBodySha256 := hex.EncodeToString(bodySha256[:])
-			if hexBodySha256 == bRule.SHA256Sum {
-				allHashMatches = append(allHashMatches, BodyHashMatch{BodyHash: bRule.SHA256Sum, BodyHashAlgorithm: "SHA256"})
-			}
+		if bRule.SHA256Sum != "" && strings.EqualFold(body.SHA256, bRule.SHA256Sum) {
+			allHashMatches = append(allHashMatches, BodyHashMatch{BodyHash: bRule.SHA256Sum, BodyHashAlgorithm: "SHA256"})
 		}
 	}
 
 	return allMatches, allHashMatches, nil
 }
 
-// Run all the rules on the request
-// @param r - the http request to operate on
-// Returns a list of findings or an error if something occured
-func (rl *RuleRunner) RunRulesOnRequest(r *http.Request) ([]*data.RuleFindingData, error) {
-	//Create the list which will hold all the matches from all the rules for the request
-	findings := make([]*data.RuleFindingData, 0)
-
-	//Check if the rules are nil
-	if rl.rules == nil {
-		return findings, nil
+// Checks if any of the cookies matches a rule specification for that cookie name
+// @param cookies - the cookies of the request
+// @param ruleCookies - the rule search specification
+// Returns the list of matches (with the name of the cookie as path) or an error if something occured
+func (rl *RuleRunner) checkCookies(cookies []*http.Cookie, ruleCookies []*CookiesRule) ([]searchMatch, error) {
+	//Check if the cookies field is specified in the rule
+	if ruleCookies == nil {
+		return make([]searchMatch, 0), nil
 	}
 
-	//Loop through all the rules and check if any one of them matches a string in the request
-	//TO DO... Run each rule on a different go routine
-	for _, rule := range rl.rules {
-		//Check if the rule has request matchers specified
-		if rule.Request == nil {
-			continue
+	//Create the structure which will hold all the matches
+	allMatches := make([]searchMatch, 0)
+
+	//Loop through all the cookies
+	for _, cookie := range cookies {
+		for _, ruleCookie := range ruleCookies {
+			//If the rule cookie name is any then all the cookies are searched
+			if ruleCookie.Name != "any" && ruleCookie.Name != cookie.Name {
+				continue
+			}
+			matches := rl.search(cookie.Value, &RuleSearchMode{Match: ruleCookie.Match, Regex: ruleCookie.Regex, Encodings: ruleCookie.Encodings})
+			allMatches = append(allMatches, setMatchesPath(setMatchesPart(matches, MatchPartCookie, cookie.Name), cookie.Name)...)
 		}
-		allMatches := make([]string, 0)
-		//Check the Method of the request
-		matches, _ := rl.checkMethod(r.Method, rule.Request.Method)
-		allMatches = append(allMatches, matches...)
-
-		//Check the URL of the request
-		matches, _ = rl.checkURL(r.URL.RawPath, rule.Request.URL)
-		allMatches = append(allMatches, matches...)
-		//Check the Headers of the request
-		matches, _ = rl.checkHeaders(r.Header, rule.Request.Headers)
-		allMatches = append(allMatches, matches...)
-		//Check the parameters of the request
-		//Check the GET parameters
-		matches, _ = rl.checkParameters(r.URL.Query(), rule.Request.Parameters)
-		allMatches = append(allMatches, matches...)
-
-		//Check the POST parameters
-		bodyData, err := io.ReadAll(r.Body)
+	}
+
+	return allMatches, nil
+}
+
+// Checks if any of the values selected from the JSON body matches the rule specification
+// The rules are applied only if the Content-Type of the body is JSON
+// @param body - the body of the request/response
+// @param ruleJSON - the rule search specification
+// Returns the list of matches (with the JSONPath of the value as path) or an error if something occured
+func (rl *RuleRunner) checkJSON(body *InspectedBody, ruleJSON []*JSONPathRule) ([]searchMatch, error) {
+	allMatches := make([]searchMatch, 0)
+	if ruleJSON == nil {
+		return allMatches, nil
+	}
+
+	//Check if the body is a valid JSON document
+	document, ok := body.getJSONDocument()
+	if !ok {
+		return allMatches, nil
+	}
+
+	for _, jsonRule := range ruleJSON {
+		path, err := rl.index.getJSONPath(jsonRule.Path)
 		if err != nil {
-			rl.logger.Error("Error occured when reading the body contents from the request in order to parse the form", err.Error())
-		} else {
-			//Reassign the body so other function can read the data
-			r.Body = io.NopCloser(bytes.NewReader(bodyData))
-		}
-		//Parse the form
-		err = r.ParseForm()
-		//Check if an error occured
+			rl.logger.Error("Could not compile the JSONPath from the rule, invalid path:", jsonRule.Path)
+			return nil, err
+		}
+		for _, value := range path.selectValues(document) {
+			matches := rl.search(value.Value, &RuleSearchMode{Match: jsonRule.Match, Regex: jsonRule.Regex, Encodings: jsonRule.Encodings})
+			allMatches = append(allMatches, setMatchesPath(setMatchesPart(matches, MatchPartBody, ""), value.Path)...)
+		}
+	}
+
+	return allMatches, nil
+}
+
+// Checks if any of the values selected from the XML body matches the rule specification
+// The rules are applied only if the Content-Type of the body is XML
+// @param body - the body of the request/response
+// @param ruleXML - the rule search specification
+// Returns the list of matches (with the XPath of the node as path) or an error if something occured
+func (rl *RuleRunner) checkXML(body *InspectedBody, ruleXML []*XMLPathRule) ([]searchMatch, error) {
+	allMatches := make([]searchMatch, 0)
+	if ruleXML == nil {
+		return allMatches, nil
+	}
+
+	//Check if the body is a valid XML document
+	document, ok := body.getXMLDocument()
+	if !ok {
+		return allMatches, nil
+	}
+
+	for _, xmlRule := range ruleXML {
+		//The compiled XPath expressions keep state during the evaluation so they cannot be shared between the workers
+		expression, err := xpath.Compile(xmlRule.Path)
 		if err != nil {
-			rl.logger.Error("Error occured when parsing the request form when running rules on request", err.Error())
-		} else {
-			//The request has body parameters so search for matches in these.
-			matches, _ := rl.checkParameters(r.PostForm, rule.Request.Parameters)
-			allMatches = append(allMatches, matches...)
+			rl.logger.Error("Could not compile the XPath from the rule, invalid path:", xmlRule.Path)
+			return nil, err
 		}
+		for _, value := range selectXMLValues(expression, document) {
+			matches := rl.search(value.Value, &RuleSearchMode{Match: xmlRule.Match, Regex: xmlRule.Regex, Encodings: xmlRule.Encodings})
+			allMatches = append(allMatches, setMatchesPath(setMatchesPart(matches, MatchPartBody, ""), value.Path)...)
+		}
+	}
+
+	return allMatches, nil
+}
+
+// Checks if any of the uploaded files satisfies all the criteria of a rule specification
+// @param files - the files uploaded in the multipart body
+// @param ruleFiles - the rule search specification
+// Returns the list of matches, the list of hash matches (with the field and the filename as path) or an error if something occured
+func (rl *RuleRunner) checkFiles(files []*InspectedFile, ruleFiles []*FilesRule) ([]searchMatch, []BodyHashMatch, error) {
+	allMatches := make([]searchMatch, 0)
+	allHashMatches := make([]BodyHashMatch, 0)
 
-		//Reasign the body after parsing the form
-		r.Body = io.NopCloser(bytes.NewReader(bodyData))
+	for _, fileRule := range ruleFiles {
+		for _, file := range files {
+			//Check if the file was uploaded in the field specified in the rule
+			if fileRule.Field != "" && fileRule.Field != "any" && fileRule.Field != file.Field {
+				continue
+			}
 
-		//Append matches to the list of findings
-		for _, match := range allMatches {
-			findingFound := false
-			//Check if the finding is not the classification is already made for this request
-			for _, finding := range findings {
-				if finding.RuleId == rule.Id {
-					findingFound = true
+			//Every criterion specified should be satisfied by the file
+			fileMatches := make([]searchMatch, 0)
+			satisfied := true
+			for _, criterion := range []struct {
+				value string
+				mode  *RuleSearchMode
+			}{{file.Filename, fileRule.Filename}, {file.ContentType, fileRule.ContentType}, {file.SniffedContentType, fileRule.SniffedContentType}} {
+				if criterion.mode == nil {
+					continue
+				}
+				matches := rl.search(criterion.value, criterion.mode)
+				if len(matches) == 0 {
+					satisfied = false
 					break
 				}
+				fileMatches = append(fileMatches, matches...)
 			}
-			if findingFound {
+			if !satisfied {
 				continue
 			}
-
-			findings = append(findings, &data.RuleFindingData{RuleId: rule.Id, RuleName: rule.Info.Name, RuleDescription: rule.Info.Description, Classification: rule.Info.Classification, Severity: ConvertSeverityStringToInteger(rule.Info.Severity), MatchedString: match, Length: int64(len(match))})
-		}
-		//Check the body of the request
-		bodyData, err = io.ReadAll(r.Body)
-		//Check if an error occured when getting the body data
-		if err != nil {
-			rl.logger.Error("Error occured when reading the body contents from the request", err.Error())
-		} else {
-			//Reassign the body so other function can read the data
-			r.Body = io.NopCloser(bytes.NewReader(bodyData))
-			matches, hashMatches, _ := rl.checkBody(string(bodyData), rule.Request.Body)
-			//Add the matches to the list of findings
-			for _, match := range matches {
-				findings = append(findings, &data.RuleFindingData{RuleId: rule.Id, RuleName: rule.Info.Name, RuleDescription: rule.Info.Description, Classification: rule.Info.Classification, Severity: ConvertSeverityStringToInteger(rule.Info.Severity), MatchedString: match, Length: int64(len(match))})
+			if fileRule.ContentTypeMismatch && file.ContentType == file.SniffedContentType {
+				continue
+			}
+			if (fileRule.MinSize > 0 && file.Size < fileRule.MinSize) || (fileRule.MaxSize > 0 && file.Size > fileRule.MaxSize) {
+				continue
 			}
-			//Add the hash matches to the list of matches
-			for _, hashMatch := range hashMatches {
-				findings = append(findings, &data.RuleFindingData{RuleId: rule.Id, RuleName: rule.Info.Name, RuleDescription: rule.Info.Description, Line: -1, LineIndex: -1, Classification: rule.Info.Classification, Severity: ConvertSeverityStringToInteger(rule.Info.Severity), MatchedString: "", MatchedBodyHash: hashMatch.BodyHash, MatchedBodyHashAlg: hashMatch.BodyHashAlgorithm, Length: int64(len(hashMatch.BodyHash))})
+			if (fileRule.MD5Sum != "" && !strings.EqualFold(file.MD5, fileRule.MD5Sum)) || (fileRule.SHA256Sum != "" && !strings.EqualFold(file.SHA256, fileRule.SHA256Sum)) {
+				continue
 			}
-		}
 
-		//Check if the rule has at least high severity
-		if ConvertSeverityStringToInteger(rule.Info.Severity) >= data.HIGH {
-			//Send an alert to the API via WebSocket
-			if rl.apiWsConn != nil {
-				err := rl.apiWsConn.SendRuleDetectionAlert(websocket.RuleDetectionAlert{AgentId: rl.configuration.UUID, RuleId: rule.Id, RuleName: rule.Info.Name, RuleDescription: rule.Info.Description, Classification: rule.Info.Classification, Severity: rule.Info.Severity, Timestamp: time.Now().Unix()})
-				//Check if an error occured when sending the alert
-				if err != nil {
-					rl.logger.Error("Error occured when sending alert to API when a high or critical payload was detected")
-				}
+			path := file.Field + ":" + file.Filename
+			if fileRule.MD5Sum != "" {
+				allHashMatches = append(allHashMatches, BodyHashMatch{BodyHash: fileRule.MD5Sum, BodyHashAlgorithm: "MD5", Path: path})
+			}
+			if fileRule.SHA256Sum != "" {
+				allHashMatches = append(allHashMatches, BodyHashMatch{BodyHash: fileRule.SHA256Sum, BodyHashAlgorithm: "SHA256", Path: path})
+			}
+			//The filename is reported when only the size or the content type mismatch were checked
+			if len(fileMatches) == 0 && fileRule.MD5Sum == "" && fileRule.SHA256Sum == "" {
+				fileMatches = append(fileMatches, searchMatch{Value: file.Filename, Inspected: file.Filename})
 			}
+			allMatches = append(allMatches, setMatchesPath(setMatchesPart(fileMatches, MatchPartFile, ""), path)...)
 		}
 	}
 
-	//Dump the request
-	rawRequest, err := utils.DumpHTTPRequest(r)
-	//Check if an error occured when dumping the request
-	if err != nil {
-		rl.logger.Error("Error occured when dumping the request to raw string", err.Error())
-		return nil, err
+	return allMatches, allHashMatches, nil
+}
+
+// Checks if the status code of the response matches the rule matching specification
+// @param statusCode - the status code of the response
+// @param ruleCode - the rule search specification
+// Returns the list of matches or an error if something occured
+func (rl *RuleRunner) checkCode(statusCode int, ruleCode *RuleSearchMode) ([]searchMatch, error) {
+	//Check if the rule has a code specification
+	if ruleCode == nil || (ruleCode.Match == "" && ruleCode.Regex == "") {
+		//Return an empty list of matches
+		return make([]searchMatch, 0), nil
 	}
+	//Search in the status code for any matches
+	matches := rl.search(strconv.Itoa(statusCode), ruleCode)
+	return setMatchesPart(matches, MatchPartStatus, ""), nil
+}
 
-	//Look for every match in the raw request to find the line number, line offset of the match
-	for _, finding := range findings {
-		//Check if this finding is not a hash match
-		if finding.Line != -1 && finding.LineIndex != -1 {
-			lineNumber, lineOffset, err := utils.FindFindingDataInRawdata(string(rawRequest), finding.MatchedString)
-			//Check if an error occures
-			if err != nil {
-				//Skip the match
-				rl.logger.Error("Skipping match,", finding.MatchedString, "error occured when searching for the match in the raw request", err.Error())
-				continue
+// Creates the rule finding structure with the information and the metadata of the rule
+// The finding is not located in the raw data until the match is located
+// @param rule - the rule which matched
+// Returns the rule finding without the match information
+func newRuleFindingData(rule Rule) *data.RuleFindingData {
+	return &data.RuleFindingData{RuleId: rule.Id, RuleName: rule.Info.Name, RuleDescription: rule.Info.Description, Classification: rule.Info.Classification, Severity: ConvertSeverityStringToInteger(rule.Info.Severity), Tags: rule.Info.Tags, References: rule.Info.References, CWEIds: rule.Info.CWEIds, CVSSScore: rule.Info.CVSSScore, CVSSMetrics: rule.Info.CVSSMetrics, MitreTechniques: rule.Info.MitreTechniques, Line: -1, LineIndex: -1, StartOffset: -1, EndOffset: -1}
+}
+
+// Creates the rule finding structure for a match of the rule
+// @param rule - the rule which matched
+// @param match - the string matched and the decodings which led to the match
+// Returns the rule finding
+func newRuleFinding(rule Rule, match searchMatch) *data.RuleFindingData {
+	finding := newRuleFindingData(rule)
+	finding.MatchedString = match.Value
+	finding.Length = int64(len(match.Value))
+	finding.DecodingChain = match.DecodingChain
+	finding.MatchedPath = match.Path
+	finding.Part = match.Part
+	finding.PartName = match.Name
+	finding.PartSource = match.Source
+	return finding
+}
+
+// Creates the rule finding structure for a body hash match of the rule
+// @param rule - the rule which matched
+// @param hashMatch - the body hash matched
+// Returns the rule finding
+func newRuleHashFinding(rule Rule, hashMatch BodyHashMatch) *data.RuleFindingData {
+	finding := newRuleFindingData(rule)
+	finding.Line = -1
+	finding.LineIndex = -1
+	finding.MatchedBodyHash = hashMatch.BodyHash
+	finding.MatchedBodyHashAlg = hashMatch.BodyHashAlgorithm
+	finding.Length = int64(len(hashMatch.BodyHash))
+	finding.MatchedPath = hashMatch.Path
+	return finding
+}
+
+// Runs a rule on the inspection context of the request
+// @param rule - the rule to run
+// @param ctx - the inspection context of the request
+// Returns the findings of the rule (empty if the rule did not match)
+func (rl *RuleRunner) runRuleOnRequest(rule Rule, ctx *RequestInspectionContext) []*data.RuleFindingData {
+	findings := make([]*data.RuleFindingData, 0)
+	//Check if the rule has request matchers specified
+	if rule.Request == nil {
+		return findings
+	}
+
+	//Run every matcher of the rule separately so the rule condition can be evaluated
+	results := make([]*matcherResult, 0)
+	//Check the Method of the request
+	if rule.Request.Method != nil {
+		matches, _ := rl.checkMethod(ctx.Method, rule.Request.Method)
+		results = append(results, &matcherResult{Reference: "request.method", Matches: matches})
+	}
+	//Check the URL of the request
+	for i, urlRule := range rule.Request.URL {
+		matches, _ := rl.checkURL(ctx.URL, []*RuleSearchMode{urlRule})
+		results = append(results, &matcherResult{Reference: indexedReference("request.url", i), Matches: matches})
+	}
+	//Check the Headers of the request
+	for i, headerRule := range rule.Request.Headers {
+		matches, _ := rl.checkHeaders(ctx.Headers, []*HeadersRule{headerRule})
+		results = append(results, &matcherResult{Reference: indexedReference("request.headers", i), Matches: matches})
+	}
+	//Check the parameters of the request (GET and POST parameters)
+	for i, parameterRule := range rule.Request.Parameters {
+		matches, _ := rl.checkParameters(ctx.Query, ParameterSourceQuery, []*RequestParametersRule{parameterRule})
+		postMatches, _ := rl.checkParameters(ctx.PostForm, ParameterSourceBody, []*RequestParametersRule{parameterRule})
+		results = append(results, &matcherResult{Reference: indexedReference("request.params", i), Matches: append(matches, postMatches...)})
+	}
+	//Check the body of the request
+	for i, bodyRule := range rule.Request.Body {
+		matches, hashMatches, _ := rl.checkBody(ctx.Body, []*BodyRule{bodyRule})
+		results = append(results, &matcherResult{Reference: indexedReference("request.body", i), Matches: matches, HashMatches: hashMatches})
+	}
+	//Check the files uploaded in the request
+	for i, fileRule := range rule.Request.Files {
+		matches, hashMatches, _ := rl.checkFiles(ctx.Files, []*FilesRule{fileRule})
+		results = append(results, &matcherResult{Reference: indexedReference("request.files", i), Matches: matches, HashMatches: hashMatches})
+	}
+	//Check the cookies of the request
+	for i, cookieRule := range rule.Request.Cookies {
+		matches, _ := rl.checkCookies(ctx.Cookies, []*CookiesRule{cookieRule})
+		results = append(results, &matcherResult{Reference: indexedReference("request.cookies", i), Matches: matches})
+	}
+	//Check the values from the JSON body of the request
+	for i, jsonRule := range rule.Request.JSON {
+		matches, _ := rl.checkJSON(ctx.Body, []*JSONPathRule{jsonRule})
+		results = append(results, &matcherResult{Reference: indexedReference("request.json", i), Matches: matches})
+	}
+	//Check the values from the XML body of the request
+	for i, xmlRule := range rule.Request.XML {
+		matches, _ := rl.checkXML(ctx.Body, []*XMLPathRule{xmlRule})
+		results = append(results, &matcherResult{Reference: indexedReference("request.xml", i), Matches: matches})
+	}
+
+	//Decide if the rule matched based on the rule condition and add the matches to the list of findings
+	var ruleFindingFound bool = false
+	for _, result := range ruleMatchedResults(rule, RequestPhase, results) {
+		isBodyResult := referenceCovers("request.body", result.Reference) || referenceCovers("request.json", result.Reference) || referenceCovers("request.xml", result.Reference) || referenceCovers("request.files", result.Reference)
+		for _, match := range result.Matches {
+			//Only one finding is added for the matchers which are not on the body (all the matched values of the JSON and XML bodies are reported)
+			if !isBodyResult {
+				if ruleFindingFound {
+					break
+				}
+				ruleFindingFound = true
 			}
-			finding.Line = lineNumber
-			finding.LineIndex = lineOffset
-			//rl.logger.Debug(*finding)
+			finding := newRuleFinding(rule, match)
+			ctx.Raw.locate(finding, match)
+			findings = append(findings, finding)
+		}
+		//Add the hash matches to the list of matches
+		for _, hashMatch := range result.HashMatches {
+			findings = append(findings, newRuleHashFinding(rule, hashMatch))
 		}
 	}
 
-	return findings, nil
+	return findings
 }
 
-// Run all the rules on the response
-// @param r - the http response to operate on
-// Returns a list of findings or an error if something occured
-func (rl *RuleRunner) RunRulesOnResponse(r *http.Response) ([]*data.RuleFindingData, error) {
+// Sends an alert to the API via WebSocket for the rules with at least high severity
+// @param rule - the rule which matched
+func (rl *RuleRunner) sendRuleAlert(rule Rule) {
+	//Check if the rule has at least high severity
+	if ConvertSeverityStringToInteger(rule.Info.Severity) < data.HIGH || rl.apiWsConn == nil {
+		return
+	}
+	err := rl.apiWsConn.SendRuleDetectionAlert(websocket.RuleDetectionAlert{AgentId: rl.configuration.UUID, RuleId: rule.Id, RuleName: rule.Info.Name, RuleDescription: rule.Info.Description, Classification: rule.Info.Classification, Severity: rule.Info.Severity, Timestamp: time.Now().Unix()})
+	//Check if an error occured when sending the alert
+	if err != nil {
+		rl.logger.Error("Error occured when sending alert to API when a high or critical payload was detected")
+	}
+}
+
+// Run all the rules on the request
+// The request is parsed once into an inspection context and the rules are evaluated concurrently
+// The rules are evaluated only on the requests in their scope and the findings removed by the exclusions are returned separately
+// @param r - the http request to operate on
+// Returns a list of findings, a list of findings suppressed by the exclusions or an error if something occured
+func (rl *RuleRunner) RunRulesOnRequest(r *http.Request) ([]*data.RuleFindingData, []*data.RuleFindingData, error) {
 	//Create the list which will hold all the matches from all the rules for the request
 	findings := make([]*data.RuleFindingData, 0)
+	suppressedFindings := make([]*data.RuleFindingData, 0)
 
 	//Check if the rules are nil
 	if rl.rules == nil {
-		return findings, nil
+		return findings, suppressedFindings, nil
 	}
 
-	//Loop through all the rules and check if any one of them matches a string in the request
-	for _, rule := range rl.rules {
-		//Check if the rule has request matchers specified
-		if rule.Response == nil {
+	//Parse the request once for all the rules
+	ctx := NewRequestInspectionContext(r, rl.configuration.InspectDecodedURL, rl.logger)
+	//Dump the request to locate the matches in the raw request
+	rawRequest, err := utils.DumpHTTPRequest(r)
+	//Check if an error occured when dumping the request
+	if err != nil {
+		rl.logger.Error("Error occured when dumping the request to raw string", err.Error())
+		return nil, nil, err
+	}
+	ctx.Raw = newRawMessage(string(rawRequest))
+	//Select the candidate rules based on all the values inspected by the rules
+	prefilterTexts, truncated := rl.getPrefilterTexts(RequestPhase, ctx.getInspectedValues())
+	candidateRules := rl.index.getCandidateRules(RequestPhase, prefilterTexts, truncated)
+
+	//Evaluate the candidate rules and collect the findings in the order of the rules
+	evaluations := rl.evaluateRules(candidateRules, func(runner *RuleRunner, rule Rule) ([]*data.RuleFindingData, []*data.RuleFindingData) {
+		//Skip the rules which do not apply to the request and the rules which confirm the request on the response
+		if !rl.index.isRuleInScope(rule, ctx.Target) || ruleConfirmsRequest(rule) {
+			return nil, nil
+		}
+		return rl.index.applyExclusions(rule, ctx.Target, runner.runRuleOnRequest(rule, ctx), func(parameters []string, headers []string) []*data.RuleFindingData {
+			return runner.runRuleOnRequest(rule, ctx.without(parameters, headers))
+		})
+	})
+	for _, evaluation := range evaluations {
+		suppressedFindings = append(suppressedFindings, evaluation.suppressed...)
+		if len(evaluation.findings) == 0 {
 			continue
 		}
+		findings = append(findings, evaluation.findings...)
+		rl.sendRuleAlert(evaluation.rule)
+	}
 
-		allMatches := make([]string, 0)
-		//Check the Headers of the request
-		matches, _ := rl.checkHeaders(r.Header, rule.Response.Headers)
-		allMatches = append(allMatches, matches...)
+	return findings, suppressedFindings, nil
+}
 
-		//Append matches to the list of findings
-		for _, match := range allMatches {
-			findings = append(findings, &data.RuleFindingData{RuleId: rule.Id, RuleName: rule.Info.Name, RuleDescription: rule.Info.Description, Classification: rule.Info.Classification, Severity: ConvertSeverityStringToInteger(rule.Info.Severity), MatchedString: match, Length: int64(len(match))})
-		}
+// Runs a rule on the inspection context of the response
+// @param rule - the rule to run
+// @param ctx - the inspection context of the response
+// Returns the findings of the rule (empty if the rule did not match)
+func (rl *RuleRunner) runRuleOnResponse(rule Rule, ctx *ResponseInspectionContext) []*data.RuleFindingData {
+	findings := make([]*data.RuleFindingData, 0)
+	//Check if the rule has response matchers specified
+	if rule.Response == nil {
+		return findings
+	}
 
-		//Check the body of the request
-		bodyData, err := io.ReadAll(r.Body)
-		//Check if an error occured when getting the body data
-		if err != nil {
-			rl.logger.Error("Error occured when reading the body contents from the request", err.Error())
-		} else {
-			//Reassign the body so other function can read the data
-			r.Body = io.NopCloser(bytes.NewReader(bodyData))
-			matches, hashMatches, _ := rl.checkBody(string(bodyData), rule.Response.Body)
-			//Add the matches to the list of findings
-			for _, match := range matches {
-				findings = append(findings, &data.RuleFindingData{RuleId: rule.Id, RuleName: rule.Info.Name, RuleDescription: rule.Info.Description, Classification: rule.Info.Classification, Severity: ConvertSeverityStringToInteger(rule.Info.Severity), MatchedString: match, Length: int64(len(match))})
-			}
-			//Add the hash matches to the list of matches
-			for _, hashMatch := range hashMatches {
-				findings = append(findings, &data.RuleFindingData{RuleId: rule.Id, RuleName: rule.Info.Name, RuleDescription: rule.Info.Description, Line: -1, LineIndex: -1, Classification: rule.Info.Classification, Severity: ConvertSeverityStringToInteger(rule.Info.Severity), MatchedString: "", MatchedBodyHash: hashMatch.BodyHash, MatchedBodyHashAlg: hashMatch.BodyHashAlgorithm, Length: int64(len(hashMatch.BodyHash))})
+	//Run every matcher of the rule separately so the rule condition can be evaluated
+	results := make([]*matcherResult, 0)
+	//Check the status code of the response
+	if rule.Response.Code != nil {
+		matches, _ := rl.checkCode(ctx.StatusCode, rule.Response.Code)
+		results = append(results, &matcherResult{Reference: "response.code", Matches: matches})
+	}
+	//Check the Headers of the response
+	for i, headerRule := range rule.Response.Headers {
+		matches, _ := rl.checkHeaders(ctx.Headers, []*HeadersRule{headerRule})
+		results = append(results, &matcherResult{Reference: indexedReference("response.headers", i), Matches: matches})
+	}
+	//Check the body of the response
+	for i, bodyRule := range rule.Response.Body {
+		matches, hashMatches, _ := rl.checkBody(ctx.Body, []*BodyRule{bodyRule})
+		results = append(results, &matcherResult{Reference: indexedReference("response.body", i), Matches: matches, HashMatches: hashMatches})
+	}
+	//Check the values from the JSON body of the response
+	for i, jsonRule := range rule.Response.JSON {
+		matches, _ := rl.checkJSON(ctx.Body, []*JSONPathRule{jsonRule})
+		results = append(results, &matcherResult{Reference: indexedReference("response.json", i), Matches: matches})
+	}
+	//Check the values from the XML body of the response
+	for i, xmlRule := range rule.Response.XML {
+		matches, _ := rl.checkXML(ctx.Body, []*XMLPathRule{xmlRule})
+		results = append(results, &matcherResult{Reference: indexedReference("response.xml", i), Matches: matches})
+	}
+	//Check the time the web server took to respond
+	if rule.Response.Latency != nil {
+		results = append(results, &matcherResult{Reference: "response.latency", Matches: rl.checkLatency(ctx.Upstream, rule.Response.Latency)})
+	}
+	//Check the size of the body of the response
+	if rule.Response.Size != nil {
+		results = append(results, &matcherResult{Reference: "response.size", Matches: rl.checkSize(int64(len(ctx.Body.Data)), rule.Response.Size)})
+	}
+
+	//Decide if the rule matched based on the rule condition and add the matches to the list of findings
+	for _, result := range ruleMatchedResults(rule, ResponsePhase, results) {
+		//The latency and the size are not in the raw response
+		isMeasureResult := result.Reference == "response.latency" || result.Reference == "response.size"
+		for _, match := range result.Matches {
+			if isMeasureResult {
+				findings = append(findings, newRuleMeasureFinding(rule, match))
+				continue
 			}
+			finding := newRuleFinding(rule, match)
+			ctx.Raw.locate(finding, match)
+			findings = append(findings, finding)
+		}
+		//Add the hash matches to the list of matches
+		for _, hashMatch := range result.HashMatches {
+			findings = append(findings, newRuleHashFinding(rule, hashMatch))
+		}
+	}
+
+	//The rules confirming the request match only if the request matchers matched too
+	if len(findings) > 0 && rule.Response.ConfirmRequest {
+		if ctx.Request == nil {
+			return make([]*data.RuleFindingData, 0)
+		}
+		requestFindings := rl.runRuleOnRequest(rule, ctx.Request)
+		if len(requestFindings) == 0 {
+			return requestFindings
 		}
+		//The request matches are not located (the request of the response is not dumped) because they are not in the raw response
+		findings = append(requestFindings, findings...)
+	}
+
+	return findings
+}
+
+// Run all the rules on the response
+// The response is parsed once into an inspection context and the rules are evaluated concurrently
+// The scope of the rules and the exclusions are checked on the request of the response (r.Request)
+// @param r - the http response to operate on
+// @param upstream - the time the web server took to respond (nil if it was not measured)
+// Returns a list of findings, a list of findings suppressed by the exclusions or an error if something occured
+func (rl *RuleRunner) RunRulesOnResponse(r *http.Response, upstream *data.UpstreamMetrics) ([]*data.RuleFindingData, []*data.RuleFindingData, error) {
+	//Create the list which will hold all the matches from all the rules for the response
+	findings := make([]*data.RuleFindingData, 0)
+	suppressedFindings := make([]*data.RuleFindingData, 0)
+
+	//Check if the rules are nil
+	if rl.rules == nil {
+		return findings, suppressedFindings, nil
 	}
 
-	//Dump the request
+	//Parse the response once for all the rules
+	ctx := NewResponseInspectionContext(r, upstream, rl.logger)
+	//Dump the response to locate the matches in the raw response
 	rawResponse, err := utils.DumpHTTPResponse(r)
-	//Check if an error occured when dumping the request
+	//Check if an error occured when dumping the response
 	if err != nil {
-		rl.logger.Error("Error occured when dumping the request to raw string", err.Error())
-		return nil, err
+		rl.logger.Error("Error occured when dumping the response to raw string", err.Error())
+		return nil, nil, err
 	}
-
-	//Look for every match in the raw request to find the line number, line offset of the match
-	for _, finding := range findings {
-		//Check if this finding is not a hash match
-		if finding.Line != -1 && finding.LineIndex != -1 {
-			lineNumber, lineOffset, err := utils.FindFindingDataInRawdata(string(rawResponse), finding.MatchedString)
-			//Check if an error occures
-			if err != nil {
-				//Skip the match
-				rl.logger.Error("Skipping match,", finding.MatchedString, "error occured when searching for the match in the raw request", err.Error())
-				continue
+	ctx.Raw = newRawMessage(string(rawResponse))
+	//Select the candidate rules based on all the values inspected by the rules
+	prefilterTexts, truncated := rl.getPrefilterTexts(ResponsePhase, ctx.getInspectedValues())
+	candidateRules := rl.index.getCandidateRules(ResponsePhase, prefilterTexts, truncated)
+	//Parse the request of the response only if a rule needs to confirm it
+	if r.Request != nil {
+		for _, ruleIndex := range candidateRules {
+			if ruleConfirmsRequest(rl.rules[ruleIndex]) {
+				ctx.Request = NewRequestInspectionContext(r.Request, rl.configuration.InspectDecodedURL, rl.logger)
+				break
 			}
-			finding.Line = lineNumber
-			finding.LineIndex = lineOffset
-			//rl.logger.Debug(*finding)
 		}
 	}
 
-	return findings, nil
+	//Evaluate the candidate rules and collect the findings in the order of the rules
+	evaluations := rl.evaluateRules(candidateRules, func(runner *RuleRunner, rule Rule) ([]*data.RuleFindingData, []*data.RuleFindingData) {
+		//Skip the rules which do not apply to the request of the response
+		if !rl.index.isRuleInScope(rule, ctx.Target) {
+			return nil, nil
+		}
+		return rl.index.applyExclusions(rule, ctx.Target, runner.runRuleOnResponse(rule, ctx), func(parameters []string, headers []string) []*data.RuleFindingData {
+			return runner.runRuleOnResponse(rule, ctx.without(headers))
+		})
+	})
+	for _, evaluation := range evaluations {
+		findings = append(findings, evaluation.findings...)
+		suppressedFindings = append(suppressedFindings, evaluation.suppressed...)
+	}
+
+	return findings, suppressedFindings, nil
 }
 
 // Searches for hex match on the value or if the regex can find any hex matches on the given value
@@ -534,8 +814,8 @@ func (rl *RuleRunner) searchHex(value []byte, mode *RuleHexSearchMod) []string {
 
 	//Check if the regex match is specified
 	if mode.Regex != "" {
-		//Compile the regex
-		r, err := regexp.Compile(mode.Regex)
+		//Get the precompiled regex
+		r, err := rl.index.getRegex(mode.Regex)
 
 		//Check if an error occured during the regex compilation
 		if err != nil {
@@ -543,7 +823,9 @@ func (rl *RuleRunner) searchHex(value []byte, mode *RuleHexSearchMod) []string {
 			return nil
 		}
 		//Find all the matches for the regex
+		regexStart := time.Now()
 		matches := r.FindAllString(strings.ToLower(hex.EncodeToString(value)), -1)
+		rl.checkSearchTime(regexStart)
 		//rl.logger.Debug("Value:", value, "Regex:", mode.Regex, "Matches:", matches)
 		//Check if there were any matches
 		if len(matches) > 0 {
@@ -558,58 +840,117 @@ func (rl *RuleRunner) searchHex(value []byte, mode *RuleHexSearchMod) []string {
 }
 
 // Run the rules on the websocket message
-func (rl *RuleRunner) RunRulesOnWebsocketMessage(messageType int, messageText []byte) ([]*data.RuleFindingData, error) {
+// The scope of the rules and the exclusions are checked on the upgrade request of the connection
+// @param connection - the context of the websocket connection (nil if the upgrade request is not known)
+// @param direction - the direction of the message (client-to-server, server-to-client)
+// @param messageType - the type of the message (1 - text, 2 - binary)
+// @param messageText - the content of the message
+// Returns a list of findings, a list of findings suppressed by the exclusions or an error if something occured
+func (rl *RuleRunner) RunRulesOnWebsocketMessage(connection *WebsocketConnectionContext, direction string, messageType int, messageText []byte) ([]*data.RuleFindingData, []*data.RuleFindingData, error) {
 	//Create the list which will hold all the matches from all the rules for the request
 	findings := make([]*data.RuleFindingData, 0)
+	suppressedFindings := make([]*data.RuleFindingData, 0)
 
 	//Check if the rules are nil
 	if rl.rules == nil {
-		return findings, nil
+		return findings, suppressedFindings, nil
 	}
 
-	//Loop through all the rules and check if any one of them matches a string in the request
-	//TO DO... Run each rule on a different go routine
-	for _, rule := range rl.rules {
-		//Create the list of all matches
-		allMatches := make([]string, 0)
+	//The JSON text messages are parsed only when a rule needs them (once for all the rules)
+	var body *InspectedBody = nil
+	if messageType == 1 {
+		body = newInspectedBody(messageText, "application/json")
+	}
 
-		//Check if the rule has request matchers specified
-		if rule.Websocket == nil {
-			continue
+	//Select the candidate rules based on the message (binary messages are searched as hex) and the headers of the upgrade request
+	inspectedValues := []string{string(messageText)}
+	if messageType == 2 {
+		inspectedValues = []string{hex.EncodeToString(messageText)}
+	}
+	if body != nil {
+		inspectedValues = append(inspectedValues, body.getDocumentValues()...)
+	}
+	if connection != nil {
+		for _, headerValues := range connection.Headers {
+			inspectedValues = append(inspectedValues, headerValues...)
+		}
+	}
+	prefilterTexts, truncated := rl.getPrefilterTexts(WebsocketPhase, inspectedValues)
+	candidateRules := rl.index.getCandidateRules(WebsocketPhase, prefilterTexts, truncated)
+
+	//Evaluate the candidate rules and collect the findings in the order of the rules
+	target := connection.getTarget()
+	evaluations := rl.evaluateRules(candidateRules, func(runner *RuleRunner, rule Rule) ([]*data.RuleFindingData, []*data.RuleFindingData) {
+		//Skip the rules which do not apply to the upgrade request
+		if !rl.index.isRuleInScope(rule, target) {
+			return nil, nil
 		}
+		return rl.index.applyExclusions(rule, target, runner.runRuleOnWebsocketMessage(rule, connection, direction, messageType, messageText, body), func(parameters []string, headers []string) []*data.RuleFindingData {
+			return runner.runRuleOnWebsocketMessage(rule, connection.without(headers), direction, messageType, messageText, body)
+		})
+	})
+	for _, evaluation := range evaluations {
+		findings = append(findings, evaluation.findings...)
+		suppressedFindings = append(suppressedFindings, evaluation.suppressed...)
+	}
 
-		for _, ws_rule := range rule.Websocket {
-			//Check if the message is text
-			if messageType == 1 {
-				matches := rl.search(string(messageText), &RuleSearchMode{Match: ws_rule.Match, Regex: ws_rule.Regex, Encodings: nil})
-				allMatches = append(allMatches, matches...)
-			}
+	return findings, suppressedFindings, nil
+}
 
-			//Check if the message is binary and apply the hex search
-			if messageType == 2 {
-				matches := rl.searchHex(messageText, &RuleHexSearchMod{Match: ws_rule.Match, Regex: ws_rule.Regex})
-				allMatches = append(allMatches, matches...)
-			}
-		}
+// Runs a rule on the websocket message
+// @param rule - the rule to run
+// @param connection - the context of the websocket connection (nil if the upgrade request is not known)
+// @param direction - the direction of the message
+// @param messageType - the type of the message (1 - text, 2 - binary)
+// @param messageText - the content of the message
+// @param body - the text message as JSON document (nil for the binary messages)
+// Returns the finding of the rule (empty if the rule did not match)
+func (rl *RuleRunner) runRuleOnWebsocketMessage(rule Rule, connection *WebsocketConnectionContext, direction string, messageType int, messageText []byte, body *InspectedBody) []*data.RuleFindingData {
+	findings := make([]*data.RuleFindingData, 0)
+	//Check if the rule has websocket matchers specified
+	if rule.Websocket == nil {
+		return findings
+	}
 
-		//Append matches to the list of findings
-		for _, match := range allMatches {
-			findingFound := false
-			//Check if the finding is not the classification is already made for this request
-			for _, finding := range findings {
-				if finding.RuleId == rule.Id {
-					findingFound = true
-					break
-				}
+	//Run every matcher of the rule separately so the rule condition can be evaluated
+	results := make([]*matcherResult, 0)
+	for i, ws_rule := range rule.Websocket {
+		matches := make([]searchMatch, 0)
+		switch {
+		case !ws_rule.appliesTo(direction, messageType):
+			//The matcher is for the messages of the other direction or of another type
+		case ws_rule.Header != "":
+			//Check the headers of the upgrade request
+			if connection != nil {
+				matches, _ = rl.checkHeaders(connection.Headers, []*HeadersRule{{Name: ws_rule.Header, Match: ws_rule.Match, Regex: ws_rule.Regex, Encodings: ws_rule.Encodings}})
 			}
-
-			if findingFound {
-				continue
+		case ws_rule.JSONPath != "":
+			//Check the values selected from the JSON text message
+			if body != nil {
+				matches, _ = rl.checkJSON(body, []*JSONPathRule{{Path: ws_rule.JSONPath, Match: ws_rule.Match, Regex: ws_rule.Regex, Encodings: ws_rule.Encodings}})
 			}
+		case messageType == 1:
+			//Check if the message is text
+			matches = setMatchesPart(rl.search(string(messageText), &RuleSearchMode{Match: ws_rule.Match, Regex: ws_rule.Regex, Encodings: ws_rule.Encodings}), MatchPartMessage, "")
+		case messageType == 2:
+			//Check if the message is binary and apply the hex search
+			matches = setMatchesPart(newSearchMatches(rl.searchHex(messageText, &RuleHexSearchMod{Match: ws_rule.Match, Regex: ws_rule.Regex})), MatchPartMessage, "")
+		}
+		results = append(results, &matcherResult{Reference: indexedReference("websocket", i), Matches: matches})
+	}
 
-			findings = append(findings, &data.RuleFindingData{RuleId: rule.Id, RuleName: rule.Info.Name, RuleDescription: rule.Info.Description, Classification: rule.Info.Classification, Severity: ConvertSeverityStringToInteger(rule.Info.Severity), MatchedString: match, Length: int64(len(match)), Line: -1, LineIndex: -1})
+	//Decide if the rule matched based on the rule condition
+	for _, result := range ruleMatchedResults(rule, WebsocketPhase, results) {
+		//Only one finding is added for each rule
+		if len(result.Matches) > 0 {
+			match := result.Matches[0]
+			finding := newRuleFinding(rule, match)
+			//The message is the raw data of the log (the matches on the headers of the upgrade request are not located)
+			newRawWebsocketMessage(messageText).locate(finding, match)
+			findings = append(findings, finding)
+			break
 		}
 	}
 
-	return findings, nil
+	return findings
 }
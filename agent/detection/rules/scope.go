@@ -0,0 +1,184 @@
+package detection
+
+import (
+	"errors"
+	"net"
+	"net/http"
+	"regexp"
+	"strings"
+)
+
+// Holds the information about the request used to check the scope of the rules and the exclusions
+type requestTarget struct {
+	Host     string //The host of the request without the port (lowercase)
+	Path     string //The path of the request (decoded)
+	Method   string //The method of the request
+	RemoteIP net.IP //The IP address of the client (nil if it cannot be parsed)
+}
+
+// Creates the target information of the request
+// @param r - the request
+// Returns the target or nil if the request is nil
+func newRequestTarget(r *http.Request) *requestTarget {
+	if r == nil {
+		return nil
+	}
+	host := r.Host
+	if splitHost, _, err := net.SplitHostPort(host); err == nil {
+		host = splitHost
+	}
+	remoteAddress := r.RemoteAddr
+	if splitAddress, _, err := net.SplitHostPort(remoteAddress); err == nil {
+		remoteAddress = splitAddress
+	}
+	path := ""
+	if r.URL != nil {
+		path = r.URL.Path
+	}
+	return &requestTarget{Host: strings.ToLower(host), Path: path, Method: r.Method, RemoteIP: net.ParseIP(remoteAddress)}
+}
+
+// Converts a path glob into a regex
+// * matches anything inside a path segment, ** matches anything (including /) and ? matches a single character inside a segment
+// @param glob - the path glob (/api/*/users, /static/**)
+// Returns the anchored regex
+func GlobToRegex(glob string) string {
+	var builder strings.Builder
+	builder.WriteString("^")
+	for i := 0; i < len(glob); i++ {
+		switch {
+		case strings.HasPrefix(glob[i:], "**"):
+			builder.WriteString(".*")
+			i++
+		case glob[i] == '*':
+			builder.WriteString("[^/]*")
+		case glob[i] == '?':
+			builder.WriteString("[^/]")
+		default:
+			builder.WriteString(regexp.QuoteMeta(glob[i : i+1]))
+		}
+	}
+	builder.WriteString("$")
+	return builder.String()
+}
+
+// Gets the regexes of the path globs and the path regexes
+func getPathPatterns(globs []string, regexes []string) []string {
+	patterns := make([]string, 0, len(globs)+len(regexes))
+	for _, glob := range globs {
+		patterns = append(patterns, GlobToRegex(glob))
+	}
+	return append(patterns, regexes...)
+}
+
+// Checks if the path matches any of the globs or the regexes
+// @param index - the rule index holding the precompiled regexes
+// @param path - the path of the request
+// @param globs - the path globs
+// @param regexes - the path regexes
+// Returns true if the path matches one of them
+func (index *RuleIndex) matchesPath(path string, globs []string, regexes []string) bool {
+	for _, pattern := range getPathPatterns(globs, regexes) {
+		compiledRegex, err := index.getRegex(pattern)
+		if err != nil {
+			continue
+		}
+		if compiledRegex.MatchString(path) {
+			return true
+		}
+	}
+	return false
+}
+
+// Checks if the host matches the host pattern
+// The patterns starting with *. match all the subdomains of the domain (not the domain itself)
+func matchesHost(host string, pattern string) bool {
+	pattern = strings.ToLower(pattern)
+	if strings.HasPrefix(pattern, "*.") {
+		return strings.HasSuffix(host, pattern[1:])
+	}
+	return host == pattern
+}
+
+// Checks if the request is in the scope of the rule
+// If the request is not known (the response does not have the request) the rule is evaluated
+// @param rule - the rule
+// @param target - the information about the request
+// Returns true if the rule should be evaluated on the request
+func (index *RuleIndex) isRuleInScope(rule Rule, target *requestTarget) bool {
+	if rule.Scope == nil || target == nil {
+		return true
+	}
+	scope := rule.Scope
+	if len(scope.Hosts) > 0 {
+		found := false
+		for _, host := range scope.Hosts {
+			if matchesHost(target.Host, host) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	if len(scope.Methods) > 0 {
+		found := false
+		for _, method := range scope.Methods {
+			if strings.EqualFold(method, target.Method) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	if len(scope.Paths) > 0 || len(scope.PathRegexes) > 0 {
+		if !index.matchesPath(target.Path, scope.Paths, scope.PathRegexes) {
+			return false
+		}
+	}
+	return true
+}
+
+// Checks if the path globs and the path regexes are valid
+func checkPathPatterns(globs []string, regexes []string) error {
+	for _, glob := range globs {
+		if !strings.HasPrefix(glob, "/") && !strings.HasPrefix(glob, "*") {
+			return errors.New("path glob should start with /, " + glob)
+		}
+	}
+	for _, pattern := range regexes {
+		if _, err := regexp.Compile(pattern); err != nil {
+			return errors.New("cannot compile path regex, " + err.Error())
+		}
+	}
+	return nil
+}
+
+// Checks if the scope of the rule is valid
+// @param scope - the scope of the rule
+// Returns an error if the scope is not valid
+func CheckRuleScope(scope *RuleScope) error {
+	if scope == nil {
+		return nil
+	}
+	if len(scope.Hosts) == 0 && len(scope.Paths) == 0 && len(scope.PathRegexes) == 0 && len(scope.Methods) == 0 {
+		return errors.New("rule scope should have at least one of: hosts, paths, path-regex, methods")
+	}
+	for _, host := range scope.Hosts {
+		if strings.TrimSpace(host) == "" || strings.ContainsAny(host, "/: ") {
+			return errors.New("rule scope host is not valid, " + host)
+		}
+	}
+	for _, method := range scope.Methods {
+		if strings.TrimSpace(method) == "" || strings.ContainsAny(method, " /") {
+			return errors.New("rule scope method is not valid, " + method)
+		}
+	}
+	if err := checkPathPatterns(scope.Paths, scope.PathRegexes); err != nil {
+		return errors.New("rule scope " + err.Error())
+	}
+	return nil
+}
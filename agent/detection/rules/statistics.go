@@ -0,0 +1,182 @@
+package detection
+
+import (
+	"math"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/lucacoratu/disertatie/agent/data"
+)
+
+// The default time after which a regex search is counted as slow
+// The regexes are RE2 so they run in linear time and cannot be stopped, the searches are only measured
+// A slow search means a costly pattern (large alternations, bounded repetitions) on a large value
+const defaultSlowSearchThreshold = 100 * time.Millisecond
+
+// The upper bounds of the buckets of the evaluation times (in microseconds) used to compute the percentiles
+// The last bucket holds the evaluations slower than the last bound
+var ruleTimeBuckets = []int64{5, 10, 25, 50, 100, 250, 500, 1000, 2500, 5000, 10000, 25000, 50000, 100000, 250000, 500000, 1000000}
+
+// Holds the counters of a rule
+// The counters are updated by the workers concurrently so all of them are atomic
+type ruleCounters struct {
+	evaluations  atomic.Int64
+	matches      atomic.Int64
+	totalTime    atomic.Int64
+	maxTime      atomic.Int64
+	slowSearches atomic.Int64
+	lastMatch    atomic.Int64
+	histogram    []atomic.Int64
+}
+
+// Adds the evaluation time to the histogram and the total and keeps the slowest evaluation
+func (counters *ruleCounters) addTime(elapsed int64) {
+	counters.totalTime.Add(elapsed)
+	bucket := sort.Search(len(ruleTimeBuckets), func(i int) bool { return ruleTimeBuckets[i] >= elapsed })
+	counters.histogram[bucket].Add(1)
+	for {
+		current := counters.maxTime.Load()
+		if elapsed <= current || counters.maxTime.CompareAndSwap(current, elapsed) {
+			break
+		}
+	}
+}
+
+// Gets the percentile of the evaluation time from the histogram
+// The upper bound of the bucket is returned (the slowest evaluation for the last bucket)
+func (counters *ruleCounters) getPercentile(percentile float64) int64 {
+	total := int64(0)
+	for i := range counters.histogram {
+		total += counters.histogram[i].Load()
+	}
+	if total == 0 {
+		return 0
+	}
+	threshold := int64(math.Ceil(float64(total) * percentile))
+	count := int64(0)
+	for i := range counters.histogram {
+		count += counters.histogram[i].Load()
+		if count >= threshold && i < len(ruleTimeBuckets) {
+			return min(ruleTimeBuckets[i], counters.maxTime.Load())
+		}
+	}
+	return counters.maxTime.Load()
+}
+
+// Holds the measurements of a rule evaluation which are not returned by the evaluation (the slow searches)
+// Every evaluation has its own profile so the measurements are not mixed between the workers
+type ruleProfile struct {
+	slowSearchThreshold time.Duration
+	slowSearches        int64
+}
+
+// Holds the performance and the hit counters of the rules since the agent started
+// The counters are kept by rule id so they are not lost when the rules are reloaded
+type RuleStatistics struct {
+	mu       sync.RWMutex
+	counters map[string]*ruleCounters
+	since    time.Time
+}
+
+// Creates a new rule statistics store
+func NewRuleStatistics() *RuleStatistics {
+	return &RuleStatistics{counters: make(map[string]*ruleCounters), since: time.Now()}
+}
+
+// Gets the counters of the rule, the counters are created on the first evaluation
+func (stats *RuleStatistics) getCounters(ruleId string) *ruleCounters {
+	stats.mu.RLock()
+	counters, found := stats.counters[ruleId]
+	stats.mu.RUnlock()
+	if found {
+		return counters
+	}
+
+	stats.mu.Lock()
+	defer stats.mu.Unlock()
+	counters, found = stats.counters[ruleId]
+	if !found {
+		counters = &ruleCounters{histogram: make([]atomic.Int64, len(ruleTimeBuckets)+1)}
+		stats.counters[ruleId] = counters
+	}
+	return counters
+}
+
+// Records an evaluation of the rule
+// @param ruleId - the id of the rule
+// @param elapsed - the time the evaluation took
+// @param matched - if the rule matched
+// @param profile - the measurements of the evaluation (nil if they were not taken)
+func (stats *RuleStatistics) record(ruleId string, elapsed time.Duration, matched bool, profile *ruleProfile) {
+	if stats == nil {
+		return
+	}
+	counters := stats.getCounters(ruleId)
+	counters.evaluations.Add(1)
+	counters.addTime(elapsed.Microseconds())
+	if matched {
+		counters.matches.Add(1)
+		counters.lastMatch.Store(time.Now().Unix())
+	}
+	if profile != nil && profile.slowSearches > 0 {
+		counters.slowSearches.Add(profile.slowSearches)
+	}
+}
+
+// Gets the time since the counters are kept
+func (stats *RuleStatistics) GetSince() time.Time {
+	return stats.since
+}
+
+// Gets the counters of the rules
+// The rules which were not evaluated yet are included with empty counters so the rules which never matched can be found
+// @param rules - the loaded rules
+// Returns the counters sorted by the cumulative evaluation time (the most expensive rules first)
+func (stats *RuleStatistics) Snapshot(rules []Rule) []data.RuleStatistics {
+	snapshot := make([]data.RuleStatistics, 0, len(rules))
+	for _, rule := range rules {
+		ruleStatistics := data.RuleStatistics{RuleId: rule.Id}
+		stats.mu.RLock()
+		counters, found := stats.counters[rule.Id]
+		stats.mu.RUnlock()
+		if found {
+			ruleStatistics.Evaluations = counters.evaluations.Load()
+			ruleStatistics.Matches = counters.matches.Load()
+			ruleStatistics.TotalTime = counters.totalTime.Load()
+			ruleStatistics.P99Time = counters.getPercentile(0.99)
+			ruleStatistics.MaxTime = counters.maxTime.Load()
+			ruleStatistics.SlowSearches = counters.slowSearches.Load()
+			ruleStatistics.LastMatch = counters.lastMatch.Load()
+		}
+		snapshot = append(snapshot, ruleStatistics)
+	}
+	sort.SliceStable(snapshot, func(i, j int) bool {
+		return snapshot[i].TotalTime > snapshot[j].TotalTime
+	})
+	return snapshot
+}
+
+// Creates the profile of a rule evaluation (nil if the rules are not profiled)
+func (rl *RuleRunner) newRuleProfile() *ruleProfile {
+	if rl.statistics == nil {
+		return nil
+	}
+	slowSearchThreshold := defaultSlowSearchThreshold
+	if rl.configuration.SlowSearchThreshold > 0 {
+		slowSearchThreshold = time.Duration(rl.configuration.SlowSearchThreshold) * time.Millisecond
+	}
+	return &ruleProfile{slowSearchThreshold: slowSearchThreshold}
+}
+
+// Counts the regex search as slow if it took longer than the slow search threshold
+// @param start - when the regex search started
+func (rl *RuleRunner) checkSearchTime(start time.Time) {
+	if rl.profile == nil {
+		return
+	}
+	if time.Since(start) > rl.profile.slowSearchThreshold {
+		rl.profile.slowSearches++
+	}
+}
@@ -0,0 +1,116 @@
+package detection
+
+import (
+	"errors"
+	"strconv"
+	"strings"
+
+	"github.com/lucacoratu/disertatie/agent/data"
+)
+
+// The values accepted by the measure field of the latency matcher
+const (
+	LatencyMeasureTTFB  = "ttfb"  //The time until the first byte of the response was received
+	LatencyMeasureTotal = "total" //The time until the whole response was received
+)
+
+// Checks if the value is between the bounds
+func (rangeMatch *RuleRangeMatch) contains(value int64) bool {
+	if rangeMatch.Gt != nil && value <= *rangeMatch.Gt {
+		return false
+	}
+	if rangeMatch.Lt != nil && value >= *rangeMatch.Lt {
+		return false
+	}
+	return true
+}
+
+// Checks if the bounds of the numeric matcher are valid
+// @param name - the name of the matcher (used in the error)
+// @param rangeMatch - the bounds
+// Returns an error if the bounds are not valid
+func checkRuleRangeMatch(name string, rangeMatch *RuleRangeMatch) error {
+	if rangeMatch.Gt == nil && rangeMatch.Lt == nil {
+		return errors.New("rule response " + name + " should have gt or lt")
+	}
+	if (rangeMatch.Gt != nil && *rangeMatch.Gt < 0) || (rangeMatch.Lt != nil && *rangeMatch.Lt < 0) {
+		return errors.New("rule response " + name + " bounds cannot be negative")
+	}
+	if rangeMatch.Gt != nil && rangeMatch.Lt != nil && *rangeMatch.Gt >= *rangeMatch.Lt {
+		return errors.New("rule response " + name + " gt should be less than lt")
+	}
+	return nil
+}
+
+// Checks if the latency and the size matchers of the response and the confirm-request field are valid
+// @param rule - the rule to be checked
+// Returns an error if any of them is not valid
+func CheckResponseMeasures(rule Rule) error {
+	if rule.Response == nil {
+		return nil
+	}
+	if rule.Response.Latency != nil {
+		if err := checkRuleRangeMatch("latency", &rule.Response.Latency.RuleRangeMatch); err != nil {
+			return err
+		}
+		measure := strings.ToLower(rule.Response.Latency.Measure)
+		if measure != "" && measure != LatencyMeasureTTFB && measure != LatencyMeasureTotal {
+			return errors.New("rule response latency measure cannot be something other than: ttfb, total")
+		}
+	}
+	if rule.Response.Size != nil {
+		if err := checkRuleRangeMatch("size", rule.Response.Size); err != nil {
+			return err
+		}
+	}
+	if rule.Response.ConfirmRequest && len(getMatcherReferences(rule, RequestPhase)) == 0 {
+		return errors.New("rule response confirm-request needs request matchers to confirm")
+	}
+	return nil
+}
+
+// Checks if the time the web server took to respond matches the latency matcher
+// @param upstream - the measurements of the response (nil if the response was not received from the web server)
+// @param ruleLatency - the latency matcher
+// Returns the list of matches (the latency in milliseconds)
+func (rl *RuleRunner) checkLatency(upstream *data.UpstreamMetrics, ruleLatency *RuleLatencyMatch) []searchMatch {
+	if upstream == nil || ruleLatency == nil {
+		return make([]searchMatch, 0)
+	}
+	latency := upstream.TimeToFirstByte
+	if strings.ToLower(ruleLatency.Measure) == LatencyMeasureTotal {
+		latency = upstream.Duration
+	}
+	if !ruleLatency.contains(latency) {
+		return make([]searchMatch, 0)
+	}
+	return setMatchesPart(newSearchMatches([]string{strconv.FormatInt(latency, 10) + "ms"}), MatchPartLatency, "")
+}
+
+// Checks if the size of the response body matches the size matcher
+// @param size - the size of the body in bytes
+// @param ruleSize - the size matcher
+// Returns the list of matches (the size in bytes)
+func (rl *RuleRunner) checkSize(size int64, ruleSize *RuleRangeMatch) []searchMatch {
+	if ruleSize == nil || !ruleSize.contains(size) {
+		return make([]searchMatch, 0)
+	}
+	return setMatchesPart(newSearchMatches([]string{strconv.FormatInt(size, 10) + " bytes"}), MatchPartSize, "")
+}
+
+// Creates the rule finding structure for a latency or size match of the rule
+// The measurements are not in the raw response so the finding does not have a location
+// @param rule - the rule which matched
+// @param match - the measurement which matched
+// Returns the rule finding
+func newRuleMeasureFinding(rule Rule, match searchMatch) *data.RuleFindingData {
+	finding := newRuleFinding(rule, match)
+	finding.Line = -1
+	finding.LineIndex = -1
+	return finding
+}
+
+// Checks if the rule confirms the request before reporting the response matches
+func ruleConfirmsRequest(rule Rule) bool {
+	return rule.Response != nil && rule.Response.ConfirmRequest
+}
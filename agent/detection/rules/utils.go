@@ -1,19 +1,31 @@
 package detection
 
 import (
+	"crypto/md5"
+	"crypto/sha256"
+	"encoding/hex"
 	"errors"
 	"io/fs"
+	"net/url"
 	"os"
 	"path/filepath"
 	"regexp"
 	"strings"
 
+	"github.com/antchfx/xpath"
 	"github.com/lucacoratu/disertatie/agent/config"
 	"github.com/lucacoratu/disertatie/agent/data"
 	"github.com/lucacoratu/disertatie/agent/logging"
 )
 
-var SupportedEncodings = []string{"base64", "url"}
+// The formats of the CWE ids (CWE-89) and of the MITRE ATT&CK techniques (T1190, T1059.004)
+var (
+	cweIdRegex          = regexp.MustCompile(`^CWE-[0-9]+$`)
+	mitreTechniqueRegex = regexp.MustCompile(`^T[0-9]{4}(\.[0-9]{3})?$`)
+)
+
+// The encodings which can be specified in the rules (the decoders can be found in decoder.go)
+var SupportedEncodings = []string{"base64", "base64url", "url", "hex", "html", "js", "utf8-overlong", "sql-char", "gzip", "deflate"}
 
 // Loads all the rules that can be found in the specified directory
 // Pass the logger as a parameter for better view of the problems
@@ -21,18 +33,127 @@ var SupportedEncodings = []string{"base64", "url"}
 // @param logger - the logger to be used to display the errors
 // If the directory cannot be opened to read all the files in it then an error is returned
 func LoadRulesFromDirectory(configuration config.Configuration, logger logging.ILogger) ([]Rule, error) {
+	return loadRulesFromDirectory(configuration, logger, false)
+}
+
+// Loads all the rules that can be found in the specified directory, failing if any of the rule files is not valid
+// It is used when reloading the rules so that a broken rule file does not silently remove rules from the running set
+// @param configuration - the configuration of the agent (the rules directory and the ignored directories)
+// @param logger - the logger to be used to display the errors
+// Returns the list of rules or an error describing the first rule file which is not valid
+func LoadRulesFromDirectoryStrict(configuration config.Configuration, logger logging.ILogger) ([]Rule, error) {
+	return loadRulesFromDirectory(configuration, logger, true)
+}
+
+// Loads the rules from the directory
+// @param configuration - the configuration of the agent (the rules directory and the ignored directories)
+// @param logger - the logger to be used to display the errors
+// @param strict - if true an invalid rule file stops the loading, otherwise the file is skipped
+// Returns the list of rules or an error
+func loadRulesFromDirectory(configuration config.Configuration, logger logging.ILogger, strict bool) ([]Rule, error) {
+	rulesList, _, err := loadRuleFilesFromDirectory(configuration, logger, strict, nil)
+	return rulesList, err
+}
+
+// Loads and validates a rule file
+// @param path - the path of the rule file
+// @param configuration - the configuration of the agent (if the unknown keys and the lint errors reject the rule)
+// @param library - the pattern lists and the macros referenced by the rule
+// @param logger - the logger
+// Returns the rule or an error describing why the rule file is not valid
+func loadRuleFile(path string, configuration config.Configuration, library *PatternLibrary, logger logging.ILogger) (Rule, error) {
+	//Try to load the path content into a rule structure
+	rule := Rule{}
+	file, err := os.Open(path)
+	//Check if an error occured when opening the yaml rule file
+	if err != nil {
+		return rule, errors.New("could not open the file for reading " + err.Error())
+	}
+	//In strict rules mode the unknown keys are rejected
+	if configuration.StrictRules {
+		err = rule.FromYAMLStrict(file)
+	} else {
+		err = rule.FromYAML(file)
+	}
+	file.Close()
+	//Check if an error occured when loading the yaml file
+	if err != nil {
+		return rule, errors.New("error when parsing " + err.Error())
+	}
+	//Replace the pattern lists and the macros referenced by the matchers with regexes
+	library.ResolveRule(&rule)
+	//Check if the rule is valid
+	err = CheckRule(rule, logger)
+	if err != nil {
+		//The rule is not valid
+		return rule, errors.New("error when checking rule, " + err.Error())
+	}
+	//In strict rules mode the lint errors reject the rule as well
+	if configuration.StrictRules {
+		if err := firstLintError(LintRule(rule)); err != nil {
+			return rule, errors.New("error when linting rule, " + err.Error())
+		}
+	}
+	//Apply the encodings to the matching subrules based on the global and local encodings lists
+	err = HandleEncodingsField(&rule)
+	if err != nil {
+		return rule, errors.New("error occured when handling encodings lists " + err.Error())
+	}
+	return rule, nil
+}
+
+// Loads the rules from the directory together with the files they were loaded from
+// @param configuration - the configuration of the agent (the rules directory and the ignored directories)
+// @param logger - the logger to be used to display the errors
+// @param strict - if true an invalid rule file stops the loading, otherwise the file is skipped
+// @param previousRules - the rules loaded previously mapped by the path of their files, an invalid file keeps its previous rule instead of being skipped (nil if there are none)
+// Returns the list of rules, the rules mapped by the path of their files or an error
+func loadRuleFilesFromDirectory(configuration config.Configuration, logger logging.ILogger, strict bool, previousRules map[string]Rule) ([]Rule, map[string]Rule, error) {
 	rulesDirectory := configuration.RulesDirectory
 
 	//Check if the directory exists
 	_, err := os.Stat(rulesDirectory)
 	if err != nil {
-		return nil, errors.New("rules directory does not exist")
+		return nil, nil, errors.New("rules directory does not exist")
+	}
+
+	//Skips the rule file or stops the loading in strict mode
+	skipRuleFile := func(path string, reason string) error {
+		if strict {
+			return errors.New("invalid rule file " + path + ", " + reason)
+		}
+		logger.Warning("Skipping rule file", path, reason)
+		return nil
+	}
+
+	//Load the pattern lists and the macros which can be referenced from the rules
+	library, err := LoadPatternLibrary(rulesDirectory)
+	if err != nil {
+		if strict {
+			return nil, nil, errors.New("invalid pattern library, " + err.Error())
+		}
+		logger.Error("Could not load the pattern lists and the macros, the rules referencing them will be skipped,", err.Error())
 	}
+
 	//Traverse the directory to get all the rules and append them to the list
 	rulesList := make([]Rule, 0)
+	ruleFiles := make(map[string]Rule)
 	err = filepath.WalkDir(rulesDirectory, func(path string, d fs.DirEntry, err error) error {
+		//Check if the directory entry could be read
+		if err != nil {
+			return skipRuleFile(path, "could not read the directory entry, "+err.Error())
+		}
+
 		//Check if the directory is not in the list of ignored directories from the config
 		if d.IsDir() {
+			//The lists directory holds the pattern lists and the macros, not rules
+			if isPatternListsDirectory(rulesDirectory, path) {
+				return filepath.SkipDir
+			}
+			//The rule set received from the API is written in a staging directory before it is applied
+			if isRuleSetStagingDirectory(rulesDirectory, path) {
+				return filepath.SkipDir
+			}
 			if configuration.IgnoreRulesDirectories != nil {
 				for _, ignoreDir := range configuration.IgnoreRulesDirectories {
 					if ignoreDir == d.Name() {
@@ -46,63 +167,44 @@ func LoadRulesFromDirectory(configuration config.Configuration, logger logging.I
 
 		//If the directory entry is not a directory
 		if !d.IsDir() {
+			//The version of the rule set received from the API is not a rule
+			if isRuleSetVersionFile(rulesDirectory, path) {
+				return nil
+			}
 			//If the file ends doesn't .yaml
 			if !strings.HasSuffix(d.Name(), ".yaml") {
 				logger.Warning("Skipping rule file", path, ", it is not a yaml file, check the file extension")
 				return nil
 			}
 			//The file has .yaml extension
-			//Try to load the path content into a rule structure
-			rule := Rule{}
-			file, err := os.Open(path)
-			//Check if an error occured when opening the yaml rule file
-			if err != nil {
-				//Log the error
-				logger.Warning("Skipping rule file", path, "could not open the file for reading", err.Error())
-				return nil
-			}
-			err = rule.FromYAML(file)
-			//Check if an error occured when loading the yaml file
+			rule, err := loadRuleFile(path, configuration, library, logger)
 			if err != nil {
-				logger.Warning("Skipping rule file", path, "error when parsing", err.Error())
-				return nil
-			}
-			//Check if the rule is valid
-			err = CheckRule(rule, logger)
-			if err != nil {
-				//The rule is not valid
-				logger.Warning("Skipping rule file", path, "error when checking rule,", err.Error())
-				return nil
-			}
-			//Check if the rule id is not already in the list of rules
-			var found bool = false
-			for _, ruleElem := range rulesList {
-				if ruleElem.Id == rule.Id {
-					found = true
-					break
+				//A file which was valid before keeps its previous rule until it is fixed
+				previousRule, found := previousRules[path]
+				if strict || !found {
+					return skipRuleFile(path, err.Error())
 				}
+				logger.Warning("Keeping the previous version of the rule file", path, err.Error())
+				rule = previousRule
 			}
-			if found {
-				logger.Warning("Skipping rule file", path, "a rule with this id already exists")
-				return nil
-			}
-
-			//Apply the encodings to the matching subrules based on the global and local encodings lists
-			err = HandleEncodingsField(&rule)
-			if err != nil {
-				logger.Warning("Skipping rule file", path, "error occured when handling encodings lists", err.Error())
-				return nil
+			//Check if the rule id is not already in the list of rules
+			if GetRule(rulesList, rule.Id) != nil {
+				return skipRuleFile(path, "a rule with this id already exists")
 			}
 
 			//Add the rule read from file to the list of rules
 			rulesList = append(rulesList, rule)
+			ruleFiles[path] = rule
 		}
 		return nil
 	})
 	if err != nil {
-		return nil, errors.New("could not walk rules directory")
+		if strict {
+			return nil, nil, err
+		}
+		return nil, nil, errors.New("could not walk rules directory")
 	}
-	return rulesList, nil
+	return rulesList, ruleFiles, nil
 }
 
 // Adds the encodings field based on the definition of the encodings
@@ -137,6 +239,23 @@ func HandleEncodingsField(rule *Rule) error {
 					bodyRule.Encodings = rule.Info.Encodings
 				}
 			}
+
+			//Inherit the list of global encodings to the cookies matching rules
+			for _, cookieRule := range rule.Request.Cookies {
+				if cookieRule.Encodings == nil {
+					cookieRule.Encodings = rule.Info.Encodings
+				}
+			}
+
+			//Inherit the list of global encodings to the JSON and XML matching rules
+			inheritDocumentEncodings(rule.Request.JSON, rule.Request.XML, rule.Info.Encodings)
+
+			//Inherit the list of global encodings to the files matching rules
+			for _, mode := range getFilesSearchModes(rule.Request.Files) {
+				if mode.Encodings == nil {
+					mode.Encodings = rule.Info.Encodings
+				}
+			}
 		}
 
 		if rule.Response != nil {
@@ -155,6 +274,16 @@ func HandleEncodingsField(rule *Rule) error {
 					bodyRule.Encodings = rule.Info.Encodings
 				}
 			}
+
+			//Inherit the list of global encodings to the JSON and XML matching rules
+			inheritDocumentEncodings(rule.Response.JSON, rule.Response.XML, rule.Info.Encodings)
+		}
+
+		//Inherit the list of global encodings to the websocket matching rules
+		for _, wsRule := range rule.Websocket {
+			if wsRule.Encodings == nil {
+				wsRule.Encodings = rule.Info.Encodings
+			}
 		}
 
 		//Inherit the global encodings to the independent fields of the rule
@@ -186,6 +315,88 @@ func HandleEncodingsField(rule *Rule) error {
 	return nil
 }
 
+// Sets the global encodings on the JSON and XML matching rules which do not have a list of encodings
+func inheritDocumentEncodings(jsonRules []*JSONPathRule, xmlRules []*XMLPathRule, encodings []string) {
+	for _, jsonRule := range jsonRules {
+		if jsonRule.Encodings == nil {
+			jsonRule.Encodings = encodings
+		}
+	}
+	for _, xmlRule := range xmlRules {
+		if xmlRule.Encodings == nil {
+			xmlRule.Encodings = encodings
+		}
+	}
+}
+
+// Checks if the JSON and XML matching rules have valid paths and regexes
+// @param jsonRules - the JSON matching rules
+// @param xmlRules - the XML matching rules
+// Returns an error if any of the paths or regexes cannot be compiled
+func CheckDocumentRules(jsonRules []*JSONPathRule, xmlRules []*XMLPathRule) error {
+	for _, jsonRule := range jsonRules {
+		if _, err := compileJSONPath(jsonRule.Path); err != nil {
+			return errors.New("cannot compile JSONPath, " + err.Error())
+		}
+		if _, err := regexp.Compile(jsonRule.Regex); err != nil {
+			return errors.New("cannot compile regex for JSONPath, " + jsonRule.Path + ", " + err.Error())
+		}
+	}
+	for _, xmlRule := range xmlRules {
+		if _, err := xpath.Compile(xmlRule.Path); err != nil {
+			return errors.New("cannot compile XPath, " + xmlRule.Path + ", " + err.Error())
+		}
+		if _, err := regexp.Compile(xmlRule.Regex); err != nil {
+			return errors.New("cannot compile regex for XPath, " + xmlRule.Path + ", " + err.Error())
+		}
+	}
+	return nil
+}
+
+// Gets the search modes of the files matching rules
+func getFilesSearchModes(filesRules []*FilesRule) []*RuleSearchMode {
+	modes := make([]*RuleSearchMode, 0)
+	for _, fileRule := range filesRules {
+		for _, mode := range []*RuleSearchMode{fileRule.Filename, fileRule.ContentType, fileRule.SniffedContentType} {
+			if mode != nil {
+				modes = append(modes, mode)
+			}
+		}
+	}
+	return modes
+}
+
+// Checks if the files matching rules are valid
+// @param filesRules - the files matching rules
+// Returns an error if any of the files rules is not valid
+func CheckFilesRules(filesRules []*FilesRule) error {
+	for _, fileRule := range filesRules {
+		//Check if the file rule has at least one criterion (else it would match every uploaded file)
+		if fileRule.Filename == nil && fileRule.ContentType == nil && fileRule.SniffedContentType == nil && !fileRule.ContentTypeMismatch && fileRule.MinSize == 0 && fileRule.MaxSize == 0 && fileRule.MD5Sum == "" && fileRule.SHA256Sum == "" {
+			return errors.New("files matcher should have at least one criterion")
+		}
+		if fileRule.MinSize < 0 || fileRule.MaxSize < 0 || (fileRule.MaxSize > 0 && fileRule.MinSize > fileRule.MaxSize) {
+			return errors.New("files matcher has invalid size limits")
+		}
+		if fileRule.MD5Sum != "" {
+			if decoded, err := hex.DecodeString(fileRule.MD5Sum); err != nil || len(decoded) != md5.Size {
+				return errors.New("files matcher has invalid md5sum, " + fileRule.MD5Sum)
+			}
+		}
+		if fileRule.SHA256Sum != "" {
+			if decoded, err := hex.DecodeString(fileRule.SHA256Sum); err != nil || len(decoded) != sha256.Size {
+				return errors.New("files matcher has invalid sha256sum, " + fileRule.SHA256Sum)
+			}
+		}
+	}
+	for _, mode := range getFilesSearchModes(filesRules) {
+		if _, err := regexp.Compile(mode.Regex); err != nil {
+			return errors.New("cannot compile regex for files matcher, " + err.Error())
+		}
+	}
+	return nil
+}
+
 // Check if the rule information is valid or not
 // @param info - the rule information structure
 // Returns an error if the info field is not valid
@@ -200,30 +411,56 @@ func CheckRuleInfo(info *RuleInfo) error {
 		return errors.New("rule severity cannot be something apart from: low, medium, high, critical")
 	}
 
-	//Check if the rule action is one of the allowed (case insensitive) - allow, drop
-	//This should apply only if the rule action is not empty
-	if info.Action != "" {
-		if strings.ToLower(info.Action) != "allow" && strings.ToLower(info.Action) != "drop" {
-			return errors.New("rule action cannot be something other than: allow, drop")
-		}
+	//Check if the rule action is one of the allowed (case insensitive) and its parameters are valid
+	if err := CheckRuleAction(info); err != nil {
+		return err
 	}
 
 	//Check if the encodings is a list containing supported encodings
 	if info.Encodings != nil {
 		for _, encoding := range info.Encodings {
-			var found bool = false
-			for _, supportedEncoding := range SupportedEncodings {
-				if strings.ToLower(encoding) == supportedEncoding {
-					found = true
-				}
-			}
-
-			if !found {
+			if !isSupportedEncoding(encoding) {
 				return errors.New("rule encodings contains unsuported encodings, " + encoding)
 			}
 		}
 	}
 
+	//Check the tags, the references, the CVSS score, the CWE ids and the MITRE ATT&CK techniques
+	if err := CheckRuleMetadata(info); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// Checks if the metadata of the rule (carried in the rule findings) is valid
+// @param info - the rule information structure
+// Returns an error if the metadata is not valid
+func CheckRuleMetadata(info *RuleInfo) error {
+	for _, tag := range info.Tags {
+		if strings.TrimSpace(tag) == "" || strings.ContainsAny(tag, ", ") {
+			return errors.New("rule tag is not valid, it should not be empty or contain spaces and commas, " + tag)
+		}
+	}
+	for _, reference := range info.References {
+		parsedReference, err := url.Parse(reference)
+		if err != nil || parsedReference.Scheme == "" || parsedReference.Host == "" {
+			return errors.New("rule reference should be an absolute URL, " + reference)
+		}
+	}
+	if info.CVSSScore < 0 || info.CVSSScore > 10 {
+		return errors.New("rule cvss-score should be between 0 and 10")
+	}
+	for _, cweId := range info.CWEIds {
+		if !cweIdRegex.MatchString(cweId) {
+			return errors.New("rule cwe id should have the CWE-<number> format, " + cweId)
+		}
+	}
+	for _, technique := range info.MitreTechniques {
+		if !mitreTechniqueRegex.MatchString(technique) {
+			return errors.New("rule mitre technique should have the T<number> or T<number>.<number> format, " + technique)
+		}
+	}
 	return nil
 }
 
@@ -237,6 +474,16 @@ func CheckRule(rule Rule, logger logging.ILogger) error {
 		return err
 	}
 
+	//Check the requests the rule applies to
+	if err := CheckRuleScope(rule.Scope); err != nil {
+		return err
+	}
+
+	//Check the pattern lists and the macros referenced by the matchers were resolved
+	if err := CheckRulePatternReferences(rule); err != nil {
+		return err
+	}
+
 	//Check if the request field exists in the rule
 	if rule.Request != nil {
 		//Check if the regexes specified in the rule are compiling
@@ -280,11 +527,52 @@ func CheckRule(rule Rule, logger logging.ILogger) error {
 		}
 	}
 
+	//Check the cookies, JSON and XML matchers
+	if rule.Request != nil {
+		for _, cookie := range rule.Request.Cookies {
+			if _, err := regexp.Compile(cookie.Regex); err != nil {
+				return errors.New("cannot compile regex for cookie, " + cookie.Name + ", " + err.Error())
+			}
+		}
+		if err := CheckDocumentRules(rule.Request.JSON, rule.Request.XML); err != nil {
+			return err
+		}
+		if err := CheckFilesRules(rule.Request.Files); err != nil {
+			return err
+		}
+	}
+	if rule.Response != nil {
+		if err := CheckDocumentRules(rule.Response.JSON, rule.Response.XML); err != nil {
+			return err
+		}
+		if err := CheckResponseMeasures(rule); err != nil {
+			return err
+		}
+	}
+	if err := CheckWebsocketRules(rule.Websocket); err != nil {
+		return err
+	}
+
+	//Check the correlation of the requests
+	if err := CheckCorrelationRule(rule); err != nil {
+		return err
+	}
+
 	//Check all the encodings fields
 	if err := CheckEncodingSubfields(rule, logger); err != nil {
 		return errors.New("subfield contains invalid encoding, " + err.Error())
 	}
 
+	//Check the matchers condition and the condition trees
+	if err := CheckRuleConditions(rule); err != nil {
+		return err
+	}
+
+	//Check the samples used to test the rule
+	if err := CheckRuleTests(rule.Tests); err != nil {
+		return err
+	}
+
 	return nil
 }
 
@@ -337,6 +625,30 @@ func CheckEncodingSubfields(rule Rule, logger logging.ILogger) error {
 				return errors.New("Invalid encodings list in request body, " + err.Error())
 			}
 		}
+
+		//Check the request cookies rules
+		for _, subRule := range rule.Request.Cookies {
+			err := CheckEncodingsList(subRule.Encodings)
+			if err != nil {
+				return errors.New("Invalid encodings list in request cookie " + subRule.Name + ", " + err.Error())
+			}
+		}
+
+		//Check the request files rules
+		for _, mode := range getFilesSearchModes(rule.Request.Files) {
+			err := CheckEncodingsList(mode.Encodings)
+			if err != nil {
+				return errors.New("Invalid encodings list in request files matchers, " + err.Error())
+			}
+		}
+
+		//Check the request JSON and XML rules
+		for _, mode := range getDocumentSearchModes(rule.Request.JSON, rule.Request.XML) {
+			err := CheckEncodingsList(mode.Encodings)
+			if err != nil {
+				return errors.New("Invalid encodings list in request JSON/XML matchers, " + err.Error())
+			}
+		}
 	}
 
 	//Check the response code encodings
@@ -363,6 +675,14 @@ func CheckEncodingSubfields(rule Rule, logger logging.ILogger) error {
 				return errors.New("Invalid encodings list in the response body, " + err.Error())
 			}
 		}
+
+		//Check the response JSON and XML rules
+		for _, mode := range getDocumentSearchModes(rule.Response.JSON, rule.Response.XML) {
+			err := CheckEncodingsList(mode.Encodings)
+			if err != nil {
+				return errors.New("Invalid encodings list in the response JSON/XML matchers, " + err.Error())
+			}
+		}
 	}
 
 	//All encodings list have the right values
@@ -371,10 +691,8 @@ func CheckEncodingSubfields(rule Rule, logger logging.ILogger) error {
 
 func CheckEncodingsList(encodings []string) error {
 	for _, encoding := range encodings {
-		for _, supportedEncoding := range SupportedEncodings {
-			if strings.ToLower(encoding) != supportedEncoding {
-				return errors.New("invalid encoding specified, " + encoding)
-			}
+		if !isSupportedEncoding(encoding) {
+			return errors.New("invalid encoding specified, " + encoding)
 		}
 	}
 	return nil
@@ -401,7 +719,7 @@ func ConvertSeverityStringToInteger(severity string) int64 {
 // Gets the action for a specific rule
 // @param rules - the list of rules loaded from disk
 // @param ruleId - the id of the rule to retrieve the action field
-// Returns the action defined inside the rule (allow, drop) or empty string if no action is specified
+// Returns the action defined inside the rule (allow, log, drop, deceive, tarpit, redirect, respond) or empty string if no action is specified
 func GetRuleAction(rules []Rule, ruleId string) string {
 	for _, rule := range rules {
 		if rule.Id == ruleId {
@@ -0,0 +1,108 @@
+package detection
+
+import (
+	"errors"
+	"net/http"
+	"regexp"
+	"strings"
+)
+
+// The directions of the websocket messages
+const (
+	WebsocketDirectionClient = "client-to-server" //The messages sent by the client to the web server
+	WebsocketDirectionServer = "server-to-client" //The messages sent by the web server to the client
+)
+
+// Holds the information about the websocket connection which is available to the rules for every message
+// The connection is created from the upgrade request so it is not modified afterwards
+type WebsocketConnectionContext struct {
+	Headers http.Header    //The headers of the upgrade request
+	Target  *requestTarget //The host, path and client of the upgrade request (used by the scope of the rules and the exclusions)
+}
+
+// Creates the context of the websocket connection from the upgrade request
+// @param r - the upgrade request
+// Returns the context of the connection (nil if the request is nil)
+func NewWebsocketConnectionContext(r *http.Request) *WebsocketConnectionContext {
+	if r == nil {
+		return nil
+	}
+	return &WebsocketConnectionContext{Headers: r.Header.Clone(), Target: newRequestTarget(r)}
+}
+
+// Creates a copy of the connection without some of the headers (removed by the exclusions)
+// @param headers - the names of the headers
+// Returns the new connection context
+func (connection *WebsocketConnectionContext) without(headers []string) *WebsocketConnectionContext {
+	if connection == nil {
+		return nil
+	}
+	filtered := *connection
+	filtered.Headers = withoutHeaders(connection.Headers, headers)
+	return &filtered
+}
+
+// Gets the target of the upgrade request (nil if the connection is not known)
+func (connection *WebsocketConnectionContext) getTarget() *requestTarget {
+	if connection == nil {
+		return nil
+	}
+	return connection.Target
+}
+
+// Checks if the websocket matcher applies to the message
+// @param wsRule - the websocket matcher
+// @param direction - the direction of the message
+// @param messageType - the type of the message
+// Returns true if the matcher should be evaluated on the message
+func (wsRule *WebsocketRule) appliesTo(direction string, messageType int) bool {
+	if wsRule.Direction != "" && direction != "" && !strings.EqualFold(wsRule.Direction, direction) {
+		return false
+	}
+	if wsRule.MessageType != 0 && wsRule.MessageType != messageType {
+		return false
+	}
+	return true
+}
+
+// Gets the search modes of the websocket matchers
+func getWebsocketSearchModes(wsRules []*WebsocketRule) []*RuleSearchMode {
+	modes := make([]*RuleSearchMode, 0)
+	for _, wsRule := range wsRules {
+		modes = append(modes, &RuleSearchMode{Match: wsRule.Match, Regex: wsRule.Regex, Encodings: wsRule.Encodings})
+	}
+	return modes
+}
+
+// Checks if the websocket matchers are valid
+// @param wsRules - the websocket matchers of the rule
+// Returns an error if any of the matchers is not valid
+func CheckWebsocketRules(wsRules []*WebsocketRule) error {
+	for _, wsRule := range wsRules {
+		direction := strings.ToLower(wsRule.Direction)
+		if direction != "" && direction != WebsocketDirectionClient && direction != WebsocketDirectionServer {
+			return errors.New("rule websocket direction cannot be something other than: client-to-server, server-to-client")
+		}
+		if _, err := regexp.Compile(wsRule.Regex); err != nil {
+			return errors.New("cannot compile regex for the websocket message, " + err.Error())
+		}
+		if wsRule.JSONPath != "" && wsRule.Header != "" {
+			return errors.New("rule websocket matcher cannot have both json-path and header")
+		}
+		if wsRule.JSONPath != "" {
+			if wsRule.MessageType != 0 && wsRule.MessageType != 1 {
+				return errors.New("rule websocket json-path can be used only on the text messages (message_type 1)")
+			}
+			if _, err := compileJSONPath(wsRule.JSONPath); err != nil {
+				return errors.New("rule websocket json-path is not valid, " + wsRule.JSONPath + ", " + err.Error())
+			}
+		}
+		if wsRule.Header != "" && strings.ContainsAny(wsRule.Header, " :") {
+			return errors.New("rule websocket header is not a valid header name, " + wsRule.Header)
+		}
+		if err := CheckEncodingsList(wsRule.Encodings); err != nil {
+			return errors.New("Invalid encodings list in the websocket matchers, " + err.Error())
+		}
+	}
+	return nil
+}
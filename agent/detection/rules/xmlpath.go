@@ -0,0 +1,296 @@
+package detection
+
+import (
+	"bytes"
+	"encoding/xml"
+	"errors"
+	"io"
+	"strconv"
+	"strings"
+
+	"github.com/antchfx/xpath"
+)
+
+// The maximum depth of the XML document parsed (protects against deeply nested documents)
+const maxXMLDepth = 256
+
+// Holds a node of the parsed XML document
+type xmlNode struct {
+	nodeType   xpath.NodeType //The type of the node (root, element, text, comment)
+	name       string         //The local name of the element
+	prefix     string         //The namespace prefix of the element
+	data       string         //The text of the text and comment nodes
+	attributes []xml.Attr     //The attributes of the element
+	parent     *xmlNode
+	children   []*xmlNode
+	position   int //The position of the node in the children list of the parent
+}
+
+// Parses the XML document into a tree which can be queried with XPath
+// The external entities are not resolved by the parser
+// @param data - the XML document
+// Returns the root node or an error if the document is not valid XML
+func parseXMLDocument(data []byte) (*xmlNode, error) {
+	decoder := xml.NewDecoder(bytes.NewReader(data))
+	root := &xmlNode{nodeType: xpath.RootNode}
+	current := root
+	depth := 0
+	hasElement := false
+
+	appendChild := func(parent *xmlNode, child *xmlNode) {
+		child.parent = parent
+		child.position = len(parent.children)
+		parent.children = append(parent.children, child)
+	}
+
+	for {
+		//The raw tokens keep the namespace prefixes used in the XPath selectors
+		token, err := decoder.RawToken()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		switch t := token.(type) {
+		case xml.StartElement:
+			depth++
+			if depth > maxXMLDepth {
+				return nil, errors.New("the XML document is too deeply nested")
+			}
+			element := &xmlNode{nodeType: xpath.ElementNode, name: t.Name.Local, prefix: t.Name.Space, attributes: t.Copy().Attr}
+			appendChild(current, element)
+			current = element
+			hasElement = true
+		case xml.EndElement:
+			//Ignore the unbalanced end elements
+			if current.parent != nil {
+				depth--
+				current = current.parent
+			}
+		case xml.CharData:
+			appendChild(current, &xmlNode{nodeType: xpath.TextNode, data: string(t)})
+		case xml.Comment:
+			appendChild(current, &xmlNode{nodeType: xpath.CommentNode, data: string(t)})
+		}
+	}
+
+	if !hasElement {
+		return nil, errors.New("the XML document does not contain any element")
+	}
+	return root, nil
+}
+
+// Gets the text content of the node (all the text of the descendants for elements)
+func (node *xmlNode) textContent() string {
+	switch node.nodeType {
+	case xpath.TextNode, xpath.CommentNode:
+		return node.data
+	}
+	var builder strings.Builder
+	for _, child := range node.children {
+		if child.nodeType != xpath.CommentNode {
+			builder.WriteString(child.textContent())
+		}
+	}
+	return builder.String()
+}
+
+// Gets the node and all its descendants
+func allXMLNodes(node *xmlNode) []*xmlNode {
+	nodes := []*xmlNode{node}
+	for _, child := range node.children {
+		nodes = append(nodes, allXMLNodes(child)...)
+	}
+	return nodes
+}
+
+// Gets the qualified name of the element
+func (node *xmlNode) qualifiedName() string {
+	if node.prefix != "" {
+		return node.prefix + ":" + node.name
+	}
+	return node.name
+}
+
+// Gets the exact path of the node in the document (/users/user[2]/name)
+// The position is added only when the parent has more elements with the same name
+func (node *xmlNode) path() string {
+	if node.nodeType == xpath.RootNode {
+		return ""
+	}
+	parentPath := node.parent.path()
+	switch node.nodeType {
+	case xpath.TextNode:
+		return parentPath + "/text()"
+	case xpath.CommentNode:
+		return parentPath + "/comment()"
+	}
+
+	position, count := 0, 0
+	for _, sibling := range node.parent.children {
+		if sibling.nodeType == xpath.ElementNode && sibling.name == node.name && sibling.prefix == node.prefix {
+			count++
+			if sibling == node {
+				position = count
+			}
+		}
+	}
+	if count > 1 {
+		return parentPath + "/" + node.qualifiedName() + "[" + strconv.Itoa(position) + "]"
+	}
+	return parentPath + "/" + node.qualifiedName()
+}
+
+// Navigates the XML tree for the XPath evaluation
+type xmlNavigator struct {
+	root      *xmlNode
+	current   *xmlNode
+	attribute int //The index of the current attribute (-1 if the navigator is on the node)
+}
+
+// Creates a navigator positioned on the root of the document
+func newXMLNavigator(root *xmlNode) *xmlNavigator {
+	return &xmlNavigator{root: root, current: root, attribute: -1}
+}
+
+func (nav *xmlNavigator) NodeType() xpath.NodeType {
+	if nav.attribute != -1 {
+		return xpath.AttributeNode
+	}
+	return nav.current.nodeType
+}
+
+func (nav *xmlNavigator) LocalName() string {
+	if nav.attribute != -1 {
+		return nav.current.attributes[nav.attribute].Name.Local
+	}
+	return nav.current.name
+}
+
+func (nav *xmlNavigator) Prefix() string {
+	if nav.attribute != -1 {
+		return nav.current.attributes[nav.attribute].Name.Space
+	}
+	return nav.current.prefix
+}
+
+func (nav *xmlNavigator) Value() string {
+	if nav.attribute != -1 {
+		return nav.current.attributes[nav.attribute].Value
+	}
+	return nav.current.textContent()
+}
+
+func (nav *xmlNavigator) Copy() xpath.NodeNavigator {
+	navCopy := *nav
+	return &navCopy
+}
+
+func (nav *xmlNavigator) MoveToRoot() {
+	nav.current = nav.root
+	nav.attribute = -1
+}
+
+func (nav *xmlNavigator) MoveToParent() bool {
+	if nav.attribute != -1 {
+		nav.attribute = -1
+		return true
+	}
+	if nav.current.parent == nil {
+		return false
+	}
+	nav.current = nav.current.parent
+	return true
+}
+
+func (nav *xmlNavigator) MoveToNextAttribute() bool {
+	if nav.attribute+1 >= len(nav.current.attributes) {
+		return false
+	}
+	nav.attribute++
+	return true
+}
+
+func (nav *xmlNavigator) MoveToChild() bool {
+	if nav.attribute != -1 || len(nav.current.children) == 0 {
+		return false
+	}
+	nav.current = nav.current.children[0]
+	return true
+}
+
+func (nav *xmlNavigator) MoveToFirst() bool {
+	if nav.attribute != -1 || nav.current.parent == nil || nav.current.position == 0 {
+		return false
+	}
+	nav.current = nav.current.parent.children[0]
+	return true
+}
+
+func (nav *xmlNavigator) MoveToNext() bool {
+	if nav.attribute != -1 || nav.current.parent == nil || nav.current.position+1 >= len(nav.current.parent.children) {
+		return false
+	}
+	nav.current = nav.current.parent.children[nav.current.position+1]
+	return true
+}
+
+func (nav *xmlNavigator) MoveToPrevious() bool {
+	if nav.attribute != -1 || nav.current.parent == nil || nav.current.position == 0 {
+		return false
+	}
+	nav.current = nav.current.parent.children[nav.current.position-1]
+	return true
+}
+
+func (nav *xmlNavigator) MoveTo(other xpath.NodeNavigator) bool {
+	otherNav, ok := other.(*xmlNavigator)
+	if !ok || otherNav.root != nav.root {
+		return false
+	}
+	nav.current = otherNav.current
+	nav.attribute = otherNav.attribute
+	return true
+}
+
+// Gets the exact path of the node the navigator is positioned on
+func (nav *xmlNavigator) path() string {
+	if nav.attribute != -1 {
+		attributeName := nav.current.attributes[nav.attribute].Name
+		if attributeName.Space != "" {
+			return nav.current.path() + "/@" + attributeName.Space + ":" + attributeName.Local
+		}
+		return nav.current.path() + "/@" + attributeName.Local
+	}
+	if nav.current.nodeType == xpath.RootNode {
+		return "/"
+	}
+	return nav.current.path()
+}
+
+// Selects the values from the XML document using the XPath expression
+// The nodes are converted to their text content, the expressions which do not return nodes (count(), string()) return a single value
+// @param expression - the compiled XPath expression
+// @param root - the root of the parsed XML document
+// Returns the selected values with their exact paths
+func selectXMLValues(expression *xpath.Expr, root *xmlNode) []selectedValue {
+	values := make([]selectedValue, 0)
+	switch result := expression.Evaluate(newXMLNavigator(root)).(type) {
+	case *xpath.NodeIterator:
+		for result.MoveNext() {
+			nav, ok := result.Current().(*xmlNavigator)
+			if !ok {
+				continue
+			}
+			values = append(values, selectedValue{Path: nav.path(), Value: nav.Value()})
+		}
+	case string:
+		values = append(values, selectedValue{Path: expression.String(), Value: result})
+	case float64:
+		values = append(values, selectedValue{Path: expression.String(), Value: strconv.FormatFloat(result, 'f', -1, 64)})
+	case bool:
+		values = append(values, selectedValue{Path: expression.String(), Value: strconv.FormatBool(result)})
+	}
+	return values
+}
@@ -3,21 +3,22 @@ module github.com/lucacoratu/disertatie/agent
 go 1.21.0
 
 require (
+	github.com/antchfx/xpath v1.3.3
+	github.com/fsnotify/fsnotify v1.7.0
+	github.com/gabriel-vasile/mimetype v1.4.2
 	github.com/go-playground/validator/v10 v10.15.4
 	github.com/gorilla/mux v1.8.0
+	github.com/gorilla/websocket v1.5.1
+	gopkg.in/yaml.v2 v2.4.0
 )
 
 require (
-	github.com/gabriel-vasile/mimetype v1.4.2 // indirect
 	github.com/go-playground/locales v0.14.1 // indirect
 	github.com/go-playground/universal-translator v0.18.1 // indirect
-	github.com/gorilla/websocket v1.5.1 // indirect
 	github.com/leodido/go-urn v1.2.4 // indirect
-	github.com/mowshon/iterium v1.0.0 // indirect
 	golang.org/x/crypto v0.14.0 // indirect
 	golang.org/x/exp v0.0.0-20230307190834-24139beb5833 // indirect
 	golang.org/x/net v0.17.0 // indirect
 	golang.org/x/sys v0.13.0 // indirect
 	golang.org/x/text v0.13.0 // indirect
-	gopkg.in/yaml.v2 v2.4.0 // indirect
 )
@@ -1,8 +1,18 @@
 package main
 
-import "github.com/lucacoratu/disertatie/agent/server"
+import (
+	"os"
+
+	"github.com/lucacoratu/disertatie/agent/commands"
+	"github.com/lucacoratu/disertatie/agent/server"
+)
 
 func main() {
+	//Check if the rules tooling should be run instead of the agent
+	if len(os.Args) > 1 && os.Args[1] == "rules" {
+		os.Exit(commands.RunRulesCommand(os.Args[2:]))
+	}
+
 	proxyServer := server.AgentServer{}
 	err := proxyServer.Init()
 	if err != nil {
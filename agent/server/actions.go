@@ -0,0 +1,171 @@
+package server
+
+import (
+	b64 "encoding/base64"
+	"fmt"
+	"math"
+	"net/http"
+	"os"
+	"strconv"
+	"time"
+
+	rules "github.com/lucacoratu/disertatie/agent/detection/rules"
+)
+
+// The default values of the tarpit action (used when they are not specified in the configuration)
+const (
+	defaultTarpitDelay     = 1000 //The delay in milliseconds between the chunks
+	defaultTarpitChunkSize = 16   //The size in bytes of the chunks
+)
+
+// The action recorded in the logs of the requests throttled by the rate limits
+const rateLimitAction = "rate-limit"
+
+// Holds the response sent to the client by a blocking action instead of the response from the web server
+type actionResponse struct {
+	statusCode int           //The status code of the response
+	headers    http.Header   //The headers of the response
+	body       []byte        //The body of the response
+	delay      time.Duration //The delay between the chunks of the body (only for the tarpit action)
+	chunkSize  int           //The size of the chunks of the body (only for the tarpit action)
+}
+
+// Reads the forbidden page from the disk
+// If the page cannot be read then Forbidden is used
+func (agentHandler *AgentHandler) getForbiddenPage() []byte {
+	forbiddenPageContent, err := os.ReadFile(agentHandler.configuration.ForbiddenPagePath)
+	//Check if an error occured when reading forbidden page
+	if err != nil {
+		agentHandler.logger.Error("Failed to read forbidden page from disk,", err.Error())
+		return []byte("Forbidden")
+	}
+	return forbiddenPageContent
+}
+
+// Creates the forbidden page response (the response of the drop action)
+func (agentHandler *AgentHandler) newForbiddenResponse() *actionResponse {
+	headers := make(http.Header)
+	headers.Set("Content-Type", "text/html")
+	return &actionResponse{statusCode: http.StatusForbidden, headers: headers, body: agentHandler.getForbiddenPage()}
+}
+
+// Creates the response of the throttled requests
+// @param retryAfter - the time the client should wait before retrying (rounded up to seconds)
+func (agentHandler *AgentHandler) newRateLimitResponse(retryAfter time.Duration) *actionResponse {
+	headers := make(http.Header)
+	headers.Set("Content-Type", "text/plain")
+	headers.Set("Retry-After", strconv.Itoa(max(1, int(math.Ceil(retryAfter.Seconds())))))
+	return &actionResponse{statusCode: http.StatusTooManyRequests, headers: headers, body: []byte(http.StatusText(http.StatusTooManyRequests))}
+}
+
+// Creates the response which should be sent to the client for the blocking action of the decision
+// If the parameters of the action are missing (the redirect URL) the forbidden page is sent
+// @param decision - the decision taken in waf mode
+// Returns the response
+func (agentHandler *AgentHandler) newActionResponse(decision WAFDecision) *actionResponse {
+	var parameters *rules.RuleActionParameters = nil
+	ruleId := ""
+	if decision.Rule != nil {
+		parameters = decision.Rule.Info.ActionParameters
+		ruleId = decision.Rule.Id
+	}
+
+	switch decision.Action {
+	case rules.ActionRedirect:
+		redirectURL := agentHandler.configuration.RedirectURL
+		if parameters != nil && parameters.RedirectURL != "" {
+			redirectURL = parameters.RedirectURL
+		}
+		if redirectURL == "" {
+			agentHandler.logger.Error("No redirect URL for the redirect action of rule", ruleId, "sending the forbidden page")
+			return agentHandler.newForbiddenResponse()
+		}
+		headers := make(http.Header)
+		headers.Set("Location", redirectURL)
+		return &actionResponse{statusCode: http.StatusFound, headers: headers, body: []byte{}}
+	case rules.ActionRespond:
+		headers := make(http.Header)
+		body := []byte{}
+		if parameters != nil {
+			for name, value := range parameters.Headers {
+				headers.Set(name, value)
+			}
+			if parameters.BodyFile != "" {
+				bodyContent, err := os.ReadFile(parameters.BodyFile)
+				if err != nil {
+					agentHandler.logger.Error("Failed to read the body file of rule", ruleId, err.Error())
+				} else {
+					body = bodyContent
+				}
+			}
+		}
+		return &actionResponse{statusCode: parameters.GetStatus(), headers: headers, body: body}
+	case rules.ActionTarpit:
+		response := agentHandler.newForbiddenResponse()
+		response.delay = time.Duration(defaultTarpitDelay) * time.Millisecond
+		if agentHandler.configuration.TarpitDelay > 0 {
+			response.delay = time.Duration(agentHandler.configuration.TarpitDelay) * time.Millisecond
+		}
+		response.chunkSize = defaultTarpitChunkSize
+		if agentHandler.configuration.TarpitChunkSize > 0 {
+			response.chunkSize = agentHandler.configuration.TarpitChunkSize
+		}
+		return response
+	}
+
+	return agentHandler.newForbiddenResponse()
+}
+
+// Converts the response to raw string then base64 encodes it (used in the log data)
+func (response *actionResponse) toB64() string {
+	rawResponse := fmt.Sprintf("HTTP/1.1 %d %s\r\n", response.statusCode, http.StatusText(response.statusCode))
+	for name, values := range response.headers {
+		for _, value := range values {
+			rawResponse += fmt.Sprintf("%s: %s\r\n", name, value)
+		}
+	}
+	rawResponse += "\r\n" + string(response.body)
+	return b64.StdEncoding.EncodeToString([]byte(rawResponse))
+}
+
+// Sends the response of the blocking action to the client
+// The tarpit response is sent in chunks with a delay between them, until the client closes the connection
+// @param rw - the response writer
+// @param r - the request of the client
+// @param response - the response of the action
+func (agentHandler *AgentHandler) writeActionResponse(rw http.ResponseWriter, r *http.Request, response *actionResponse) {
+	for name, values := range response.headers {
+		for _, value := range values {
+			rw.Header().Add(name, value)
+		}
+	}
+	rw.WriteHeader(response.statusCode)
+
+	if response.delay == 0 {
+		rw.Write(response.body)
+		return
+	}
+
+	flusher, canFlush := rw.(http.Flusher)
+	for start := 0; start < len(response.body); start += response.chunkSize {
+		end := start + response.chunkSize
+		if end > len(response.body) {
+			end = len(response.body)
+		}
+		if _, err := rw.Write(response.body[start:end]); err != nil {
+			return
+		}
+		if canFlush {
+			flusher.Flush()
+		}
+		if end == len(response.body) {
+			return
+		}
+		select {
+		case <-r.Context().Done():
+			agentHandler.logger.Debug("Client closed the connection during the tarpit response")
+			return
+		case <-time.After(response.delay):
+		}
+	}
+}
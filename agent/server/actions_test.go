@@ -0,0 +1,30 @@
+package server
+
+import (
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestNewRateLimitResponse(t *testing.T) {
+	tests := []struct {
+		retryAfter time.Duration
+		expected   string
+	}{
+		{0, "1"},
+		{300 * time.Millisecond, "1"},
+		{10 * time.Second, "10"},
+		{10*time.Second + time.Millisecond, "11"},
+		{5 * time.Minute, "300"},
+	}
+	agentHandler := &AgentHandler{}
+	for _, test := range tests {
+		response := agentHandler.newRateLimitResponse(test.retryAfter)
+		if response.statusCode != http.StatusTooManyRequests {
+			t.Errorf("expected the status code 429, got %d", response.statusCode)
+		}
+		if retryAfter := response.headers.Get("Retry-After"); retryAfter != test.expected {
+			t.Errorf("retry after %s: expected the Retry-After header %s, got %s", test.retryAfter, test.expected, retryAfter)
+		}
+	}
+}
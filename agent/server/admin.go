@@ -0,0 +1,67 @@
+package server
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/lucacoratu/disertatie/agent/config"
+	"github.com/lucacoratu/disertatie/agent/data"
+	rules "github.com/lucacoratu/disertatie/agent/detection/rules"
+	"github.com/lucacoratu/disertatie/agent/logging"
+	"github.com/lucacoratu/disertatie/agent/websocket"
+)
+
+// The default interval between the rule statistics reports sent to the API
+const defaultRuleStatisticsInterval = 60 * time.Second
+
+/*
+ * Structure which holds the information needed by the handler of the local admin endpoint
+ */
+type AdminHandler struct {
+	logger        logging.ILogger      //The logger interface
+	configuration config.Configuration //The configuration structure
+	ruleStore     *rules.RuleStore     //The store holding the rules in use and their statistics
+}
+
+// Creates a new AdminHandler structure
+func NewAdminHandler(logger logging.ILogger, configuration config.Configuration, ruleStore *rules.RuleStore) *AdminHandler {
+	return &AdminHandler{logger: logger, configuration: configuration, ruleStore: ruleStore}
+}
+
+// Creates the report with the statistics of the rules in use
+func (adminHandler *AdminHandler) getRuleStatisticsReport() data.RuleStatisticsReport {
+	statistics := adminHandler.ruleStore.GetStatistics()
+	return data.RuleStatisticsReport{
+		AgentId:   adminHandler.configuration.UUID,
+		Since:     statistics.GetSince().Unix(),
+		Timestamp: time.Now().Unix(),
+		Rules:     statistics.Snapshot(adminHandler.ruleStore.GetIndex().GetRules()),
+	}
+}
+
+// Returns the performance and hit counters of the rules in use (the most expensive rules first)
+func (adminHandler *AdminHandler) GetRuleStatistics(rw http.ResponseWriter, r *http.Request) {
+	report := adminHandler.getRuleStatisticsReport()
+	rw.Header().Set("Content-Type", "application/json")
+	err := report.ToJSON(rw)
+	if err != nil {
+		adminHandler.logger.Error("Could not send the rule statistics on the admin endpoint,", err.Error())
+	}
+}
+
+// Sends the statistics of the rules to the API periodically
+// @param apiWsConn - the websocket connection to the API
+func (adminHandler *AdminHandler) ReportRuleStatistics(apiWsConn *websocket.APIWebSocketConnection) {
+	interval := defaultRuleStatisticsInterval
+	if adminHandler.configuration.RuleStatisticsInterval > 0 {
+		interval = time.Duration(adminHandler.configuration.RuleStatisticsInterval) * time.Second
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for range ticker.C {
+		err := apiWsConn.SendRuleStatistics(adminHandler.getRuleStatisticsReport())
+		if err != nil {
+			adminHandler.logger.Error("Could not send the rule statistics to the API,", err.Error())
+		}
+	}
+}
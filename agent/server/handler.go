@@ -2,7 +2,6 @@ package server
 
 import (
 	"bytes"
-	"encoding/base64"
 	b64 "encoding/base64"
 	"errors"
 	"fmt"
@@ -10,8 +9,9 @@ import (
 	"io"
 	"log"
 	"net/http"
+	"net/http/httptrace"
 	"net/url"
-	"os"
+	"slices"
 	"strings"
 	"time"
 
@@ -36,22 +36,24 @@ type AgentHandler struct {
 	apiBaseURL    string                            //The API base URL
 	configuration config.Configuration              //The configuration structure
 	checkers      []code.IValidator                 //The list of validators which will be run on the request and the response to find malicious activity
-	rules         []rules.Rule                      //The list of rules which will try to find anomalies in the requests and the responses
+	ruleStore     *rules.RuleStore                  //The store holding the compiled rules which will try to find anomalies in the requests and the responses (swapped when the rules are reloaded)
 	apiWsConn     *websocket.APIWebSocketConnection //The WS connection to the API
 }
 
 // Creates a new AgentHandlerStructure
-func NewAgentHandler(logger logging.ILogger, apiBaseURL string, configuration config.Configuration, checkers []code.IValidator, rules []rules.Rule, apiWsConn *websocket.APIWebSocketConnection) *AgentHandler {
-	return &AgentHandler{logger: logger, apiBaseURL: apiBaseURL, configuration: configuration, checkers: checkers, rules: rules, apiWsConn: apiWsConn}
+func NewAgentHandler(logger logging.ILogger, apiBaseURL string, configuration config.Configuration, checkers []code.IValidator, ruleStore *rules.RuleStore, apiWsConn *websocket.APIWebSocketConnection) *AgentHandler {
+	return &AgentHandler{logger: logger, apiBaseURL: apiBaseURL, configuration: configuration, checkers: checkers, ruleStore: ruleStore, apiWsConn: apiWsConn}
 }
 
 // Forwards the request to the target server
-func (agentHandler *AgentHandler) forwardRequest(req *http.Request) (*http.Response, error) {
+// The time until the first byte of the response and until the whole body is received are measured (time based blind attacks)
+// Returns the response, the measurements of the response or an error if the request could not be sent
+func (agentHandler *AgentHandler) forwardRequest(req *http.Request) (*http.Response, *data.UpstreamMetrics, error) {
 	// we need to buffer the body if we want to read it here and send it
 	// in the request.
 	body, err := io.ReadAll(req.Body)
 	if err != nil {
-		return nil, errors.New("could not send the request to the target web server, " + err.Error())
+		return nil, nil, errors.New("could not send the request to the target web server, " + err.Error())
 	}
 
 	// you can reassign the body if you need to parse it as multipart
@@ -62,7 +64,7 @@ func (agentHandler *AgentHandler) forwardRequest(req *http.Request) (*http.Respo
 
 	proxyReq, err := http.NewRequest(req.Method, url, bytes.NewReader(body))
 	if err != nil {
-		return nil, errors.New("could not create the new request to forward to target web server")
+		return nil, nil, errors.New("could not create the new request to forward to target web server")
 	}
 
 	proxyReq.Header = make(http.Header)
@@ -77,14 +79,40 @@ func (agentHandler *AgentHandler) forwardRequest(req *http.Request) (*http.Respo
 		},
 	}
 
+	//Record when the first byte of the response is received
+	var firstByteTime time.Time
+	trace := &httptrace.ClientTrace{
+		GotFirstResponseByte: func() {
+			firstByteTime = time.Now()
+		},
+	}
+	proxyReq = proxyReq.WithContext(httptrace.WithClientTrace(proxyReq.Context(), trace))
+
+	startTime := time.Now()
 	resp, err := httpClient.Do(proxyReq)
 	if err != nil {
-		return nil, errors.New("could not send the request to the target web server, " + err.Error())
+		return nil, nil, errors.New("could not send the request to the target web server, " + err.Error())
+	}
+
+	//Read the whole body to measure the total duration and the size of the response
+	responseBody, err := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if err != nil {
+		return nil, nil, errors.New("could not read the response from the target web server, " + err.Error())
+	}
+	resp.Body = io.NopCloser(bytes.NewReader(responseBody))
+	endTime := time.Now()
+	if firstByteTime.IsZero() {
+		firstByteTime = endTime
 	}
+	upstream := &data.UpstreamMetrics{TimeToFirstByte: firstByteTime.Sub(startTime).Milliseconds(), Duration: endTime.Sub(startTime).Milliseconds(), Size: int64(len(responseBody))}
 
-	agentHandler.logger.Debug("Forward request, response status code", resp.StatusCode)
+	agentHandler.logger.Debug("Forward request, response status code", resp.StatusCode, "time to first byte", upstream.TimeToFirstByte, "ms, duration", upstream.Duration, "ms")
 
-	return resp, nil
+	//The scope of the rules and the exclusions are checked on the request received from the client (the host of the forwarded request is the web server)
+	resp.Request = req
+
+	return resp, upstream, nil
 }
 
 // Forwards the response back to the client
@@ -298,7 +326,7 @@ func (agentHandler *AgentHandler) HandleAdaptiveOperationMode(rw http.ResponseWr
 	if len(requestRuleFindings) == 0 && aiClassification == "benign" {
 		agentHandler.logger.Debug("The request is benign, sending the request to the target web server")
 		//Send the request to the target server and send the response to the client
-		response, err := agentHandler.forwardRequest(r)
+		response, upstream, err := agentHandler.forwardRequest(r)
 		if err != nil {
 			agentHandler.logger.Error("Failed to send request to target server", err.Error())
 			return
@@ -314,7 +342,7 @@ func (agentHandler *AgentHandler) HandleAdaptiveOperationMode(rw http.ResponseWr
 		b64RawRequest, b64RawResponse, _ := agentHandler.convertRequestAndResponseToB64(r, response)
 
 		//Create the log structure that should be sent to the API
-		logData := data.LogData{AgentId: agentHandler.configuration.UUID, RemoteIP: r.RemoteAddr, Timestamp: time.Now().Unix(), Request: b64RawRequest, Response: b64RawResponse, Findings: allFindings, RuleFindings: allRuleFindings}
+		logData := data.LogData{AgentId: agentHandler.configuration.UUID, RemoteIP: r.RemoteAddr, Timestamp: time.Now().Unix(), Request: b64RawRequest, Response: b64RawResponse, Findings: allFindings, RuleFindings: allRuleFindings, Upstream: upstream}
 
 		if agentHandler.apiWsConn != nil {
 			agentHandler.logger.Debug("Sending log in adaptive mode to the API...")
@@ -422,51 +450,178 @@ func (agentHandler *AgentHandler) HandleAdaptiveOperationMode(rw http.ResponseWr
 
 }
 
-// Handle the request if the agent is running in waf operation mode
-// @param requestFindings the code findings after checking the request
-// @param requestRuleFindings the findings after applying the rules on the request
-// Returns bool (true if the request should be dropped, false if should be allowed)
-// Returns error if an error occured during the handling of findings
-func (agentHandler *AgentHandler) HandleWAFOperationModeOnRequest(requestFindings []data.FindingData, requestRuleFindings []*data.RuleFindingData) (bool, error) {
-	//Loop through all the code findings
+// Holds the anomaly score of a request or a response and what contributed to it
+type AnomalyScore struct {
+	Score        int      //The sum of the weights of the findings
+	Contributors []string //The ids of the rules and the names of the validators which added to the score
+}
 
-	//Loop through all the rules findings
-	for _, ruleFinding := range requestRuleFindings {
-		//Get the id of the rule
-		ruleAction := rules.GetRuleAction(agentHandler.rules, ruleFinding.RuleId)
-		//Check if the rule action is drop
-		//If the rule action is empty the default behavior should be to drop
-		if ruleAction == "drop" || ruleAction == "" {
-			//The request should be blocked
-			return true, nil
+// Adds the score of another phase (the total score of the request and the response)
+func (score *AnomalyScore) Add(other AnomalyScore) {
+	score.Score += other.Score
+	for _, contributor := range other.Contributors {
+		if !slices.Contains(score.Contributors, contributor) {
+			score.Contributors = append(score.Contributors, contributor)
 		}
 	}
+}
 
-	//The request shouldn't be blocked
-	return false, nil
+// Gets the weight of the severity from the anomaly scoring configuration
+func (agentHandler *AgentHandler) getSeverityWeight(severity int64) int {
+	scoring := agentHandler.configuration.AnomalyScoring
+	switch severity {
+	case data.CRITICAL:
+		return scoring.CriticalWeight
+	case data.HIGH:
+		return scoring.HighWeight
+	case data.MEDIUM:
+		return scoring.MediumWeight
+	case data.LOW:
+		return scoring.LowWeight
+	default:
+		return 0
+	}
+}
+
+// Computes the anomaly score of the findings of a request or a response
+// Every rule and validator adds the weight of its highest severity once, so a rule matching multiple times does not inflate the score
+// The rules with the allow and log actions only log the findings so they do not add to the score
+// @param findings - the code findings
+// @param ruleFindings - the rules findings
+// Returns the anomaly score
+func (agentHandler *AgentHandler) computeAnomalyScore(findings []data.FindingData, ruleFindings []*data.RuleFindingData) AnomalyScore {
+	//The highest severity of each contributor, in the order they were found
+	severities := make(map[string]int64)
+	contributors := make([]string, 0)
+	addContributor := func(name string, severity int64) {
+		current, found := severities[name]
+		if !found {
+			contributors = append(contributors, name)
+		}
+		if !found || severity > current {
+			severities[name] = severity
+		}
+	}
+
+	for _, finding := range findings {
+		addContributor(finding.ValidatorName, finding.Severity)
+	}
+	ruleIndex := agentHandler.ruleStore.GetIndex()
+	for _, ruleFinding := range ruleFindings {
+		if !rules.IsBlockingAction(rules.GetRuleAction(ruleIndex.GetRules(), ruleFinding.RuleId)) {
+			continue
+		}
+		addContributor(ruleFinding.RuleId, ruleFinding.Severity)
+	}
+
+	score := AnomalyScore{Score: 0, Contributors: make([]string, 0)}
+	for _, contributor := range contributors {
+		weight := agentHandler.getSeverityWeight(severities[contributor])
+		if weight == 0 {
+			continue
+		}
+		score.Score += weight
+		score.Contributors = append(score.Contributors, contributor)
+	}
+	return score
+}
+
+// Holds the decision taken in waf mode for a request or a response
+type WAFDecision struct {
+	Action string       //The action which is applied (allow if the findings do not block)
+	Rule   *rules.Rule  //The rule whose action is applied (nil if the action does not come from a rule)
+	Score  AnomalyScore //The anomaly score (empty if the anomaly scoring is not used)
+}
+
+// Checks if the action of the decision blocks the request (or the response)
+func (decision WAFDecision) Blocked() bool {
+	return rules.IsBlockingAction(decision.Action)
+}
+
+// Decides the action which should be taken for the findings of the request (or the response)
+// By default the action of the matching rule with the highest precedence is applied (rules without an action drop), the denied clients (deny list, blocked countries) are dropped if no rule blocks them
+// The other validators findings (Tor exit nodes, hosting providers) are only used in anomaly scoring mode
+// In anomaly scoring mode the findings block only if the score reaches the threshold, the validators findings drop if no rule has a blocking action
+// @param findings - the code findings
+// @param ruleFindings - the rules findings
+// @param threshold - the anomaly score threshold of the phase
+// Returns the decision
+func (agentHandler *AgentHandler) decideWAFAction(findings []data.FindingData, ruleFindings []*data.RuleFindingData, threshold int) WAFDecision {
+	loadedRules := agentHandler.ruleStore.GetIndex().GetRules()
+	if agentHandler.configuration.UsesAnomalyScoring() {
+		score := agentHandler.computeAnomalyScore(findings, ruleFindings)
+		if score.Score < threshold {
+			return WAFDecision{Action: rules.ActionAllow, Score: score}
+		}
+		rule, action := rules.SelectRuleAction(loadedRules, ruleFindings)
+		if !rules.IsBlockingAction(action) {
+			return WAFDecision{Action: rules.ActionDrop, Score: score}
+		}
+		return WAFDecision{Action: action, Rule: rule, Score: score}
+	}
+
+	rule, action := rules.SelectRuleAction(loadedRules, ruleFindings)
+	if !rules.IsBlockingAction(action) {
+		for _, finding := range findings {
+			if code.IsDeniedClientFinding(finding) {
+				return WAFDecision{Action: rules.ActionDrop}
+			}
+		}
+	}
+	return WAFDecision{Action: action, Rule: rule}
+}
+
+// Sends 429 to the client which exceeded the rate limits, the request is not inspected or forwarded
+// @param rw - the response writer
+// @param r - the request of the client
+// @param limitFindings - the findings of the exceeded limits
+// @param retryAfter - the time the client should wait before retrying
+func (agentHandler *AgentHandler) HandleThrottledRequest(rw http.ResponseWriter, r *http.Request, limitFindings []data.FindingData, retryAfter time.Duration) {
+	agentHandler.logger.Info("Throttled", r.Method, "request on", r.URL.Path, "from", r.RemoteAddr)
+	throttledResponse := agentHandler.newRateLimitResponse(retryAfter)
+
+	//Dump the HTTP request to raw string
+	rawRequest, _ := utils.DumpHTTPRequest(r)
+	b64RawRequest := b64.StdEncoding.EncodeToString(rawRequest)
+
+	//Create the log structure that should be sent to the API
+	logData := data.LogData{AgentId: agentHandler.configuration.UUID, RemoteIP: r.RemoteAddr, Timestamp: time.Now().Unix(), Websocket: false, Request: b64RawRequest, Response: throttledResponse.toB64(), Findings: agentHandler.combineFindings(limitFindings, nil), RuleFindings: agentHandler.combineRuleFindings(nil, nil), SuppressedRuleFindings: agentHandler.combineRuleFindings(nil, nil), Action: rateLimitAction}
+	agentHandler.logger.Debug("Log data", logData)
+
+	if agentHandler.apiWsConn != nil {
+		//Send log information to the API
+		apiHandler := api.NewAPIHandler(agentHandler.logger, agentHandler.configuration)
+		_, err := apiHandler.SendLog(agentHandler.apiBaseURL, logData)
+		//Check if an error occured when sending log to the API
+		if err != nil {
+			agentHandler.logger.Error(err.Error())
+		}
+	}
+
+	agentHandler.writeActionResponse(rw, r, throttledResponse)
+}
+
+// Handle the request if the agent is running in waf operation mode
+// @param requestFindings the code findings after checking the request
+// @param requestRuleFindings the findings after applying the rules on the request
+// Returns the decision (the action which should be applied on the request and the anomaly score)
+// Returns error if an error occured during the handling of findings
+func (agentHandler *AgentHandler) HandleWAFOperationModeOnRequest(requestFindings []data.FindingData, requestRuleFindings []*data.RuleFindingData) (WAFDecision, error) {
+	return agentHandler.decideWAFAction(requestFindings, requestRuleFindings, agentHandler.configuration.AnomalyScoring.RequestThreshold), nil
 }
 
 // Handle the response in waf operation mode
+// The request was already sent to the web server so the deceive action drops the response
 // @param responseFindings the code findings after checking the request
 // @param responseRuleFindings the findings after applying the rules on the request
-// Returns bool (true if the request should be dropped, false if should be allowed)
+// Returns the decision (the action which should be applied on the response and the anomaly score)
 // Returns error if an error occured during the handling of findings
-func (agentHandler *AgentHandler) HandleWAFOperationModeOnResponse(responseFindings []data.FindingData, responseRuleFindings []*data.RuleFindingData) (bool, error) {
-	//Loop through all the code findings
-
-	//Loop through all the rules findings
-	for _, ruleFinding := range responseRuleFindings {
-		//Get the id of the rule
-		ruleAction := rules.GetRuleAction(agentHandler.rules, ruleFinding.RuleId)
-		//Check if the rule action is drop
-		//If the rule action is empty the default behavior should be to drop
-		if ruleAction == "drop" || ruleAction == "" {
-			//The request should be blocked
-			return true, nil
-		}
+func (agentHandler *AgentHandler) HandleWAFOperationModeOnResponse(responseFindings []data.FindingData, responseRuleFindings []*data.RuleFindingData) (WAFDecision, error) {
+	decision := agentHandler.decideWAFAction(responseFindings, responseRuleFindings, agentHandler.configuration.AnomalyScoring.ResponseThreshold)
+	if decision.Action == rules.ActionDeceive {
+		decision.Action = rules.ActionDrop
 	}
-
-	return false, nil
+	return decision, nil
 }
 
 // Upgrader for the websocket
@@ -504,18 +659,24 @@ func (agentHandler *AgentHandler) HandleWebsocketConnection(rw http.ResponseWrit
 	// Proxy messages between client and backend
 	errc := make(chan error, 2)
 
+	//The upgrade request is available to the rules for every message of the connection
+	connection := rules.NewWebsocketConnectionContext(r)
+
 	//Proxy messages from client to the backend web server
-	go agentHandler.proxyWS(clientConn, backendConn, errc)
+	go agentHandler.proxyWS(clientConn, backendConn, connection, rules.WebsocketDirectionClient, errc)
 	//Proxy messages from the backend web server to the client
-	go agentHandler.proxyWS(backendConn, clientConn, errc)
+	go agentHandler.proxyWS(backendConn, clientConn, connection, rules.WebsocketDirectionServer, errc)
 
 	<-errc // wait for first error or disconnect
 }
 
-func (agentHandler *AgentHandler) proxyWS(src, dest *ws_gorilla.Conn, errc chan error) {
-	//Create the rule runner
-	ruleRunner := rules.NewRuleRunner(agentHandler.logger, agentHandler.rules, agentHandler.apiWsConn, agentHandler.configuration)
-
+// Proxies the websocket messages in one direction, running the rules on every message
+// @param src - the connection the messages are read from
+// @param dest - the connection the messages are written to
+// @param connection - the context of the websocket connection (the upgrade request)
+// @param direction - the direction of the messages (client-to-server, server-to-client)
+// @param errc - the channel where the error which closed the connection is sent
+func (agentHandler *AgentHandler) proxyWS(src, dest *ws_gorilla.Conn, connection *rules.WebsocketConnectionContext, direction string, errc chan error) {
 	for {
 		mt, message, err := src.ReadMessage()
 		if err != nil {
@@ -524,8 +685,12 @@ func (agentHandler *AgentHandler) proxyWS(src, dest *ws_gorilla.Conn, errc chan
 			return
 		}
 
+		//Get the rules in use when the message is received so the long lived connections see the reloaded rules
+		ruleRunner := rules.NewRuleRunner(agentHandler.logger, agentHandler.ruleStore.GetIndex(), agentHandler.apiWsConn, agentHandler.configuration)
+		ruleRunner.SetStatistics(agentHandler.ruleStore.GetStatistics())
+
 		//Apply the rules on the websocket messages
-		findings, err := ruleRunner.RunRulesOnWebsocketMessage(mt, message)
+		findings, suppressedFindings, err := ruleRunner.RunRulesOnWebsocketMessage(connection, direction, mt, message)
 		if err != nil {
 			agentHandler.logger.Error("Error when running rules on websocket message", err.Error())
 		}
@@ -542,24 +707,26 @@ func (agentHandler *AgentHandler) proxyWS(src, dest *ws_gorilla.Conn, errc chan
 		//Add all request findings
 		for _, finding := range findings {
 			allFindings = append(allFindings, data.RuleFinding{Request: finding, Response: nil})
+		}
 
-			//Check if operation mode of the agent is waf
-			if agentHandler.configuration.OperationMode == "waf" {
-				rule_action := rules.GetRuleAction(agentHandler.rules, finding.RuleId)
-				if rule_action == "drop" || rule_action == "" {
-					requestBlocked = true
-				}
-			}
+		//Check if operation mode of the agent is waf
+		//The messages are scored using the request threshold
+		//The messages cannot be redirected or deceived so every blocking action drops the message
+		var decision WAFDecision
+		if agentHandler.configuration.OperationMode == "waf" {
+			decision, _ = agentHandler.HandleWAFOperationModeOnRequest(nil, findings)
+			requestBlocked = decision.Blocked()
 		}
 
 		//Initialize the forbidden message
 		forbiddenMessage := []byte("{\"status_code\": 403, \"message\": \"Forbidden, you do not have permissions to access this resource\"}")
 
 		//Create the log structure that should be sent to the API
-		logData := data.LogData{AgentId: agentHandler.configuration.UUID, RemoteIP: src.NetConn().RemoteAddr().String(), Timestamp: time.Now().Unix(), Websocket: true, Request: b64RawRequest, Response: "", Findings: nil, RuleFindings: allFindings}
+		logData := data.LogData{AgentId: agentHandler.configuration.UUID, RemoteIP: src.NetConn().RemoteAddr().String(), Timestamp: time.Now().Unix(), Websocket: true, Direction: direction, Request: b64RawRequest, Response: "", Findings: nil, RuleFindings: allFindings, SuppressedRuleFindings: agentHandler.combineRuleFindings(suppressedFindings, nil), AnomalyScore: decision.Score.Score, AnomalyContributors: decision.Score.Contributors}
 
 		//If the request is blocked then add the forbidden message as response in the log data
 		if requestBlocked {
+			logData.Action = rules.ActionDrop
 			logData.Response = b64.StdEncoding.EncodeToString(forbiddenMessage)
 		}
 
@@ -577,9 +744,13 @@ func (agentHandler *AgentHandler) proxyWS(src, dest *ws_gorilla.Conn, errc chan
 			}
 		}
 
-		//If the request is blocked
+		//If the message is blocked then the client is notified (the message from the client is answered, the message to the client is replaced)
 		if requestBlocked {
-			src.WriteMessage(ws_gorilla.TextMessage, forbiddenMessage)
+			if direction == rules.WebsocketDirectionClient {
+				src.WriteMessage(ws_gorilla.TextMessage, forbiddenMessage)
+			} else {
+				dest.WriteMessage(ws_gorilla.TextMessage, forbiddenMessage)
+			}
 		}
 
 		if !requestBlocked {
@@ -615,15 +786,37 @@ func (agentHandler *AgentHandler) HandleRequest(rw http.ResponseWriter, r *http.
 
 	//Create the validator runner
 	validatorRunner := code.NewValidatorRunner(agentHandler.checkers, agentHandler.logger)
+
+	//The requests of the trusted clients (monitoring) are forwarded without being inspected or logged
+	if validatorName, bypass := validatorRunner.ShouldBypass(r); bypass {
+		agentHandler.logger.Debug("Request from", r.RemoteAddr, "bypasses the inspection because of", validatorName)
+		response, _, err := agentHandler.forwardRequest(r)
+		if err != nil {
+			agentHandler.logger.Error(err.Error())
+			return
+		}
+		agentHandler.forwardResponse(rw, response)
+		return
+	}
+
+	//Throttle the clients which exceeded the rate limits (only in waf mode, the other modes log the findings)
+	limitFindings, retryAfter, throttled := validatorRunner.RunLimiters(r)
+	if throttled && agentHandler.configuration.OperationMode == "waf" {
+		agentHandler.HandleThrottledRequest(rw, r, limitFindings, retryAfter)
+		return
+	}
+
 	//Create the rule runner
-	ruleRunner := rules.NewRuleRunner(agentHandler.logger, agentHandler.rules, agentHandler.apiWsConn, agentHandler.configuration)
+	ruleRunner := rules.NewRuleRunner(agentHandler.logger, agentHandler.ruleStore.GetIndex(), agentHandler.apiWsConn, agentHandler.configuration)
+	ruleRunner.SetStatistics(agentHandler.ruleStore.GetStatistics())
 	//Create the AI classifier runner
 	aiClassifierRunner := ai.NewAIClassifierRunner(agentHandler.logger, agentHandler.configuration)
 
 	//Run all the validators on the request
 	requestFindings, _ := validatorRunner.RunValidatorsOnRequest(r)
+	requestFindings = append(limitFindings, requestFindings...)
 	//Run all the rules on the request
-	requestRuleFindings, _ := ruleRunner.RunRulesOnRequest(r)
+	requestRuleFindings, requestSuppressedFindings, _ := ruleRunner.RunRulesOnRequest(r)
 	//Run the ai classifier on the request
 	requestClassification := ""
 
@@ -635,18 +828,28 @@ func (agentHandler *AgentHandler) HandleRequest(rw http.ResponseWriter, r *http.
 	agentHandler.logger.Debug("Request findings", requestFindings)
 	//Log the request rule findings
 	agentHandler.logger.Debug("Request rule findings", requestRuleFindings)
+	//Log the request rule findings suppressed by the exclusions
+	agentHandler.logger.Debug("Request suppressed rule findings", requestSuppressedFindings)
 
 	//If the mode of operation is waf check the action from the rule
-	//If the action specified inside the rule is block then the forbidden page should be sent to the client
-	var requestDropped bool = false
+	//If the action specified inside the rule blocks then the response of the action should be sent to the client
+	var requestDecision WAFDecision = WAFDecision{Action: rules.ActionAllow}
 	var err error = nil
 
 	if agentHandler.configuration.OperationMode == "waf" {
-		requestDropped, err = agentHandler.HandleWAFOperationModeOnRequest(requestFindings, requestRuleFindings)
+		requestDecision, err = agentHandler.HandleWAFOperationModeOnRequest(requestFindings, requestRuleFindings)
 		if err != nil {
 			agentHandler.logger.Error("Error occured when handling waf operation mode on request", err.Error())
 		}
+
+		//The request is handled by the adaptive mode (the response is generated by the LLM)
+		if requestDecision.Action == rules.ActionDeceive {
+			agentHandler.logger.Info("Deceiving", r.Method, "request on", r.URL.Path, "because of rule", requestDecision.Rule.Id)
+			agentHandler.HandleAdaptiveOperationMode(rw, r, requestFindings, requestRuleFindings, requestClassification)
+			return
+		}
 	}
+	var requestDropped bool = requestDecision.Blocked()
 
 	//If the mode of operation is adaptive then send the raw request encoded base64 to LLM
 	if agentHandler.configuration.OperationMode == "adaptive" {
@@ -660,15 +863,17 @@ func (agentHandler *AgentHandler) HandleRequest(rw http.ResponseWriter, r *http.
 	//Also the rules and validators shouldn't be applied on response (as it will always be the forbidden page)
 
 	var response *http.Response = nil
+	var upstream *data.UpstreamMetrics = nil
 	var responseFindings []data.FindingData = make([]data.FindingData, 0)
 	var responseRuleFindings []*data.RuleFindingData = make([]*data.RuleFindingData, 0)
+	var responseSuppressedFindings []*data.RuleFindingData = make([]*data.RuleFindingData, 0)
 
-	//Initialize the response dropped
-	var responseDropped bool = false
+	//Initialize the response decision
+	var responseDecision WAFDecision = WAFDecision{Action: rules.ActionAllow}
 
 	if !requestDropped || agentHandler.configuration.OperationMode != "waf" {
 		//Forward the request to the destination web server
-		response, err = agentHandler.forwardRequest(r)
+		response, upstream, err = agentHandler.forwardRequest(r)
 		if err != nil {
 			agentHandler.logger.Error(err.Error())
 			return
@@ -677,7 +882,11 @@ func (agentHandler *AgentHandler) HandleRequest(rw http.ResponseWriter, r *http.
 		//Run the validators on the response
 		responseFindings, _ = validatorRunner.RunValidatorsOnResponse(response)
 		//Run the rules on the response
-		responseRuleFindings, _ = ruleRunner.RunRulesOnResponse(response)
+		responseRuleFindings, responseSuppressedFindings, _ = ruleRunner.RunRulesOnResponse(response, upstream)
+		//Count the request in the correlation rules (the findings of the rules which reached their threshold are added to the response findings)
+		correlationFindings, correlationSuppressedFindings := ruleRunner.RunCorrelationRules(agentHandler.ruleStore.GetCorrelations(), r, response.StatusCode, requestRuleFindings, responseRuleFindings)
+		responseRuleFindings = append(responseRuleFindings, correlationFindings...)
+		responseSuppressedFindings = append(responseSuppressedFindings, correlationSuppressedFindings...)
 
 		//Log response findings
 		agentHandler.logger.Debug("Response findings", responseFindings)
@@ -685,32 +894,51 @@ func (agentHandler *AgentHandler) HandleRequest(rw http.ResponseWriter, r *http.
 		agentHandler.logger.Debug("Response rule findings", responseRuleFindings)
 
 		//Check if the response should be dropped
-		responseDropped, err = agentHandler.HandleWAFOperationModeOnResponse(responseFindings, responseRuleFindings)
-		if err != nil {
-			agentHandler.logger.Error("Error occured when handling waf operation mode on request", err.Error())
+		if agentHandler.configuration.OperationMode == "waf" {
+			responseDecision, err = agentHandler.HandleWAFOperationModeOnResponse(responseFindings, responseRuleFindings)
+			if err != nil {
+				agentHandler.logger.Error("Error occured when handling waf operation mode on response", err.Error())
+			}
 		}
 	}
 
+	//The response sent to the client by the blocking action instead of the response from the web server
+	var blockedResponse *actionResponse = nil
+	var appliedAction string = ""
+	if requestDropped {
+		blockedResponse = agentHandler.newActionResponse(requestDecision)
+		appliedAction = requestDecision.Action
+		//The blocked requests which were not forwarded are counted in the correlation rules with the status code of the action
+		if response == nil {
+			correlationFindings, correlationSuppressedFindings := ruleRunner.RunCorrelationRules(agentHandler.ruleStore.GetCorrelations(), r, blockedResponse.statusCode, requestRuleFindings, responseRuleFindings)
+			responseRuleFindings = append(responseRuleFindings, correlationFindings...)
+			responseSuppressedFindings = append(responseSuppressedFindings, correlationSuppressedFindings...)
+		}
+	} else if responseDecision.Blocked() {
+		blockedResponse = agentHandler.newActionResponse(responseDecision)
+		appliedAction = responseDecision.Action
+	}
+
+	//The anomaly score of the request and the response (empty if the waf does not block based on the anomaly score)
+	anomalyScore := requestDecision.Score
+	anomalyScore.Add(responseDecision.Score)
+
 	//Combine the findings into a single structure
 	//If the request is not forwarded then the response findings should be empty arrays
 	allFindings := agentHandler.combineFindings(requestFindings, responseFindings)
 	//Combine the rule findings into a single structure
 	allRuleFindings := agentHandler.combineRuleFindings(requestRuleFindings, responseRuleFindings)
+	//Combine the findings suppressed by the exclusions (they are only logged)
+	allSuppressedFindings := agentHandler.combineRuleFindings(requestSuppressedFindings, responseSuppressedFindings)
 
 	//Convert the request and response to base64 string
-	//If the response is nil (the request was dropped then convert the forbidden page to base64)
+	//If the response is nil (the request was dropped then convert the response of the action to base64)
 	var b64RawRequest string = ""
 	var b64RawResponse string = ""
 	if !requestDropped {
 		b64RawRequest, b64RawResponse, _ = agentHandler.convertRequestAndResponseToB64(r, response)
 	} else {
-		forbiddenPageContent, err := os.ReadFile(agentHandler.configuration.ForbiddenPagePath)
-		//Check if an error occured when reading forbidden page
-		if err != nil {
-			forbiddenPageContent = []byte("Forbidden")
-		}
-		rawResponse := append([]byte("HTTP/1.1 403 Forbidden\r\nContent-Type: text/html\r\n\r\n"), forbiddenPageContent...)
-		b64RawResponse = base64.StdEncoding.EncodeToString(rawResponse)
+		b64RawResponse = blockedResponse.toB64()
 
 		//Dump the HTTP request to raw string
 		rawRequest, _ := utils.DumpHTTPRequest(r)
@@ -719,7 +947,7 @@ func (agentHandler *AgentHandler) HandleRequest(rw http.ResponseWriter, r *http.
 	}
 
 	//Create the log structure that should be sent to the API
-	logData := data.LogData{AgentId: agentHandler.configuration.UUID, RemoteIP: r.RemoteAddr, Timestamp: time.Now().Unix(), Websocket: false, Request: b64RawRequest, Response: b64RawResponse, Findings: allFindings, RuleFindings: allRuleFindings}
+	logData := data.LogData{AgentId: agentHandler.configuration.UUID, RemoteIP: r.RemoteAddr, Timestamp: time.Now().Unix(), Websocket: false, Request: b64RawRequest, Response: b64RawResponse, Findings: allFindings, RuleFindings: allRuleFindings, SuppressedRuleFindings: allSuppressedFindings, Action: appliedAction, AnomalyScore: anomalyScore.Score, AnomalyContributors: anomalyScore.Contributors, Upstream: upstream}
 
 	if true {
 		agentHandler.logger.Debug("Log data", logData)
@@ -736,23 +964,9 @@ func (agentHandler *AgentHandler) HandleRequest(rw http.ResponseWriter, r *http.
 		}
 	}
 
-	//Send the forbidden page if the request should be dropped and the operation mode is waf
-	if (requestDropped || responseDropped) && agentHandler.configuration.OperationMode == "waf" {
-		//Send the forbidden page
-		//Read the forbidden page from the disk
-		forbiddenPageContent, err := os.ReadFile(agentHandler.configuration.ForbiddenPagePath)
-
-		//Check if an error occured when reading forbidden page
-		if err != nil {
-			agentHandler.logger.Error("Failed to read forbidden page from disk,", err.Error())
-			rw.WriteHeader(http.StatusForbidden)
-			rw.Write([]byte("Forbidden"))
-			return
-		}
-
-		//Send the content of forbidden file to client
-		rw.WriteHeader(http.StatusForbidden)
-		rw.Write(forbiddenPageContent)
+	//Send the response of the action if the request (or the response) should be blocked and the operation mode is waf
+	if blockedResponse != nil && agentHandler.configuration.OperationMode == "waf" {
+		agentHandler.writeActionResponse(rw, r, blockedResponse)
 		return
 	}
 
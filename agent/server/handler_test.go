@@ -0,0 +1,44 @@
+package server
+
+import (
+	"testing"
+
+	"github.com/lucacoratu/disertatie/agent/config"
+	"github.com/lucacoratu/disertatie/agent/data"
+	rules "github.com/lucacoratu/disertatie/agent/detection/rules"
+	"github.com/lucacoratu/disertatie/agent/logging"
+)
+
+func TestDecideWAFActionDeniedClients(t *testing.T) {
+	logger := logging.NewDefaultLogger()
+	logRule := rules.Rule{Id: "log-rule", Info: &rules.RuleInfo{Name: "Log rule", Severity: "low", Action: rules.ActionLog}}
+	agentHandler := &AgentHandler{logger: logger, ruleStore: rules.NewRuleStore(rules.NewRuleIndex([]rules.Rule{logRule}, logger))}
+
+	tests := []struct {
+		name         string
+		findings     []data.FindingData
+		ruleFindings []*data.RuleFindingData
+		expected     string
+	}{
+		{"no findings", nil, nil, rules.ActionAllow},
+		{"deny list", []data.FindingData{{Classification: data.KNOWN_BAD_IP, Severity: data.HIGH}}, nil, rules.ActionDrop},
+		{"blocked country", []data.FindingData{{Classification: data.BLOCKED_COUNTRY, Severity: data.MEDIUM}}, nil, rules.ActionDrop},
+		{"deny list with a log rule", []data.FindingData{{Classification: data.KNOWN_BAD_IP, Severity: data.HIGH}}, []*data.RuleFindingData{{RuleId: "log-rule"}}, rules.ActionDrop},
+		{"tor exit node", []data.FindingData{{Classification: data.KNOWN_BAD_IP, Severity: data.MEDIUM}}, nil, rules.ActionAllow},
+		{"hosting provider", []data.FindingData{{Classification: data.HOSTING_ASN, Severity: data.LOW}}, nil, rules.ActionAllow},
+		{"log rule", nil, []*data.RuleFindingData{{RuleId: "log-rule"}}, rules.ActionLog},
+	}
+	for _, test := range tests {
+		decision := agentHandler.decideWAFAction(test.findings, test.ruleFindings, 0)
+		if decision.Action != test.expected {
+			t.Errorf("%s: expected the action %s, got %s", test.name, test.expected, decision.Action)
+		}
+	}
+
+	//In anomaly scoring mode the denied clients only add to the score
+	agentHandler.configuration = config.Configuration{WAFBlockingMode: "anomaly"}
+	decision := agentHandler.decideWAFAction([]data.FindingData{{Classification: data.KNOWN_BAD_IP, Severity: data.HIGH}}, nil, 1000)
+	if decision.Action != rules.ActionAllow {
+		t.Errorf("expected the deny list to be allowed below the anomaly threshold, got %s", decision.Action)
+	}
+}
@@ -9,6 +9,7 @@ import (
 	"os"
 	"os/signal"
 	"strings"
+	"syscall"
 	"time"
 
 	"github.com/gorilla/mux"
@@ -24,11 +25,14 @@ import (
 
 type AgentServer struct {
 	srv           *http.Server
+	adminSrv      *http.Server //The server of the local admin endpoint (nil if the admin address is not configured)
 	logger        logging.ILogger
 	apiBaseURL    string
 	configuration config.Configuration
 	checkers      []code.IValidator
 	rules         []rules.Rule
+	ruleStore     *rules.RuleStore
+	ruleReloader  *rules.RuleReloader
 	configFile    string
 }
 
@@ -51,10 +55,14 @@ func (agent *AgentServer) Init() error {
 	}
 	agent.logger.Info("Loaded configuration from file")
 
+	//The rule store is filled after the rules are loaded, the reloader remembers the files of the rules
+	agent.ruleStore = rules.NewRuleStore(rules.NewRuleIndex(nil, agent.logger))
+	agent.ruleReloader = rules.NewRuleReloader(agent.logger, agent.configuration, agent.ruleStore)
+
 	//Check if the rules directory was specified in the configuration file
 	if agent.configuration.RulesDirectory != "" {
 		//Load the rules from the rules directory
-		allRules, err := rules.LoadRulesFromDirectory(agent.configuration, agent.logger)
+		allRules, err := agent.ruleReloader.LoadRules()
 		if err != nil {
 			agent.logger.Error("Could not load rules from", agent.configuration.RulesDirectory, err.Error())
 		}
@@ -67,6 +75,29 @@ func (agent *AgentServer) Init() error {
 		agent.rules = make([]rules.Rule, 0)
 	}
 
+	//Compile the rules into the index used to select the candidate rules for each request
+	ruleIndex := rules.NewRuleIndex(agent.rules, agent.logger)
+	//Load the exclusions which disable the rules for some requests
+	exclusions, err := rules.LoadConfiguredExclusions(agent.configuration)
+	if err != nil {
+		agent.logger.Error("Could not load the exclusions from", agent.configuration.ExclusionsFile, err.Error())
+	} else if agent.configuration.ExclusionsFile != "" {
+		agent.logger.Info("Loaded", len(exclusions), "exclusions from", agent.configuration.ExclusionsFile)
+	}
+	ruleIndex.SetExclusions(exclusions)
+	agent.ruleStore.SwapIndex(ruleIndex)
+	agent.logger.Info("Compiled the rule index")
+
+	//Watch the rules directory so the rules are reloaded without restarting the agent
+	if agent.configuration.RulesDirectory != "" {
+		err = agent.ruleReloader.Start()
+		if err != nil {
+			agent.logger.Warning("The rules will not be reloaded when the files change,", err.Error())
+		} else {
+			agent.logger.Info("Watching", agent.configuration.RulesDirectory, "for rule changes")
+		}
+	}
+
 	//Check if the listening protocol is https and if it is check if the certificate file and the key file exist on disk
 	if strings.ToLower(agent.configuration.ListeningProtocol) == "https" {
 		//Check if the certificate exists
@@ -152,6 +183,15 @@ func (agent *AgentServer) Init() error {
 			return errors.New("could not connect to the API ws endpoint")
 		}
 
+		//Apply the rule sets pushed by the API
+		apiWsConnection.SetRuleSetHandler(func(update websocket.RuleSetUpdate) (int64, int, error) {
+			ruleFiles := make(map[string]string, len(update.Rules))
+			for _, rule := range update.Rules {
+				ruleFiles[rule.Id] = rule.Content
+			}
+			return agent.ruleReloader.ApplyRuleSet(update.Version, ruleFiles)
+		})
+
 		//Start waiting for messages from the server
 		go apiWsConnection.Start()
 	}
@@ -159,18 +199,42 @@ func (agent *AgentServer) Init() error {
 	//Send a test notification
 	//apiWsConnection.SendNotification("Connected to the WS endpoint")
 
-	//Add the validators to the list of validators
-	agent.checkers = append(agent.checkers, code.NewUserAgentValidator(agent.logger, agent.configuration))
+	//Create the validators enabled in the configuration
+	agent.checkers, err = code.NewValidatorRegistry().CreateValidators(agent.logger, agent.configuration)
+	if err != nil {
+		agent.logger.Error("Error occured when creating the validators,", err.Error())
+		return err
+	}
 
 	//Create the router
 	r := mux.NewRouter()
 
 	//Create the handler which will contain the function to handle requests
-	handler := NewAgentHandler(agent.logger, agent.apiBaseURL, agent.configuration, agent.checkers, agent.rules, apiWsConnection)
+	handler := NewAgentHandler(agent.logger, agent.apiBaseURL, agent.configuration, agent.checkers, agent.ruleStore, apiWsConnection)
 
 	//Create a single route that will catch every request on every method
 	r.PathPrefix("/").HandlerFunc(handler.HandleRequest)
 
+	//Report the statistics of the rules to the API
+	adminHandler := NewAdminHandler(agent.logger, agent.configuration, agent.ruleStore)
+	if apiWsConnection != nil {
+		go adminHandler.ReportRuleStatistics(apiWsConnection)
+	}
+
+	//Expose the statistics of the rules on the local admin endpoint (separated from the proxied requests)
+	if agent.configuration.AdminAddress != "" {
+		adminRouter := mux.NewRouter()
+		adminGetRouter := adminRouter.Methods(http.MethodGet).Subrouter()
+		adminGetRouter.HandleFunc("/rules/statistics", adminHandler.GetRuleStatistics)
+		agent.adminSrv = &http.Server{
+			Addr:         agent.configuration.AdminAddress,
+			WriteTimeout: time.Second * 15,
+			ReadTimeout:  time.Second * 15,
+			IdleTimeout:  time.Second * 60,
+			Handler:      adminRouter,
+		}
+	}
+
 	agent.srv = &http.Server{
 		Addr: agent.configuration.ListeningAddress + ":" + agent.configuration.ListeningPort,
 		// Good practice to set timeouts to avoid Slowloris attacks.
@@ -202,6 +266,26 @@ func (agent *AgentServer) Run() {
 
 	agent.logger.Info("Started server on port", agent.configuration.ListeningPort)
 
+	//Start the local admin endpoint
+	if agent.adminSrv != nil {
+		go func() {
+			if err := agent.adminSrv.ListenAndServe(); err != nil {
+				agent.logger.Error(err.Error())
+			}
+		}()
+		agent.logger.Info("Started admin endpoint on", agent.configuration.AdminAddress)
+	}
+
+	//Reload the rules when SIGHUP is received
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	go func() {
+		for range hup {
+			agent.logger.Info("Received SIGHUP, reloading the rules")
+			agent.ruleReloader.Reload()
+		}
+	}()
+
 	c := make(chan os.Signal, 1)
 	// We'll accept graceful shutdowns when quit via SIGINT (Ctrl+C)
 	// SIGKILL, SIGQUIT or SIGTERM (Ctrl+/) will not be caught.
@@ -210,12 +294,20 @@ func (agent *AgentServer) Run() {
 	// Block until we receive our signal.
 	<-c
 
+	//Stop watching the rules directory
+	agent.ruleReloader.Close()
+
 	// Create a deadline to wait for.
 	ctx, cancel := context.WithTimeout(context.Background(), wait)
 	defer cancel()
 	// Doesn't block if no connections, but will otherwise wait
 	// until the timeout deadline.
 	agent.srv.Shutdown(ctx)
+	if agent.adminSrv != nil {
+		agent.adminSrv.Shutdown(ctx)
+	}
+	//Release the resources of the validators
+	code.CloseValidators(agent.checkers, agent.logger)
 	// Optionally, you could run srv.Shutdown in a goroutine and block on
 	// <-ctx.Done() if your application should wait for other services
 	// to finalize based on context cancellation.
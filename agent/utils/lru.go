@@ -0,0 +1,74 @@
+package utils
+
+import "container/list"
+
+// Holds a bounded number of entries, the least recently used entry is evicted when the cache is full
+// The cache is not safe for concurrent use, the callers hold their own lock
+type LRUCache[K comparable, V any] struct {
+	capacity int
+	order    *list.List //The entries from the most recently used to the least recently used
+	entries  map[K]*list.Element
+}
+
+// Holds a key and its value in the order list
+type lruEntry[K comparable, V any] struct {
+	key   K
+	value V
+}
+
+// Creates a cache holding at most capacity entries
+func NewLRUCache[K comparable, V any](capacity int) *LRUCache[K, V] {
+	return &LRUCache[K, V]{capacity: capacity, order: list.New(), entries: make(map[K]*list.Element)}
+}
+
+// Gets the number of entries in the cache
+func (cache *LRUCache[K, V]) Len() int {
+	return cache.order.Len()
+}
+
+// Gets the value of the key and marks it as the most recently used
+func (cache *LRUCache[K, V]) Get(key K) (V, bool) {
+	element, found := cache.entries[key]
+	if !found {
+		var empty V
+		return empty, false
+	}
+	cache.order.MoveToFront(element)
+	return element.Value.(*lruEntry[K, V]).value, true
+}
+
+// Adds the value of the key, evicting the least recently used entry if the cache is full
+func (cache *LRUCache[K, V]) Add(key K, value V) {
+	if element, found := cache.entries[key]; found {
+		element.Value.(*lruEntry[K, V]).value = value
+		cache.order.MoveToFront(element)
+		return
+	}
+	if cache.order.Len() >= cache.capacity {
+		oldest := cache.order.Back()
+		cache.order.Remove(oldest)
+		delete(cache.entries, oldest.Value.(*lruEntry[K, V]).key)
+	}
+	cache.entries[key] = cache.order.PushFront(&lruEntry[K, V]{key: key, value: value})
+}
+
+// Removes the key from the cache
+func (cache *LRUCache[K, V]) Remove(key K) {
+	if element, found := cache.entries[key]; found {
+		cache.order.Remove(element)
+		delete(cache.entries, key)
+	}
+}
+
+// Removes all the entries for which the function returns true
+func (cache *LRUCache[K, V]) RemoveFunc(remove func(key K, value V) bool) {
+	for element := cache.order.Front(); element != nil; {
+		next := element.Next()
+		entry := element.Value.(*lruEntry[K, V])
+		if remove(entry.key, entry.value) {
+			cache.order.Remove(element)
+			delete(cache.entries, entry.key)
+		}
+		element = next
+	}
+}
@@ -0,0 +1,55 @@
+package utils
+
+import "testing"
+
+func TestLRUCache(t *testing.T) {
+	cache := NewLRUCache[string, int](2)
+	cache.Add("a", 1)
+	cache.Add("b", 2)
+
+	//Getting a marks it as the most recently used so b is evicted
+	if value, found := cache.Get("a"); !found || value != 1 {
+		t.Fatalf("expected a to be 1, got %d (found %v)", value, found)
+	}
+	cache.Add("c", 3)
+	if _, found := cache.Get("b"); found {
+		t.Error("expected b to be evicted")
+	}
+
+	//Updating a key does not evict the other keys
+	cache.Add("a", 4)
+	if value, found := cache.Get("a"); !found || value != 4 {
+		t.Errorf("expected a to be 4, got %d (found %v)", value, found)
+	}
+	if value, found := cache.Get("c"); !found || value != 3 {
+		t.Errorf("expected c to be 3, got %d (found %v)", value, found)
+	}
+	if cache.order.Len() != 2 || len(cache.entries) != 2 {
+		t.Errorf("expected 2 entries, got %d", len(cache.entries))
+	}
+}
+
+func TestLRUCacheRemove(t *testing.T) {
+	cache := NewLRUCache[string, int](3)
+	cache.Add("a", 1)
+	cache.Add("b", 2)
+	cache.Add("c", 3)
+
+	cache.Remove("b")
+	cache.Remove("missing")
+	if _, found := cache.Get("b"); found || cache.Len() != 2 {
+		t.Errorf("expected b to be removed, got %d entries", cache.Len())
+	}
+
+	//The entries matching the function are removed while iterating
+	cache.Add("d", 4)
+	cache.RemoveFunc(func(key string, value int) bool {
+		return value%2 == 1
+	})
+	if cache.Len() != 1 || len(cache.entries) != 1 {
+		t.Fatalf("expected 1 entry, got %d", cache.Len())
+	}
+	if value, found := cache.Get("d"); !found || value != 4 {
+		t.Errorf("expected d to be 4, got %d (found %v)", value, found)
+	}
+}
@@ -4,7 +4,6 @@ import (
 	"bufio"
 	"bytes"
 	"errors"
-	"fmt"
 	"io"
 	"net"
 	"net/http"
@@ -163,7 +162,7 @@ func DumpHTTPRequest(req *http.Request) ([]byte, error) {
 
 	//Reassign the body so other function can read the data
 	req.Body = io.NopCloser(bytes.NewReader(bodyData))
-	fmt.Println(bodyData)
+	//fmt.Println(bodyData)
 	//bodyData, _ = io.ReadAll(req.Body)
 
 	rawRequest = append(rawRequest, bodyData...)
@@ -14,6 +14,15 @@ const (
 	WsAgentDisconnectedNotification int64 = 4
 	WsAgentConnectedNotification    int64 = 5
 	WsRuleDetectionAlert            int64 = 6
+	WsRuleSetUpdate                 int64 = 7
+	WsRuleSetAck                    int64 = 8
+	WsRuleStatistics                int64 = 9
+)
+
+// The status of the rule set update sent in the acknowledgement
+const (
+	RuleSetApplied  = "applied"  //The rule set is in use
+	RuleSetRejected = "rejected" //The rule set is not valid, the previous rules are kept
 )
 
 // WebSocket message format
@@ -76,3 +85,36 @@ func (rda *RuleDetectionAlert) FromJSON(r io.Reader) error {
 	e := json.NewDecoder(r)
 	return e.Decode(rda)
 }
+
+// A rule from the rule set stored in the API
+type RuleSetRule struct {
+	Id      string `json:"id"`      //The id of the rule
+	Content string `json:"content"` //The YAML content of the rule
+	Version int64  `json:"version"` //The version of the rule set when the rule was last modified
+}
+
+// The rule set pushed by the API to the agents
+type RuleSetUpdate struct {
+	Version int64         `json:"version"` //The version of the rule set
+	Rules   []RuleSetRule `json:"rules"`   //The rules of the rule set
+}
+
+func (rsu *RuleSetUpdate) FromJSON(r io.Reader) error {
+	d := json.NewDecoder(r)
+	return d.Decode(rsu)
+}
+
+// The acknowledgement of the agent after a rule set update
+type RuleSetAck struct {
+	AgentId    string `json:"agentId"`         //The id of the agent
+	Version    int64  `json:"version"`         //The version of the rule set the agent is running
+	Status     string `json:"status"`          //The status of the update (applied or rejected)
+	Error      string `json:"error,omitempty"` //Why the rule set was rejected
+	RulesCount int64  `json:"rulesCount"`      //The number of rules in use
+	Timestamp  int64  `json:"timestamp"`       //When the update was handled
+}
+
+func (rsa *RuleSetAck) FromJSON(r io.Reader) error {
+	d := json.NewDecoder(r)
+	return d.Decode(rsa)
+}
@@ -1,11 +1,14 @@
 package websocket
 
 import (
+	"encoding/json"
+	"strings"
 	"sync"
 	"time"
 
 	"github.com/gorilla/websocket"
 	"github.com/lucacoratu/disertatie/agent/config"
+	"github.com/lucacoratu/disertatie/agent/data"
 	"github.com/lucacoratu/disertatie/agent/logging"
 )
 
@@ -14,13 +17,18 @@ type message struct {
 	Body string `json:"body"`
 }
 
+// Applies the rule set received from the API
+// Returns the version of the rule set in use, the number of rules in use and an error if the rule set was rejected
+type RuleSetHandler func(update RuleSetUpdate) (int64, int, error)
+
 type APIWebSocketConnection struct {
-	logger        logging.ILogger      //The logger
-	apiWsURL      string               //The ws url of the API
-	configuration config.Configuration //The configuration structure of the agent
-	State         bool                 //The state of the websocket connection (true for active, false for inactive)
-	connection    *websocket.Conn      //The connection structure
-	mu            sync.Mutex           //Mutex for the websocket connection
+	logger         logging.ILogger      //The logger
+	apiWsURL       string               //The ws url of the API
+	configuration  config.Configuration //The configuration structure of the agent
+	State          bool                 //The state of the websocket connection (true for active, false for inactive)
+	connection     *websocket.Conn      //The connection structure
+	mu             sync.Mutex           //Mutex for the websocket connection
+	ruleSetHandler RuleSetHandler       //The function which applies the rule sets received from the API
 }
 
 func NewAPIWebSocketConnection(logger logging.ILogger, apiWsURL string, configuration config.Configuration) *APIWebSocketConnection {
@@ -47,9 +55,54 @@ func (awsc *APIWebSocketConnection) Connect() (bool, error) {
 // 	return nil
 // }
 
+// Sets the function which applies the rule sets received from the API
+// It should be called before starting to listen for messages
+func (awsc *APIWebSocketConnection) SetRuleSetHandler(handler RuleSetHandler) {
+	awsc.ruleSetHandler = handler
+}
+
 // Handle the message received
 func (awsc *APIWebSocketConnection) handleReceivedMessage(message message) {
 	awsc.logger.Debug("Message received", message)
+	//Parse the message body to a websocket message
+	wsMessage := WebSocketMessage{}
+	err := wsMessage.FromJSON(strings.NewReader(message.Body))
+	if err != nil {
+		awsc.logger.Error("Cannot parse the websocket message from JSON", err.Error())
+		return
+	}
+
+	switch wsMessage.Type {
+	case WsRuleSetUpdate:
+		err = awsc.handleRuleSetUpdate(wsMessage)
+		if err != nil {
+			awsc.logger.Error("Error occured when handling the rule set update", err.Error())
+		}
+	}
+}
+
+// Applies the rule set received from the API and acknowledges the version in use
+func (awsc *APIWebSocketConnection) handleRuleSetUpdate(msg WebSocketMessage) error {
+	//Convert the message data field to the corresponding structure
+	data, _ := json.Marshal(msg.Data)
+	update := RuleSetUpdate{}
+	err := json.Unmarshal(data, &update)
+	if err != nil {
+		return err
+	}
+	if awsc.ruleSetHandler == nil {
+		return awsc.SendRuleSetAck(RuleSetAck{Version: 0, Status: RuleSetRejected, Error: "the agent cannot apply rule sets"})
+	}
+
+	awsc.logger.Info("Received rule set version", update.Version, "with", len(update.Rules), "rules")
+	version, rulesCount, err := awsc.ruleSetHandler(update)
+	ack := RuleSetAck{Version: version, Status: RuleSetApplied, RulesCount: int64(rulesCount)}
+	if err != nil {
+		awsc.logger.Error("Rule set version", update.Version, "rejected,", err.Error())
+		ack.Status = RuleSetRejected
+		ack.Error = err.Error()
+	}
+	return awsc.SendRuleSetAck(ack)
 }
 
 func (awsc *APIWebSocketConnection) Start() {
@@ -90,3 +143,21 @@ func (awsc *APIWebSocketConnection) SendRuleDetectionAlert(alert RuleDetectionAl
 	awsc.mu.Unlock()
 	return err
 }
+
+// Function to acknowledge the version of the rule set the agent is running
+func (awsc *APIWebSocketConnection) SendRuleSetAck(ack RuleSetAck) error {
+	ack.AgentId = awsc.configuration.UUID
+	ack.Timestamp = time.Now().Unix()
+	awsc.mu.Lock()
+	err := awsc.connection.WriteJSON(WebSocketMessage{Type: WsRuleSetAck, Data: ack})
+	awsc.mu.Unlock()
+	return err
+}
+
+// Function to send the performance and hit counters of the rules
+func (awsc *APIWebSocketConnection) SendRuleStatistics(report data.RuleStatisticsReport) error {
+	awsc.mu.Lock()
+	err := awsc.connection.WriteJSON(WebSocketMessage{Type: WsRuleStatistics, Data: report})
+	awsc.mu.Unlock()
+	return err
+}
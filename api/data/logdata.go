@@ -7,15 +7,16 @@ import (
 
 // This structure holds the log data that is sent to the api
 type LogData struct {
-	Id           string        `json:"id"`           //The UUID of the log from the database
-	AgentId      string        `json:"agentId"`      //The UUID of the agent that collected the log data
-	RemoteIP     string        `json:"remoteIp"`     //The IP address of the sender of the request
-	Timestamp    int64         `json:"timestamp"`    //Timestamp when the request was received
-	Websocket    bool          `json:"websocket"`    //If the message is a websocket
-	Request      string        `json:"request"`      //The request base64 encoded
-	Response     string        `json:"response"`     // The response base64 encoded
-	Findings     []Finding     `json:"findings"`     //A list of findings
-	RuleFindings []RuleFinding `json:"ruleFindings"` //The list of rule findings
+	Id           string        `json:"id"`                  //The UUID of the log from the database
+	AgentId      string        `json:"agentId"`             //The UUID of the agent that collected the log data
+	RemoteIP     string        `json:"remoteIp"`            //The IP address of the sender of the request
+	Timestamp    int64         `json:"timestamp"`           //Timestamp when the request was received
+	Websocket    bool          `json:"websocket"`           //If the message is a websocket
+	Direction    string        `json:"direction,omitempty"` //The direction of the websocket message (client-to-server, server-to-client)
+	Request      string        `json:"request"`             //The request base64 encoded
+	Response     string        `json:"response"`            // The response base64 encoded
+	Findings     []Finding     `json:"findings"`            //A list of findings
+	RuleFindings []RuleFinding `json:"ruleFindings"`        //The list of rule findings
 }
 
 // Convert json data to LogData structure
@@ -70,16 +71,17 @@ func (lds *LogDataShort) ToJSON(w io.Writer) error {
 
 // This structure holds the log data that is sent to the api
 type LogDataElastic struct {
-	Id              string        `json:"id"`               //The UUID of the log from the database
-	AgentId         string        `json:"agentId"`          //The UUID of the agent that collected the log data
-	AgentName       string        `json:"agentName"`        //The name of the agent that collected the log data
-	RemoteIP        string        `json:"remoteIp"`         //The IP address of the sender of the request
-	Timestamp       int64         `json:"timestamp"`        //Timestamp when the request was received
-	RequestPreview  string        `json:"request_preview"`  //The preview of the request
-	ResponsePreview string        `json:"response_preview"` //The preview of the response
-	Findings        []Finding     `json:"findings"`         //A list of findings
-	Websocket       bool          `json:"websocket"`        //If the message is an websocket message
-	RuleFindings    []RuleFinding `json:"ruleFindings"`     //The list of rule findings
+	Id              string        `json:"id"`                  //The UUID of the log from the database
+	AgentId         string        `json:"agentId"`             //The UUID of the agent that collected the log data
+	AgentName       string        `json:"agentName"`           //The name of the agent that collected the log data
+	RemoteIP        string        `json:"remoteIp"`            //The IP address of the sender of the request
+	Timestamp       int64         `json:"timestamp"`           //Timestamp when the request was received
+	RequestPreview  string        `json:"request_preview"`     //The preview of the request
+	ResponsePreview string        `json:"response_preview"`    //The preview of the response
+	Findings        []Finding     `json:"findings"`            //A list of findings
+	Websocket       bool          `json:"websocket"`           //If the message is an websocket message
+	Direction       string        `json:"direction,omitempty"` //The direction of the websocket message (client-to-server, server-to-client)
+	RuleFindings    []RuleFinding `json:"ruleFindings"`        //The list of rule findings
 }
 
 // Convert json data to LogData structure
@@ -146,3 +146,51 @@ func (cm *ClassificationMetrics) FromJSON(r io.Reader) error {
 	d := json.NewDecoder(r)
 	return d.Decode(cm)
 }
+
+// Structure that holds the number of logs with rule findings of the rules with a tag
+type TagMetrics struct {
+	Tag   string `json:"tag"`   //The tag of the rules
+	Count int64  `json:"count"` //The count of occurences
+}
+
+func (tm *TagMetrics) ToJSON(w io.Writer) error {
+	e := json.NewEncoder(w)
+	return e.Encode(tm)
+}
+
+func (tm *TagMetrics) FromJSON(r io.Reader) error {
+	d := json.NewDecoder(r)
+	return d.Decode(tm)
+}
+
+// Structure that holds the number of logs with rule findings of the rules mapped to a MITRE ATT&CK technique
+type AttackMetrics struct {
+	Technique string `json:"technique"` //The MITRE ATT&CK technique id (T1190)
+	Count     int64  `json:"count"`     //The count of occurences
+}
+
+func (am *AttackMetrics) ToJSON(w io.Writer) error {
+	e := json.NewEncoder(w)
+	return e.Encode(am)
+}
+
+func (am *AttackMetrics) FromJSON(r io.Reader) error {
+	d := json.NewDecoder(r)
+	return d.Decode(am)
+}
+
+// Structure that holds the number of logs with rule findings of the rules mapped to a CWE
+type CWEMetrics struct {
+	CWE   string `json:"cwe"`   //The CWE id (CWE-89)
+	Count int64  `json:"count"` //The count of occurences
+}
+
+func (cm *CWEMetrics) ToJSON(w io.Writer) error {
+	e := json.NewEncoder(w)
+	return e.Encode(cm)
+}
+
+func (cm *CWEMetrics) FromJSON(r io.Reader) error {
+	d := json.NewDecoder(r)
+	return d.Decode(cm)
+}
@@ -0,0 +1,21 @@
+package data
+
+import (
+	"encoding/json"
+	"io"
+)
+
+// Holds the rule sent to be added or modified in the central rule repository
+type RuleRequest struct {
+	Content string `json:"content" validate:"required"` //The YAML content of the rule (the id is taken from the content)
+}
+
+func (rr *RuleRequest) ToJSON(w io.Writer) error {
+	e := json.NewEncoder(w)
+	return e.Encode(rr)
+}
+
+func (rr *RuleRequest) FromJSON(r io.Reader) error {
+	d := json.NewDecoder(r)
+	return d.Decode(rr)
+}
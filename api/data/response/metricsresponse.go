@@ -118,3 +118,45 @@ func (amr *ClassificationMetricsResponse) FromJSON(r io.Reader) error {
 	d := json.NewDecoder(r)
 	return d.Decode(amr)
 }
+
+type TagMetricsResponse struct {
+	Metrics []data.TagMetrics `json:"metrics"`
+}
+
+func (tmr *TagMetricsResponse) ToJSON(w io.Writer) error {
+	e := json.NewEncoder(w)
+	return e.Encode(tmr)
+}
+
+func (tmr *TagMetricsResponse) FromJSON(r io.Reader) error {
+	d := json.NewDecoder(r)
+	return d.Decode(tmr)
+}
+
+type AttackMetricsResponse struct {
+	Metrics []data.AttackMetrics `json:"metrics"`
+}
+
+func (amr *AttackMetricsResponse) ToJSON(w io.Writer) error {
+	e := json.NewEncoder(w)
+	return e.Encode(amr)
+}
+
+func (amr *AttackMetricsResponse) FromJSON(r io.Reader) error {
+	d := json.NewDecoder(r)
+	return d.Decode(amr)
+}
+
+type CWEMetricsResponse struct {
+	Metrics []data.CWEMetrics `json:"metrics"`
+}
+
+func (cmr *CWEMetricsResponse) ToJSON(w io.Writer) error {
+	e := json.NewEncoder(w)
+	return e.Encode(cmr)
+}
+
+func (cmr *CWEMetricsResponse) FromJSON(r io.Reader) error {
+	d := json.NewDecoder(r)
+	return d.Decode(cmr)
+}
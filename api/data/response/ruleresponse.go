@@ -0,0 +1,71 @@
+package data
+
+import (
+	"encoding/json"
+	"io"
+
+	"github.com/lucacoratu/disertatie/api/data"
+)
+
+type RulesGetResponse struct {
+	Version int64       `json:"version"` //The version of the rule set
+	Rules   []data.Rule `json:"rules"`   //The rules of the rule set
+}
+
+func (rgr *RulesGetResponse) ToJSON(w io.Writer) error {
+	e := json.NewEncoder(w)
+	return e.Encode(rgr)
+}
+
+func (rgr *RulesGetResponse) FromJSON(r io.Reader) error {
+	d := json.NewDecoder(r)
+	return d.Decode(rgr)
+}
+
+type AgentsRuleVersionsResponse struct {
+	Version int64                   `json:"version"` //The latest version of the rule set
+	Agents  []data.AgentRuleVersion `json:"agents"`  //The version of the rule set each agent is running
+}
+
+func (arvr *AgentsRuleVersionsResponse) ToJSON(w io.Writer) error {
+	e := json.NewEncoder(w)
+	return e.Encode(arvr)
+}
+
+func (arvr *AgentsRuleVersionsResponse) FromJSON(r io.Reader) error {
+	d := json.NewDecoder(r)
+	return d.Decode(arvr)
+}
+
+type AgentRuleStatisticsResponse struct {
+	AgentId string                `json:"agentId"` //The id of the agent
+	Rules   []data.RuleStatistics `json:"rules"`   //The counters of the selected rules
+}
+
+func (arsr *AgentRuleStatisticsResponse) ToJSON(w io.Writer) error {
+	e := json.NewEncoder(w)
+	return e.Encode(arsr)
+}
+
+func (arsr *AgentRuleStatisticsResponse) FromJSON(r io.Reader) error {
+	d := json.NewDecoder(r)
+	return d.Decode(arsr)
+}
+
+type VirtualPatchResponse struct {
+	LogId   string                  `json:"logId"`   //The id of the log the rule was generated from
+	RuleId  string                  `json:"ruleId"`  //The id of the generated rule
+	Content string                  `json:"content"` //The YAML content of the rule
+	Target  data.VirtualPatchTarget `json:"target"`  //The value of the request targeted by the rule
+	Saved   bool                    `json:"saved"`   //If the rule was added to the rule set
+}
+
+func (vpr *VirtualPatchResponse) ToJSON(w io.Writer) error {
+	e := json.NewEncoder(w)
+	return e.Encode(vpr)
+}
+
+func (vpr *VirtualPatchResponse) FromJSON(r io.Reader) error {
+	d := json.NewDecoder(r)
+	return d.Decode(vpr)
+}
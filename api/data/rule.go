@@ -0,0 +1,66 @@
+package data
+
+import (
+	"encoding/json"
+	"io"
+)
+
+// Holds a rule stored in the central rule repository
+type Rule struct {
+	Id      string `json:"id"`      //The id of the rule (the id from the YAML content)
+	Content string `json:"content"` //The YAML content of the rule
+	Version int64  `json:"version"` //The version of the rule set when the rule was last modified
+	Updated int64  `json:"updated"` //The unix timestamp of the last modification
+}
+
+// Convert from json into the rule structure
+func (rule *Rule) FromJSON(r io.Reader) error {
+	d := json.NewDecoder(r)
+	return d.Decode(rule)
+}
+
+// Convert to json the rule structure
+func (rule *Rule) ToJSON(w io.Writer) error {
+	e := json.NewEncoder(w)
+	return e.Encode(rule)
+}
+
+// Holds the version of the rule set an agent is running
+type AgentRuleVersion struct {
+	AgentId    string `json:"agentId"`         //The id of the agent
+	Version    int64  `json:"version"`         //The version of the rule set the agent is running
+	Status     string `json:"status"`          //The status of the last update (applied or rejected)
+	Error      string `json:"error,omitempty"` //Why the last update was rejected
+	RulesCount int64  `json:"rulesCount"`      //The number of rules the agent has in use
+	Updated    int64  `json:"updated"`         //The unix timestamp of the last acknowledgement
+	UpToDate   bool   `json:"upToDate"`        //If the agent is running the latest version of the rule set
+	Online     bool   `json:"online"`          //If the agent is connected to the websocket
+}
+
+// Holds the performance and hit counters of a rule reported by an agent
+// The counters are kept by the agent since it started, the times are in microseconds
+type RuleStatistics struct {
+	AgentId      string `json:"agentId"`      //The id of the agent
+	RuleId       string `json:"ruleId"`       //The id of the rule
+	Evaluations  int64  `json:"evaluations"`  //The number of times the rule was evaluated
+	Matches      int64  `json:"matches"`      //The number of evaluations in which the rule matched
+	TotalTime    int64  `json:"totalTime"`    //The cumulative evaluation time
+	P99Time      int64  `json:"p99Time"`      //The 99th percentile of the evaluation time
+	MaxTime      int64  `json:"maxTime"`      //The slowest evaluation
+	SlowSearches int64  `json:"slowSearches"` //The number of regex searches which took longer than the slow search threshold of the agent
+	LastMatch    int64  `json:"lastMatch"`    //The unix timestamp of the last match (0 if the rule never matched)
+	FirstSeen    int64  `json:"firstSeen"`    //The unix timestamp of the first report with the rule
+	Since        int64  `json:"since"`        //The unix timestamp since the agent keeps the counters (when it started)
+	Updated      int64  `json:"updated"`      //The unix timestamp of the last report
+}
+
+// Holds the malicious value of the request targeted by a virtual patch rule
+type VirtualPatchTarget struct {
+	Part      string   `json:"part"`                //The part of the request (url, parameter, header, cookie, body, json, xml)
+	Name      string   `json:"name,omitempty"`      //The name of the parameter, header or cookie
+	Source    string   `json:"source,omitempty"`    //The source of the parameter (query, body)
+	Path      string   `json:"path,omitempty"`      //The JSONPath or XPath of the value in the body
+	Value     string   `json:"value"`               //The malicious value found by the findings
+	Regex     string   `json:"regex"`               //The regex generalized from the value
+	Encodings []string `json:"encodings,omitempty"` //The decodings which revealed the value to the rule
+}
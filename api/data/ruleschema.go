@@ -0,0 +1,225 @@
+package data
+
+// The structures below mirror the rule schema of the agent (the same YAML keys, one structure for every structure of the agent)
+// They are used to build and validate the rules before they are added to the rule set
+// The rules are decoded with the same YAML package as the agent (yaml.v2) so the content is parsed the same way
+// The keys are kept in sync with the agent by the tests of the rule schema validation (utils/ruleschema_test.go)
+
+// Holds the info field of the rule
+type RuleSchemaInfo struct {
+	Name             string                      `yaml:"name,omitempty"`              //The name of the rule
+	Description      string                      `yaml:"description,omitempty"`       //The description of the rule
+	Severity         string                      `yaml:"severity,omitempty"`          //The severity of the rule (low, medium, high, critical)
+	Classification   string                      `yaml:"classification,omitempty"`    //The classification of the findings of the rule
+	Action           string                      `yaml:"action,omitempty"`            //The action taken by the agent in waf mode when the rule matches
+	ActionParameters *RuleSchemaActionParameters `yaml:"action-parameters,omitempty"` //The parameters of the action
+	Encodings        []string                    `yaml:"encodings,omitempty"`         //The encodings decoded before searching (applied to all the matchers)
+	Tags             []string                    `yaml:"tags,omitempty"`              //The tags of the rule
+	References       []string                    `yaml:"references,omitempty"`        //The links to the advisories of the vulnerability
+	CVSSScore        float64                     `yaml:"cvss-score,omitempty"`        //The CVSS score of the vulnerability
+	CVSSMetrics      string                      `yaml:"cvss-metrics,omitempty"`      //The CVSS vector of the vulnerability
+	CWEIds           []string                    `yaml:"cwe-ids,omitempty"`           //The CWE ids of the weakness
+	MitreTechniques  []string                    `yaml:"mitre-techniques,omitempty"`  //The MITRE ATT&CK techniques of the attack
+}
+
+// Holds the parameters of the rule action
+type RuleSchemaActionParameters struct {
+	RedirectURL string            `yaml:"redirect-url,omitempty"` //The URL where the client is redirected (redirect action)
+	Status      int               `yaml:"status,omitempty"`       //The status code of the response (respond action)
+	Headers     map[string]string `yaml:"headers,omitempty"`      //The headers of the response (respond action)
+	BodyFile    string            `yaml:"body-file,omitempty"`    //The file with the body of the response on the agent (respond action)
+}
+
+// Holds the requests the rule applies to
+type RuleSchemaScope struct {
+	Hosts       []string `yaml:"hosts,omitempty"`      //The hosts
+	Paths       []string `yaml:"paths,omitempty"`      //The globs of the path
+	PathRegexes []string `yaml:"path-regex,omitempty"` //The regexes of the path
+	Methods     []string `yaml:"methods,omitempty"`    //The methods
+}
+
+// Holds a matcher searching a single value (the method, the URL, the status code, the filename)
+type RuleSchemaSearchMode struct {
+	Match     string   `yaml:"match,omitempty"`      //The string to match exactly
+	Regex     string   `yaml:"regex,omitempty"`      //The regex used for searching
+	MatchList string   `yaml:"match_list,omitempty"` //The name of the pattern list of the agents whose patterns are searched
+	Encodings []string `yaml:"encodings,omitempty"`  //The encodings decoded before searching
+}
+
+// Holds a matcher of the headers, the parameters or the cookies
+type RuleSchemaMatcher struct {
+	Name      string   `yaml:"name,omitempty"`       //The name of the header, parameter or cookie (any for all of them)
+	Match     string   `yaml:"match,omitempty"`      //The string to match exactly
+	Regex     string   `yaml:"regex,omitempty"`      //The regex used for searching
+	MatchList string   `yaml:"match_list,omitempty"` //The name of the pattern list of the agents whose patterns are searched
+	Encodings []string `yaml:"encodings,omitempty"`  //The encodings decoded before searching
+}
+
+// Holds a matcher of the values selected from a JSON or a XML body
+type RuleSchemaPathMatcher struct {
+	Path      string   `yaml:"path,omitempty"`       //The JSONPath or XPath selector of the values
+	Match     string   `yaml:"match,omitempty"`      //The string to match exactly
+	Regex     string   `yaml:"regex,omitempty"`      //The regex used for searching
+	MatchList string   `yaml:"match_list,omitempty"` //The name of the pattern list of the agents whose patterns are searched
+	Encodings []string `yaml:"encodings,omitempty"`  //The encodings decoded before searching
+}
+
+// Holds a matcher of the body
+type RuleSchemaBodyMatcher struct {
+	SHA256Sum string   `yaml:"sha256sum,omitempty"`  //The SHA256 hash of the body
+	MD5Sum    string   `yaml:"md5sum,omitempty"`     //The MD5 hash of the body
+	Match     string   `yaml:"match,omitempty"`      //The string to match exactly
+	Regex     string   `yaml:"regex,omitempty"`      //The regex used for searching
+	MatchList string   `yaml:"match_list,omitempty"` //The name of the pattern list of the agents whose patterns are searched
+	Encodings []string `yaml:"encodings,omitempty"`  //The encodings decoded before searching
+}
+
+// Holds a matcher of the files uploaded in the multipart body
+type RuleSchemaFilesMatcher struct {
+	Field               string                `yaml:"field,omitempty"`                 //The name of the form field of the file
+	Filename            *RuleSchemaSearchMode `yaml:"filename,omitempty"`              //The matcher of the filename
+	ContentType         *RuleSchemaSearchMode `yaml:"content-type,omitempty"`          //The matcher of the declared content type
+	SniffedContentType  *RuleSchemaSearchMode `yaml:"sniffed-content-type,omitempty"`  //The matcher of the content type detected from the content
+	ContentTypeMismatch bool                  `yaml:"content-type-mismatch,omitempty"` //If the declared and the detected content types should differ
+	MinSize             int64                 `yaml:"min-size,omitempty"`              //The minimum size of the file in bytes
+	MaxSize             int64                 `yaml:"max-size,omitempty"`              //The maximum size of the file in bytes
+	SHA256Sum           string                `yaml:"sha256sum,omitempty"`             //The SHA256 hash of the file
+	MD5Sum              string                `yaml:"md5sum,omitempty"`                //The MD5 hash of the file
+}
+
+// Holds a matcher of the websocket messages
+type RuleSchemaWebsocketMatcher struct {
+	MessageType int      `yaml:"message_type,omitempty"` //The type of the websocket message
+	Direction   string   `yaml:"direction,omitempty"`    //The direction of the messages (client-to-server, server-to-client)
+	JSONPath    string   `yaml:"json-path,omitempty"`    //The JSONPath selector of the values of the JSON messages
+	Header      string   `yaml:"header,omitempty"`       //The header of the upgrade request searched instead of the message
+	Match       string   `yaml:"match,omitempty"`        //The string to match exactly
+	Regex       string   `yaml:"regex,omitempty"`        //The regex used for searching
+	MatchList   string   `yaml:"match_list,omitempty"`   //The name of the pattern list of the agents whose patterns are searched
+	HexMatch    string   `yaml:"hexmatch,omitempty"`     //The hex string to find in the message
+	HexRegex    string   `yaml:"hexregex,omitempty"`     //The regex with hex bytes used for searching
+	Encodings   []string `yaml:"encodings,omitempty"`    //The encodings decoded before searching
+}
+
+// Holds the request field of the rule
+type RuleSchemaRequest struct {
+	Method     *RuleSchemaSearchMode     `yaml:"method,omitempty"`  //The matcher of the method
+	URL        []*RuleSchemaSearchMode   `yaml:"url,omitempty"`     //The matchers of the URL
+	Headers    []*RuleSchemaMatcher      `yaml:"headers,omitempty"` //The matchers of the headers
+	Parameters []*RuleSchemaMatcher      `yaml:"params,omitempty"`  //The matchers of the query and body parameters
+	Body       []*RuleSchemaBodyMatcher  `yaml:"body,omitempty"`    //The matchers of the body
+	Cookies    []*RuleSchemaMatcher      `yaml:"cookies,omitempty"` //The matchers of the cookies
+	JSON       []*RuleSchemaPathMatcher  `yaml:"json,omitempty"`    //The matchers of the values selected from the JSON body
+	XML        []*RuleSchemaPathMatcher  `yaml:"xml,omitempty"`     //The matchers of the values selected from the XML body
+	Files      []*RuleSchemaFilesMatcher `yaml:"files,omitempty"`   //The matchers of the uploaded files
+}
+
+// Holds the bounds of a numeric matcher
+type RuleSchemaRange struct {
+	Gt *int64 `yaml:"gt,omitempty"` //The value should be greater than this
+	Lt *int64 `yaml:"lt,omitempty"` //The value should be less than this
+}
+
+// Holds the bounds of the time the web server took to respond
+type RuleSchemaLatency struct {
+	RuleSchemaRange `yaml:",inline"`
+	Measure         string `yaml:"measure,omitempty"` //What is measured (ttfb, total)
+}
+
+// Holds the response field of the rule
+type RuleSchemaResponse struct {
+	Code           *RuleSchemaSearchMode    `yaml:"code,omitempty"`            //The matcher of the status code
+	Headers        []*RuleSchemaMatcher     `yaml:"headers,omitempty"`         //The matchers of the headers
+	Body           []*RuleSchemaBodyMatcher `yaml:"body,omitempty"`            //The matchers of the body
+	JSON           []*RuleSchemaPathMatcher `yaml:"json,omitempty"`            //The matchers of the values selected from the JSON body
+	XML            []*RuleSchemaPathMatcher `yaml:"xml,omitempty"`             //The matchers of the values selected from the XML body
+	Latency        *RuleSchemaLatency       `yaml:"latency,omitempty"`         //The time the web server took to respond in milliseconds
+	Size           *RuleSchemaRange         `yaml:"size,omitempty"`            //The size of the response body in bytes
+	ConfirmRequest bool                     `yaml:"confirm-request,omitempty"` //If the request matchers should match the request of the response
+}
+
+// Holds the filters of the requests counted by a correlation rule
+type RuleSchemaCorrelationFilter struct {
+	Status          []int    `yaml:"status,omitempty"`          //The status codes of the responses
+	Rules           []string `yaml:"rules,omitempty"`           //The ids of the rules which should have matched
+	Classifications []string `yaml:"classifications,omitempty"` //The classifications of the rules which should have matched
+}
+
+// Holds the correlation field of the rule
+type RuleSchemaCorrelation struct {
+	Keys          []string                     `yaml:"keys,omitempty"`           //The values which identify the client
+	SessionCookie string                       `yaml:"session-cookie,omitempty"` //The name of the session cookie
+	Window        string                       `yaml:"window,omitempty"`         //The length of the sliding window
+	Threshold     int                          `yaml:"threshold,omitempty"`      //The number of requests which trips the rule
+	Distinct      string                       `yaml:"distinct,omitempty"`       //The value whose distinct values are counted
+	Parameter     string                       `yaml:"parameter,omitempty"`      //The parameter whose distinct values are counted
+	Filter        *RuleSchemaCorrelationFilter `yaml:"filter,omitempty"`         //The requests which are counted
+}
+
+// Holds a boolean composition of the matchers
+type RuleSchemaCondition struct {
+	And     []*RuleSchemaCondition `yaml:"and,omitempty"`     //All the subconditions should be true
+	Or      []*RuleSchemaCondition `yaml:"or,omitempty"`      //At least one of the subconditions should be true
+	Not     *RuleSchemaCondition   `yaml:"not,omitempty"`     //The subcondition should be false
+	Matcher string                 `yaml:"matcher,omitempty"` //The reference to a matcher of the rule
+}
+
+// Allows a condition leaf to be written as a plain string (- request.method) the same way the agent does
+func (rc *RuleSchemaCondition) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	var reference string
+	if err := unmarshal(&reference); err == nil {
+		rc.Matcher = reference
+		return nil
+	}
+
+	//The condition is a mapping so decode it into an alias type to avoid recursion
+	type plainRuleSchemaCondition RuleSchemaCondition
+	return unmarshal((*plainRuleSchemaCondition)(rc))
+}
+
+// Writes the condition leaves as plain strings
+func (rc RuleSchemaCondition) MarshalYAML() (interface{}, error) {
+	if rc.Matcher != "" && rc.And == nil && rc.Or == nil && rc.Not == nil {
+		return rc.Matcher, nil
+	}
+	type plainRuleSchemaCondition RuleSchemaCondition
+	return plainRuleSchemaCondition(rc), nil
+}
+
+// Holds the conditions of the phases
+type RuleSchemaConditions struct {
+	Request   *RuleSchemaCondition `yaml:"request,omitempty"`   //The condition of the request matchers
+	Response  *RuleSchemaCondition `yaml:"response,omitempty"`  //The condition of the response matchers
+	Websocket *RuleSchemaCondition `yaml:"websocket,omitempty"` //The condition of the websocket matchers
+}
+
+// Holds the websocket message of a rule test
+type RuleSchemaTestWebsocket struct {
+	MessageType int    `yaml:"message_type,omitempty"` //The type of the websocket message
+	Direction   string `yaml:"direction,omitempty"`    //The direction of the message
+	Message     string `yaml:"message,omitempty"`      //The content of the message
+}
+
+// Holds a sample of traffic the rule is tested on by the agent
+type RuleSchemaTest struct {
+	Name      string                   `yaml:"name,omitempty"`      //The name of the test
+	Request   string                   `yaml:"request,omitempty"`   //The raw HTTP request
+	Response  string                   `yaml:"response,omitempty"`  //The raw HTTP response
+	Latency   int64                    `yaml:"latency,omitempty"`   //The time the web server took to respond in milliseconds
+	Websocket *RuleSchemaTestWebsocket `yaml:"websocket,omitempty"` //The websocket message
+	Expect    string                   `yaml:"expect,omitempty"`    //The expected result (match or no-match)
+}
+
+// Holds a rule
+type RuleSchema struct {
+	Id                string                        `yaml:"id,omitempty"`                 //The id of the rule
+	Info              *RuleSchemaInfo               `yaml:"info,omitempty"`               //The info structure
+	Scope             *RuleSchemaScope              `yaml:"scope,omitempty"`              //The requests the rule applies to
+	Request           *RuleSchemaRequest            `yaml:"request,omitempty"`            //The request matchers
+	Response          *RuleSchemaResponse           `yaml:"response,omitempty"`           //The response matchers
+	Websocket         []*RuleSchemaWebsocketMatcher `yaml:"websocket,omitempty"`          //The websocket matchers
+	Correlation       *RuleSchemaCorrelation        `yaml:"correlation,omitempty"`        //The correlation of the requests of the same client
+	MatchersCondition string                        `yaml:"matchers-condition,omitempty"` //How the matchers of a phase are combined (or, and)
+	Condition         *RuleSchemaConditions         `yaml:"condition,omitempty"`          //The boolean composition of the matchers of every phase
+	Tests             []*RuleSchemaTest             `yaml:"tests,omitempty"`              //The samples of traffic the rule is tested on
+}
@@ -29,6 +29,35 @@ func NewCassandraConnection(logger logging.ILogger, configuration config.Configu
 	return &CassandraConnection{logger: logger, configuration: configuration}
 }
 
+// Adds the columns which are missing from a table (the tables created by the older versions of the api)
+// @param table - the name of the table
+// @param columns - the definitions of the columns (name TYPE)
+// Returns an error if the columns of the table cannot be read or a column cannot be added
+func (cassandra *CassandraConnection) addMissingColumns(table string, columns []string) error {
+	//Get the columns the table already has
+	existingColumns := make(map[string]bool)
+	iter := cassandra.session.Query("SELECT column_name FROM system_schema.columns WHERE keyspace_name = ? AND table_name = ?", cassandra.configuration.CassandraKeyspace, table).Iter()
+	var columnName string
+	for iter.Scan(&columnName) {
+		existingColumns[columnName] = true
+	}
+	if err := iter.Close(); err != nil {
+		return err
+	}
+
+	for _, column := range columns {
+		name := strings.Fields(column)[0]
+		if existingColumns[name] {
+			continue
+		}
+		err := cassandra.session.Query("ALTER TABLE " + cassandra.configuration.CassandraKeyspace + "." + table + " ADD " + column).Exec()
+		if err != nil {
+			return errors.New("cannot add column " + name + ", " + err.Error())
+		}
+	}
+	return nil
+}
+
 // Creates the tables needed in the cassandra database (keyspace)
 func (cassandra *CassandraConnection) createTables() error {
 	// _ = cassandra.session.Query("DROP TABLE api.users").Exec()
@@ -74,12 +103,24 @@ func (cassandra *CassandraConnection) createTables() error {
 	}
 
 	//Create the rule findings table which will hold all the rule based findings of a log
-	err = cassandra.session.Query("CREATE TABLE IF NOT EXISTS " + cassandra.configuration.CassandraKeyspace + ".rulefindings (id TEXT, log_id TEXT, rule_id TEXT, rule_name TEXT, rule_description TEXT, line INT, line_index INT, length INT, matched_string TEXT, matched_hash TEXT, matched_hash_alg TEXT, classification TEXT, severity INT, finding_type INT, PRIMARY KEY (id, log_id))").Exec()
+	err = cassandra.session.Query("CREATE TABLE IF NOT EXISTS " + cassandra.configuration.CassandraKeyspace + ".rulefindings (id TEXT, log_id TEXT, rule_id TEXT, rule_name TEXT, rule_description TEXT, line INT, line_index INT, length INT, matched_string TEXT, matched_hash TEXT, matched_hash_alg TEXT, classification TEXT, severity INT, finding_type INT, tags LIST<TEXT>, rule_references LIST<TEXT>, cwe_ids LIST<TEXT>, cvss_score DOUBLE, cvss_metrics TEXT, mitre_techniques LIST<TEXT>, start_offset INT, end_offset INT, part TEXT, part_name TEXT, part_source TEXT, matched_path TEXT, decoding_chain LIST<TEXT>, PRIMARY KEY (id, log_id))").Exec()
 	//Check if an error occured when creating the rules findings table
 	if err != nil {
 		return errors.New("cannot create rules findings table, " + err.Error())
 	}
 
+	//Add the rule metadata columns to the rule findings table created by the older versions
+	err = cassandra.addMissingColumns("rulefindings", []string{"tags LIST<TEXT>", "rule_references LIST<TEXT>", "cwe_ids LIST<TEXT>", "cvss_score DOUBLE", "cvss_metrics TEXT", "mitre_techniques LIST<TEXT>"})
+	if err != nil {
+		return errors.New("cannot add the rule metadata columns to the rules findings table, " + err.Error())
+	}
+
+	//Add the match location columns to the rule findings table created by the older versions
+	err = cassandra.addMissingColumns("rulefindings", []string{"start_offset INT", "end_offset INT", "part TEXT", "part_name TEXT", "part_source TEXT", "matched_path TEXT", "decoding_chain LIST<TEXT>"})
+	if err != nil {
+		return errors.New("cannot add the match location columns to the rules findings table, " + err.Error())
+	}
+
 	//Create the exploitcodes table which will hold the exploit code of a log
 	err = cassandra.session.Query("CREATE TABLE IF NOT EXISTS " + cassandra.configuration.CassandraKeyspace + ".exploitcodes (id TEXT, log_id TEXT, exploit_code TEXT, PRIMARY KEY (id, log_id))").Exec()
 	//Check if an error occured when creating the rules findings table
@@ -87,6 +128,34 @@ func (cassandra *CassandraConnection) createTables() error {
 		return errors.New("cannot create rules findings table, " + err.Error())
 	}
 
+	//Create the rules table which will hold the rules of the central rule repository
+	err = cassandra.session.Query("CREATE TABLE IF NOT EXISTS " + cassandra.configuration.CassandraKeyspace + ".rules (id TEXT PRIMARY KEY, content TEXT, version BIGINT, updated TIMESTAMP)").Exec()
+	//Check if an error occured when creating the rules table
+	if err != nil {
+		return errors.New("cannot create rules table, " + err.Error())
+	}
+
+	//Create the rulesets table which will hold the version of the rule set (changed on every modification of the rules)
+	err = cassandra.session.Query("CREATE TABLE IF NOT EXISTS " + cassandra.configuration.CassandraKeyspace + ".rulesets (name TEXT PRIMARY KEY, version BIGINT, updated TIMESTAMP)").Exec()
+	//Check if an error occured when creating the rulesets table
+	if err != nil {
+		return errors.New("cannot create rulesets table, " + err.Error())
+	}
+
+	//Create the agentrules table which will hold the version of the rule set each agent is running
+	err = cassandra.session.Query("CREATE TABLE IF NOT EXISTS " + cassandra.configuration.CassandraKeyspace + ".agentrules (agent_id TEXT PRIMARY KEY, version BIGINT, status TEXT, error TEXT, rules_count BIGINT, updated TIMESTAMP)").Exec()
+	//Check if an error occured when creating the agentrules table
+	if err != nil {
+		return errors.New("cannot create agent rules table, " + err.Error())
+	}
+
+	//Create the rulestatistics table which will hold the performance and hit counters of the rules reported by each agent
+	err = cassandra.session.Query("CREATE TABLE IF NOT EXISTS " + cassandra.configuration.CassandraKeyspace + ".rulestatistics (agent_id TEXT, rule_id TEXT, evaluations BIGINT, matches BIGINT, total_time BIGINT, p99_time BIGINT, max_time BIGINT, slow_searches BIGINT, last_match TIMESTAMP, first_seen TIMESTAMP, since TIMESTAMP, updated TIMESTAMP, PRIMARY KEY (agent_id, rule_id))").Exec()
+	//Check if an error occured when creating the rulestatistics table
+	if err != nil {
+		return errors.New("cannot create rule statistics table, " + err.Error())
+	}
+
 	// //Create the index for the agent id in the logs table
 	// err = cassandra.session.Query("CREATE INDEX IF NOT EXISTS logs_agent_index ON " + cassandra.configuration.CassandraKeyspace + ".logs(agent_id)").Exec()
 	// if err != nil {
@@ -288,7 +357,7 @@ func (cassandra *CassandraConnection) InsertRuleFindings(log_id string, ruleFind
 				cassandra.logger.Warning("could not save the request finding - uuid generation failed")
 			} else {
 				//Insert the request finding
-				err := cassandra.session.Query("INSERT INTO "+cassandra.configuration.CassandraKeyspace+".rulefindings (id, log_id, line, line_index, length, matched_string, matched_hash, matched_hash_alg, classification, severity, rule_id, rule_name, rule_description, finding_type) VALUES (?,?,?,?,?,?,?,?,?,?,?,?,?,?)", id_request, log_id, ruleFinding.Request.Line, ruleFinding.Request.LineIndex, ruleFinding.Request.Length, ruleFinding.Request.MatchedString, ruleFinding.Request.MatchedBodyHash, ruleFinding.Request.MatchedBodyHashAlg, ruleFinding.Request.Classification, ruleFinding.Request.Severity, ruleFinding.Request.RuleId, ruleFinding.Request.RuleName, ruleFinding.Request.RuleDescription, 0).Exec()
+				err := cassandra.session.Query("INSERT INTO "+cassandra.configuration.CassandraKeyspace+".rulefindings (id, log_id, line, line_index, length, matched_string, matched_hash, matched_hash_alg, classification, severity, rule_id, rule_name, rule_description, finding_type, tags, rule_references, cwe_ids, cvss_score, cvss_metrics, mitre_techniques, start_offset, end_offset, part, part_name, part_source, matched_path, decoding_chain) VALUES (?,?,?,?,?,?,?,?,?,?,?,?,?,?,?,?,?,?,?,?,?,?,?,?,?,?,?)", id_request, log_id, ruleFinding.Request.Line, ruleFinding.Request.LineIndex, ruleFinding.Request.Length, ruleFinding.Request.MatchedString, ruleFinding.Request.MatchedBodyHash, ruleFinding.Request.MatchedBodyHashAlg, ruleFinding.Request.Classification, ruleFinding.Request.Severity, ruleFinding.Request.RuleId, ruleFinding.Request.RuleName, ruleFinding.Request.RuleDescription, 0, ruleFinding.Request.Tags, ruleFinding.Request.References, ruleFinding.Request.CWEIds, ruleFinding.Request.CVSSScore, ruleFinding.Request.CVSSMetrics, ruleFinding.Request.MitreTechniques, ruleFinding.Request.StartOffset, ruleFinding.Request.EndOffset, ruleFinding.Request.Part, ruleFinding.Request.PartName, ruleFinding.Request.PartSource, ruleFinding.Request.MatchedPath, ruleFinding.Request.DecodingChain).Exec()
 				if err != nil {
 					cassandra.logger.Error("Could not insert the request rule findings in the database", err.Error())
 				}
@@ -303,7 +372,7 @@ func (cassandra *CassandraConnection) InsertRuleFindings(log_id string, ruleFind
 				cassandra.logger.Warning("could not save the request finding - uuid generation failed")
 			} else {
 				//Insert the request finding
-				err := cassandra.session.Query("INSERT INTO "+cassandra.configuration.CassandraKeyspace+".rulefindings (id, log_id, line, line_index, length, matched_string, matched_hash, matched_hash_alg, classification, severity, rule_id, rule_name, rule_description, finding_type) VALUES (?,?,?,?,?,?,?,?,?,?,?,?,?,?)", id_response, log_id, ruleFinding.Response.Line, ruleFinding.Response.LineIndex, ruleFinding.Response.Length, ruleFinding.Response.MatchedString, ruleFinding.Response.MatchedBodyHash, ruleFinding.Response.MatchedBodyHashAlg, ruleFinding.Response.Classification, ruleFinding.Response.Severity, ruleFinding.Response.RuleId, ruleFinding.Response.RuleName, ruleFinding.Response.RuleDescription, 0).Exec()
+				err := cassandra.session.Query("INSERT INTO "+cassandra.configuration.CassandraKeyspace+".rulefindings (id, log_id, line, line_index, length, matched_string, matched_hash, matched_hash_alg, classification, severity, rule_id, rule_name, rule_description, finding_type, tags, rule_references, cwe_ids, cvss_score, cvss_metrics, mitre_techniques, start_offset, end_offset, part, part_name, part_source, matched_path, decoding_chain) VALUES (?,?,?,?,?,?,?,?,?,?,?,?,?,?,?,?,?,?,?,?,?,?,?,?,?,?,?)", id_response, log_id, ruleFinding.Response.Line, ruleFinding.Response.LineIndex, ruleFinding.Response.Length, ruleFinding.Response.MatchedString, ruleFinding.Response.MatchedBodyHash, ruleFinding.Response.MatchedBodyHashAlg, ruleFinding.Response.Classification, ruleFinding.Response.Severity, ruleFinding.Response.RuleId, ruleFinding.Response.RuleName, ruleFinding.Response.RuleDescription, 1, ruleFinding.Response.Tags, ruleFinding.Response.References, ruleFinding.Response.CWEIds, ruleFinding.Response.CVSSScore, ruleFinding.Response.CVSSMetrics, ruleFinding.Response.MitreTechniques, ruleFinding.Response.StartOffset, ruleFinding.Response.EndOffset, ruleFinding.Response.Part, ruleFinding.Response.PartName, ruleFinding.Response.PartSource, ruleFinding.Response.MatchedPath, ruleFinding.Response.DecodingChain).Exec()
 				if err != nil {
 					cassandra.logger.Error("Could not insert the request rule findings in the database", err.Error())
 				}
@@ -614,12 +683,12 @@ func (cassandra *CassandraConnection) GetLogRuleFindings(log_id string) ([]data.
 	//cassandra.logger.Debug("Necessary structures rule findings", necessary_structures)
 
 	//Prepare the query to select all the findings on the request of a specific log
-	query := cassandra.session.Query("SELECT id, log_id, line, line_index, length, matched_string, classification, severity, rule_id, rule_name, rule_description, matched_hash, matched_hash_alg FROM "+cassandra.configuration.CassandraKeyspace+".rulefindings WHERE log_id = ? AND finding_type = 0 ALLOW FILTERING", log_id)
+	query := cassandra.session.Query("SELECT id, log_id, line, line_index, length, matched_string, classification, severity, rule_id, rule_name, rule_description, matched_hash, matched_hash_alg, tags, rule_references, cwe_ids, cvss_score, cvss_metrics, mitre_techniques, start_offset, end_offset, part, part_name, part_source, matched_path, decoding_chain FROM "+cassandra.configuration.CassandraKeyspace+".rulefindings WHERE log_id = ? AND finding_type = 0 ALLOW FILTERING", log_id)
 	findings := make([]data.RuleFindingDatabase, necessary_structures)
 	findingRequest := data.RuleFindingDataDatabase{}
 	iter := query.Iter()
 	var index int64 = 0
-	for iter.Scan(&findingRequest.Id, &findingRequest.LogId, &findingRequest.Line, &findingRequest.LineIndex, &findingRequest.Length, &findingRequest.MatchedString, &findingRequest.Classification, &findingRequest.Severity, &findingRequest.RuleId, &findingRequest.RuleName, &findingRequest.RuleDescription, &findingRequest.MatchedBodyHash, &findingRequest.MatchedBodyHashAlg) {
+	for iter.Scan(&findingRequest.Id, &findingRequest.LogId, &findingRequest.Line, &findingRequest.LineIndex, &findingRequest.Length, &findingRequest.MatchedString, &findingRequest.Classification, &findingRequest.Severity, &findingRequest.RuleId, &findingRequest.RuleName, &findingRequest.RuleDescription, &findingRequest.MatchedBodyHash, &findingRequest.MatchedBodyHashAlg, &findingRequest.Tags, &findingRequest.References, &findingRequest.CWEIds, &findingRequest.CVSSScore, &findingRequest.CVSSMetrics, &findingRequest.MitreTechniques, &findingRequest.StartOffset, &findingRequest.EndOffset, &findingRequest.Part, &findingRequest.PartName, &findingRequest.PartSource, &findingRequest.MatchedPath, &findingRequest.DecodingChain) {
 		//cassandra.logger.Debug(findingRequest)
 		aux := findingRequest
 		findings[index].Request = &aux
@@ -627,11 +696,11 @@ func (cassandra *CassandraConnection) GetLogRuleFindings(log_id string) ([]data.
 	}
 
 	//Prepare the query to select all the findings on the response of a specific log
-	query = cassandra.session.Query("SELECT id, log_id, line, line_index, length, matched_string, classification, severity, rule_id, rule_name, rule_description, matched_hash, matched_hash_alg FROM "+cassandra.configuration.CassandraKeyspace+".rulefindings WHERE log_id = ? AND finding_type = 1 ALLOW FILTERING", log_id)
+	query = cassandra.session.Query("SELECT id, log_id, line, line_index, length, matched_string, classification, severity, rule_id, rule_name, rule_description, matched_hash, matched_hash_alg, tags, rule_references, cwe_ids, cvss_score, cvss_metrics, mitre_techniques, start_offset, end_offset, part, part_name, part_source, matched_path, decoding_chain FROM "+cassandra.configuration.CassandraKeyspace+".rulefindings WHERE log_id = ? AND finding_type = 1 ALLOW FILTERING", log_id)
 	findingResponse := data.RuleFindingDataDatabase{}
 	iter = query.Iter()
 	index = 0
-	for iter.Scan(&findingResponse.Id, &findingResponse.LogId, &findingResponse.Line, &findingResponse.LineIndex, &findingResponse.Length, &findingResponse.MatchedString, &findingResponse.Classification, &findingResponse.Severity, &findingResponse.RuleId, &findingResponse.RuleName, &findingResponse.RuleDescription, &findingResponse.MatchedBodyHash, &findingResponse.MatchedBodyHashAlg) {
+	for iter.Scan(&findingResponse.Id, &findingResponse.LogId, &findingResponse.Line, &findingResponse.LineIndex, &findingResponse.Length, &findingResponse.MatchedString, &findingResponse.Classification, &findingResponse.Severity, &findingResponse.RuleId, &findingResponse.RuleName, &findingResponse.RuleDescription, &findingResponse.MatchedBodyHash, &findingResponse.MatchedBodyHashAlg, &findingResponse.Tags, &findingResponse.References, &findingResponse.CWEIds, &findingResponse.CVSSScore, &findingResponse.CVSSMetrics, &findingResponse.MitreTechniques, &findingResponse.StartOffset, &findingResponse.EndOffset, &findingResponse.Part, &findingResponse.PartName, &findingResponse.PartSource, &findingResponse.MatchedPath, &findingResponse.DecodingChain) {
 		aux := findingResponse
 		findings[index].Response = &aux
 		index += 1
@@ -918,3 +987,171 @@ func (cassandra *CassandraConnection) CheckExploitCodeExists(log_uuid string) (b
 	//Return the result
 	return countLogExploits > 0, nil
 }
+
+// The name of the rule set in the rulesets table
+const defaultRuleSetName = "default"
+
+// Get the version of the rule set (0 if the rules were never modified)
+func (cassandra *CassandraConnection) GetRuleSetVersion() (int64, error) {
+	query := cassandra.session.Query("SELECT version FROM "+cassandra.configuration.CassandraKeyspace+".rulesets WHERE name = ?", defaultRuleSetName)
+	var version int64 = 0
+	iter := query.Iter()
+	iter.Scan(&version)
+	err := iter.Close()
+	if err != nil {
+		return 0, errors.New("could not get the version of the rule set, " + err.Error())
+	}
+	return version, nil
+}
+
+// Change the version of the rule set if it was not changed since it was read (lightweight transaction)
+// @param current - the version of the rule set which was read (0 if the rule set does not have a version)
+// @param version - the new version of the rule set
+// Returns true if the version was changed, false if the rule set was changed concurrently
+func (cassandra *CassandraConnection) UpdateRuleSetVersion(current int64, version int64) (bool, error) {
+	var query *gocql.Query
+	if current == 0 {
+		query = cassandra.session.Query("INSERT INTO "+cassandra.configuration.CassandraKeyspace+".rulesets (name, version, updated) VALUES (?,?,?) IF NOT EXISTS", defaultRuleSetName, version, time.Now())
+	} else {
+		query = cassandra.session.Query("UPDATE "+cassandra.configuration.CassandraKeyspace+".rulesets SET version = ?, updated = ? WHERE name = ? IF version = ?", version, time.Now(), defaultRuleSetName, current)
+	}
+	applied, err := query.MapScanCAS(make(map[string]interface{}))
+	if err != nil {
+		return false, errors.New("could not update the version of the rule set, " + err.Error())
+	}
+	return applied, nil
+}
+
+// Get all the rules of the central rule repository
+func (cassandra *CassandraConnection) GetRules() ([]data.Rule, error) {
+	query := cassandra.session.Query("SELECT id, content, version, updated FROM " + cassandra.configuration.CassandraKeyspace + ".rules")
+	rules := make([]data.Rule, 0)
+	rule := data.Rule{}
+	var updated time.Time
+	iter := query.Iter()
+	for iter.Scan(&rule.Id, &rule.Content, &rule.Version, &updated) {
+		rule.Updated = updated.Unix()
+		rules = append(rules, rule)
+	}
+	err := iter.Close()
+	if err != nil {
+		return nil, errors.New("could not get the rules, " + err.Error())
+	}
+	return rules, nil
+}
+
+// Get a single rule from the central rule repository
+func (cassandra *CassandraConnection) GetRule(id string) (data.Rule, error) {
+	query := cassandra.session.Query("SELECT id, content, version, updated FROM "+cassandra.configuration.CassandraKeyspace+".rules WHERE id = ?", id)
+	rule := data.Rule{}
+	var updated time.Time
+	res := query.Iter().Scan(&rule.Id, &rule.Content, &rule.Version, &updated)
+	if !res {
+		return rule, errors.New("rule does not exist")
+	}
+	rule.Updated = updated.Unix()
+	return rule, nil
+}
+
+// Insert or replace a rule in the central rule repository
+func (cassandra *CassandraConnection) SaveRule(rule data.Rule) error {
+	err := cassandra.session.Query("INSERT INTO "+cassandra.configuration.CassandraKeyspace+".rules (id, content, version, updated) VALUES (?,?,?,?)", rule.Id, rule.Content, rule.Version, time.Unix(rule.Updated, 0)).Exec()
+	if err != nil {
+		return errors.New("could not save rule " + rule.Id + ", " + err.Error())
+	}
+	return nil
+}
+
+// Delete a rule from the central rule repository
+func (cassandra *CassandraConnection) DeleteRule(id string) error {
+	err := cassandra.session.Query("DELETE FROM "+cassandra.configuration.CassandraKeyspace+".rules WHERE id = ?", id).Exec()
+	return err
+}
+
+// Save the version of the rule set the agent acknowledged
+func (cassandra *CassandraConnection) SaveAgentRuleVersion(agentRuleVersion data.AgentRuleVersion) error {
+	err := cassandra.session.Query("INSERT INTO "+cassandra.configuration.CassandraKeyspace+".agentrules (agent_id, version, status, error, rules_count, updated) VALUES (?,?,?,?,?,?)", agentRuleVersion.AgentId, agentRuleVersion.Version, agentRuleVersion.Status, agentRuleVersion.Error, agentRuleVersion.RulesCount, time.Unix(agentRuleVersion.Updated, 0)).Exec()
+	if err != nil {
+		return errors.New("could not save the rule set version of agent " + agentRuleVersion.AgentId + ", " + err.Error())
+	}
+	return nil
+}
+
+// Get the versions of the rule set the agents acknowledged
+func (cassandra *CassandraConnection) GetAgentsRuleVersions() ([]data.AgentRuleVersion, error) {
+	query := cassandra.session.Query("SELECT agent_id, version, status, error, rules_count, updated FROM " + cassandra.configuration.CassandraKeyspace + ".agentrules")
+	agentRuleVersions := make([]data.AgentRuleVersion, 0)
+	agentRuleVersion := data.AgentRuleVersion{}
+	var updated time.Time
+	iter := query.Iter()
+	for iter.Scan(&agentRuleVersion.AgentId, &agentRuleVersion.Version, &agentRuleVersion.Status, &agentRuleVersion.Error, &agentRuleVersion.RulesCount, &updated) {
+		agentRuleVersion.Updated = updated.Unix()
+		agentRuleVersions = append(agentRuleVersions, agentRuleVersion)
+	}
+	err := iter.Close()
+	if err != nil {
+		return nil, errors.New("could not get the rule set versions of the agents, " + err.Error())
+	}
+	return agentRuleVersions, nil
+}
+
+// Save the counters of the rules reported by the agent
+// The last match and the first report of a rule are kept between the reports (the agent counters are reset when it restarts)
+// The rules which are no longer loaded by the agent are removed
+func (cassandra *CassandraConnection) SaveRuleStatistics(agentId string, since int64, timestamp int64, statistics []data.RuleStatistics) error {
+	previousStatistics, err := cassandra.GetAgentRuleStatistics(agentId)
+	if err != nil {
+		return err
+	}
+	previous := make(map[string]data.RuleStatistics, len(previousStatistics))
+	for _, ruleStatistics := range previousStatistics {
+		previous[ruleStatistics.RuleId] = ruleStatistics
+	}
+
+	reported := make(map[string]bool, len(statistics))
+	for _, ruleStatistics := range statistics {
+		reported[ruleStatistics.RuleId] = true
+		firstSeen := timestamp
+		lastMatch := ruleStatistics.LastMatch
+		if previousRule, found := previous[ruleStatistics.RuleId]; found {
+			firstSeen = previousRule.FirstSeen
+			lastMatch = max(lastMatch, previousRule.LastMatch)
+		}
+		err := cassandra.session.Query("INSERT INTO "+cassandra.configuration.CassandraKeyspace+".rulestatistics (agent_id, rule_id, evaluations, matches, total_time, p99_time, max_time, slow_searches, last_match, first_seen, since, updated) VALUES (?,?,?,?,?,?,?,?,?,?,?,?)", agentId, ruleStatistics.RuleId, ruleStatistics.Evaluations, ruleStatistics.Matches, ruleStatistics.TotalTime, ruleStatistics.P99Time, ruleStatistics.MaxTime, ruleStatistics.SlowSearches, time.Unix(lastMatch, 0), time.Unix(firstSeen, 0), time.Unix(since, 0), time.Unix(timestamp, 0)).Exec()
+		if err != nil {
+			return errors.New("could not save the statistics of rule " + ruleStatistics.RuleId + " for agent " + agentId + ", " + err.Error())
+		}
+	}
+
+	for ruleId := range previous {
+		if reported[ruleId] {
+			continue
+		}
+		err := cassandra.session.Query("DELETE FROM "+cassandra.configuration.CassandraKeyspace+".rulestatistics WHERE agent_id = ? AND rule_id = ?", agentId, ruleId).Exec()
+		if err != nil {
+			return errors.New("could not delete the statistics of rule " + ruleId + " for agent " + agentId + ", " + err.Error())
+		}
+	}
+	return nil
+}
+
+// Get the counters of the rules reported by the agent
+func (cassandra *CassandraConnection) GetAgentRuleStatistics(agentId string) ([]data.RuleStatistics, error) {
+	query := cassandra.session.Query("SELECT agent_id, rule_id, evaluations, matches, total_time, p99_time, max_time, slow_searches, last_match, first_seen, since, updated FROM "+cassandra.configuration.CassandraKeyspace+".rulestatistics WHERE agent_id = ?", agentId)
+	statistics := make([]data.RuleStatistics, 0)
+	ruleStatistics := data.RuleStatistics{}
+	var lastMatch, firstSeen, since, updated time.Time
+	iter := query.Iter()
+	for iter.Scan(&ruleStatistics.AgentId, &ruleStatistics.RuleId, &ruleStatistics.Evaluations, &ruleStatistics.Matches, &ruleStatistics.TotalTime, &ruleStatistics.P99Time, &ruleStatistics.MaxTime, &ruleStatistics.SlowSearches, &lastMatch, &firstSeen, &since, &updated) {
+		ruleStatistics.LastMatch = lastMatch.Unix()
+		ruleStatistics.FirstSeen = firstSeen.Unix()
+		ruleStatistics.Since = since.Unix()
+		ruleStatistics.Updated = updated.Unix()
+		statistics = append(statistics, ruleStatistics)
+	}
+	err := iter.Close()
+	if err != nil {
+		return nil, errors.New("could not get the rule statistics of agent " + agentId + ", " + err.Error())
+	}
+	return statistics, nil
+}
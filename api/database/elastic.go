@@ -8,6 +8,7 @@ import (
 	"fmt"
 	"io"
 	"net/http"
+	"sort"
 	"strings"
 
 	"github.com/elastic/go-elasticsearch/v8"
@@ -26,12 +27,52 @@ func NewElasticConnection(logger logging.ILogger, configuration config.Configura
 	return &ElasticConnection{logger: logger, configuration: configuration}
 }
 
+// Gets the mapping of the match location fields of the rule findings
+// The strings are mapped like the dynamic mapping (text with a keyword field) so the mapping can be added to the existing indices
+func getRuleFindingsLocationMapping() string {
+	location := `{
+		"properties": {
+			"startOffset": { "type": "long" },
+			"endOffset": { "type": "long" },
+			"part": { "type": "text", "fields": { "keyword": { "type": "keyword", "ignore_above": 256 } } },
+			"partName": { "type": "text", "fields": { "keyword": { "type": "keyword", "ignore_above": 256 } } },
+			"partSource": { "type": "text", "fields": { "keyword": { "type": "keyword", "ignore_above": 256 } } },
+			"matchedPath": { "type": "text", "fields": { "keyword": { "type": "keyword", "ignore_above": 256 } } },
+			"decodingChain": { "type": "text", "fields": { "keyword": { "type": "keyword", "ignore_above": 256 } } }
+		}
+	}`
+	return fmt.Sprintf(`
+	{
+		"properties": {
+			"ruleFindings": {
+				"properties": {
+					"request": %s,
+					"response": %s
+				}
+			}
+		}
+	}
+	`, location, location)
+}
+
 func (elastic *ElasticConnection) create() error {
 	//elastic.connection.Indices.Delete([]string{"logs"})
 	//Create the index where the documents will be stored
 	_, err := elastic.connection.Indices.Create(elastic.configuration.ElasticIndex)
-	//Return the error
-	return err
+	if err != nil {
+		return err
+	}
+
+	//Add the mapping of the match location fields (the index can be created by an older version of the api)
+	res, err := elastic.connection.Indices.PutMapping([]string{elastic.configuration.ElasticIndex}, strings.NewReader(getRuleFindingsLocationMapping()))
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	if res.IsError() {
+		elastic.logger.Warning("Could not add the mapping of the rule findings location fields to the index,", res.Status())
+	}
+	return nil
 }
 
 func (elastic *ElasticConnection) Init() error {
@@ -155,7 +196,7 @@ func (elastic *ElasticConnection) GetRecentLogs() []data.LogDataElastic {
 		//Create the response preview
 		response_preview := strings.Split(hit.Source.Response, "\n")[0]
 		//Create the rule findings structure
-		returnData = append(returnData, data.LogDataElastic{Id: hit.Source.Id, AgentId: hit.Source.AgentId, RemoteIP: hit.Source.RemoteIP, Websocket: hit.Source.Websocket, Timestamp: hit.Source.Timestamp, RequestPreview: request_preview, ResponsePreview: response_preview, Findings: hit.Source.Findings, RuleFindings: hit.Source.RuleFindings})
+		returnData = append(returnData, data.LogDataElastic{Id: hit.Source.Id, AgentId: hit.Source.AgentId, RemoteIP: hit.Source.RemoteIP, Websocket: hit.Source.Websocket, Direction: hit.Source.Direction, Timestamp: hit.Source.Timestamp, RequestPreview: request_preview, ResponsePreview: response_preview, Findings: hit.Source.Findings, RuleFindings: hit.Source.RuleFindings})
 	}
 
 	elastic.logger.Debug(len(response.Hits.Hits))
@@ -211,7 +252,7 @@ func (elastic *ElasticConnection) GetRecentRuleClassifiedLogs() []data.LogDataEl
 		//Create the response preview
 		response_preview := strings.Split(hit.Source.Response, "\n")[0]
 		//Create the rule findings structure
-		returnData = append(returnData, data.LogDataElastic{Id: hit.Source.Id, AgentId: hit.Source.AgentId, RemoteIP: hit.Source.RemoteIP, Timestamp: hit.Source.Timestamp, Websocket: hit.Source.Websocket, RequestPreview: request_preview, ResponsePreview: response_preview, Findings: hit.Source.Findings, RuleFindings: hit.Source.RuleFindings})
+		returnData = append(returnData, data.LogDataElastic{Id: hit.Source.Id, AgentId: hit.Source.AgentId, RemoteIP: hit.Source.RemoteIP, Timestamp: hit.Source.Timestamp, Websocket: hit.Source.Websocket, Direction: hit.Source.Direction, RequestPreview: request_preview, ResponsePreview: response_preview, Findings: hit.Source.Findings, RuleFindings: hit.Source.RuleFindings})
 	}
 
 	elastic.logger.Debug(len(response.Hits.Hits))
@@ -261,7 +302,7 @@ func (elastic *ElasticConnection) GetAllLogs() []data.LogDataElastic {
 		//Create the response preview
 		response_preview := strings.Split(hit.Source.Response, "\n")[0]
 		//Create the rule findings structure
-		returnData = append(returnData, data.LogDataElastic{Id: hit.Source.Id, AgentId: hit.Source.AgentId, RemoteIP: hit.Source.RemoteIP, Timestamp: hit.Source.Timestamp, Websocket: hit.Source.Websocket, RequestPreview: request_preview, ResponsePreview: response_preview, Findings: hit.Source.Findings, RuleFindings: hit.Source.RuleFindings})
+		returnData = append(returnData, data.LogDataElastic{Id: hit.Source.Id, AgentId: hit.Source.AgentId, RemoteIP: hit.Source.RemoteIP, Timestamp: hit.Source.Timestamp, Websocket: hit.Source.Websocket, Direction: hit.Source.Direction, RequestPreview: request_preview, ResponsePreview: response_preview, Findings: hit.Source.Findings, RuleFindings: hit.Source.RuleFindings})
 	}
 
 	elastic.logger.Debug(len(response.Hits.Hits))
@@ -320,7 +361,7 @@ func (elastic *ElasticConnection) GetAllClassifiedLogs() []data.LogDataElastic {
 		//Create the response preview
 		response_preview := strings.Split(hit.Source.Response, "\n")[0]
 		//Create the rule findings structure
-		returnData = append(returnData, data.LogDataElastic{Id: hit.Source.Id, AgentId: hit.Source.AgentId, RemoteIP: hit.Source.RemoteIP, Timestamp: hit.Source.Timestamp, Websocket: hit.Source.Websocket, RequestPreview: request_preview, ResponsePreview: response_preview, Findings: hit.Source.Findings, RuleFindings: hit.Source.RuleFindings})
+		returnData = append(returnData, data.LogDataElastic{Id: hit.Source.Id, AgentId: hit.Source.AgentId, RemoteIP: hit.Source.RemoteIP, Timestamp: hit.Source.Timestamp, Websocket: hit.Source.Websocket, Direction: hit.Source.Direction, RequestPreview: request_preview, ResponsePreview: response_preview, Findings: hit.Source.Findings, RuleFindings: hit.Source.RuleFindings})
 	}
 
 	elastic.logger.Debug(len(response.Hits.Hits))
@@ -372,7 +413,7 @@ func (elastic *ElasticConnection) GetLogsPaginated(agentId string) []data.LogDat
 		//Create the response preview
 		response_preview := strings.Split(hit.Source.Response, "\n")[0]
 		//Create the rule findings structure
-		returnData = append(returnData, data.LogDataElastic{Id: hit.Source.Id, AgentId: hit.Source.AgentId, RemoteIP: hit.Source.RemoteIP, Timestamp: hit.Source.Timestamp, Websocket: hit.Source.Websocket, RequestPreview: request_preview, ResponsePreview: response_preview, Findings: hit.Source.Findings, RuleFindings: hit.Source.RuleFindings})
+		returnData = append(returnData, data.LogDataElastic{Id: hit.Source.Id, AgentId: hit.Source.AgentId, RemoteIP: hit.Source.RemoteIP, Timestamp: hit.Source.Timestamp, Websocket: hit.Source.Websocket, Direction: hit.Source.Direction, RequestPreview: request_preview, ResponsePreview: response_preview, Findings: hit.Source.Findings, RuleFindings: hit.Source.RuleFindings})
 	}
 
 	elastic.logger.Debug(len(response.Hits.Hits))
@@ -421,7 +462,7 @@ func (elastic *ElasticConnection) GetAllAgentLogs(agentId string) []data.LogData
 		//Create the response preview
 		response_preview := strings.Split(hit.Source.Response, "\n")[0]
 		//Create the rule findings structure
-		returnData = append(returnData, data.LogDataElastic{Id: hit.Source.Id, AgentId: hit.Source.AgentId, RemoteIP: hit.Source.RemoteIP, Timestamp: hit.Source.Timestamp, Websocket: hit.Source.Websocket, RequestPreview: request_preview, ResponsePreview: response_preview, Findings: hit.Source.Findings, RuleFindings: hit.Source.RuleFindings})
+		returnData = append(returnData, data.LogDataElastic{Id: hit.Source.Id, AgentId: hit.Source.AgentId, RemoteIP: hit.Source.RemoteIP, Timestamp: hit.Source.Timestamp, Websocket: hit.Source.Websocket, Direction: hit.Source.Direction, RequestPreview: request_preview, ResponsePreview: response_preview, Findings: hit.Source.Findings, RuleFindings: hit.Source.RuleFindings})
 	}
 
 	elastic.logger.Debug(len(response.Hits.Hits))
@@ -642,3 +683,116 @@ func (elastic *ElasticConnection) GetRuleFindingsCount() (int64, error) {
 	err = rce.FromJSON(response.Body)
 	return rce.Count, err
 }
+
+// Holds the terms aggregations of a rule findings field for the request and the response findings
+type RuleFindingsTermsAggregation struct {
+	Request  Langs `json:"request"`
+	Response Langs `json:"response"`
+}
+
+type RuleFindingsTermsResponse struct {
+	Aggregations RuleFindingsTermsAggregation `json:"aggregations"`
+}
+
+func (rftr *RuleFindingsTermsResponse) FromJSON(r io.Reader) error {
+	e := json.NewDecoder(r)
+	return e.Decode(rftr)
+}
+
+// Counts the logs which have rule findings for each value of a rule findings field (the request and the response findings are summed)
+// @param field - the field of the rule findings (tags, cweIds, mitreTechniques)
+// Returns the buckets sorted by count or an error if the aggregation failed
+func (elastic *ElasticConnection) getRuleFindingsTermsStats(field string) ([]Bucket, error) {
+	query := fmt.Sprintf(`
+	{
+		"size": 0,
+		"aggs" : {
+			"request" : {
+				"terms" : { "field" : "ruleFindings.request.%s.keyword", "size": 100 }
+			},
+			"response" : {
+				"terms" : { "field" : "ruleFindings.response.%s.keyword", "size": 100 }
+			}
+		}
+	}
+	`, field, field)
+
+	//Search the logs in the elasticsearch database
+	res, err := elastic.connection.Search(
+		elastic.connection.Search.WithIndex(elastic.configuration.ElasticIndex),
+		elastic.connection.Search.WithBody(strings.NewReader(query)),
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+	if res.IsError() {
+		return nil, errors.New("could not aggregate the rule findings by " + field + ", " + res.Status())
+	}
+
+	response := RuleFindingsTermsResponse{}
+	err = response.FromJSON(res.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	//Sum the counts of the request and the response findings
+	counts := make(map[string]int64)
+	keys := make([]string, 0)
+	for _, bucket := range append(response.Aggregations.Request.Buckets, response.Aggregations.Response.Buckets...) {
+		if _, found := counts[bucket.Key]; !found {
+			keys = append(keys, bucket.Key)
+		}
+		counts[bucket.Key] += bucket.Count
+	}
+	buckets := make([]Bucket, 0, len(keys))
+	for _, key := range keys {
+		buckets = append(buckets, Bucket{Key: key, Count: counts[key]})
+	}
+	sort.SliceStable(buckets, func(i, j int) bool {
+		return buckets[i].Count > buckets[j].Count
+	})
+	return buckets, nil
+}
+
+func (elastic *ElasticConnection) GetRuleTagsStats() ([]data.TagMetrics, error) {
+	buckets, err := elastic.getRuleFindingsTermsStats("tags")
+	if err != nil {
+		return nil, err
+	}
+
+	metrics := make([]data.TagMetrics, 0)
+	for _, metric := range buckets {
+		metrics = append(metrics, data.TagMetrics{Tag: metric.Key, Count: metric.Count})
+	}
+
+	return metrics, nil
+}
+
+func (elastic *ElasticConnection) GetRuleAttackTechniquesStats() ([]data.AttackMetrics, error) {
+	buckets, err := elastic.getRuleFindingsTermsStats("mitreTechniques")
+	if err != nil {
+		return nil, err
+	}
+
+	metrics := make([]data.AttackMetrics, 0)
+	for _, metric := range buckets {
+		metrics = append(metrics, data.AttackMetrics{Technique: metric.Key, Count: metric.Count})
+	}
+
+	return metrics, nil
+}
+
+func (elastic *ElasticConnection) GetRuleCWEStats() ([]data.CWEMetrics, error) {
+	buckets, err := elastic.getRuleFindingsTermsStats("cweIds")
+	if err != nil {
+		return nil, err
+	}
+
+	metrics := make([]data.CWEMetrics, 0)
+	for _, metric := range buckets {
+		metrics = append(metrics, data.CWEMetrics{CWE: metric.Key, Count: metric.Count})
+	}
+
+	return metrics, nil
+}
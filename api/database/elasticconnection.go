@@ -16,4 +16,7 @@ type IElasticConnection interface {
 	GetRuleIdStats() ([]data.FindingsMetrics, error)
 	GetFindingsStats() (data.FindingsCountMetrics, error)
 	GetAgentsStatistics() ([]data.AgentsMetrics, error)
+	GetRuleTagsStats() ([]data.TagMetrics, error)
+	GetRuleAttackTechniquesStats() ([]data.AttackMetrics, error)
+	GetRuleCWEStats() ([]data.CWEMetrics, error)
 }
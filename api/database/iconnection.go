@@ -32,4 +32,14 @@ type IConnection interface {
 	GetLogFindings(log_uuid string) ([]data.FindingDatabase, error)
 	GetLogRuleFindings(log_uuid string) ([]data.RuleFindingDatabase, error)
 	CheckExploitCodeExists(log_uuid string) (bool, error)
+	GetRuleSetVersion() (int64, error)
+	UpdateRuleSetVersion(current int64, version int64) (bool, error)
+	GetRules() ([]data.Rule, error)
+	GetRule(id string) (data.Rule, error)
+	SaveRule(rule data.Rule) error
+	DeleteRule(id string) error
+	SaveAgentRuleVersion(agentRuleVersion data.AgentRuleVersion) error
+	GetAgentsRuleVersions() ([]data.AgentRuleVersion, error)
+	SaveRuleStatistics(agentId string, since int64, timestamp int64, statistics []data.RuleStatistics) error
+	GetAgentRuleStatistics(agentId string) ([]data.RuleStatistics, error)
 }
@@ -616,6 +616,57 @@ func (lh *LogsHandler) GetLogsRuleIdMetrics(rw http.ResponseWriter, r *http.Requ
 	resp.ToJSON(rw)
 }
 
+// Handler for getting the rule findings metrics grouped by the tags of the rules
+func (lh *LogsHandler) GetLogsRuleTagMetrics(rw http.ResponseWriter, r *http.Request) {
+	//Get the metrics from elasticsearch
+	metrics, err := lh.elasticConnection.GetRuleTagsStats()
+	if err != nil {
+		//Send an error message
+		rw.WriteHeader(http.StatusBadRequest)
+		apiErr := data.APIError{Code: data.DATABASE_ERROR, Message: "could not retrieve the metrics for rule tags"}
+		apiErr.ToJSON(rw)
+		return
+	}
+	//Send the metrics back to the client
+	resp := response.TagMetricsResponse{Metrics: metrics}
+	rw.WriteHeader(http.StatusOK)
+	resp.ToJSON(rw)
+}
+
+// Handler for getting the rule findings metrics grouped by the MITRE ATT&CK techniques of the rules
+func (lh *LogsHandler) GetLogsRuleAttackMetrics(rw http.ResponseWriter, r *http.Request) {
+	//Get the metrics from elasticsearch
+	metrics, err := lh.elasticConnection.GetRuleAttackTechniquesStats()
+	if err != nil {
+		//Send an error message
+		rw.WriteHeader(http.StatusBadRequest)
+		apiErr := data.APIError{Code: data.DATABASE_ERROR, Message: "could not retrieve the metrics for rule attack techniques"}
+		apiErr.ToJSON(rw)
+		return
+	}
+	//Send the metrics back to the client
+	resp := response.AttackMetricsResponse{Metrics: metrics}
+	rw.WriteHeader(http.StatusOK)
+	resp.ToJSON(rw)
+}
+
+// Handler for getting the rule findings metrics grouped by the CWE ids of the rules
+func (lh *LogsHandler) GetLogsRuleCWEMetrics(rw http.ResponseWriter, r *http.Request) {
+	//Get the metrics from elasticsearch
+	metrics, err := lh.elasticConnection.GetRuleCWEStats()
+	if err != nil {
+		//Send an error message
+		rw.WriteHeader(http.StatusBadRequest)
+		apiErr := data.APIError{Code: data.DATABASE_ERROR, Message: "could not retrieve the metrics for rule cwe ids"}
+		apiErr.ToJSON(rw)
+		return
+	}
+	//Send the metrics back to the client
+	resp := response.CWEMetricsResponse{Metrics: metrics}
+	rw.WriteHeader(http.StatusOK)
+	resp.ToJSON(rw)
+}
+
 func (lh *LogsHandler) GetFindingsCount(rw http.ResponseWriter, r *http.Request) {
 	//Get the metrics from elasticsearch
 	metrics, err := lh.elasticConnection.GetFindingsStats()
@@ -0,0 +1,462 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/go-playground/validator/v10"
+	"github.com/gorilla/mux"
+	"github.com/lucacoratu/disertatie/api/config"
+	"github.com/lucacoratu/disertatie/api/data"
+	request "github.com/lucacoratu/disertatie/api/data/request"
+	response "github.com/lucacoratu/disertatie/api/data/response"
+	"github.com/lucacoratu/disertatie/api/database"
+	"github.com/lucacoratu/disertatie/api/logging"
+	"github.com/lucacoratu/disertatie/api/utils"
+	"github.com/lucacoratu/disertatie/api/websocket"
+)
+
+type RulesHandler struct {
+	logger        logging.ILogger
+	configuration config.Configuration
+	dbConnection  database.IConnection
+	wsPool        *websocket.Pool
+}
+
+// Creates a new handler that will hold the functions necessary for managing the central rule repository
+func NewRulesHandler(logger logging.ILogger, configuration config.Configuration, dbConnection database.IConnection, wsPool *websocket.Pool) *RulesHandler {
+	return &RulesHandler{logger: logger, configuration: configuration, dbConnection: dbConnection, wsPool: wsPool}
+}
+
+// Validates the YAML content of the rule and gets its id
+// The rule is checked the same way the agents check it so a rule which would reject the whole rule set on the agents is not saved
+func getRuleId(content string) (string, error) {
+	err := utils.ValidateRuleSchema(content)
+	if err != nil {
+		return "", err
+	}
+	rule, err := utils.ParseRuleSchema(content)
+	if err != nil {
+		return "", err
+	}
+	return rule.Id, nil
+}
+
+// Parses and validates the rule from the request body
+func (rh *RulesHandler) parseRuleRequest(r *http.Request) (request.RuleRequest, string, error) {
+	ruleRequest := request.RuleRequest{}
+	err := ruleRequest.FromJSON(r.Body)
+	if err != nil {
+		return ruleRequest, "", errors.New("failed to parse body from JSON")
+	}
+	validate := validator.New(validator.WithRequiredStructEnabled())
+	err = validate.Struct(ruleRequest)
+	if err != nil {
+		return ruleRequest, "", err
+	}
+	ruleId, err := getRuleId(ruleRequest.Content)
+	return ruleRequest, ruleId, err
+}
+
+// The number of attempts to change the rule set when it is modified concurrently
+const ruleSetChangeAttempts = 5
+
+// Changes the rule set, increments its version and pushes it to the connected agents
+// The change is written before the version is incremented so an agent receiving the new version always receives the change
+// The version is incremented in a lightweight transaction, if the rule set was modified concurrently the change is written again with the next version
+// @param change - writes the change to the rule set, it receives the new version of the rule set
+// Returns the new version of the rule set
+func (rh *RulesHandler) changeRuleSet(change func(version int64) error) (int64, error) {
+	for attempt := 0; attempt < ruleSetChangeAttempts; attempt++ {
+		current, err := rh.dbConnection.GetRuleSetVersion()
+		if err != nil {
+			return 0, err
+		}
+		err = change(current + 1)
+		if err != nil {
+			return 0, err
+		}
+		applied, err := rh.dbConnection.UpdateRuleSetVersion(current, current+1)
+		if err != nil {
+			return 0, err
+		}
+		if applied {
+			rh.wsPool.PublishRuleSet()
+			return current + 1, nil
+		}
+	}
+	return 0, errors.New("the rule set was modified concurrently, try again")
+}
+
+// Saves the rule with the new version of the rule set
+// Returns the saved rule
+func (rh *RulesHandler) saveRule(id string, content string) (data.Rule, error) {
+	rule := data.Rule{Id: id, Content: content, Updated: time.Now().Unix()}
+	_, err := rh.changeRuleSet(func(version int64) error {
+		rule.Version = version
+		return rh.dbConnection.SaveRule(rule)
+	})
+	return rule, err
+}
+
+// Function that handles GET request on /api/v1/rules (returns the rule set)
+func (rh *RulesHandler) GetRules(rw http.ResponseWriter, r *http.Request) {
+	version, err := rh.dbConnection.GetRuleSetVersion()
+	if err != nil {
+		rw.WriteHeader(http.StatusInternalServerError)
+		retErr := data.APIError{Code: data.DATABASE_ERROR, Message: err.Error()}
+		retErr.ToJSON(rw)
+		return
+	}
+	rules, err := rh.dbConnection.GetRules()
+	if err != nil {
+		rw.WriteHeader(http.StatusInternalServerError)
+		retErr := data.APIError{Code: data.DATABASE_ERROR, Message: err.Error()}
+		retErr.ToJSON(rw)
+		return
+	}
+	//Send the response back to the client
+	resp := response.RulesGetResponse{Version: version, Rules: rules}
+	rw.WriteHeader(http.StatusOK)
+	resp.ToJSON(rw)
+}
+
+// Function that handles GET request on /api/v1/rules/{ruleid} (returns a single rule)
+func (rh *RulesHandler) GetRule(rw http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	ruleId := vars["ruleid"]
+	rule, err := rh.dbConnection.GetRule(ruleId)
+	if err != nil {
+		rw.WriteHeader(http.StatusNotFound)
+		retErr := data.APIError{Code: data.DATABASE_ERROR, Message: err.Error()}
+		retErr.ToJSON(rw)
+		return
+	}
+	rw.WriteHeader(http.StatusOK)
+	rule.ToJSON(rw)
+}
+
+// Function that handles POST request on /api/v1/rules (adds a rule to the rule set)
+func (rh *RulesHandler) AddRule(rw http.ResponseWriter, r *http.Request) {
+	ruleRequest, ruleId, err := rh.parseRuleRequest(r)
+	if err != nil {
+		rh.logger.Error("Could not add rule, data validation error", err.Error())
+		rw.WriteHeader(http.StatusBadRequest)
+		retErr := data.APIError{Code: data.REQUEST_ERROR, Message: err.Error()}
+		retErr.ToJSON(rw)
+		return
+	}
+
+	//Check if the rule already exists
+	if _, err := rh.dbConnection.GetRule(ruleId); err == nil {
+		rw.WriteHeader(http.StatusConflict)
+		retErr := data.APIError{Code: data.REQUEST_ERROR, Message: "a rule with this id already exists"}
+		retErr.ToJSON(rw)
+		return
+	}
+
+	rule, err := rh.saveRule(ruleId, ruleRequest.Content)
+	if err != nil {
+		rw.WriteHeader(http.StatusInternalServerError)
+		retErr := data.APIError{Code: data.DATABASE_ERROR, Message: err.Error()}
+		retErr.ToJSON(rw)
+		return
+	}
+
+	rh.logger.Info("Rule", ruleId, "added, rule set version", rule.Version)
+	rw.WriteHeader(http.StatusOK)
+	rule.ToJSON(rw)
+}
+
+// Function that handles PUT request on /api/v1/rules/{ruleid} (modifies a rule of the rule set)
+func (rh *RulesHandler) ModifyRule(rw http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	ruleId := vars["ruleid"]
+	ruleRequest, contentRuleId, err := rh.parseRuleRequest(r)
+	if err != nil {
+		rh.logger.Error("Could not modify rule, data validation error", err.Error())
+		rw.WriteHeader(http.StatusBadRequest)
+		retErr := data.APIError{Code: data.REQUEST_ERROR, Message: err.Error()}
+		retErr.ToJSON(rw)
+		return
+	}
+	if contentRuleId != ruleId {
+		rw.WriteHeader(http.StatusBadRequest)
+		retErr := data.APIError{Code: data.REQUEST_ERROR, Message: "the id of the rule cannot be changed"}
+		retErr.ToJSON(rw)
+		return
+	}
+
+	//Check if the rule exists
+	if _, err := rh.dbConnection.GetRule(ruleId); err != nil {
+		rw.WriteHeader(http.StatusNotFound)
+		retErr := data.APIError{Code: data.DATABASE_ERROR, Message: err.Error()}
+		retErr.ToJSON(rw)
+		return
+	}
+
+	rule, err := rh.saveRule(ruleId, ruleRequest.Content)
+	if err != nil {
+		rw.WriteHeader(http.StatusInternalServerError)
+		retErr := data.APIError{Code: data.DATABASE_ERROR, Message: err.Error()}
+		retErr.ToJSON(rw)
+		return
+	}
+
+	rh.logger.Info("Rule", ruleId, "modified, rule set version", rule.Version)
+	rw.WriteHeader(http.StatusOK)
+	rule.ToJSON(rw)
+}
+
+// Function that handles DELETE request on /api/v1/rules/{ruleid} (removes a rule from the rule set)
+func (rh *RulesHandler) DeleteRule(rw http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	ruleId := vars["ruleid"]
+
+	//Check if the rule exists
+	if _, err := rh.dbConnection.GetRule(ruleId); err != nil {
+		rw.WriteHeader(http.StatusNotFound)
+		retErr := data.APIError{Code: data.DATABASE_ERROR, Message: err.Error()}
+		retErr.ToJSON(rw)
+		return
+	}
+
+	version, err := rh.changeRuleSet(func(version int64) error {
+		return rh.dbConnection.DeleteRule(ruleId)
+	})
+	if err != nil {
+		rw.WriteHeader(http.StatusInternalServerError)
+		retErr := data.APIError{Code: data.DATABASE_ERROR, Message: err.Error()}
+		retErr.ToJSON(rw)
+		return
+	}
+
+	rh.logger.Info("Rule", ruleId, "deleted, rule set version", version)
+	rw.WriteHeader(http.StatusOK)
+	message := data.SuccessMessage{Message: "rule has been deleted"}
+	message.ToJSON(rw)
+}
+
+// Function that handles GET request on /api/v1/rules/agent-versions (returns the version of the rule set each agent is running)
+func (rh *RulesHandler) GetAgentsRuleVersions(rw http.ResponseWriter, r *http.Request) {
+	version, err := rh.dbConnection.GetRuleSetVersion()
+	if err != nil {
+		rw.WriteHeader(http.StatusInternalServerError)
+		retErr := data.APIError{Code: data.DATABASE_ERROR, Message: err.Error()}
+		retErr.ToJSON(rw)
+		return
+	}
+	agents, err := rh.dbConnection.GetAgents()
+	if err != nil {
+		rw.WriteHeader(http.StatusInternalServerError)
+		retErr := data.APIError{Code: data.DATABASE_ERROR, Message: err.Error()}
+		retErr.ToJSON(rw)
+		return
+	}
+	agentRuleVersions, err := rh.dbConnection.GetAgentsRuleVersions()
+	if err != nil {
+		rw.WriteHeader(http.StatusInternalServerError)
+		retErr := data.APIError{Code: data.DATABASE_ERROR, Message: err.Error()}
+		retErr.ToJSON(rw)
+		return
+	}
+
+	//The agents which never acknowledged a rule set are reported with version 0
+	versions := make([]data.AgentRuleVersion, 0, len(agents))
+	for _, agent := range agents {
+		agentRuleVersion := data.AgentRuleVersion{AgentId: agent.ID}
+		for _, acknowledged := range agentRuleVersions {
+			if acknowledged.AgentId == agent.ID {
+				agentRuleVersion = acknowledged
+				break
+			}
+		}
+		agentRuleVersion.UpToDate = agentRuleVersion.Version == version
+		agentRuleVersion.Online = rh.wsPool.IsAgentConnected(agent.ID)
+		versions = append(versions, agentRuleVersion)
+	}
+
+	resp := response.AgentsRuleVersionsResponse{Version: version, Agents: versions}
+	rw.WriteHeader(http.StatusOK)
+	resp.ToJSON(rw)
+}
+
+// Gets a positive number from the query parameters of the request
+// @param r - the request
+// @param name - the name of the query parameter
+// @param defaultValue - the value used when the parameter is missing
+// Returns the number or an error if the parameter is not a positive number
+func getPositiveQueryParameter(r *http.Request, name string, defaultValue int) (int, error) {
+	value := r.URL.Query().Get(name)
+	if value == "" {
+		return defaultValue, nil
+	}
+	number, err := strconv.Atoi(value)
+	if err != nil || number <= 0 {
+		return 0, errors.New(name + " should be a positive number")
+	}
+	return number, nil
+}
+
+// Function that handles GET request on /api/v1/agents/{uuid}/rules/dead (returns the rules which did not match in the last N days)
+// The number of days is taken from the days query parameter (default 7)
+// The rules reported for the first time in the last N days are not included since they could not have matched yet
+func (rh *RulesHandler) GetAgentDeadRules(rw http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	uuid := vars["uuid"]
+	days, err := getPositiveQueryParameter(r, "days", 7)
+	if err != nil {
+		rw.WriteHeader(http.StatusBadRequest)
+		retErr := data.APIError{Code: data.REQUEST_ERROR, Message: err.Error()}
+		retErr.ToJSON(rw)
+		return
+	}
+	statistics, err := rh.dbConnection.GetAgentRuleStatistics(uuid)
+	if err != nil {
+		rw.WriteHeader(http.StatusInternalServerError)
+		retErr := data.APIError{Code: data.DATABASE_ERROR, Message: err.Error()}
+		retErr.ToJSON(rw)
+		return
+	}
+
+	cutoff := time.Now().AddDate(0, 0, -days).Unix()
+	deadRules := make([]data.RuleStatistics, 0)
+	for _, ruleStatistics := range statistics {
+		if ruleStatistics.LastMatch < cutoff && ruleStatistics.FirstSeen <= cutoff {
+			deadRules = append(deadRules, ruleStatistics)
+		}
+	}
+	sort.Slice(deadRules, func(i, j int) bool {
+		return deadRules[i].RuleId < deadRules[j].RuleId
+	})
+
+	resp := response.AgentRuleStatisticsResponse{AgentId: uuid, Rules: deadRules}
+	rw.WriteHeader(http.StatusOK)
+	resp.ToJSON(rw)
+}
+
+// Function that handles GET request on /api/v1/agents/{uuid}/rules/expensive (returns the rules with the highest evaluation time)
+// The number of rules is taken from the limit query parameter (default 10)
+// The rules are sorted by the cumulative evaluation time or by the 99th percentile if the sort query parameter is p99
+func (rh *RulesHandler) GetAgentExpensiveRules(rw http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	uuid := vars["uuid"]
+	limit, err := getPositiveQueryParameter(r, "limit", 10)
+	if err != nil {
+		rw.WriteHeader(http.StatusBadRequest)
+		retErr := data.APIError{Code: data.REQUEST_ERROR, Message: err.Error()}
+		retErr.ToJSON(rw)
+		return
+	}
+	sortBy := r.URL.Query().Get("sort")
+	if sortBy != "" && sortBy != "total" && sortBy != "p99" {
+		rw.WriteHeader(http.StatusBadRequest)
+		retErr := data.APIError{Code: data.REQUEST_ERROR, Message: "sort should be total or p99"}
+		retErr.ToJSON(rw)
+		return
+	}
+	statistics, err := rh.dbConnection.GetAgentRuleStatistics(uuid)
+	if err != nil {
+		rw.WriteHeader(http.StatusInternalServerError)
+		retErr := data.APIError{Code: data.DATABASE_ERROR, Message: err.Error()}
+		retErr.ToJSON(rw)
+		return
+	}
+
+	sort.SliceStable(statistics, func(i, j int) bool {
+		if sortBy == "p99" {
+			return statistics[i].P99Time > statistics[j].P99Time
+		}
+		return statistics[i].TotalTime > statistics[j].TotalTime
+	})
+	if len(statistics) > limit {
+		statistics = statistics[:limit]
+	}
+
+	resp := response.AgentRuleStatisticsResponse{AgentId: uuid, Rules: statistics}
+	rw.WriteHeader(http.StatusOK)
+	resp.ToJSON(rw)
+}
+
+// Creates the virtual patch rule from the request of the log and its findings
+// @param logId - the id of the log
+// Returns the response with the generated rule, the status code and an error if the rule could not be generated
+func (rh *RulesHandler) createVirtualPatch(logId string) (response.VirtualPatchResponse, int, error) {
+	resp := response.VirtualPatchResponse{LogId: logId}
+	rawRequest, err := rh.dbConnection.GetLogRequest(logId)
+	if err != nil {
+		return resp, http.StatusNotFound, errors.New("could not retrieve the log request from database, " + err.Error())
+	}
+	findings, err := rh.dbConnection.GetLogFindings(logId)
+	if err != nil {
+		return resp, http.StatusInternalServerError, err
+	}
+	ruleFindings, err := rh.dbConnection.GetLogRuleFindings(logId)
+	if err != nil {
+		return resp, http.StatusInternalServerError, err
+	}
+	content, target, err := utils.CreateVirtualPatchRule(logId, rawRequest, findings, ruleFindings)
+	if err != nil {
+		return resp, http.StatusBadRequest, err
+	}
+	ruleId, err := getRuleId(content)
+	if err != nil {
+		return resp, http.StatusBadRequest, err
+	}
+	resp.RuleId = ruleId
+	resp.Content = content
+	resp.Target = target
+	return resp, http.StatusOK, nil
+}
+
+// Function that handles GET request on /api/v1/logs/{loguuid}/rule (proposes a rule blocking the malicious value of the logged request)
+func (rh *RulesHandler) GetLogVirtualPatch(rw http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	logId := vars["loguuid"]
+	resp, status, err := rh.createVirtualPatch(logId)
+	if err != nil {
+		rw.WriteHeader(status)
+		retErr := data.APIError{Code: data.REQUEST_ERROR, Message: err.Error()}
+		retErr.ToJSON(rw)
+		return
+	}
+	rw.WriteHeader(http.StatusOK)
+	resp.ToJSON(rw)
+}
+
+// Function that handles POST request on /api/v1/rules/from-log/{loguuid} (adds the virtual patch rule of the logged request to the rule set)
+func (rh *RulesHandler) AddLogVirtualPatch(rw http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	logId := vars["loguuid"]
+	resp, status, err := rh.createVirtualPatch(logId)
+	if err != nil {
+		rw.WriteHeader(status)
+		retErr := data.APIError{Code: data.REQUEST_ERROR, Message: err.Error()}
+		retErr.ToJSON(rw)
+		return
+	}
+
+	//Check if the rule was already added for the log
+	if _, err := rh.dbConnection.GetRule(resp.RuleId); err == nil {
+		rw.WriteHeader(http.StatusConflict)
+		retErr := data.APIError{Code: data.REQUEST_ERROR, Message: "a rule with this id already exists"}
+		retErr.ToJSON(rw)
+		return
+	}
+
+	rule, err := rh.saveRule(resp.RuleId, resp.Content)
+	if err != nil {
+		rw.WriteHeader(http.StatusInternalServerError)
+		retErr := data.APIError{Code: data.DATABASE_ERROR, Message: err.Error()}
+		retErr.ToJSON(rw)
+		return
+	}
+
+	rh.logger.Info("Virtual patch", resp.RuleId, "added from log", logId+", rule set version", rule.Version)
+	resp.Saved = true
+	rw.WriteHeader(http.StatusOK)
+	resp.ToJSON(rw)
+}
@@ -133,6 +133,7 @@ func (api *APIServer) Init() error {
 	logsHandler := handlers.NewLogsHandler(api.logger, api.configuration, api.dbConnection, api.elasticConnection)
 	machinesHandler := handlers.NewMachinesHandler(api.logger, api.configuration, api.dbConnection)
 	wsHandler := handlers.NewWebsocketHandler(api.logger, api.configuration, api.dbConnection)
+	rulesHandler := handlers.NewRulesHandler(api.logger, api.configuration, api.dbConnection, pool)
 
 	//Create the standalone login route
 	r.HandleFunc("/api/v1/auth/login", authHandler.Login)
@@ -142,6 +143,8 @@ func (api *APIServer) Init() error {
 	//Add the routes
 	//Create the subrouter for the API path
 	apiGetSubrouter := r.PathPrefix("/api/v1/").Methods("GET").Subrouter()
+	//The rules are added by the users so the POST requests need authentication, unlike the ones sent by the agents
+	apiRulesPostSubrouter := r.PathPrefix("/api/v1/rules").Methods("POST").Subrouter()
 	apiPostSubrouter := r.PathPrefix("/api/v1/").Methods("POST").Subrouter()
 	apiDeleteSubrouter := r.PathPrefix("/api/v1/").Methods("DELETE").Subrouter()
 	apiPutSubrouter := r.PathPrefix("/api/v1/").Methods("PUT").Subrouter()
@@ -150,6 +153,7 @@ func (api *APIServer) Init() error {
 	apiGetSubrouter.Use(api.AuthMiddleware)
 	apiPutSubrouter.Use(api.AuthMiddleware)
 	apiDeleteSubrouter.Use(api.AuthMiddleware)
+	apiRulesPostSubrouter.Use(api.AuthMiddleware)
 
 	//Create the route for checking the token (this should use the middleware)
 	apiGetSubrouter.HandleFunc("/auth/check-token", authHandler.CheckToken)
@@ -180,6 +184,8 @@ func (api *APIServer) Init() error {
 	apiGetSubrouter.HandleFunc("/logs/{loguuid:[0-9a-f]+-[0-9a-f]+-[0-9a-f]+-[0-9a-f]+-[0-9a-f]+}", logsHandler.GetLog)
 	//Create the route that will send the exploit code of a log
 	apiGetSubrouter.HandleFunc("/logs/{loguuid:[0-9a-f]+-[0-9a-f]+-[0-9a-f]+-[0-9a-f]+-[0-9a-f]+}/exploit", logsHandler.GetLogExploitPythonCode)
+	//Create the route that will send the virtual patch rule generated from the request of the log
+	apiGetSubrouter.HandleFunc("/logs/{loguuid:[0-9a-f]+-[0-9a-f]+-[0-9a-f]+-[0-9a-f]+-[0-9a-f]+}/rule", rulesHandler.GetLogVirtualPatch)
 	//Create the route that will send recent logs (10) to the client
 	apiGetSubrouter.HandleFunc("/logs/recent", logsHandler.GetRecentLogsElastic)
 	//Create the route that will send recent classified logs (10) to the client
@@ -205,6 +211,23 @@ func (api *APIServer) Init() error {
 	apiGetSubrouter.HandleFunc("/findings/rule/metrics", logsHandler.GetLogsRuleFindingsMetrics)
 	//Create the route that will send the rule id metrics
 	apiGetSubrouter.HandleFunc("/findings/rule/id-metrics", logsHandler.GetLogsRuleIdMetrics)
+	//Create the route that will send the rule findings metrics grouped by the tags of the rules
+	apiGetSubrouter.HandleFunc("/findings/rule/tag-metrics", logsHandler.GetLogsRuleTagMetrics)
+	//Create the route that will send the rule findings metrics grouped by the MITRE ATT&CK techniques of the rules
+	apiGetSubrouter.HandleFunc("/findings/rule/attack-metrics", logsHandler.GetLogsRuleAttackMetrics)
+	//Create the route that will send the rule findings metrics grouped by the CWE ids of the rules
+	apiGetSubrouter.HandleFunc("/findings/rule/cwe-metrics", logsHandler.GetLogsRuleCWEMetrics)
+	//Create the route that will send the version of the rule set each agent is running
+	apiGetSubrouter.HandleFunc("/rules/agent-versions", rulesHandler.GetAgentsRuleVersions)
+	//Create the route that will send the rule set
+	apiGetSubrouter.HandleFunc("/rules", rulesHandler.GetRules)
+	//Create the route that will send a single rule
+	apiGetSubrouter.HandleFunc("/rules/{ruleid:[A-Za-z0-9_.-]+}", rulesHandler.GetRule)
+	//Create the route that will send the rules of an agent which did not match in the last days
+	apiGetSubrouter.HandleFunc("/agents/{uuid:[0-9a-f]+-[0-9a-f]+-[0-9a-f]+-[0-9a-f]+-[0-9a-f]+}/rules/dead", rulesHandler.GetAgentDeadRules)
+	//Create the route that will send the rules of an agent with the highest evaluation time
+	apiGetSubrouter.HandleFunc("/agents/{uuid:[0-9a-f]+-[0-9a-f]+-[0-9a-f]+-[0-9a-f]+-[0-9a-f]+}/rules/expensive", rulesHandler.GetAgentExpensiveRules)
+
 	//Create the route that will send all the registered machines
 	apiGetSubrouter.HandleFunc("/machines", machinesHandler.GetMachines)
 	//Create the route that will send the machines statistics
@@ -227,11 +250,20 @@ func (api *APIServer) Init() error {
 	//Create the route to register a new machine
 	apiPostSubrouter.HandleFunc("/machines", machinesHandler.RegisterMachine)
 
+	//Create the route to add a rule to the rule set
+	apiRulesPostSubrouter.HandleFunc("", rulesHandler.AddRule)
+	//Create the route to add the virtual patch rule generated from the request of a log to the rule set
+	apiRulesPostSubrouter.HandleFunc("/from-log/{loguuid:[0-9a-f]+-[0-9a-f]+-[0-9a-f]+-[0-9a-f]+-[0-9a-f]+}", rulesHandler.AddLogVirtualPatch)
+
 	//Create the route to delete a machine
 	apiDeleteSubrouter.HandleFunc("/machines/{machineuuid:[0-9a-f]+-[0-9a-f]+-[0-9a-f]+-[0-9a-f]+-[0-9a-f]+}", machinesHandler.DeleteMachine)
+	//Create the route to delete a rule from the rule set
+	apiDeleteSubrouter.HandleFunc("/rules/{ruleid:[A-Za-z0-9_.-]+}", rulesHandler.DeleteRule)
 
 	//Create the route to update an agent
 	apiPutSubrouter.HandleFunc("/agents/{uuid:[0-9a-f]+-[0-9a-f]+-[0-9a-f]+-[0-9a-f]+-[0-9a-f]+}", agentsHandler.ModifyAgent)
+	//Create the route to modify a rule of the rule set
+	apiPutSubrouter.HandleFunc("/rules/{ruleid:[A-Za-z0-9_.-]+}", rulesHandler.ModifyRule)
 
 	api.srv = &http.Server{
 		Addr: api.configuration.ListeningAddress + ":" + api.configuration.ListeningPort,
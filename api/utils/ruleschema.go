@@ -0,0 +1,637 @@
+package utils
+
+import (
+	"bufio"
+	"encoding/hex"
+	"errors"
+	"net/http"
+	"net/url"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/lucacoratu/disertatie/api/data"
+	"gopkg.in/yaml.v2"
+)
+
+// The values of the rules accepted by the agents
+// The lists are kept in sync with the agent by the tests of the rule schema validation (ruleschema_test.go)
+var (
+	ruleSchemaIdRegex              = regexp.MustCompile(`^[A-Za-z0-9_.-]+$`)
+	ruleSchemaCWEIdRegex           = regexp.MustCompile(`^CWE-[0-9]+$`)
+	ruleSchemaMitreTechniqueRegex  = regexp.MustCompile(`^T[0-9]{4}(\.[0-9]{3})?$`)
+	ruleSchemaSeverities           = []string{"low", "medium", "high", "critical"}
+	ruleSchemaActions              = []string{"allow", "log", "drop", "deceive", "tarpit", "redirect", "respond"}
+	ruleSchemaEncodings            = []string{"base64", "base64url", "url", "hex", "html", "js", "utf8-overlong", "sql-char", "gzip", "deflate"}
+	ruleSchemaMatchersConditions   = []string{"or", "and"}
+	ruleSchemaWebsocketDirections  = []string{"client-to-server", "server-to-client"}
+	ruleSchemaLatencyMeasures      = []string{"ttfb", "total"}
+	ruleSchemaCorrelationKeys      = []string{"ip", "session", "user-agent"}
+	ruleSchemaCorrelationDistincts = []string{"path", "method", "status", "ip", "session", "user-agent", "parameter"}
+)
+
+// Holds the search modes of a kind of matchers (used to check the regexes and the encodings of all the matchers the same way)
+type ruleSchemaSearchModes struct {
+	kind  string
+	modes []*data.RuleSchemaSearchMode
+}
+
+// Converts the matchers of the headers, the parameters or the cookies to search modes
+func getMatchersSearchModes(matchers []*data.RuleSchemaMatcher) []*data.RuleSchemaSearchMode {
+	modes := make([]*data.RuleSchemaSearchMode, 0, len(matchers))
+	for _, matcher := range matchers {
+		modes = append(modes, &data.RuleSchemaSearchMode{Match: matcher.Match, Regex: matcher.Regex, MatchList: matcher.MatchList, Encodings: matcher.Encodings})
+	}
+	return modes
+}
+
+// Converts the matchers of the JSON and the XML values to search modes
+func getPathMatchersSearchModes(matchers []*data.RuleSchemaPathMatcher) []*data.RuleSchemaSearchMode {
+	modes := make([]*data.RuleSchemaSearchMode, 0, len(matchers))
+	for _, matcher := range matchers {
+		modes = append(modes, &data.RuleSchemaSearchMode{Match: matcher.Match, Regex: matcher.Regex, MatchList: matcher.MatchList, Encodings: matcher.Encodings})
+	}
+	return modes
+}
+
+// Converts the matchers of the body to search modes
+func getBodyMatchersSearchModes(matchers []*data.RuleSchemaBodyMatcher) []*data.RuleSchemaSearchMode {
+	modes := make([]*data.RuleSchemaSearchMode, 0, len(matchers))
+	for _, matcher := range matchers {
+		modes = append(modes, &data.RuleSchemaSearchMode{Match: matcher.Match, Regex: matcher.Regex, MatchList: matcher.MatchList, Encodings: matcher.Encodings})
+	}
+	return modes
+}
+
+// Gets the search modes of all the matchers of the rule
+func getRuleSchemaSearchModes(rule data.RuleSchema) []ruleSchemaSearchModes {
+	searchModes := make([]ruleSchemaSearchModes, 0)
+	if request := rule.Request; request != nil {
+		if request.Method != nil {
+			searchModes = append(searchModes, ruleSchemaSearchModes{"request method", []*data.RuleSchemaSearchMode{request.Method}})
+		}
+		fileModes := make([]*data.RuleSchemaSearchMode, 0)
+		for _, fileMatcher := range request.Files {
+			for _, mode := range []*data.RuleSchemaSearchMode{fileMatcher.Filename, fileMatcher.ContentType, fileMatcher.SniffedContentType} {
+				if mode != nil {
+					fileModes = append(fileModes, mode)
+				}
+			}
+		}
+		searchModes = append(searchModes,
+			ruleSchemaSearchModes{"request url", request.URL},
+			ruleSchemaSearchModes{"request header", getMatchersSearchModes(request.Headers)},
+			ruleSchemaSearchModes{"request parameter", getMatchersSearchModes(request.Parameters)},
+			ruleSchemaSearchModes{"request body", getBodyMatchersSearchModes(request.Body)},
+			ruleSchemaSearchModes{"request cookie", getMatchersSearchModes(request.Cookies)},
+			ruleSchemaSearchModes{"request json", getPathMatchersSearchModes(request.JSON)},
+			ruleSchemaSearchModes{"request xml", getPathMatchersSearchModes(request.XML)},
+			ruleSchemaSearchModes{"request files", fileModes},
+		)
+	}
+	if response := rule.Response; response != nil {
+		if response.Code != nil {
+			searchModes = append(searchModes, ruleSchemaSearchModes{"response code", []*data.RuleSchemaSearchMode{response.Code}})
+		}
+		searchModes = append(searchModes,
+			ruleSchemaSearchModes{"response header", getMatchersSearchModes(response.Headers)},
+			ruleSchemaSearchModes{"response body", getBodyMatchersSearchModes(response.Body)},
+			ruleSchemaSearchModes{"response json", getPathMatchersSearchModes(response.JSON)},
+			ruleSchemaSearchModes{"response xml", getPathMatchersSearchModes(response.XML)},
+		)
+	}
+	websocketModes := make([]*data.RuleSchemaSearchMode, 0, len(rule.Websocket))
+	for _, matcher := range rule.Websocket {
+		websocketModes = append(websocketModes, &data.RuleSchemaSearchMode{Match: matcher.Match, Regex: matcher.Regex, MatchList: matcher.MatchList, Encodings: matcher.Encodings})
+	}
+	return append(searchModes, ruleSchemaSearchModes{"websocket", websocketModes})
+}
+
+// Checks if the encodings are supported by the agents (case insensitive)
+func checkRuleSchemaEncodings(kind string, encodings []string) error {
+	for _, encoding := range encodings {
+		if !slices.Contains(ruleSchemaEncodings, strings.ToLower(encoding)) {
+			return errors.New("rule " + kind + " contains unsuported encodings, " + encoding)
+		}
+	}
+	return nil
+}
+
+// Checks the info field of the rule (the severity, the action, the encodings and the metadata)
+func checkRuleSchemaInfo(info *data.RuleSchemaInfo) error {
+	if info == nil {
+		return errors.New("rule cannot have empty info")
+	}
+	if !slices.Contains(ruleSchemaSeverities, strings.ToLower(info.Severity)) {
+		return errors.New("rule severity cannot be something apart from: " + strings.Join(ruleSchemaSeverities, ", "))
+	}
+	action := strings.ToLower(info.Action)
+	if action != "" && !slices.Contains(ruleSchemaActions, action) {
+		return errors.New("rule action cannot be something apart from: " + strings.Join(ruleSchemaActions, ", "))
+	}
+	if parameters := info.ActionParameters; parameters != nil {
+		if parameters.RedirectURL != "" {
+			if action != "redirect" {
+				return errors.New("rule redirect-url can be used only with the redirect action")
+			}
+			if _, err := url.Parse(parameters.RedirectURL); err != nil {
+				return errors.New("rule redirect-url is not a valid url, " + err.Error())
+			}
+		}
+		if parameters.Status != 0 || len(parameters.Headers) > 0 || parameters.BodyFile != "" {
+			if action != "respond" {
+				return errors.New("rule status, headers and body-file can be used only with the respond action")
+			}
+			if parameters.Status != 0 && (parameters.Status < 200 || parameters.Status > 599) {
+				return errors.New("rule respond status should be between 200 and 599")
+			}
+			for name := range parameters.Headers {
+				if strings.TrimSpace(name) == "" || strings.ContainsAny(name, " :\r\n") {
+					return errors.New("rule respond header name is not valid, " + name)
+				}
+			}
+		}
+	}
+	if err := checkRuleSchemaEncodings("encodings", info.Encodings); err != nil {
+		return err
+	}
+
+	for _, tag := range info.Tags {
+		if strings.TrimSpace(tag) == "" || strings.ContainsAny(tag, ", ") {
+			return errors.New("rule tag is not valid, it should not be empty or contain spaces and commas, " + tag)
+		}
+	}
+	for _, reference := range info.References {
+		parsedReference, err := url.Parse(reference)
+		if err != nil || parsedReference.Scheme == "" || parsedReference.Host == "" {
+			return errors.New("rule reference should be an absolute URL, " + reference)
+		}
+	}
+	if info.CVSSScore < 0 || info.CVSSScore > 10 {
+		return errors.New("rule cvss-score should be between 0 and 10")
+	}
+	for _, cweId := range info.CWEIds {
+		if !ruleSchemaCWEIdRegex.MatchString(cweId) {
+			return errors.New("rule cwe id should have the CWE-<number> format, " + cweId)
+		}
+	}
+	for _, technique := range info.MitreTechniques {
+		if !ruleSchemaMitreTechniqueRegex.MatchString(technique) {
+			return errors.New("rule mitre technique should have the T<number> or T<number>.<number> format, " + technique)
+		}
+	}
+	return nil
+}
+
+// Checks the requests the rule applies to
+func checkRuleSchemaScope(scope *data.RuleSchemaScope) error {
+	if scope == nil {
+		return nil
+	}
+	if len(scope.Hosts) == 0 && len(scope.Paths) == 0 && len(scope.PathRegexes) == 0 && len(scope.Methods) == 0 {
+		return errors.New("rule scope should have at least one of: hosts, paths, path-regex, methods")
+	}
+	for _, host := range scope.Hosts {
+		if strings.TrimSpace(host) == "" || strings.ContainsAny(host, "/: ") {
+			return errors.New("rule scope host is not valid, " + host)
+		}
+	}
+	for _, method := range scope.Methods {
+		if strings.TrimSpace(method) == "" || strings.ContainsAny(method, " /") {
+			return errors.New("rule scope method is not valid, " + method)
+		}
+	}
+	for _, glob := range scope.Paths {
+		if !strings.HasPrefix(glob, "/") && !strings.HasPrefix(glob, "*") {
+			return errors.New("rule scope path glob should start with /, " + glob)
+		}
+	}
+	for _, pattern := range scope.PathRegexes {
+		if _, err := regexp.Compile(pattern); err != nil {
+			return errors.New("rule scope cannot compile path regex, " + err.Error())
+		}
+	}
+	return nil
+}
+
+// Checks the selectors of the JSON and the XML matchers are specified
+func checkRuleSchemaPathMatchers(kind string, matchers []*data.RuleSchemaPathMatcher) error {
+	for _, matcher := range matchers {
+		if strings.TrimSpace(matcher.Path) == "" {
+			return errors.New("rule " + kind + " matcher should have a path")
+		}
+	}
+	return nil
+}
+
+// Checks the matchers of the uploaded files
+func checkRuleSchemaFilesMatchers(matchers []*data.RuleSchemaFilesMatcher) error {
+	for _, matcher := range matchers {
+		if matcher.Filename == nil && matcher.ContentType == nil && matcher.SniffedContentType == nil && !matcher.ContentTypeMismatch && matcher.MinSize == 0 && matcher.MaxSize == 0 && matcher.MD5Sum == "" && matcher.SHA256Sum == "" {
+			return errors.New("files matcher should have at least one criterion")
+		}
+		if matcher.MinSize < 0 || matcher.MaxSize < 0 || (matcher.MaxSize > 0 && matcher.MinSize > matcher.MaxSize) {
+			return errors.New("files matcher has invalid size limits")
+		}
+		if decoded, err := hex.DecodeString(matcher.MD5Sum); matcher.MD5Sum != "" && (err != nil || len(decoded) != 16) {
+			return errors.New("files matcher has invalid md5sum, " + matcher.MD5Sum)
+		}
+		if decoded, err := hex.DecodeString(matcher.SHA256Sum); matcher.SHA256Sum != "" && (err != nil || len(decoded) != 32) {
+			return errors.New("files matcher has invalid sha256sum, " + matcher.SHA256Sum)
+		}
+	}
+	return nil
+}
+
+// Checks the bounds of a numeric matcher of the response
+func checkRuleSchemaRange(name string, bounds *data.RuleSchemaRange) error {
+	if bounds.Gt == nil && bounds.Lt == nil {
+		return errors.New("rule response " + name + " should have gt or lt")
+	}
+	if (bounds.Gt != nil && *bounds.Gt < 0) || (bounds.Lt != nil && *bounds.Lt < 0) {
+		return errors.New("rule response " + name + " bounds cannot be negative")
+	}
+	if bounds.Gt != nil && bounds.Lt != nil && *bounds.Gt >= *bounds.Lt {
+		return errors.New("rule response " + name + " gt should be less than lt")
+	}
+	return nil
+}
+
+// Checks the latency and the size matchers of the response and the confirm-request field
+func checkRuleSchemaResponse(rule data.RuleSchema) error {
+	response := rule.Response
+	if response == nil {
+		return nil
+	}
+	if err := checkRuleSchemaPathMatchers("response json", response.JSON); err != nil {
+		return err
+	}
+	if err := checkRuleSchemaPathMatchers("response xml", response.XML); err != nil {
+		return err
+	}
+	if response.Latency != nil {
+		if err := checkRuleSchemaRange("latency", &response.Latency.RuleSchemaRange); err != nil {
+			return err
+		}
+		if measure := strings.ToLower(response.Latency.Measure); measure != "" && !slices.Contains(ruleSchemaLatencyMeasures, measure) {
+			return errors.New("rule response latency measure cannot be something other than: " + strings.Join(ruleSchemaLatencyMeasures, ", "))
+		}
+	}
+	if response.Size != nil {
+		if err := checkRuleSchemaRange("size", response.Size); err != nil {
+			return err
+		}
+	}
+	if response.ConfirmRequest && len(getRuleSchemaMatcherReferences(rule, "request")) == 0 {
+		return errors.New("rule response confirm-request needs request matchers to confirm")
+	}
+	return nil
+}
+
+// Checks the websocket matchers
+func checkRuleSchemaWebsocket(matchers []*data.RuleSchemaWebsocketMatcher) error {
+	for _, matcher := range matchers {
+		if direction := strings.ToLower(matcher.Direction); direction != "" && !slices.Contains(ruleSchemaWebsocketDirections, direction) {
+			return errors.New("rule websocket direction cannot be something other than: " + strings.Join(ruleSchemaWebsocketDirections, ", "))
+		}
+		if matcher.JSONPath != "" && matcher.Header != "" {
+			return errors.New("rule websocket matcher cannot have both json-path and header")
+		}
+		if matcher.JSONPath != "" && matcher.MessageType != 0 && matcher.MessageType != 1 {
+			return errors.New("rule websocket json-path can be used only on the text messages (message_type 1)")
+		}
+		if matcher.Header != "" && strings.ContainsAny(matcher.Header, " :") {
+			return errors.New("rule websocket header is not a valid header name, " + matcher.Header)
+		}
+	}
+	return nil
+}
+
+// Checks the correlation of the requests
+func checkRuleSchemaCorrelation(rule data.RuleSchema) error {
+	correlation := rule.Correlation
+	if correlation == nil {
+		return nil
+	}
+	if rule.Request != nil || rule.Response != nil || len(rule.Websocket) > 0 {
+		return errors.New("rule correlation cannot be used together with the request, response and websocket matchers, use the correlation filter on the findings of the other rules")
+	}
+	if rule.Condition != nil || rule.MatchersCondition != "" {
+		return errors.New("rule correlation cannot have a condition")
+	}
+	if len(rule.Tests) > 0 {
+		return errors.New("rule correlation cannot have tests, they need more than one request")
+	}
+
+	if len(correlation.Keys) == 0 {
+		return errors.New("rule correlation should have at least one key")
+	}
+	usesSession := correlation.Distinct == "session"
+	for i, key := range correlation.Keys {
+		if !slices.Contains(ruleSchemaCorrelationKeys, key) {
+			return errors.New("rule correlation key cannot be something other than: " + strings.Join(ruleSchemaCorrelationKeys, ", "))
+		}
+		if slices.Contains(correlation.Keys[:i], key) {
+			return errors.New("rule correlation key is used more than once, " + key)
+		}
+		usesSession = usesSession || key == "session"
+	}
+	if correlation.Distinct != "" && !slices.Contains(ruleSchemaCorrelationDistincts, correlation.Distinct) {
+		return errors.New("rule correlation distinct cannot be something other than: " + strings.Join(ruleSchemaCorrelationDistincts, ", "))
+	}
+	if correlation.Distinct == "parameter" && strings.TrimSpace(correlation.Parameter) == "" {
+		return errors.New("rule correlation distinct parameter needs the name of the parameter")
+	}
+	if correlation.Parameter != "" && correlation.Distinct != "parameter" {
+		return errors.New("rule correlation parameter can be used only when the distinct parameter values are counted")
+	}
+	if slices.Contains(correlation.Keys, correlation.Distinct) {
+		return errors.New("rule correlation distinct cannot be one of the keys")
+	}
+	if usesSession && strings.TrimSpace(correlation.SessionCookie) == "" {
+		return errors.New("rule correlation session needs the name of the session cookie")
+	}
+
+	window, err := time.ParseDuration(correlation.Window)
+	if err != nil {
+		return errors.New("rule correlation window is not a valid duration (30s, 5m, 1h), " + correlation.Window)
+	}
+	if window <= 0 {
+		return errors.New("rule correlation window should be greater than 0")
+	}
+	if correlation.Threshold < 1 {
+		return errors.New("rule correlation threshold should be greater than 0")
+	}
+	if correlation.Filter != nil {
+		for _, status := range correlation.Filter.Status {
+			if status < 100 || status > 599 {
+				return errors.New("rule correlation filter status is not a valid status code, " + strconv.Itoa(status))
+			}
+		}
+	}
+	return nil
+}
+
+// Appends the references of the elements of a matchers list (request.params[0], request.params[1] ...)
+func appendRuleSchemaReferences(references []string, section string, count int) []string {
+	for i := 0; i < count; i++ {
+		references = append(references, section+"["+strconv.Itoa(i)+"]")
+	}
+	return references
+}
+
+// Gets the references of the matchers of the rule for the phase (the same references as the agents use in the conditions)
+func getRuleSchemaMatcherReferences(rule data.RuleSchema, phase string) []string {
+	references := make([]string, 0)
+	switch phase {
+	case "request":
+		if rule.Request == nil {
+			return references
+		}
+		if rule.Request.Method != nil {
+			references = append(references, "request.method")
+		}
+		references = appendRuleSchemaReferences(references, "request.url", len(rule.Request.URL))
+		references = appendRuleSchemaReferences(references, "request.headers", len(rule.Request.Headers))
+		references = appendRuleSchemaReferences(references, "request.params", len(rule.Request.Parameters))
+		references = appendRuleSchemaReferences(references, "request.body", len(rule.Request.Body))
+		references = appendRuleSchemaReferences(references, "request.cookies", len(rule.Request.Cookies))
+		references = appendRuleSchemaReferences(references, "request.files", len(rule.Request.Files))
+		references = appendRuleSchemaReferences(references, "request.json", len(rule.Request.JSON))
+		references = appendRuleSchemaReferences(references, "request.xml", len(rule.Request.XML))
+	case "response":
+		if rule.Response == nil {
+			return references
+		}
+		if rule.Response.Code != nil {
+			references = append(references, "response.code")
+		}
+		references = appendRuleSchemaReferences(references, "response.headers", len(rule.Response.Headers))
+		references = appendRuleSchemaReferences(references, "response.body", len(rule.Response.Body))
+		references = appendRuleSchemaReferences(references, "response.json", len(rule.Response.JSON))
+		references = appendRuleSchemaReferences(references, "response.xml", len(rule.Response.XML))
+		if rule.Response.Latency != nil {
+			references = append(references, "response.latency")
+		}
+		if rule.Response.Size != nil {
+			references = append(references, "response.size")
+		}
+	case "websocket":
+		references = appendRuleSchemaReferences(references, "websocket", len(rule.Websocket))
+	}
+	return references
+}
+
+// Checks the condition tree of a phase, the leaves should reference matchers defined in the rule for the phase
+func checkRuleSchemaCondition(condition *data.RuleSchemaCondition, phase string, references []string) error {
+	if condition == nil {
+		return errors.New("empty condition in " + phase + " condition")
+	}
+	specifiedFields := 0
+	for _, specified := range []bool{condition.And != nil, condition.Or != nil, condition.Not != nil, condition.Matcher != ""} {
+		if specified {
+			specifiedFields++
+		}
+	}
+	if specifiedFields != 1 {
+		return errors.New("a condition node should have exactly one of: and, or, not, matcher")
+	}
+
+	switch {
+	case condition.And != nil:
+		if len(condition.And) == 0 {
+			return errors.New("and condition cannot be empty")
+		}
+		for _, subCondition := range condition.And {
+			if err := checkRuleSchemaCondition(subCondition, phase, references); err != nil {
+				return err
+			}
+		}
+	case condition.Or != nil:
+		if len(condition.Or) == 0 {
+			return errors.New("or condition cannot be empty")
+		}
+		for _, subCondition := range condition.Or {
+			if err := checkRuleSchemaCondition(subCondition, phase, references); err != nil {
+				return err
+			}
+		}
+	case condition.Not != nil:
+		return checkRuleSchemaCondition(condition.Not, phase, references)
+	default:
+		if condition.Matcher != phase && !strings.HasPrefix(condition.Matcher, phase+".") && !strings.HasPrefix(condition.Matcher, phase+"[") {
+			return errors.New("matcher " + condition.Matcher + " cannot be used in the " + phase + " condition")
+		}
+		for _, reference := range references {
+			if reference == condition.Matcher || strings.HasPrefix(reference, condition.Matcher+"[") {
+				return nil
+			}
+		}
+		return errors.New("matcher " + condition.Matcher + " is not defined in the rule")
+	}
+	return nil
+}
+
+// Checks the matchers condition and the condition trees of the rule
+func checkRuleSchemaConditions(rule data.RuleSchema) error {
+	if rule.MatchersCondition != "" && !slices.Contains(ruleSchemaMatchersConditions, strings.ToLower(rule.MatchersCondition)) {
+		return errors.New("matchers-condition cannot be something other than: and, or")
+	}
+	if rule.Condition == nil {
+		return nil
+	}
+	conditions := map[string]*data.RuleSchemaCondition{"request": rule.Condition.Request, "response": rule.Condition.Response, "websocket": rule.Condition.Websocket}
+	for _, phase := range []string{"request", "response", "websocket"} {
+		if conditions[phase] == nil {
+			continue
+		}
+		if err := checkRuleSchemaCondition(conditions[phase], phase, getRuleSchemaMatcherReferences(rule, phase)); err != nil {
+			return errors.New("invalid " + phase + " condition, " + err.Error())
+		}
+	}
+	return nil
+}
+
+// Parses the head of a raw HTTP message of a rule test the same way as the agents (the line endings are normalized and the body is not needed)
+func getRuleTestHead(raw string) *bufio.Reader {
+	raw = strings.TrimLeft(strings.ReplaceAll(raw, "\r\n", "\n"), "\n")
+	head, _, _ := strings.Cut(raw, "\n\n")
+	head = strings.ReplaceAll(strings.TrimRight(head, "\n"), "\n", "\r\n") + "\r\n\r\n"
+	return bufio.NewReader(strings.NewReader(head))
+}
+
+// Checks the tests of the rule, every test should have exactly one sample which can be parsed by the agents
+func checkRuleSchemaTests(tests []*data.RuleSchemaTest) error {
+	for i, test := range tests {
+		name := strconv.Itoa(i)
+		if test == nil {
+			return errors.New("rule test " + name + " is empty")
+		}
+		if test.Expect != "match" && test.Expect != "no-match" {
+			return errors.New("rule test " + name + " has invalid expect value " + test.Expect + ", should be match or no-match")
+		}
+
+		//The request together with its response (or its websocket message) counts as one sample
+		samples := 0
+		if test.Request != "" {
+			samples++
+			if _, err := http.ReadRequest(getRuleTestHead(test.Request)); err != nil {
+				return errors.New("rule test " + name + " has invalid raw request, " + err.Error())
+			}
+		}
+		if test.Response != "" {
+			if test.Request == "" {
+				samples++
+			}
+			if _, err := http.ReadResponse(getRuleTestHead(test.Response), nil); err != nil {
+				return errors.New("rule test " + name + " has invalid raw response, " + err.Error())
+			}
+		}
+		if test.Websocket != nil {
+			if test.Request == "" {
+				samples++
+			}
+			if test.Response != "" {
+				return errors.New("rule test " + name + " cannot have both response and websocket")
+			}
+			switch test.Websocket.MessageType {
+			case 0, 1:
+			case 2:
+				if _, err := hex.DecodeString(test.Websocket.Message); err != nil {
+					return errors.New("rule test " + name + " has the binary websocket message which is not hex encoded, " + err.Error())
+				}
+			default:
+				return errors.New("rule test " + name + " has invalid websocket message type " + strconv.Itoa(test.Websocket.MessageType) + ", should be 1 (text) or 2 (binary)")
+			}
+			if direction := strings.ToLower(test.Websocket.Direction); direction != "" && !slices.Contains(ruleSchemaWebsocketDirections, direction) {
+				return errors.New("rule test " + name + " has invalid websocket direction " + test.Websocket.Direction + ", should be client-to-server or server-to-client")
+			}
+		}
+		if samples != 1 {
+			return errors.New("rule test " + name + " should have exactly one of request, response or websocket")
+		}
+		if test.Latency < 0 {
+			return errors.New("rule test " + name + " latency cannot be negative")
+		}
+		if test.Latency > 0 && test.Response == "" {
+			return errors.New("rule test " + name + " latency needs a response")
+		}
+	}
+	return nil
+}
+
+// Parses the YAML content of a rule the same way the agents running with strictRules parse it
+// The agents ignore the unknown and the duplicated keys by default, they are rejected here so a typo (regx instead of regex) does not silently disable a matcher
+// @param content - the YAML content of the rule
+// Returns the parsed rule or an error if the content cannot be parsed
+func ParseRuleSchema(content string) (data.RuleSchema, error) {
+	rule := data.RuleSchema{}
+	decoder := yaml.NewDecoder(strings.NewReader(content))
+	decoder.SetStrict(true)
+	if err := decoder.Decode(&rule); err != nil {
+		return rule, errors.New("could not parse the rule content, " + err.Error())
+	}
+	return rule, nil
+}
+
+// Checks if the YAML content is a valid rule (the same checks as the agents do when loading the rule)
+// The pattern lists and the files referenced by the rule are on the agents so they are checked only when the rule is loaded by the agents
+// @param content - the YAML content of the rule
+// Returns an error if the rule is not valid
+func ValidateRuleSchema(content string) error {
+	rule, err := ParseRuleSchema(content)
+	if err != nil {
+		return err
+	}
+
+	if !ruleSchemaIdRegex.MatchString(rule.Id) {
+		return errors.New("rule id can contain only letters, digits, dots, dashes and underscores")
+	}
+	if err := checkRuleSchemaInfo(rule.Info); err != nil {
+		return err
+	}
+	if err := checkRuleSchemaScope(rule.Scope); err != nil {
+		return err
+	}
+	if rule.Request == nil && rule.Response == nil && len(rule.Websocket) == 0 && rule.Correlation == nil {
+		return errors.New("rule should have at least one of: request, response, websocket, correlation")
+	}
+
+	//Check the regexes and the encodings of all the matchers
+	for _, searchModes := range getRuleSchemaSearchModes(rule) {
+		for _, mode := range searchModes.modes {
+			if _, err := regexp.Compile(mode.Regex); err != nil {
+				return errors.New("cannot compile regex for the " + searchModes.kind + ", " + err.Error())
+			}
+			if err := checkRuleSchemaEncodings(searchModes.kind+" matcher", mode.Encodings); err != nil {
+				return err
+			}
+		}
+	}
+	if rule.Request != nil {
+		if err := checkRuleSchemaPathMatchers("request json", rule.Request.JSON); err != nil {
+			return err
+		}
+		if err := checkRuleSchemaPathMatchers("request xml", rule.Request.XML); err != nil {
+			return err
+		}
+		if err := checkRuleSchemaFilesMatchers(rule.Request.Files); err != nil {
+			return err
+		}
+	}
+	if err := checkRuleSchemaResponse(rule); err != nil {
+		return err
+	}
+	if err := checkRuleSchemaWebsocket(rule.Websocket); err != nil {
+		return err
+	}
+	if err := checkRuleSchemaCorrelation(rule); err != nil {
+		return err
+	}
+	if err := checkRuleSchemaConditions(rule); err != nil {
+		return err
+	}
+	return checkRuleSchemaTests(rule.Tests)
+}
@@ -0,0 +1,431 @@
+package utils
+
+import (
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"reflect"
+	"slices"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/lucacoratu/disertatie/api/data"
+)
+
+// The sources of the rules of the agent (the API and the agent are in the same repository)
+const (
+	agentRulesSourceDirectory = "../../agent/detection/rules"
+	agentRulesDirectory       = "../../agent/rules"
+)
+
+// The structures of the agent rules mirrored by the rule schema of the API
+var ruleSchemaMirrors = map[string]any{
+	"RuleInfo":              data.RuleSchemaInfo{},
+	"RuleActionParameters":  data.RuleSchemaActionParameters{},
+	"RuleScope":             data.RuleSchemaScope{},
+	"RuleSearchMode":        data.RuleSchemaSearchMode{},
+	"HeadersRule":           data.RuleSchemaMatcher{},
+	"RequestParametersRule": data.RuleSchemaMatcher{},
+	"CookiesRule":           data.RuleSchemaMatcher{},
+	"JSONPathRule":          data.RuleSchemaPathMatcher{},
+	"XMLPathRule":           data.RuleSchemaPathMatcher{},
+	"FilesRule":             data.RuleSchemaFilesMatcher{},
+	"BodyRule":              data.RuleSchemaBodyMatcher{},
+	"WebsocketRule":         data.RuleSchemaWebsocketMatcher{},
+	"RequestRule":           data.RuleSchemaRequest{},
+	"RuleRangeMatch":        data.RuleSchemaRange{},
+	"RuleLatencyMatch":      data.RuleSchemaLatency{},
+	"ResponseRule":          data.RuleSchemaResponse{},
+	"CorrelationFilter":     data.RuleSchemaCorrelationFilter{},
+	"CorrelationRule":       data.RuleSchemaCorrelation{},
+	"RuleTestWebsocket":     data.RuleSchemaTestWebsocket{},
+	"RuleTest":              data.RuleSchemaTest{},
+	"Rule":                  data.RuleSchema{},
+	"RuleCondition":         data.RuleSchemaCondition{},
+	"RuleConditions":        data.RuleSchemaConditions{},
+}
+
+// Holds the parsed sources of the agent rules
+type agentRulesSource struct {
+	structs   map[string]*ast.StructType
+	constants map[string]string
+	files     []*ast.File
+}
+
+// Parses the sources of the agent rules, the test is skipped if the sources are not available
+func parseAgentRulesSource(t *testing.T) *agentRulesSource {
+	if _, err := os.Stat(agentRulesSourceDirectory); err != nil {
+		t.Skip("the sources of the agent rules are not available, ", err)
+	}
+	fileSet := token.NewFileSet()
+	packages, err := parser.ParseDir(fileSet, agentRulesSourceDirectory, func(info fs.FileInfo) bool {
+		return !strings.HasSuffix(info.Name(), "_test.go")
+	}, 0)
+	if err != nil {
+		t.Fatal("could not parse the sources of the agent rules, ", err)
+	}
+
+	source := &agentRulesSource{structs: make(map[string]*ast.StructType), constants: make(map[string]string)}
+	for _, agentPackage := range packages {
+		for _, file := range agentPackage.Files {
+			source.files = append(source.files, file)
+			ast.Inspect(file, func(node ast.Node) bool {
+				switch node := node.(type) {
+				case *ast.TypeSpec:
+					if structType, ok := node.Type.(*ast.StructType); ok {
+						source.structs[node.Name.Name] = structType
+					}
+				case *ast.ValueSpec:
+					for i, name := range node.Names {
+						if i >= len(node.Values) {
+							break
+						}
+						if literal, ok := node.Values[i].(*ast.BasicLit); ok && literal.Kind == token.STRING {
+							source.constants[name.Name], _ = strconv.Unquote(literal.Value)
+						}
+					}
+				}
+				return true
+			})
+		}
+	}
+	return source
+}
+
+// Gets the value of a string literal or of a string constant
+func (source *agentRulesSource) stringValue(expression ast.Expr) (string, bool) {
+	switch expression := expression.(type) {
+	case *ast.BasicLit:
+		if value, err := strconv.Unquote(expression.Value); err == nil {
+			return value, true
+		}
+	case *ast.Ident:
+		value, found := source.constants[expression.Name]
+		return value, found
+	}
+	return "", false
+}
+
+// Gets the keys of the map literal assigned to the variable
+func (source *agentRulesSource) mapKeys(variable string) []string {
+	keys := make([]string, 0)
+	for _, file := range source.files {
+		ast.Inspect(file, func(node ast.Node) bool {
+			valueSpec, ok := node.(*ast.ValueSpec)
+			if !ok || len(valueSpec.Names) != 1 || valueSpec.Names[0].Name != variable || len(valueSpec.Values) != 1 {
+				return true
+			}
+			if literal, ok := valueSpec.Values[0].(*ast.CompositeLit); ok {
+				for _, element := range literal.Elts {
+					if keyValue, ok := element.(*ast.KeyValueExpr); ok {
+						if key, found := source.stringValue(keyValue.Key); found {
+							keys = append(keys, key)
+						}
+					}
+				}
+			}
+			return false
+		})
+	}
+	return keys
+}
+
+// Gets the string values of the cases of the switch statements in the function
+func (source *agentRulesSource) switchCases(function string) []string {
+	values := make([]string, 0)
+	for _, file := range source.files {
+		for _, declaration := range file.Decls {
+			functionDeclaration, ok := declaration.(*ast.FuncDecl)
+			if !ok || functionDeclaration.Name.Name != function {
+				continue
+			}
+			ast.Inspect(functionDeclaration, func(node ast.Node) bool {
+				if caseClause, ok := node.(*ast.CaseClause); ok {
+					for _, expression := range caseClause.List {
+						if value, found := source.stringValue(expression); found {
+							values = append(values, value)
+						}
+					}
+				}
+				return true
+			})
+		}
+	}
+	return values
+}
+
+// Gets the values of the constants whose name starts with the prefix
+func (source *agentRulesSource) constantValues(prefix string) []string {
+	values := make([]string, 0)
+	for name, value := range source.constants {
+		if strings.HasPrefix(name, prefix) {
+			values = append(values, value)
+		}
+	}
+	return values
+}
+
+// Gets the structures of the agent used by the fields of the structure (directly or through the other structures)
+func (source *agentRulesSource) reachableStructs(name string) []string {
+	reachable := []string{name}
+	for i := 0; i < len(reachable); i++ {
+		for _, field := range source.structs[reachable[i]].Fields.List {
+			fieldType := field.Type
+			for {
+				switch typed := fieldType.(type) {
+				case *ast.StarExpr:
+					fieldType = typed.X
+					continue
+				case *ast.ArrayType:
+					fieldType = typed.Elt
+					continue
+				case *ast.MapType:
+					fieldType = typed.Value
+					continue
+				}
+				break
+			}
+			if identifier, ok := fieldType.(*ast.Ident); ok && source.structs[identifier.Name] != nil && !slices.Contains(reachable, identifier.Name) {
+				reachable = append(reachable, identifier.Name)
+			}
+		}
+	}
+	return reachable
+}
+
+// Gets the YAML keys of the fields of a structure (the inline structures are included)
+func getYAMLKeys(tags []string) []string {
+	keys := make([]string, 0, len(tags))
+	for _, tag := range tags {
+		key, _, _ := strings.Cut(reflect.StructTag(tag).Get("yaml"), ",")
+		if key != "" && key != "-" {
+			keys = append(keys, key)
+		}
+	}
+	slices.Sort(keys)
+	return keys
+}
+
+// Gets the YAML tags of the fields of a structure of the agent
+func (source *agentRulesSource) structTags(structType *ast.StructType) []string {
+	tags := make([]string, 0)
+	for _, field := range structType.Fields.List {
+		if field.Tag == nil {
+			continue
+		}
+		tag, _ := strconv.Unquote(field.Tag.Value)
+		if strings.Contains(reflect.StructTag(tag).Get("yaml"), "inline") {
+			if identifier, ok := field.Type.(*ast.Ident); ok && source.structs[identifier.Name] != nil {
+				tags = append(tags, source.structTags(source.structs[identifier.Name])...)
+			}
+			continue
+		}
+		tags = append(tags, tag)
+	}
+	return tags
+}
+
+// Gets the YAML tags of the fields of a structure of the API
+func getMirrorTags(mirrorType reflect.Type) []string {
+	tags := make([]string, 0)
+	for i := 0; i < mirrorType.NumField(); i++ {
+		field := mirrorType.Field(i)
+		if strings.Contains(field.Tag.Get("yaml"), "inline") {
+			tags = append(tags, getMirrorTags(field.Type)...)
+			continue
+		}
+		tags = append(tags, string(field.Tag))
+	}
+	return tags
+}
+
+// Sorts a copy of the values
+func sortedValues(values []string) []string {
+	sorted := slices.Clone(values)
+	slices.Sort(sorted)
+	return slices.Compact(sorted)
+}
+
+func TestRuleSchemaKeysInSyncWithAgent(t *testing.T) {
+	source := parseAgentRulesSource(t)
+	for agentStruct, mirror := range ruleSchemaMirrors {
+		structType, found := source.structs[agentStruct]
+		if !found {
+			t.Errorf("the agent does not have the %s structure mirrored by %T anymore", agentStruct, mirror)
+			continue
+		}
+		agentKeys := getYAMLKeys(source.structTags(structType))
+		mirrorKeys := getYAMLKeys(getMirrorTags(reflect.TypeOf(mirror)))
+		if !slices.Equal(agentKeys, mirrorKeys) {
+			t.Errorf("the keys of %T are not the keys of the agent %s structure, expected %v, got %v", mirror, agentStruct, agentKeys, mirrorKeys)
+		}
+	}
+
+	//Every structure reachable from the rule should be mirrored
+	for _, agentStruct := range source.reachableStructs("Rule") {
+		if _, found := ruleSchemaMirrors[agentStruct]; !found {
+			t.Errorf("the agent %s structure is not mirrored by the rule schema", agentStruct)
+		}
+	}
+}
+
+func TestRuleSchemaValuesInSyncWithAgent(t *testing.T) {
+	source := parseAgentRulesSource(t)
+	tests := []struct {
+		name     string
+		agent    []string
+		mirrored []string
+	}{
+		{"actions", source.mapKeys("actionsPrecedence"), ruleSchemaActions},
+		{"encodings", source.mapKeys("decoders"), ruleSchemaEncodings},
+		{"severities", source.switchCases("ConvertSeverityStringToInteger"), ruleSchemaSeverities},
+		{"matchers conditions", source.constantValues("MatchersCondition"), ruleSchemaMatchersConditions},
+		{"websocket directions", source.constantValues("WebsocketDirection"), ruleSchemaWebsocketDirections},
+		{"latency measures", source.constantValues("LatencyMeasure"), ruleSchemaLatencyMeasures},
+		{"correlation keys", source.constantValues("CorrelationKey"), ruleSchemaCorrelationKeys},
+		{"correlation distincts", append(source.constantValues("CorrelationKey"), source.constantValues("CorrelationValue")...), ruleSchemaCorrelationDistincts},
+	}
+	for _, test := range tests {
+		agent, mirrored := sortedValues(test.agent), sortedValues(test.mirrored)
+		if len(agent) == 0 {
+			t.Errorf("could not find the %s in the sources of the agent", test.name)
+			continue
+		}
+		if !slices.Equal(agent, mirrored) {
+			t.Errorf("the %s are not the ones of the agent, expected %v, got %v", test.name, agent, mirrored)
+		}
+	}
+}
+
+func TestValidateRuleSchemaAgentRules(t *testing.T) {
+	if _, err := os.Stat(agentRulesDirectory); err != nil {
+		t.Skip("the rules of the agent are not available, ", err)
+	}
+	err := filepath.WalkDir(agentRulesDirectory, func(path string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if entry.IsDir() && entry.Name() == "lists" {
+			return filepath.SkipDir
+		}
+		if entry.IsDir() || (filepath.Ext(path) != ".yaml" && filepath.Ext(path) != ".yml") {
+			return nil
+		}
+		content, err := os.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		if err := ValidateRuleSchema(string(content)); err != nil {
+			t.Errorf("the rule %s loaded by the agent is rejected, %s", path, err.Error())
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestValidateRuleSchema(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+		valid   bool
+	}{
+		{"request rule", `id: Request
+info: {name: Request, severity: high, classification: sqli}
+request:
+  params:
+    - name: id
+      regex: (?i)union\s+select
+      encodings: [url, base64]
+`, true},
+		{"response only rule", `id: Response
+info: {name: Response, severity: medium, classification: information-disclosure}
+response:
+  code: {regex: "^5"}
+  body:
+    - match: Traceback
+  latency: {gt: 1000, measure: ttfb}
+tests:
+  - response: "HTTP/1.1 500 Internal Server Error\n\nTraceback"
+    expect: match
+`, true},
+		{"websocket only rule", `id: Websocket
+info: {name: Websocket, severity: low, classification: xss}
+websocket:
+  - direction: client-to-server
+    json-path: $.message
+    regex: <script
+tests:
+  - websocket: {message: '{"message": "<script>"}'}
+    expect: match
+`, true},
+		{"correlation rule", `id: Correlation
+info: {name: Correlation, severity: medium, classification: bruteforce}
+correlation:
+  keys: [ip]
+  window: 1m
+  threshold: 10
+  filter: {status: [401]}
+`, true},
+		{"condition with plain references", `id: Condition
+info: {name: Condition, severity: high, classification: rce}
+request:
+  method: {match: POST}
+  body:
+    - match: whoami
+condition:
+  request:
+    and:
+      - request.method
+      - not: {matcher: "request.body[0]"}
+`, true},
+		{"metadata", `id: Metadata
+info:
+  name: Metadata
+  severity: critical
+  classification: rce
+  action: respond
+  action-parameters: {status: 451, headers: {X-Blocked: "1"}}
+  references: [https://example.com/advisory]
+  cvss-score: 9.8
+  cwe-ids: [CWE-78]
+  mitre-techniques: [T1059.004]
+request:
+  url:
+    - match: /cgi-bin/
+`, true},
+		{"unknown key", "id: Unknown\ninfo: {name: Unknown, severity: low}\nrequest:\n  url:\n    - regex: a\n      unknown: b\n", false},
+		{"unknown key in the condition", "id: Unknown\ninfo: {name: Unknown, severity: low}\nrequest:\n  url:\n    - regex: a\ncondition:\n  request: {matcher: request.url, unknown: b}\n", false},
+		{"duplicated key", "id: Duplicated\ninfo: {name: Duplicated, severity: low}\nrequest:\n  url:\n    - regex: a\n      regex: b\n", false},
+		{"condition leaf as a string", "id: Leaf\ninfo: {name: Leaf, severity: low}\nrequest:\n  url:\n    - regex: a\ncondition:\n  request:\n    not: request.url\n", true},
+		{"no matchers", "id: Empty\ninfo: {name: Empty, severity: low}\n", false},
+		{"invalid id", "id: bad id\ninfo: {name: Bad, severity: low}\nrequest:\n  url:\n    - regex: a\n", false},
+		{"invalid severity", "id: Severity\ninfo: {name: Severity, severity: urgent}\nrequest:\n  url:\n    - regex: a\n", false},
+		{"invalid action", "id: Action\ninfo: {name: Action, severity: low, action: block}\nrequest:\n  url:\n    - regex: a\n", false},
+		{"invalid respond status", "id: Status\ninfo: {name: Status, severity: low, action: respond, action-parameters: {status: 101}}\nrequest:\n  url:\n    - regex: a\n", false},
+		{"invalid cwe id", "id: CWE\ninfo: {name: CWE, severity: low, cwe-ids: [89]}\nrequest:\n  url:\n    - regex: a\n", false},
+		{"invalid regex", "id: Regex\ninfo: {name: Regex, severity: low}\nresponse:\n  headers:\n    - name: Server\n      regex: (a\n", false},
+		{"invalid encoding", "id: Encoding\ninfo: {name: Encoding, severity: low}\nwebsocket:\n  - match: a\n    encodings: [rot13]\n", false},
+		{"invalid scope path", "id: Scope\ninfo: {name: Scope, severity: low}\nscope: {paths: [admin]}\nrequest:\n  url:\n    - regex: a\n", false},
+		{"json matcher without path", "id: JSON\ninfo: {name: JSON, severity: low}\nrequest:\n  json:\n    - match: a\n", false},
+		{"files matcher without criterion", "id: Files\ninfo: {name: Files, severity: low}\nrequest:\n  files:\n    - field: upload\n", false},
+		{"invalid latency bounds", "id: Latency\ninfo: {name: Latency, severity: low}\nresponse:\n  latency: {gt: 10, lt: 5}\n", false},
+		{"correlation with matchers", "id: Correlation\ninfo: {name: Correlation, severity: low}\nrequest:\n  url:\n    - regex: a\ncorrelation: {keys: [ip], window: 1m, threshold: 2}\n", false},
+		{"undefined condition matcher", "id: Condition\ninfo: {name: Condition, severity: low}\nrequest:\n  url:\n    - regex: a\ncondition:\n  request: request.body\n", false},
+		{"test without sample", "id: Test\ninfo: {name: Test, severity: low}\nrequest:\n  url:\n    - regex: a\ntests:\n  - expect: match\n", false},
+		{"test with invalid expect", "id: Test\ninfo: {name: Test, severity: low}\nrequest:\n  url:\n    - regex: a\ntests:\n  - request: \"GET /a HTTP/1.1\\nHost: a\\n\"\n    expect: yes\n", false},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			err := ValidateRuleSchema(test.content)
+			if (err == nil) != test.valid {
+				t.Errorf("expected valid %v, got error %v", test.valid, err)
+			}
+		})
+	}
+}
@@ -0,0 +1,372 @@
+package utils
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"io"
+	"net/http"
+	"net/url"
+	"regexp"
+	"slices"
+	"sort"
+	"strings"
+	"unicode"
+
+	"github.com/lucacoratu/disertatie/api/data"
+	"gopkg.in/yaml.v3"
+)
+
+// The parts of the request targeted by the virtual patches (the same names as the parts of the rule findings)
+const (
+	VirtualPatchPartURL       = "url"
+	VirtualPatchPartParameter = "parameter"
+	VirtualPatchPartHeader    = "header"
+	VirtualPatchPartCookie    = "cookie"
+	VirtualPatchPartBody      = "body"
+	VirtualPatchPartJSON      = "json"
+	VirtualPatchPartXML       = "xml"
+)
+
+// The string representation of the severities used in the rules
+var severityNames = map[int64]string{
+	data.LOW:      "low",
+	data.MEDIUM:   "medium",
+	data.HIGH:     "high",
+	data.CRITICAL: "critical",
+}
+
+// Holds a malicious value of the request reported by a finding
+type virtualPatchCandidate struct {
+	target         data.VirtualPatchTarget
+	severity       int64
+	classification string
+	source         string //The rule or the validator which reported the value
+}
+
+// Holds the parsed request of the log
+type virtualPatchRequest struct {
+	request *http.Request
+	body    string
+	form    url.Values
+}
+
+// Gets how specific the part of the request is, the virtual patch targets the most specific value
+func getPartSpecificity(part string) int {
+	switch part {
+	case VirtualPatchPartParameter, VirtualPatchPartHeader, VirtualPatchPartCookie, VirtualPatchPartJSON, VirtualPatchPartXML:
+		return 2
+	case VirtualPatchPartURL:
+		return 1
+	}
+	return 0
+}
+
+// Checks if the value contains the matched string (case insensitive)
+func containsFold(value string, matched string) bool {
+	return strings.Contains(strings.ToLower(value), strings.ToLower(matched))
+}
+
+// Searches the parameter which contains the matched string
+// @param parameters - the parameters of the query or the body
+// @param matched - the string matched by the finding
+// Returns the name of the parameter and true if it was found
+func findParameter(parameters url.Values, matched string) (string, bool) {
+	names := make([]string, 0, len(parameters))
+	for name := range parameters {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		for _, value := range parameters[name] {
+			if containsFold(value, matched) {
+				return name, true
+			}
+		}
+	}
+	return "", false
+}
+
+// Searches the part of the request which contains the matched string
+// The parts are searched from the most specific (parameters, cookies, headers) to the least specific (path, body)
+// @param request - the parsed request of the log
+// @param matched - the string matched by the finding
+// Returns the target and true if the string was found in the request
+func (request *virtualPatchRequest) locate(matched string) (data.VirtualPatchTarget, bool) {
+	target := data.VirtualPatchTarget{Value: matched}
+	if name, found := findParameter(request.request.URL.Query(), matched); found {
+		target.Part, target.Name, target.Source = VirtualPatchPartParameter, name, "query"
+		return target, true
+	}
+	if name, found := findParameter(request.form, matched); found {
+		target.Part, target.Name, target.Source = VirtualPatchPartParameter, name, "body"
+		return target, true
+	}
+	for _, cookie := range request.request.Cookies() {
+		if containsFold(cookie.Value, matched) {
+			target.Part, target.Name = VirtualPatchPartCookie, cookie.Name
+			return target, true
+		}
+	}
+	headerNames := make([]string, 0, len(request.request.Header))
+	for name := range request.request.Header {
+		headerNames = append(headerNames, name)
+	}
+	sort.Strings(headerNames)
+	for _, name := range headerNames {
+		if strings.EqualFold(name, "Cookie") {
+			continue
+		}
+		for _, value := range request.request.Header[name] {
+			if containsFold(value, matched) {
+				target.Part, target.Name = VirtualPatchPartHeader, name
+				return target, true
+			}
+		}
+	}
+	if containsFold(request.request.URL.Path, matched) {
+		target.Part = VirtualPatchPartURL
+		return target, true
+	}
+	if containsFold(request.body, matched) {
+		target.Part = VirtualPatchPartBody
+		return target, true
+	}
+	return target, false
+}
+
+// Gets the target of a rule finding from the part of the request it matched
+// The findings of the older agents do not have the part so the matched string is searched in the request
+// @param request - the parsed request of the log
+// @param finding - the rule finding on the request
+// Returns the target and true if the matched value is in the request
+func (request *virtualPatchRequest) getRuleFindingTarget(finding data.RuleFindingDataDatabase) (data.VirtualPatchTarget, bool) {
+	target := data.VirtualPatchTarget{Value: finding.MatchedString, Name: finding.PartName, Source: finding.PartSource, Encodings: finding.DecodingChain}
+	switch finding.Part {
+	case VirtualPatchPartParameter, VirtualPatchPartHeader, VirtualPatchPartCookie:
+		target.Part = finding.Part
+		return target, target.Name != ""
+	case VirtualPatchPartBody:
+		//The values selected by the JSONPath and the XPath are targeted by their path
+		switch {
+		case strings.HasPrefix(finding.MatchedPath, "$"):
+			target.Part, target.Path = VirtualPatchPartJSON, finding.MatchedPath
+		case strings.HasPrefix(finding.MatchedPath, "/"):
+			target.Part, target.Path = VirtualPatchPartXML, finding.MatchedPath
+		default:
+			//The payload in a form body is targeted in its parameter
+			if name, found := findParameter(request.form, finding.MatchedString); found {
+				target.Part, target.Name, target.Source = VirtualPatchPartParameter, name, "body"
+			} else {
+				target.Part = VirtualPatchPartBody
+			}
+		}
+		return target, true
+	case VirtualPatchPartURL:
+		//The payload in the query string is targeted in its parameter
+		if name, found := findParameter(request.request.URL.Query(), finding.MatchedString); found {
+			target.Part, target.Name, target.Source = VirtualPatchPartParameter, name, "query"
+		} else {
+			target.Part = VirtualPatchPartURL
+		}
+		return target, true
+	case "":
+		located, found := request.locate(finding.MatchedString)
+		located.Encodings = finding.DecodingChain
+		return located, found
+	}
+	//The method, the uploaded files and the response parts are not targeted
+	return target, false
+}
+
+// Parses the raw request of the log
+func parseVirtualPatchRequest(rawRequest string) (*virtualPatchRequest, error) {
+	request, err := http.ReadRequest(bufio.NewReader(strings.NewReader(rawRequest)))
+	if err != nil {
+		return nil, errors.New("could not read the raw request into struct, " + err.Error())
+	}
+	//The body is kept even if it is shorter than the Content-Length
+	bodyData, err := io.ReadAll(request.Body)
+	if err != nil && !errors.Is(err, io.ErrUnexpectedEOF) {
+		return nil, errors.New("could not read the request body")
+	}
+	request.Body = io.NopCloser(bytes.NewReader(bodyData))
+	parsed := &virtualPatchRequest{request: request, body: string(bodyData), form: url.Values{}}
+	if strings.HasPrefix(strings.ToLower(request.Header.Get("Content-Type")), "application/x-www-form-urlencoded") {
+		if form, err := url.ParseQuery(parsed.body); err == nil {
+			parsed.form = form
+		}
+	}
+	return parsed, nil
+}
+
+// Generalizes the malicious value into a case insensitive regex
+// The numbers match any number, the whitespaces match any whitespace and the quotes match any quote
+// so the variations of the same payload are blocked as well
+// @param value - the malicious value
+// Returns the regex
+func GeneralizePayload(value string) string {
+	var builder strings.Builder
+	builder.WriteString("(?i)")
+	runes := []rune(value)
+	for i := 0; i < len(runes); i++ {
+		switch {
+		case unicode.IsDigit(runes[i]):
+			for i+1 < len(runes) && unicode.IsDigit(runes[i+1]) {
+				i++
+			}
+			builder.WriteString(`\d+`)
+		case unicode.IsSpace(runes[i]):
+			for i+1 < len(runes) && unicode.IsSpace(runes[i+1]) {
+				i++
+			}
+			builder.WriteString(`\s+`)
+		case runes[i] == '\'' || runes[i] == '"' || runes[i] == '`':
+			builder.WriteString("[\"'`]")
+		default:
+			builder.WriteString(regexp.QuoteMeta(string(runes[i])))
+		}
+	}
+	return builder.String()
+}
+
+// Creates the matcher of the virtual patch for the target
+// The values are URL decoded before the search so the encoded variants of the payload are matched as well
+// The decodings which revealed the payload to the rule are applied too
+func createVirtualPatchMatcher(target data.VirtualPatchTarget) *data.RuleSchemaRequest {
+	encodings := []string{"url"}
+	for _, encoding := range target.Encodings {
+		if !slices.Contains(encodings, encoding) {
+			encodings = append(encodings, encoding)
+		}
+	}
+	request := &data.RuleSchemaRequest{}
+	switch target.Part {
+	case VirtualPatchPartParameter:
+		request.Parameters = []*data.RuleSchemaMatcher{{Name: target.Name, Regex: target.Regex, Encodings: encodings}}
+	case VirtualPatchPartHeader:
+		request.Headers = []*data.RuleSchemaMatcher{{Name: target.Name, Regex: target.Regex, Encodings: encodings}}
+	case VirtualPatchPartCookie:
+		request.Cookies = []*data.RuleSchemaMatcher{{Name: target.Name, Regex: target.Regex, Encodings: encodings}}
+	case VirtualPatchPartJSON:
+		request.JSON = []*data.RuleSchemaPathMatcher{{Path: target.Path, Regex: target.Regex, Encodings: encodings}}
+	case VirtualPatchPartXML:
+		request.XML = []*data.RuleSchemaPathMatcher{{Path: target.Path, Regex: target.Regex, Encodings: encodings}}
+	case VirtualPatchPartURL:
+		request.URL = []*data.RuleSchemaSearchMode{{Regex: target.Regex, Encodings: encodings}}
+	default:
+		request.Body = []*data.RuleSchemaBodyMatcher{{Regex: target.Regex, Encodings: encodings}}
+	}
+	return request
+}
+
+// Creates a rule blocking the malicious value found in the request of a log (virtual patch)
+// The rule targets the parameter, header, cookie or path with the most severe finding and applies only to the path and the method of the request
+// The request of the log is added as a test of the rule so the agents check the rule matches it
+// @param logId - the id of the log
+// @param rawRequest - the raw request of the log
+// @param findings - the findings of the validators on the log
+// @param ruleFindings - the findings of the rules on the log
+// Returns the YAML content of the rule, the targeted value or an error if the request has no finding to build the rule from
+func CreateVirtualPatchRule(logId string, rawRequest string, findings []data.FindingDatabase, ruleFindings []data.RuleFindingDatabase) (string, data.VirtualPatchTarget, error) {
+	request, err := parseVirtualPatchRequest(rawRequest)
+	if err != nil {
+		return "", data.VirtualPatchTarget{}, err
+	}
+
+	//Collect the malicious values found by the rules and the validators in the request
+	candidates := make([]virtualPatchCandidate, 0)
+	for _, ruleFinding := range ruleFindings {
+		finding := ruleFinding.Request
+		if finding == nil || finding.RuleId == "" || finding.MatchedString == "" {
+			continue
+		}
+		if target, found := request.getRuleFindingTarget(*finding); found {
+			candidates = append(candidates, virtualPatchCandidate{target: target, severity: finding.Severity, classification: finding.Classification, source: finding.RuleId})
+		}
+	}
+	for _, validatorFinding := range findings {
+		finding := validatorFinding.Request
+		if finding.ValidatorName == "" || finding.MatchedString == "" {
+			continue
+		}
+		if target, found := request.locate(finding.MatchedString); found {
+			candidates = append(candidates, virtualPatchCandidate{target: target, severity: finding.Severity, classification: strings.ToLower(data.ClassificationsMap[finding.Classification]), source: finding.ValidatorName})
+		}
+	}
+	if len(candidates) == 0 {
+		return "", data.VirtualPatchTarget{}, errors.New("the request of the log has no finding which can be targeted by a rule")
+	}
+
+	//Target the most severe finding, the most specific part of the request when the severities are the same
+	sort.SliceStable(candidates, func(i, j int) bool {
+		if candidates[i].severity != candidates[j].severity {
+			return candidates[i].severity > candidates[j].severity
+		}
+		return getPartSpecificity(candidates[i].target.Part) > getPartSpecificity(candidates[j].target.Part)
+	})
+	selected := candidates[0]
+	target := selected.target
+	target.Regex = GeneralizePayload(target.Value)
+	if generalized, err := regexp.Compile(target.Regex); err != nil || !generalized.MatchString(target.Value) {
+		target.Regex = "(?i)" + regexp.QuoteMeta(target.Value)
+	}
+
+	//The findings which were reported on the same value
+	sources := make([]string, 0)
+	for _, candidate := range candidates {
+		if candidate.target.Part == target.Part && candidate.target.Name == target.Name && !slices.Contains(sources, candidate.source) {
+			sources = append(sources, candidate.source)
+		}
+	}
+
+	severity, found := severityNames[selected.severity]
+	if !found {
+		severity = "medium"
+	}
+	classification := selected.classification
+	if classification == "" {
+		classification = "virtual-patch"
+	}
+	targetName := target.Part
+	if target.Name != "" {
+		targetName += " " + target.Name
+	} else if target.Path != "" {
+		targetName += " " + target.Path
+	}
+	path := request.request.URL.Path
+	if path == "" {
+		path = "/"
+	}
+
+	rule := data.RuleSchema{
+		Id: "VirtualPatch-" + logId,
+		Info: &data.RuleSchemaInfo{
+			Name:           "Virtual patch for the " + targetName + " of " + path,
+			Description:    "Blocks the payload found by " + strings.Join(sources, ", ") + " in the request of the log " + logId,
+			Severity:       severity,
+			Classification: classification,
+			Action:         "drop",
+			Tags:           []string{"virtual-patch"},
+		},
+		Scope:   &data.RuleSchemaScope{Paths: []string{path}, Methods: []string{request.request.Method}},
+		Request: createVirtualPatchMatcher(target),
+		Tests:   []*data.RuleSchemaTest{{Name: "request of the log " + logId, Request: strings.TrimRight(strings.ReplaceAll(rawRequest, "\r\n", "\n"), "\n") + "\n", Expect: "match"}},
+	}
+
+	var content bytes.Buffer
+	encoder := yaml.NewEncoder(&content)
+	encoder.SetIndent(2)
+	err = encoder.Encode(&rule)
+	if err != nil {
+		return "", target, errors.New("could not create the YAML content of the rule, " + err.Error())
+	}
+	encoder.Close()
+
+	//Check the generated rule is accepted by the agents
+	err = ValidateRuleSchema(content.String())
+	if err != nil {
+		return "", target, errors.New("the generated rule is not valid, " + err.Error())
+	}
+	return content.String(), target, nil
+}
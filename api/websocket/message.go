@@ -3,6 +3,8 @@ package websocket
 import (
 	"encoding/json"
 	"io"
+
+	"github.com/lucacoratu/disertatie/api/data"
 )
 
 // Message Types
@@ -14,6 +16,9 @@ const (
 	WsAgentDisconnectedNotification int64 = 4
 	WsAgentConnectedNotification    int64 = 5
 	WsRuleDetectionAlert            int64 = 6
+	WsRuleSetUpdate                 int64 = 7
+	WsRuleSetAck                    int64 = 8
+	WsRuleStatistics                int64 = 9
 )
 
 // WebSocket message format
@@ -76,3 +81,49 @@ func (rda *RuleDetectionAlert) FromJSON(r io.Reader) error {
 	e := json.NewDecoder(r)
 	return e.Decode(rda)
 }
+
+// A rule from the rule set sent to the agents
+type RuleSetRule struct {
+	Id      string `json:"id"`      //The id of the rule
+	Content string `json:"content"` //The YAML content of the rule
+	Version int64  `json:"version"` //The version of the rule set when the rule was last modified
+}
+
+// The rule set pushed to the agents
+type RuleSetUpdate struct {
+	Version int64         `json:"version"` //The version of the rule set
+	Rules   []RuleSetRule `json:"rules"`   //The rules of the rule set
+}
+
+func (rsu *RuleSetUpdate) FromJSON(r io.Reader) error {
+	d := json.NewDecoder(r)
+	return d.Decode(rsu)
+}
+
+// The acknowledgement of the agent after a rule set update
+type RuleSetAck struct {
+	AgentId    string `json:"agentId"`         //The id of the agent
+	Version    int64  `json:"version"`         //The version of the rule set the agent is running
+	Status     string `json:"status"`          //The status of the update (applied or rejected)
+	Error      string `json:"error,omitempty"` //Why the rule set was rejected
+	RulesCount int64  `json:"rulesCount"`      //The number of rules in use
+	Timestamp  int64  `json:"timestamp"`       //When the update was handled
+}
+
+func (rsa *RuleSetAck) FromJSON(r io.Reader) error {
+	d := json.NewDecoder(r)
+	return d.Decode(rsa)
+}
+
+// The performance and hit counters of the rules sent periodically by the agents
+type RuleStatisticsReport struct {
+	AgentId   string                `json:"agentId"`   //The id of the agent
+	Since     int64                 `json:"since"`     //When the agent started counting
+	Timestamp int64                 `json:"timestamp"` //When the report was created
+	Rules     []data.RuleStatistics `json:"rules"`     //The counters of the rules loaded by the agent
+}
+
+func (rsr *RuleStatisticsReport) FromJSON(r io.Reader) error {
+	d := json.NewDecoder(r)
+	return d.Decode(rsr)
+}
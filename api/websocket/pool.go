@@ -25,6 +25,7 @@ type Pool struct {
 	UnregisterAgent     chan *AgentClient         //Channgel which will handle agent client disconnecting
 	AgentClients        map[*AgentClient]bool     //A map of dashboard client connections and associated state of the connection (true for online)
 	AgentBroadcast      chan AgentMessage         //Channel which will be used to handle a message from the agent
+	RuleSetBroadcast    chan struct{}             //Channel which will be used to push the latest rule set to all the agents (a pending push sends the latest version)
 	logger              logging.ILogger           //The logger
 	dbConnection        database.IConnection      //The database connection
 	configuration       config.Configuration      //The configuration
@@ -43,6 +44,7 @@ func NewPool(l logging.ILogger, dbConn database.IConnection, conf config.Configu
 		UnregisterAgent:     make(chan *AgentClient),
 		AgentClients:        make(map[*AgentClient]bool),
 		AgentBroadcast:      make(chan AgentMessage),
+		RuleSetBroadcast:    make(chan struct{}, 1),
 		logger:              l,
 		dbConnection:        dbConn,
 		configuration:       conf,
@@ -83,6 +85,19 @@ func (pool *Pool) AgentRegistered(c *AgentClient) {
 			pool.logger.Error("Error occured when sending agent connect notification to dashboard client, id:", client.Id)
 		}
 	}
+	//Send the rule set to the agent so it runs the latest version
+	update, err := pool.GetRuleSetUpdate()
+	if err != nil {
+		pool.logger.Error("Could not get the rule set to send to the agent, id:", c.Id, err.Error())
+		return
+	}
+	if update.Version == 0 {
+		return
+	}
+	err = c.Conn.WriteJSON(WebSocketMessage{Type: WsRuleSetUpdate, Data: update})
+	if err != nil {
+		pool.logger.Error("Error occured when sending the rule set to the agent, id:", c.Id)
+	}
 }
 
 func (pool *Pool) AgentUnregistered(c *AgentClient) {
@@ -152,6 +167,22 @@ func (pool *Pool) AgentMessageReceived(message AgentMessage) {
 			message.C.Conn.WriteJSON(errMessage)
 			return
 		}
+	case WsRuleSetAck:
+		err = pool.HandleRuleSetAck(message.C, wsMessage)
+		if err != nil {
+			//Send an error message back to the client
+			errMessage := WebSocketMessage{Type: WsError, Data: data.APIError{Code: data.WS_ERROR, Message: err.Error()}}
+			message.C.Conn.WriteJSON(errMessage)
+			return
+		}
+	case WsRuleStatistics:
+		err = pool.HandleRuleStatistics(message.C, wsMessage)
+		if err != nil {
+			//Send an error message back to the client
+			errMessage := WebSocketMessage{Type: WsError, Data: data.APIError{Code: data.WS_ERROR, Message: err.Error()}}
+			message.C.Conn.WriteJSON(errMessage)
+			return
+		}
 	}
 }
 
@@ -195,6 +226,15 @@ func (pool *Pool) Start() {
 		case message := <-pool.AgentBroadcast:
 			//Message received from the agent on the websocket
 			pool.AgentMessageReceived(message)
+
+		case <-pool.RuleSetBroadcast:
+			//The rule set was modified, push the latest version to all the agents
+			update, err := pool.GetRuleSetUpdate()
+			if err != nil {
+				pool.logger.Error("Could not get the rule set to send to the agents", err.Error())
+				continue
+			}
+			pool.SendRuleSet(update)
 		}
 	}
 }
@@ -257,3 +297,93 @@ func (pool *Pool) HandleRuleDetectionAlert(msg WebSocketMessage) error {
 	}
 	return nil
 }
+
+// Gets the rule set from the database in the format sent to the agents
+func (pool *Pool) GetRuleSetUpdate() (RuleSetUpdate, error) {
+	version, err := pool.dbConnection.GetRuleSetVersion()
+	if err != nil {
+		return RuleSetUpdate{}, err
+	}
+	rules, err := pool.dbConnection.GetRules()
+	if err != nil {
+		return RuleSetUpdate{}, err
+	}
+	update := RuleSetUpdate{Version: version, Rules: make([]RuleSetRule, 0, len(rules))}
+	for _, rule := range rules {
+		update.Rules = append(update.Rules, RuleSetRule{Id: rule.Id, Content: rule.Content, Version: rule.Version})
+	}
+	return update, nil
+}
+
+// Requests the latest rule set to be pushed to all the connected agents without waiting for the pool
+// The requests made while a push is pending are merged since the pending push reads the latest rule set
+func (pool *Pool) PublishRuleSet() {
+	select {
+	case pool.RuleSetBroadcast <- struct{}{}:
+	default:
+		pool.logger.Debug("A rule set push is already pending, the agents will receive the latest version")
+	}
+}
+
+// Sends the rule set to all the connected agents
+func (pool *Pool) SendRuleSet(update RuleSetUpdate) {
+	pool.logger.Info("Sending rule set version", update.Version, "to", len(pool.AgentClients), "agents")
+	wsMessage := WebSocketMessage{Type: WsRuleSetUpdate, Data: update}
+	for client := range pool.AgentClients {
+		err := client.Conn.WriteJSON(wsMessage)
+		//Check if an error occured when sending the rule set to the agent
+		if err != nil {
+			pool.logger.Error("Error occured when sending the rule set to the agent, id:", client.Id)
+		}
+	}
+}
+
+// Checks if the agent is connected to the websocket
+func (pool *Pool) IsAgentConnected(agentId string) bool {
+	for agent := range pool.AgentClients {
+		if agent.Id == agentId {
+			return true
+		}
+	}
+	return false
+}
+
+func (pool *Pool) HandleRuleSetAck(c *AgentClient, msg WebSocketMessage) error {
+	//Convert the message data field to the corresponding structure
+	msgData, _ := json.Marshal(msg.Data)
+	ack := RuleSetAck{}
+	err := json.Unmarshal(msgData, &ack)
+	if err != nil {
+		return err
+	}
+	//The agent id is the one the agent connected with
+	ack.AgentId = c.Id
+	if ack.Error != "" {
+		pool.logger.Warning("Agent", c.Id, "rejected the rule set,", ack.Error)
+	}
+	pool.logger.Info("Agent", c.Id, "is running rule set version", ack.Version)
+	agentRuleVersion := data.AgentRuleVersion{AgentId: ack.AgentId, Version: ack.Version, Status: ack.Status, Error: ack.Error, RulesCount: ack.RulesCount, Updated: ack.Timestamp}
+	err = pool.dbConnection.SaveAgentRuleVersion(agentRuleVersion)
+	if err != nil {
+		return err
+	}
+	//Send the acknowledgement to all the dashboard clients
+	for client := range pool.DashboardClients {
+		client.Conn.WriteJSON(WebSocketMessage{Type: WsRuleSetAck, Data: ack})
+	}
+	return nil
+}
+
+func (pool *Pool) HandleRuleStatistics(c *AgentClient, msg WebSocketMessage) error {
+	//Convert the message data field to the corresponding structure
+	msgData, _ := json.Marshal(msg.Data)
+	report := RuleStatisticsReport{}
+	err := json.Unmarshal(msgData, &report)
+	if err != nil {
+		return err
+	}
+	//The agent id is the one the agent connected with
+	report.AgentId = c.Id
+	pool.logger.Debug("Agent", c.Id, "reported the statistics of", len(report.Rules), "rules")
+	return pool.dbConnection.SaveRuleStatistics(report.AgentId, report.Since, report.Timestamp, report.Rules)
+}
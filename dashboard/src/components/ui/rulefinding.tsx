@@ -39,7 +39,11 @@ const RuleFinding: FC<RuleFindingProps> = ({finding, matchedString}): JSX.Elemen
                                 <p>Classified as: {finding.classification.toUpperCase()}</p>
                                 <p>Severity: <span className={severityTextColors[finding.severity]}>{severityNames[finding.severity]}</span> - Detected by: {finding.ruleName} (Id: {finding.ruleId})</p>
                                 <p>Matched on line {finding.line + 1}, position {finding.lineIndex} {matchedString != "" ? "- String: " + matchedString : ""}</p>
+                                {finding.part && <p>Matched in {finding.partSource ? finding.partSource + " " : ""}{finding.part}{finding.partName ? " " + finding.partName : ""}{finding.matchedPath ? " (" + finding.matchedPath + ")" : ""}{finding.decodingChain && finding.decodingChain.length > 0 ? " after decoding: " + finding.decodingChain.join(" > ") : ""}</p>}
                                 <p>{finding.matchedBodyHash != "" ? "Matched on body hash (Algorithm: "+ finding.matchedBodyHashAlg + "): " + finding.matchedBodyHash : ""}</p>
+                                {finding.tags && finding.tags.length > 0 && <p>Tags: {finding.tags.join(", ")}</p>}
+                                {finding.cweIds && finding.cweIds.length > 0 && <p>CWE: {finding.cweIds.join(", ")}{finding.cvssScore ? " - CVSS: " + finding.cvssScore : ""}</p>}
+                                {finding.mitreTechniques && finding.mitreTechniques.length > 0 && <p>MITRE ATT&CK: {finding.mitreTechniques.join(", ")}</p>}
                             </div>
                         </TooltipContent>
                     </Tooltip>
@@ -72,6 +72,11 @@ enum classification {
     //Request classifications
     LFI_ATTACK = 0,
     SCRIPT_USER_AGENT = 1,
+    BLOCKED_COUNTRY = 2,
+    HOSTING_ASN = 3,
+    KNOWN_BAD_IP = 4,
+    RATE_LIMIT_EXCEEDED = 5,
+    BANNED_CLIENT = 6,
 
     //Response classification
 	UNAUTHORIZED_ACCESS = 100,
@@ -126,6 +131,19 @@ type RuleFindingData = {
 	matchedBodyHashAlg:  string
 	classification: string 
 	severity: number
+	tags?: string[]
+	references?: string[]
+	cweIds?: string[]
+	cvssScore?: number
+	cvssMetrics?: string
+	mitreTechniques?: string[]
+	startOffset?: number
+	endOffset?: number
+	part?: string
+	partName?: string
+	partSource?: string
+	matchedPath?: string
+	decodingChain?: string[]
 }
 
 type RuleFinding = {